@@ -2,20 +2,29 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	"auth-service/config"
 	"auth-service/internal/db"
 	"auth-service/internal/handlers"
+	"auth-service/internal/mail"
 	"auth-service/internal/models"
+	"auth-service/internal/oidc"
+	"auth-service/internal/ratelimit"
+	"auth-service/internal/services"
 	"auth-service/pkg"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
 )
 
 func main() {
@@ -28,53 +37,260 @@ func main() {
 	// Initialize the database
 	db.InitDB()
 
-	// Perform auto-migration for the User model
-	db.DB.AutoMigrate(&models.User{})
+	// Users created before email verification keep logging in
+	if err := models.MigrateEmailVerified(db.DB); err != nil {
+		pkg.Logger.Fatal("Error adding email verification to users: ", err)
+	}
+
+	// Perform auto-migration for the User, RefreshToken, MFA, AccountToken, AuthEvent, UserEventDelivery, SSO and API key models
+	db.DB.AutoMigrate(&models.User{}, &models.RefreshToken{}, &models.RecoveryCode{}, &models.MFAChallenge{}, &models.AccountToken{}, &models.AuthEvent{}, &models.UserEventDelivery{}, &models.ExternalIdentity{}, &models.SSOState{}, &models.APIKey{})
+
+	// Store usernames and emails of users created before they were normalized in normalized form
+	if err := models.NormalizeUserKeys(db.DB); err != nil {
+		pkg.Logger.Fatal("Error normalizing usernames and emails: ", err)
+	}
 
 	// Create default admin user if not exists
-	createDefaultAdminUser()
+	createDefaultAdminUser(cfg)
 
-	// Load private key for JWT
-	err := pkg.LoadPrivateKey(cfg.PrivateKeyPath)
+	// Load the private keys for JWT
+	err := pkg.LoadKeys(cfg.PrivateKeyPath, cfg.SigningKeyFile)
 	if err != nil {
-		pkg.Logger.Fatal("Error loading private key: ", err)
+		pkg.Logger.Fatal("Error loading private keys: ", err)
 	}
+	pkg.Logger.Infof("Loaded %d signing keys, signing with %s", len(pkg.Keys.Keys), pkg.Keys.Signing.ID)
 
-	// Setup Gin router
+	tokenService, err := services.NewTokenService(db.DB, cfg.AccessTokenTTL, cfg.RefreshTokenTTL)
+	if err != nil {
+		pkg.Logger.Fatal("Error setting up token verification: ", err)
+	}
+	mfaService := services.NewMFAService(db.DB, cfg.MFAIssuer, cfg.MFARequiredRoles, cfg.MFAChallengeTTL)
+	mailer := newMailer(cfg)
+	passwordPolicy, err := services.NewPasswordPolicy(cfg.PasswordMinLength, cfg.BreachedPasswordsFile)
+	if err != nil {
+		pkg.Logger.Fatal("Error loading breached passwords: ", err)
+	}
+	pkg.Logger.Infof("Rejecting %d breached passwords", passwordPolicy.Breached())
+	accountService := services.NewAccountService(db.DB, mailer, passwordPolicy, cfg.PublicURL, cfg.EmailVerifyTTL, cfg.PasswordResetTTL)
+	loginGuard := services.NewLoginGuard(db.DB, newLoginLimitStore(cfg), mailer, services.LoginLimits{
+		Window:             cfg.LoginWindow,
+		MaxAccountFailures: cfg.LoginMaxAccountFailures,
+		MaxIPFailures:      cfg.LoginMaxIPFailures,
+		Lockout:            cfg.LoginLockout,
+		BaseDelay:          250 * time.Millisecond,
+		MaxDelay:           cfg.LoginMaxDelay,
+	})
+
+	// Deliver user events to the other services in the background
+	userEvents := services.NewUserEventDispatcher(db.DB, cfg.UserEventWebhooks, cfg.UserEventSecret)
+	stopEvents := make(chan struct{})
+	go userEvents.Start(10*time.Second, stopEvents)
+	adminService := services.NewAdminService(db.DB, tokenService, accountService, userEvents)
+	ssoService := services.NewSSOService(db.DB, tokenService, newSSOProviders(cfg), cfg.SSOStateTTL)
+	apiKeyService := services.NewAPIKeyService(db.DB, tokenService, cfg.APIKeyDefaultTTL, cfg.APIKeyMaxTTL)
+
+	// Setup Gin router. The client IP, which failed logins are counted against, is only taken from
+	// X-Forwarded-For when the request comes through a trusted proxy.
 	r := gin.Default()
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		pkg.Logger.Fatal("Error setting trusted proxies: ", err)
+	}
 
 	// Routes
-	r.POST("/api/login", handlers.Login)
-	r.POST("/api/register", handlers.Register)
+	r.POST("/api/login", handlers.Login(tokenService, mfaService, loginGuard, accountService))
+	r.POST("/api/login/mfa", handlers.LoginMFA(tokenService, mfaService, loginGuard, accountService))
+	r.GET("/api/sso/providers", handlers.SSOProviders(ssoService))
+	r.GET("/api/sso/:provider/login", handlers.SSOLogin(ssoService))
+	r.GET("/api/sso/:provider/callback", handlers.SSOCallback(tokenService, mfaService, ssoService))
+	r.POST("/api/register", handlers.Register(tokenService, accountService))
+	r.GET("/api/verify-email", handlers.VerifyEmail(accountService))
+	r.POST("/api/verify-email", handlers.VerifyEmail(accountService))
+	r.POST("/api/verify-email/resend", handlers.ResendVerification(accountService))
+	r.POST("/api/password/forgot", handlers.ForgotPassword(accountService))
+	r.POST("/api/password/reset", handlers.ResetPassword(accountService, loginGuard))
+	r.POST("/api/password/change", handlers.Authenticated(tokenService), handlers.ChangePassword(accountService, loginGuard))
+	r.POST("/api/refresh", handlers.Refresh(tokenService))
+	r.POST("/api/logout", handlers.Logout(tokenService))
+	r.GET("/api/revoked-sessions", handlers.ServiceAuthenticated(tokenService), handlers.RevokedSessions(tokenService))
+	r.POST("/api/token", handlers.ExchangeAPIKey(apiKeyService))
+	r.POST("/api/api-keys", handlers.Authenticated(tokenService), handlers.CreateAPIKey(apiKeyService))
+	r.GET("/api/api-keys", handlers.Authenticated(tokenService), handlers.ListAPIKeys(apiKeyService))
+	r.DELETE("/api/api-keys/:keyId", handlers.Authenticated(tokenService), handlers.RevokeAPIKey(apiKeyService))
+	r.POST("/api/mfa/enroll", handlers.EnrollMFA(tokenService, mfaService))
+	r.POST("/api/mfa/enroll/verify", handlers.VerifyMFAEnrollment(tokenService, mfaService, accountService))
+	r.POST("/api/mfa/disable", handlers.Authenticated(tokenService), handlers.DisableMFA(mfaService))
+	r.POST("/api/mfa/recovery-codes", handlers.Authenticated(tokenService), handlers.RegenerateRecoveryCodes(mfaService))
+	r.GET("/.well-known/jwks.json", handlers.JWKS)
+	r.GET("/.well-known/openid-configuration", handlers.Discovery(cfg.JWTIssuer, cfg.PublicURL))
+
+	// User management, restricted to admins
+	admin := r.Group("/api/admin", handlers.Authenticated(tokenService), handlers.RequireRole(models.RoleAdmin))
+	admin.GET("/users", handlers.ListUsers(adminService))
+	admin.GET("/users/:id", handlers.GetUser(adminService))
+	admin.PUT("/users/:id/role", handlers.SetUserRole(adminService))
+	admin.POST("/users/:id/disable", handlers.SetUserDisabled(adminService, true))
+	admin.POST("/users/:id/enable", handlers.SetUserDisabled(adminService, false))
+	admin.POST("/users/:id/reset-password", handlers.ForcePasswordReset(adminService))
+	admin.DELETE("/users/:id", handlers.DeleteUser(adminService))
+	admin.POST("/service-accounts", handlers.CreateServiceAccount(adminService))
+	admin.POST("/service-accounts/:id/api-keys", handlers.CreateServiceAccountKey(adminService, apiKeyService))
+	admin.GET("/service-accounts/:id/api-keys", handlers.ListServiceAccountKeys(adminService, apiKeyService))
+	admin.DELETE("/service-accounts/:id/api-keys/:keyId", handlers.RevokeServiceAccountKey(adminService, apiKeyService))
 
 	// Start the server with graceful shutdown
 	srv := startServer(r)
 
 	// Handle graceful shutdown on interrupt signal
 	gracefulShutdown(srv)
+	close(stopEvents)
 }
 
-// createDefaultAdminUser creates a default admin user if it doesn't already exist
-func createDefaultAdminUser() {
+// createDefaultAdminUser creates the bootstrap admin user from ADMIN_USERNAME, ADMIN_PASSWORD and
+// ADMIN_EMAIL if it doesn't already exist. Without ADMIN_PASSWORD a random password is generated
+// and written to ADMIN_PASSWORD_FILE, never to the logs. Either way the password must be changed at
+// the first login.
+func createDefaultAdminUser(cfg *config.Config) {
 	var adminUser models.User
-	if err := models.GetUserByUsername(db.DB, "admin", &adminUser); err != nil {
+	if err := models.GetUserByUsername(db.DB, cfg.AdminUsername, &adminUser); err != nil {
+		password := cfg.AdminPassword
+		if password == "" {
+			password = randomPassword()
+			if err := writeAdminPassword(cfg.AdminPasswordFile, password); err != nil {
+				pkg.Logger.Fatal("Error saving the generated admin password: ", err)
+			}
+			pkg.Logger.Warnf("ADMIN_PASSWORD is not set, the generated password for %s is in %s; delete the file once it is changed", cfg.AdminUsername, cfg.AdminPasswordFile)
+		}
+
 		// Hash the password for the admin user
-		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("adminpass"), bcrypt.DefaultCost)
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			pkg.Logger.Fatal("Error hashing admin password: ", err)
+		}
 
 		// Create the admin user
 		adminUser := models.User{
-			Username: "admin",
-			Password: string(hashedPassword),
-			Email:    "admin@example.com",
+			Username:           models.NormalizeUsername(cfg.AdminUsername),
+			Password:           string(hashedPassword),
+			Email:              models.NormalizeEmail(cfg.AdminEmail),
+			Role:               models.RoleAdmin,
+			EmailVerified:      true,
+			MustChangePassword: true,
 		}
 
 		if err := models.CreateUser(db.DB, &adminUser); err != nil {
 			fmt.Println("Error creating admin user:", err)
+			if cfg.AdminPassword == "" {
+				os.Remove(cfg.AdminPasswordFile)
+			}
 		} else {
 			fmt.Println("Admin user created successfully.")
 		}
+		return
+	}
+
+	// Admins created by earlier versions had no role and the well-known default password
+	if adminUser.Role == "" {
+		if err := models.UpdateUserRole(db.DB, adminUser.ID, models.RoleAdmin); err != nil {
+			pkg.Logger.Error("Error setting admin role: ", err)
+		}
+	}
+	if bcrypt.CompareHashAndPassword([]byte(adminUser.Password), []byte("adminpass")) == nil && !adminUser.MustChangePassword {
+		pkg.Logger.Warnf("%s still has the default password, it must be changed at the next login", adminUser.Username)
+		if err := models.SetMustChangePassword(db.DB, adminUser.ID); err != nil {
+			pkg.Logger.Error("Error requiring admin password change: ", err)
+		}
+	}
+}
+
+// randomPassword returns a random password for the bootstrap admin user
+func randomPassword() string {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		pkg.Logger.Fatal("Error generating admin password: ", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// writeAdminPassword writes the generated bootstrap admin password to a new file only the service
+// user can read. An existing file is not overwritten, as it may hold a password still in use.
+func writeAdminPassword(path, password string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(file, password); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
+
+// newMailer returns the sender for account emails, which keeps them in memory when no mail server
+// is configured
+func newMailer(cfg *config.Config) mail.Sender {
+	if cfg.SMTPHost == "" {
+		pkg.Logger.Warn("SMTP_HOST is not set, account emails will not be delivered")
+		return mail.NewMemorySender()
+	}
+	return mail.NewSMTPSender(mail.SMTPConfig{
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     cfg.MailFrom,
+	})
+}
+
+// newLoginLimitStore returns the store login failures are counted in. The database store is kept
+// in Postgres and shared by all replicas of the service.
+func newLoginLimitStore(cfg *config.Config) ratelimit.Store {
+	if cfg.LoginLimitStore == "database" {
+		limitDB, err := gorm.Open(postgres.Open(cfg.LoginLimitDatabaseURL), &gorm.Config{})
+		if err != nil {
+			pkg.Logger.Fatal("Error connecting to the login limit database: ", err)
+		}
+		store, err := ratelimit.NewDBStore(limitDB, cfg.LoginWindow)
+		if err != nil {
+			pkg.Logger.Fatal("Error setting up login limit store: ", err)
+		}
+		return store
+	}
+	return ratelimit.NewMemoryStore(cfg.LoginWindow)
+}
+
+// newSSOProviders returns the OpenID providers users can log in with, whose callbacks are served
+// under PUBLIC_URL
+func newSSOProviders(cfg *config.Config) []*services.SSOProvider {
+	var providers []*services.SSOProvider
+	for _, provider := range cfg.OIDCProviders {
+		if !models.ValidRole(provider.DefaultRole) {
+			pkg.Logger.Fatalf("OIDC provider %s has unknown default role %q", provider.Name, provider.DefaultRole)
+		}
+		for group, role := range provider.RoleMapping {
+			if !models.ValidRole(role) {
+				pkg.Logger.Fatalf("OIDC provider %s maps group %q to unknown role %q", provider.Name, group, role)
+			}
+		}
+
+		providers = append(providers, &services.SSOProvider{
+			OIDC: oidc.NewProvider(oidc.Config{
+				Name:         provider.Name,
+				Issuer:       provider.Issuer,
+				ClientID:     provider.ClientID,
+				ClientSecret: provider.ClientSecret,
+				RedirectURL:  strings.TrimSuffix(cfg.PublicURL, "/") + "/api/sso/" + provider.Name + "/callback",
+				Scopes:       provider.Scopes,
+			}),
+			GroupsClaim: provider.GroupsClaim,
+			RoleMapping: provider.RoleMapping,
+			DefaultRole: provider.DefaultRole,
+			LinkByEmail: provider.LinkByEmail,
+			TrustMFA:    provider.TrustMFA,
+		})
+		pkg.Logger.Infof("SSO login enabled with %s (%s)", provider.Name, provider.Issuer)
 	}
-	
+	return providers
 }
 
 // startServer starts the Gin HTTP server in a separate goroutine
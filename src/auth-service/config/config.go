@@ -3,17 +3,67 @@ package config
 import (
 	"log"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 // Config stores all the configuration needed for the application
 type Config struct {
-	JWTIssuer        string
-	PrivateKeyPath   string
-	AdminUsername    string
-	AdminPassword    string
-	AdminEmail       string
+	JWTIssuer               string
+	PrivateKeyPath          string   // Directory holding the PEM private keys
+	SigningKeyFile          string   // File in PrivateKeyPath holding the key new tokens are signed with
+	PublicURL               string   // URL the service is reachable at, used in the discovery document
+	TrustedProxies          []string // Proxies whose X-Forwarded-For is trusted for the client IP
+	AdminUsername           string
+	AdminPassword           string
+	AdminPasswordFile       string // Where a generated bootstrap admin password is written
+	AdminEmail              string
+	AccessTokenTTL          time.Duration // Lifetime of access tokens
+	RefreshTokenTTL         time.Duration // Lifetime of refresh tokens, and so of idle sessions
+	MFAIssuer               string        // Account issuer shown by authenticator apps
+	MFARequiredRoles        []string      // Roles that must use MFA to log in
+	MFAChallengeTTL         time.Duration // Time allowed for the second step of logging in
+	EmailVerifyTTL          time.Duration // Lifetime of email verification links
+	PasswordResetTTL        time.Duration // Lifetime of password reset tokens
+	PasswordMinLength       int
+	BreachedPasswordsFile   string // Extra breached passwords to reject, one per line
+	SMTPHost                string // Mail server; emails are only kept in memory when empty
+	SMTPPort                int
+	SMTPUsername            string
+	SMTPPassword            string
+	MailFrom                string // Sender address of account emails
+	LoginLimitStore         string // Where login failures are counted: "memory" or "database"
+	LoginLimitDatabaseURL   string // Postgres database of the "database" store, shared by the replicas
+	LoginWindow             time.Duration
+	LoginMaxAccountFailures int
+	LoginMaxIPFailures      int
+	LoginLockout            time.Duration
+	LoginMaxDelay           time.Duration
+	UserEventWebhooks       []string // Endpoints told about deleted users so they can remove their data
+	UserEventSecret         string   // Key the user event webhooks are signed with
+	OIDCProviders           []OIDCProvider
+	SSOStateTTL             time.Duration // Time allowed for logging in at an identity provider
+	APIKeyDefaultTTL        time.Duration // Lifetime of API keys created without an expiry
+	APIKeyMaxTTL            time.Duration // Longest lifetime API keys can be created with
+}
+
+// OIDCProvider configures an OpenID provider users can log in with. It is read from the
+// OIDC_<NAME>_* variables of each name in OIDC_PROVIDERS.
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string // Empty for public clients
+	Scopes       []string
+	GroupsClaim  string            // ID token claim listing the user's groups
+	RoleMapping  map[string]string // Group to role, from group=role pairs
+	DefaultRole  string            // Role of users in none of the mapped groups
+	LinkByEmail  bool              // Link subjects to local accounts with the same verified email
+	TrustMFA     bool              // The provider enforces MFA, so local MFA is skipped
 }
 
 // LoadConfig loads environment variables from the .env file and validates them
@@ -26,17 +76,59 @@ func LoadConfig() *Config {
 
 	// Load and validate environment variables
 	config := &Config{
-		JWTIssuer:      getEnv("JWT_ISSUER", "default-issuer"),
-		PrivateKeyPath: getEnv("PRIVATE_KEY_FILEPATH", ""),
-		AdminUsername:  getEnv("ADMIN_USERNAME", "admin"),
-		AdminPassword:  getEnv("ADMIN_PASSWORD", ""),
-		AdminEmail:     getEnv("ADMIN_EMAIL", "admin@example.com"),
+		JWTIssuer:               getEnv("JWT_ISSUER", "default-issuer"),
+		PrivateKeyPath:          getEnv("PRIVATE_KEY_FILEPATH", ""),
+		SigningKeyFile:          getEnv("SIGNING_KEY_FILE", "private_key.pem"),
+		PublicURL:               getEnv("PUBLIC_URL", "http://localhost:8080"),
+		TrustedProxies:          getListEnv("TRUSTED_PROXIES", ""),
+		AdminUsername:           getEnv("ADMIN_USERNAME", "admin"),
+		AdminPassword:           getEnv("ADMIN_PASSWORD", ""),
+		AdminPasswordFile:       getEnv("ADMIN_PASSWORD_FILE", "./admin-password.txt"),
+		AdminEmail:              getEnv("ADMIN_EMAIL", "admin@example.com"),
+		AccessTokenTTL:          time.Duration(getIntEnv("ACCESS_TOKEN_TTL_MINUTES", 15)) * time.Minute,
+		RefreshTokenTTL:         time.Duration(getIntEnv("REFRESH_TOKEN_TTL_HOURS", 720)) * time.Hour,
+		MFAIssuer:               getEnv("MFA_ISSUER", "File Streamer"),
+		MFARequiredRoles:        getListEnv("MFA_REQUIRED_ROLES", "admin"),
+		MFAChallengeTTL:         time.Duration(getIntEnv("MFA_CHALLENGE_TTL_MINUTES", 5)) * time.Minute,
+		EmailVerifyTTL:          time.Duration(getIntEnv("EMAIL_VERIFY_TTL_HOURS", 24)) * time.Hour,
+		PasswordResetTTL:        time.Duration(getIntEnv("PASSWORD_RESET_TTL_MINUTES", 60)) * time.Minute,
+		PasswordMinLength:       getIntEnv("PASSWORD_MIN_LENGTH", 8),
+		BreachedPasswordsFile:   getEnv("BREACHED_PASSWORDS_FILE", ""),
+		SMTPHost:                getEnv("SMTP_HOST", ""),
+		SMTPPort:                getIntEnv("SMTP_PORT", 587),
+		SMTPUsername:            getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:            getEnv("SMTP_PASSWORD", ""),
+		MailFrom:                getEnv("MAIL_FROM", "no-reply@example.com"),
+		LoginLimitStore:         getEnv("LOGIN_LIMIT_STORE", "memory"),
+		LoginLimitDatabaseURL:   getEnv("LOGIN_LIMIT_DATABASE_URL", ""),
+		LoginWindow:             time.Duration(getIntEnv("LOGIN_WINDOW_MINUTES", 15)) * time.Minute,
+		LoginMaxAccountFailures: getIntEnv("LOGIN_MAX_ACCOUNT_FAILURES", 5),
+		LoginMaxIPFailures:      getIntEnv("LOGIN_MAX_IP_FAILURES", 20),
+		LoginLockout:            time.Duration(getIntEnv("LOGIN_LOCKOUT_MINUTES", 15)) * time.Minute,
+		LoginMaxDelay:           time.Duration(getIntEnv("LOGIN_MAX_DELAY_SECONDS", 8)) * time.Second,
+		UserEventWebhooks:       getListEnv("USER_EVENT_WEBHOOKS", ""),
+		UserEventSecret:         getEnv("USER_EVENT_SECRET", ""),
+		OIDCProviders:           loadOIDCProviders(),
+		SSOStateTTL:             time.Duration(getIntEnv("SSO_STATE_TTL_MINUTES", 10)) * time.Minute,
+		APIKeyDefaultTTL:        time.Duration(getIntEnv("API_KEY_DEFAULT_TTL_DAYS", 90)) * 24 * time.Hour,
+		APIKeyMaxTTL:            time.Duration(getIntEnv("API_KEY_MAX_TTL_DAYS", 365)) * 24 * time.Hour,
 	}
 
 	if config.PrivateKeyPath == "" {
 		log.Fatal("PRIVATE_KEY_FILEPATH is required")
 	}
 
+	if config.LoginLimitStore != "memory" && config.LoginLimitStore != "database" {
+		log.Fatal("LOGIN_LIMIT_STORE must be memory or database")
+	}
+	if config.LoginLimitStore == "database" && config.LoginLimitDatabaseURL == "" {
+		log.Fatal("LOGIN_LIMIT_DATABASE_URL is required when LOGIN_LIMIT_STORE is database")
+	}
+
+	if len(config.UserEventWebhooks) > 0 && (config.UserEventSecret == "" || config.UserEventSecret == "change-me") {
+		log.Fatal("USER_EVENT_SECRET is required when USER_EVENT_WEBHOOKS is set and must not be the example value")
+	}
+
 	return config
 }
 
@@ -46,4 +138,79 @@ func getEnv(key string, fallback string) string {
 		return value
 	}
 	return fallback
-}
\ No newline at end of file
+}
+
+// getIntEnv retrieves a positive integer environment variable with a fallback value
+func getIntEnv(key string, fallback int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Fatalf("%s must be a positive integer", key)
+	}
+	return n
+}
+
+// getListEnv retrieves a comma-separated environment variable with a fallback value
+func getListEnv(key string, fallback string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnv(key, fallback), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getBoolEnv retrieves a boolean environment variable with a fallback value
+func getBoolEnv(key string, fallback bool) bool {
+	value, exists := os.LookupEnv(key)
+	if !exists || value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("%s must be true or false", key)
+	}
+	return b
+}
+
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// loadOIDCProviders reads the configuration of each provider named in OIDC_PROVIDERS
+func loadOIDCProviders() []OIDCProvider {
+	var providers []OIDCProvider
+	for _, name := range getListEnv("OIDC_PROVIDERS", "") {
+		if !providerNamePattern.MatchString(name) {
+			log.Fatalf("OIDC provider name %q must be lowercase letters, digits and hyphens", name)
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+
+		provider := OIDCProvider{
+			Name:         name,
+			Issuer:       getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			Scopes:       getListEnv(prefix+"SCOPES", "openid,profile,email"),
+			GroupsClaim:  getEnv(prefix+"GROUPS_CLAIM", "groups"),
+			RoleMapping:  make(map[string]string),
+			DefaultRole:  getEnv(prefix+"DEFAULT_ROLE", "viewer"),
+			LinkByEmail:  getBoolEnv(prefix+"LINK_BY_EMAIL", false),
+			TrustMFA:     getBoolEnv(prefix+"TRUST_MFA", false),
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			log.Fatalf("%sISSUER and %sCLIENT_ID are required", prefix, prefix)
+		}
+		for _, pair := range getListEnv(prefix+"ROLE_MAPPING", "") {
+			group, role, ok := strings.Cut(pair, "=")
+			if !ok || group == "" || role == "" {
+				log.Fatalf("%sROLE_MAPPING must be comma-separated group=role pairs", prefix)
+			}
+			provider.RoleMapping[strings.TrimSpace(group)] = strings.TrimSpace(role)
+		}
+		providers = append(providers, provider)
+	}
+	return providers
+}
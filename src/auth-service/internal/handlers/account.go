@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"auth-service/internal/services"
+	"auth-service/pkg"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VerifyEmail handler for the link sent to verify an email address. The token is read from the
+// token query parameter, or from a JSON body.
+func VerifyEmail(accounts *services.AccountService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			var req struct {
+				Token string `json:"token"`
+			}
+			_ = c.ShouldBindJSON(&req)
+			token = req.Token
+		}
+		if token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid input"})
+			return
+		}
+
+		err := accounts.VerifyEmail(token)
+		if errors.Is(err, services.ErrInvalidAccountToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to verify email address"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"msg": "Email address verified"})
+	}
+}
+
+// ResendVerification handler for sending a new verification link. It responds the same whether
+// or not the address has an account.
+func ResendVerification(accounts *services.AccountService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Email string `json:"email"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid input"})
+			return
+		}
+
+		if err := accounts.ResendVerification(c.Request.Context(), req.Email); err != nil {
+			pkg.Logger.Errorf("Failed to resend verification email: %v", err)
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"msg": "If the address belongs to an unverified account, a verification link has been sent"})
+	}
+}
+
+// ForgotPassword handler for requesting a password reset token by email. It responds the same
+// whether or not the address has an account.
+func ForgotPassword(accounts *services.AccountService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Email string `json:"email"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid input"})
+			return
+		}
+
+		if err := accounts.ForgotPassword(c.Request.Context(), req.Email); err != nil {
+			pkg.Logger.Errorf("Failed to send password reset email: %v", err)
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"msg": "If the address belongs to an account, a reset token has been sent"})
+	}
+}
+
+// ResetPassword handler for choosing a new password with a reset token. Every session of the user
+// is logged out, and a lockout of the account after failed logins is lifted.
+func ResetPassword(accounts *services.AccountService, guard *services.LoginGuard) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Token       string `json:"token"`
+			NewPassword string `json:"new_password"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid input"})
+			return
+		}
+
+		user, err := accounts.ResetPassword(req.Token, req.NewPassword)
+		if errors.Is(err, services.ErrInvalidAccountToken) || errors.Is(err, services.ErrWeakPassword) {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to reset password"})
+			return
+		}
+		// Whoever locked the account out no longer knows its password
+		guard.Unlock(c.Request.Context(), user.Username)
+
+		c.JSON(http.StatusOK, gin.H{"msg": "Password reset successfully"})
+	}
+}
+
+// ChangePassword handler for signed in users changing their password. Every other session of the
+// user is logged out. Wrong current passwords count as failed logins in guard, so that a stolen
+// session cannot be used to guess the password.
+func ChangePassword(accounts *services.AccountService, guard *services.LoginGuard) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			CurrentPassword string `json:"current_password"`
+			NewPassword     string `json:"new_password"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid input"})
+			return
+		}
+
+		user := currentUser(c)
+		if !checkLoginAllowed(c, guard, user.Username) {
+			return
+		}
+
+		ctx := c.Request.Context()
+		err := accounts.ChangePassword(user, currentClaims(c).SessionID, req.CurrentPassword, req.NewPassword)
+		if errors.Is(err, services.ErrWrongPassword) {
+			failLogin(c, guard.Fail(ctx, user, user.Username, c.ClientIP(), "wrong password on password change"), err.Error())
+			return
+		}
+		// The current password was right
+		guard.Succeed(ctx, user.Username)
+		if errors.Is(err, services.ErrWeakPassword) {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to change password"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"msg": "Password changed successfully"})
+	}
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"auth-service/internal/mail"
+	"auth-service/internal/models"
+	"auth-service/internal/ratelimit"
+	"auth-service/internal/services"
+	"shared/authn"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestChangePasswordIsThrottled(t *testing.T) {
+	db := newTestDB(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("current-pass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Username: "alice", Email: "alice@example.com", Password: string(hash), Role: models.RoleEditor, EmailVerified: true}
+	if err := models.CreateUser(db, user); err != nil {
+		t.Fatal(err)
+	}
+
+	guard := services.NewLoginGuard(db, ratelimit.NewMemoryStore(time.Minute), mail.NewMemorySender(), services.LoginLimits{
+		Window: time.Minute, MaxAccountFailures: 3, MaxIPFailures: 10, Lockout: time.Minute, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond,
+	})
+	passwords, err := services.NewPasswordPolicy(8, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts := services.NewAccountService(db, mail.NewMemorySender(), passwords, "https://auth.example.com/", time.Hour, time.Hour)
+	r := gin.New()
+	r.POST("/api/password/change", func(c *gin.Context) {
+		// As if signed in through Authenticated
+		c.Set(userKey, user)
+		c.Set(claimsKey, &authn.Claims{UserID: user.ID, SessionID: "s1"})
+	}, ChangePassword(accounts, guard))
+
+	// A stolen session is no help in guessing the password
+	for i := 0; i < 3; i++ {
+		code, resp := postJSON(t, r, "/api/password/change", `{"current_password": "wrong-pass", "new_password": "another-pass"}`)
+		if code != http.StatusUnauthorized {
+			t.Fatalf("Expected a wrong current password to be refused, got %d: %v", code, resp)
+		}
+	}
+	code, resp := postJSON(t, r, "/api/password/change", `{"current_password": "current-pass", "new_password": "another-pass"}`)
+	if code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the account to be locked out, got %d: %v", code, resp)
+	}
+	var failures int64
+	db.Model(&models.AuthEvent{}).Where("type = ?", models.EventLoginFailed).Count(&failures)
+	if failures != 3 {
+		t.Errorf("Expected the wrong passwords to be recorded as failed logins, got %d", failures)
+	}
+
+	// The password is left as it was
+	var stored models.User
+	if err := db.First(&stored, user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("current-pass")) != nil {
+		t.Error("Expected the password to be unchanged")
+	}
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// userResponse is the JSON form of a user shown to admins. Secrets such as the password hash and
+// the TOTP secret are left out.
+type userResponse struct {
+	ID                 uint   `json:"id"`
+	Username           string `json:"username"`
+	Email              string `json:"email"`
+	Role               string `json:"role"`
+	EmailVerified      bool   `json:"email_verified"`
+	MFAEnabled         bool   `json:"mfa_enabled"`
+	Disabled           bool   `json:"disabled"`
+	MustChangePassword bool   `json:"must_change_password"`
+	ServiceAccount     bool   `json:"service_account"`
+}
+
+func newUserResponse(user *models.User) userResponse {
+	return userResponse{
+		ID:                 user.ID,
+		Username:           user.Username,
+		Email:              user.Email,
+		Role:               user.Role,
+		EmailVerified:      user.EmailVerified,
+		MFAEnabled:         user.MFAEnabled,
+		Disabled:           user.Disabled,
+		MustChangePassword: user.MustChangePassword,
+		ServiceAccount:     user.ServiceAccount,
+	}
+}
+
+// ListUsers handler for listing users. The listing can be filtered with q (the start of the
+// username or email), role, disabled and service_account, and paged with page and page_size.
+func ListUsers(admin *services.AdminService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := models.UserQuery{Search: c.Query("q"), Role: c.Query("role")}
+		if value := c.Query("disabled"); value != "" {
+			disabled, err := strconv.ParseBool(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid disabled filter"})
+				return
+			}
+			query.Disabled = &disabled
+		}
+		if value := c.Query("service_account"); value != "" {
+			serviceAccount, err := strconv.ParseBool(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid service_account filter"})
+				return
+			}
+			query.ServiceAccount = &serviceAccount
+		}
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+		users, total, err := admin.ListUsers(query, page, pageSize)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to list users"})
+			return
+		}
+
+		resp := make([]userResponse, len(users))
+		for i := range users {
+			resp[i] = newUserResponse(&users[i])
+		}
+		c.JSON(http.StatusOK, gin.H{"users": resp, "total": total})
+	}
+}
+
+// GetUser handler for showing a user.
+func GetUser(admin *services.AdminService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := userIDParam(c)
+		if !ok {
+			return
+		}
+
+		user, err := admin.GetUser(userID)
+		if !respondAdminError(c, err, "Failed to get user") {
+			return
+		}
+		c.JSON(http.StatusOK, newUserResponse(user))
+	}
+}
+
+// SetUserRole handler for changing a user's role. The user is logged out of every session.
+func SetUserRole(admin *services.AdminService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := userIDParam(c)
+		if !ok {
+			return
+		}
+		var req struct {
+			Role string `json:"role"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid input"})
+			return
+		}
+
+		user, err := admin.SetRole(currentUser(c), userID, req.Role)
+		if !respondAdminError(c, err, "Failed to change role") {
+			return
+		}
+		c.JSON(http.StatusOK, newUserResponse(user))
+	}
+}
+
+// SetUserDisabled handler for disabling or re-enabling an account. Disabling logs the user out of
+// every session.
+func SetUserDisabled(admin *services.AdminService, disabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := userIDParam(c)
+		if !ok {
+			return
+		}
+
+		user, err := admin.SetDisabled(currentUser(c), userID, disabled)
+		if !respondAdminError(c, err, "Failed to update account") {
+			return
+		}
+		c.JSON(http.StatusOK, newUserResponse(user))
+	}
+}
+
+// ForcePasswordReset handler for requiring a user to choose a new password. The user is logged
+// out of every session and emailed a reset token.
+func ForcePasswordReset(admin *services.AdminService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := userIDParam(c)
+		if !ok {
+			return
+		}
+
+		user, err := admin.ForcePasswordReset(c.Request.Context(), currentUser(c), userID)
+		if !respondAdminError(c, err, "Failed to force password reset") {
+			return
+		}
+		c.JSON(http.StatusOK, newUserResponse(user))
+	}
+}
+
+// DeleteUser handler for deleting a user. The other services are told to remove the user's files
+// and grants.
+func DeleteUser(admin *services.AdminService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := userIDParam(c)
+		if !ok {
+			return
+		}
+
+		if !respondAdminError(c, admin.DeleteUser(currentUser(c), userID), "Failed to delete user") {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"msg": "User deleted"})
+	}
+}
+
+// userIDParam parses the :id route parameter, responding with 400 if it is invalid.
+func userIDParam(c *gin.Context) (uint, bool) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || userID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid user ID"})
+		return 0, false
+	}
+	return uint(userID), true
+}
+
+// respondAdminError responds to errors of user management operations and reports whether there
+// was none.
+func respondAdminError(c *gin.Context, err error, msg string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"msg": err.Error()})
+	case errors.Is(err, services.ErrInvalidRole):
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+	case errors.Is(err, services.ErrOwnAccount), errors.Is(err, services.ErrLastAdmin):
+		c.JSON(http.StatusConflict, gin.H{"msg": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": msg})
+	}
+	return false
+}
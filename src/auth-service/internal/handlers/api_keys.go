@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiKeyResponse is the JSON form of an API key. The key itself is only included when it is
+// created.
+type apiKeyResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Key        string     `json:"key,omitempty"`
+	Role       string     `json:"role,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func newAPIKeyResponse(key *models.APIKey) apiKeyResponse {
+	return apiKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Role:       key.Role,
+		Scopes:     strings.Fields(key.Scopes),
+		ExpiresAt:  key.ExpiresAt,
+		RevokedAt:  key.RevokedAt,
+		LastUsedAt: key.LastUsedAt,
+		LastUsedIP: key.LastUsedIP,
+		CreatedAt:  key.CreatedAt,
+	}
+}
+
+// apiKeyRequest is the JSON body of requests creating API keys.
+type apiKeyRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"` // read, write or both
+	Role          string   `json:"role"`   // At most the owner's role; the owner's role when empty
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// CreateAPIKey handler for creating an API key of the authenticated user. The key is only shown
+// in the response.
+func CreateAPIKey(apiKeys *services.APIKeyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := currentUser(c)
+		createAPIKey(c, apiKeys, user, user)
+	}
+}
+
+// ListAPIKeys handler for listing the API keys of the authenticated user.
+func ListAPIKeys(apiKeys *services.APIKeyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listAPIKeys(c, apiKeys, currentUser(c))
+	}
+}
+
+// RevokeAPIKey handler for revoking an API key of the authenticated user.
+func RevokeAPIKey(apiKeys *services.APIKeyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		revokeAPIKey(c, apiKeys, currentUser(c))
+	}
+}
+
+// ExchangeAPIKey handler for exchanging an API key for a short-lived access token. The key is
+// sent as api_key in the body or as a bearer token.
+func ExchangeAPIKey(apiKeys *services.APIKeyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			APIKey string `json:"api_key"`
+		}
+		if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			req.APIKey = strings.TrimPrefix(header, "Bearer ")
+		} else if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid input"})
+			return
+		}
+
+		token, err := apiKeys.Exchange(req.APIKey, c.ClientIP())
+		switch {
+		case errors.Is(err, services.ErrInvalidAPIKey):
+			c.JSON(http.StatusUnauthorized, gin.H{"msg": "Invalid API key"})
+		case errors.Is(err, services.ErrAccountDisabled):
+			c.JSON(http.StatusForbidden, gin.H{"msg": "Account disabled"})
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to create token"})
+		default:
+			c.JSON(http.StatusOK, token)
+		}
+	}
+}
+
+// CreateServiceAccount handler for creating a service account, an account for automation that
+// authenticates with API keys only.
+func CreateServiceAccount(admin *services.AdminService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Name string `json:"name"`
+			Role string `json:"role"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid input"})
+			return
+		}
+
+		user, err := admin.CreateServiceAccount(currentUser(c), req.Name, req.Role)
+		if !respondAPIKeyError(c, err, "Failed to create service account") {
+			return
+		}
+		c.JSON(http.StatusCreated, newUserResponse(user))
+	}
+}
+
+// CreateServiceAccountKey handler for creating an API key of a service account.
+func CreateServiceAccountKey(admin *services.AdminService, apiKeys *services.APIKeyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if account, ok := serviceAccountParam(c, admin); ok {
+			createAPIKey(c, apiKeys, account, currentUser(c))
+		}
+	}
+}
+
+// ListServiceAccountKeys handler for listing the API keys of a service account.
+func ListServiceAccountKeys(admin *services.AdminService, apiKeys *services.APIKeyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if account, ok := serviceAccountParam(c, admin); ok {
+			listAPIKeys(c, apiKeys, account)
+		}
+	}
+}
+
+// RevokeServiceAccountKey handler for revoking an API key of a service account.
+func RevokeServiceAccountKey(admin *services.AdminService, apiKeys *services.APIKeyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if account, ok := serviceAccountParam(c, admin); ok {
+			revokeAPIKey(c, apiKeys, account)
+		}
+	}
+}
+
+func createAPIKey(c *gin.Context, apiKeys *services.APIKeyService, owner, creator *models.User) {
+	var req apiKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid input"})
+		return
+	}
+
+	key, plaintext, err := apiKeys.Create(owner, creator, services.APIKeyRequest{
+		Name:          req.Name,
+		Scopes:        req.Scopes,
+		Role:          req.Role,
+		ExpiresInDays: req.ExpiresInDays,
+	})
+	if !respondAPIKeyError(c, err, "Failed to create API key") {
+		return
+	}
+	resp := newAPIKeyResponse(key)
+	resp.Key = plaintext
+	c.JSON(http.StatusCreated, resp)
+}
+
+func listAPIKeys(c *gin.Context, apiKeys *services.APIKeyService, owner *models.User) {
+	keys, err := apiKeys.List(owner.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to list API keys"})
+		return
+	}
+
+	resp := make([]apiKeyResponse, len(keys))
+	for i := range keys {
+		resp[i] = newAPIKeyResponse(&keys[i])
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": resp})
+}
+
+func revokeAPIKey(c *gin.Context, apiKeys *services.APIKeyService, owner *models.User) {
+	keyID, err := strconv.ParseUint(c.Param("keyId"), 10, 64)
+	if err != nil || keyID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid API key ID"})
+		return
+	}
+
+	if !respondAPIKeyError(c, apiKeys.Revoke(owner.ID, uint(keyID)), "Failed to revoke API key") {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"msg": "API key revoked"})
+}
+
+// serviceAccountParam fetches the service account of the :id route parameter, responding with an
+// error if there is none.
+func serviceAccountParam(c *gin.Context, admin *services.AdminService) (*models.User, bool) {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return nil, false
+	}
+	account, err := admin.GetServiceAccount(userID)
+	if !respondAPIKeyError(c, err, "Failed to get service account") {
+		return nil, false
+	}
+	return account, true
+}
+
+// respondAPIKeyError responds to errors of API key and service account operations and reports
+// whether there was none.
+func respondAPIKeyError(c *gin.Context, err error, msg string) bool {
+	var invalid *services.ValidationError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusBadRequest, gin.H{"msg": invalid.Message, "field": invalid.Field})
+	case errors.Is(err, services.ErrAPIKeyNotFound), errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"msg": err.Error()})
+	case errors.Is(err, services.ErrUsernameTaken), errors.Is(err, services.ErrEmailTaken):
+		c.JSON(http.StatusConflict, gin.H{"msg": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": msg})
+	}
+	return false
+}
@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
 	"auth-service/internal/db"
 	"auth-service/internal/models"
@@ -11,77 +14,249 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-// Login handler for authenticating users and generating JWT token.
-func Login(c *gin.Context) {
-    var loginDetails struct {
-        Username string `json:"username"`
-        Password string `json:"password"`
-    }
-
-    if err := c.ShouldBindJSON(&loginDetails); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid input"})
-        return
-    }
-
-    var user models.User
-    if err := models.GetUserByUsername(db.DB, loginDetails.Username, &user); err != nil {
-        c.JSON(http.StatusUnauthorized, gin.H{"msg": "Invalid username or password"})
-        return
-    }
-
-    // Compare hashed password
-    if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(loginDetails.Password)); err != nil {
-        c.JSON(http.StatusUnauthorized, gin.H{"msg": "Invalid username or password"})
-        return
-    }
-
-    // Create JWT token with the role included in the claims
-    token, err := services.CreateJWT(user.ID, user.Username, user.Email, user.Role)  // Pass role to the token creation
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to create token"})
-        return
-    }
-
-    c.JSON(http.StatusOK, gin.H{"access_token": token})
-}
+// Login handler for authenticating users and issuing an access token and a refresh token. Users
+// with MFA enabled, or whose role requires MFA, get a short-lived mfa_token instead, to be
+// exchanged for tokens through LoginMFA or the enrollment handlers. Users who must change their
+// password get a reset_token to choose a new one with, once they have entered every factor.
+// Failed attempts are throttled by guard.
+func Login(tokens *services.TokenService, mfa *services.MFAService, guard *services.LoginGuard, accounts *services.AccountService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var loginDetails struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+
+		if err := c.ShouldBindJSON(&loginDetails); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid input"})
+			return
+		}
+
+		// Throttle every spelling of a username together
+		loginDetails.Username = models.NormalizeUsername(loginDetails.Username)
+
+		ctx := c.Request.Context()
+		if !checkLoginAllowed(c, guard, loginDetails.Username) {
+			return
+		}
+
+		// Service accounts authenticate with API keys only
+		var user models.User
+		if err := models.GetUserByUsername(db.DB, loginDetails.Username, &user); err != nil || user.ServiceAccount {
+			failLogin(c, guard.Fail(ctx, nil, loginDetails.Username, c.ClientIP(), "unknown username"), "Invalid username or password")
+			return
+		}
+
+		// Compare hashed password
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(loginDetails.Password)); err != nil {
+			failLogin(c, guard.Fail(ctx, &user, loginDetails.Username, c.ClientIP(), "wrong password"), "Invalid username or password")
+			return
+		}
+		guard.Release(ctx, user.Username)
+
+		if user.Disabled {
+			c.JSON(http.StatusForbidden, gin.H{"msg": "Account disabled"})
+			return
+		}
+		if !user.EmailVerified {
+			c.JSON(http.StatusForbidden, gin.H{"msg": "Email address not verified"})
+			return
+		}
+
+		// Ask for the second factor before starting a session. Failures are only forgotten once
+		// it has been entered, so that guessing codes counts against the account too.
+		mfaToken, purpose, err := mfa.StartChallenge(&user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to start MFA challenge"})
+			return
+		}
+		if purpose != "" {
+			c.JSON(http.StatusOK, mfaChallengeResponse(mfaToken, purpose, mfa.ChallengeTTL()))
+			return
+		}
+		guard.Succeed(ctx, user.Username)
+
+		// A new password must be chosen, through /api/password/reset, before logging in
+		if user.MustChangePassword {
+			respondPasswordChange(c, accounts, &user)
+			return
+		}
+
+		// Start a session, with the role included in the access token claims
+		pair, err := tokens.IssueTokens(&user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to create token"})
+			return
+		}
 
-// Register handler for user registration
-func Register(c *gin.Context) {
-	var registerDetails struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
-		Email    string `json:"email"`
-		Role	 string `json:"role"` //Possible roles: viewer, editor, admin
+		c.JSON(http.StatusOK, pair)
 	}
+}
 
-	if err := c.ShouldBindJSON(&registerDetails); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid input"})
+// respondPasswordChange responds with a reset_token for a user who has entered every factor but
+// must choose a new password before logging in.
+func respondPasswordChange(c *gin.Context, accounts *services.AccountService, user *models.User) {
+	resp, err := passwordChangeResponse(accounts, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to start password change"})
 		return
 	}
+	c.JSON(http.StatusOK, resp)
+}
 
-	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(registerDetails.Password), bcrypt.DefaultCost)
+// passwordChangeResponse returns the reset_token a user who must choose a new password gets
+// instead of a session.
+func passwordChangeResponse(accounts *services.AccountService, user *models.User) (gin.H, error) {
+	resetToken, ttl, err := accounts.StartRequiredPasswordChange(user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to hash password"})
-		return
+		return nil, err
 	}
+	return gin.H{"password_change_required": true, "reset_token": resetToken, "expires_in": int64(ttl / time.Second)}, nil
+}
 
-	role := registerDetails.Role
-	if role == "" {
-		role = "viewer"
+// checkLoginAllowed responds with 429 and reports false if guard refuses a login attempt.
+func checkLoginAllowed(c *gin.Context, guard *services.LoginGuard, username string) bool {
+	err := guard.Check(c.Request.Context(), username, c.ClientIP())
+	var throttled *services.ThrottledError
+	if errors.As(err, &throttled) {
+		c.Header("Retry-After", strconv.Itoa(int(throttled.RetryAfter.Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{"msg": throttled.Error()})
+		return false
 	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to check login attempts"})
+		return false
+	}
+	return true
+}
 
-	user := models.User{
-		Username: registerDetails.Username,
-		Password: string(hashedPassword),
-		Email:    registerDetails.Email,
-		Role:     role,
+// failLogin responds to a failed login attempt with 401 after the delay set by the guard. Other
+// attempts on the account are refused by the guard meanwhile.
+func failLogin(c *gin.Context, delay time.Duration, msg string) {
+	select {
+	case <-time.After(delay):
+	case <-c.Request.Context().Done():
 	}
+	c.JSON(http.StatusUnauthorized, gin.H{"msg": msg})
+}
 
-	if err := models.CreateUser(db.DB, &user); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to create user"})
-		return
+// Refresh handler for exchanging a refresh token for a new access token and refresh token.
+func Refresh(tokens *services.TokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid input"})
+			return
+		}
+
+		pair, err := tokens.Refresh(req.RefreshToken)
+		if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, gin.H{"msg": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to refresh token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, pair)
+	}
+}
+
+// Logout handler for ending the session a refresh token belongs to. Access tokens of the session
+// are listed as revoked until they expire.
+func Logout(tokens *services.TokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid input"})
+			return
+		}
+
+		if err := tokens.Logout(req.RefreshToken); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to log out"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"msg": "Logged out successfully"})
+	}
+}
+
+// RevokedSessions handler for listing the sessions whose access tokens must be rejected although
+// they have not expired. Services verifying access tokens poll it with a service account token and
+// reject tokens whose sid is listed.
+func RevokedSessions(tokens *services.TokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessions, err := tokens.RevokedSessions()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to list revoked sessions"})
+			return
+		}
+
+		revoked := make([]gin.H, len(sessions))
+		for i, session := range sessions {
+			revoked[i] = gin.H{"sid": session.FamilyID, "revoked_at": session.RevokedAt.Unix()}
+		}
+		c.JSON(http.StatusOK, gin.H{"sessions": revoked, "access_token_ttl": int64(tokens.AccessTTL() / time.Second)})
 	}
+}
+
+// Register handler for user registration. The account can be logged in to once the email address
+// is verified through the link sent to it. Only admins, identified by an access token, may choose
+// the role of the new account; everyone else registers as a viewer.
+func Register(tokens *services.TokenService, accounts *services.AccountService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var registerDetails struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Email    string `json:"email"`
+			Role     string `json:"role"` //Possible roles: viewer, editor, admin
+		}
 
-	c.JSON(http.StatusCreated, gin.H{"msg": "User registered successfully"})
-}
\ No newline at end of file
+		if err := c.ShouldBindJSON(&registerDetails); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid input"})
+			return
+		}
+
+		if registerDetails.Role != "" {
+			if c.GetHeader("Authorization") == "" {
+				c.JSON(http.StatusForbidden, gin.H{"msg": "Only admins can assign roles"})
+				return
+			}
+			if !authenticate(c, tokens) {
+				return
+			}
+			if currentUser(c).Role != models.RoleAdmin {
+				c.JSON(http.StatusForbidden, gin.H{"msg": "Only admins can assign roles"})
+				return
+			}
+		}
+
+		user, err := accounts.Register(c.Request.Context(), services.Registration{
+			Username: registerDetails.Username,
+			Password: registerDetails.Password,
+			Email:    registerDetails.Email,
+			Role:     registerDetails.Role,
+		})
+		var invalid *services.ValidationError
+		if errors.As(err, &invalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": invalid.Message, "field": invalid.Field})
+			return
+		}
+		if errors.Is(err, services.ErrUsernameTaken) || errors.Is(err, services.ErrEmailTaken) {
+			c.JSON(http.StatusConflict, gin.H{"msg": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to create user"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"msg": "User registered successfully, check your email to verify your address", "id": user.ID})
+	}
+}
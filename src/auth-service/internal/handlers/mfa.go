@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LoginMFA handler for the second step of logging in, which exchanges the mfa_token returned by
+// Login and a TOTP or recovery code for an access token and a refresh token. Codes are throttled
+// by guard, and wrong ones count as failed logins of the account. Users who must change their
+// password get a reset_token instead of tokens, as from Login.
+func LoginMFA(tokens *services.TokenService, mfa *services.MFAService, guard *services.LoginGuard, accounts *services.AccountService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			MFAToken string `json:"mfa_token"`
+			Code     string `json:"code"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil || req.MFAToken == "" || req.Code == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid input"})
+			return
+		}
+
+		// Codes are throttled like passwords, against the account the challenge belongs to
+		_, pending, err := mfa.Challenge(req.MFAToken, models.ChallengeVerify)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"msg": services.ErrInvalidMFAToken.Error()})
+			return
+		}
+		if !checkLoginAllowed(c, guard, pending.Username) {
+			return
+		}
+
+		ctx := c.Request.Context()
+		user, err := mfa.CompleteChallenge(req.MFAToken, req.Code)
+		if errors.Is(err, services.ErrInvalidMFACode) {
+			failLogin(c, guard.Fail(ctx, user, user.Username, c.ClientIP(), "wrong mfa code"), err.Error())
+			return
+		}
+		if errors.Is(err, services.ErrInvalidMFAToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{"msg": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to verify code"})
+			return
+		}
+		guard.Succeed(ctx, user.Username)
+
+		if user.MustChangePassword {
+			respondPasswordChange(c, accounts, user)
+			return
+		}
+
+		pair, err := tokens.IssueTokens(user)
+		if errors.Is(err, services.ErrAccountDisabled) {
+			c.JSON(http.StatusForbidden, gin.H{"msg": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to create token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, pair)
+	}
+}
+
+// EnrollMFA handler for starting TOTP enrollment. It returns the secret and the otpauth://
+// provisioning URI to show as a QR code. Signed in users enroll with their access token; users
+// whose role requires MFA enroll during login with the mfa_token returned by Login.
+func EnrollMFA(tokens *services.TokenService, mfa *services.MFAService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			MFAToken string `json:"mfa_token"`
+		}
+		// The body is optional for signed in users
+		_ = c.ShouldBindJSON(&req)
+
+		user, _, ok := mfaUser(c, tokens, mfa, req.MFAToken)
+		if !ok {
+			return
+		}
+
+		secret, uri, err := mfa.BeginEnrollment(user)
+		if errors.Is(err, services.ErrMFAAlreadyEnabled) {
+			c.JSON(http.StatusConflict, gin.H{"msg": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to start enrollment"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"secret": secret, "provisioning_uri": uri})
+	}
+}
+
+// VerifyMFAEnrollment handler for finishing TOTP enrollment with a code from the authenticator
+// app. It returns the recovery codes, which are only shown this once, along with a token pair when
+// enrolling during login, or a reset_token when the user must change their password first.
+func VerifyMFAEnrollment(tokens *services.TokenService, mfa *services.MFAService, accounts *services.AccountService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			MFAToken string `json:"mfa_token"`
+			Code     string `json:"code"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid input"})
+			return
+		}
+
+		user, challenge, ok := mfaUser(c, tokens, mfa, req.MFAToken)
+		if !ok {
+			return
+		}
+
+		var codes []string
+		var err error
+		if challenge != nil {
+			codes, err = mfa.FinishEnrollChallenge(challenge, user, req.Code)
+		} else {
+			codes, err = mfa.ConfirmEnrollment(user, req.Code)
+		}
+		if !respondMFAError(c, err, "Failed to verify code") {
+			return
+		}
+
+		resp := gin.H{"recovery_codes": codes}
+		if challenge != nil && user.MustChangePassword {
+			change, err := passwordChangeResponse(accounts, user)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to start password change"})
+				return
+			}
+			for name, value := range change {
+				resp[name] = value
+			}
+		} else if challenge != nil {
+			pair, err := tokens.IssueTokens(user)
+			if errors.Is(err, services.ErrAccountDisabled) {
+				c.JSON(http.StatusForbidden, gin.H{"msg": err.Error()})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to create token"})
+				return
+			}
+			resp["tokens"] = pair
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// DisableMFA handler for turning off MFA, confirmed with a current TOTP or recovery code.
+func DisableMFA(mfa *services.MFAService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Code string `json:"code"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid input"})
+			return
+		}
+
+		if !respondMFAError(c, mfa.Disable(currentUser(c), req.Code), "Failed to disable MFA") {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"msg": "MFA disabled"})
+	}
+}
+
+// RegenerateRecoveryCodes handler for replacing the recovery codes, confirmed with a current TOTP
+// or recovery code. The old codes stop working.
+func RegenerateRecoveryCodes(mfa *services.MFAService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Code string `json:"code"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid input"})
+			return
+		}
+
+		codes, err := mfa.RegenerateRecoveryCodes(currentUser(c), req.Code)
+		if !respondMFAError(c, err, "Failed to regenerate recovery codes") {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+	}
+}
+
+// mfaUser returns the user enrolling in MFA, identified either by an enroll challenge from Login
+// or by an access token, and responds with an error if there is neither.
+func mfaUser(c *gin.Context, tokens *services.TokenService, mfa *services.MFAService, mfaToken string) (*models.User, *models.MFAChallenge, bool) {
+	if mfaToken != "" {
+		challenge, user, err := mfa.Challenge(mfaToken, models.ChallengeEnroll)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"msg": err.Error()})
+			return nil, nil, false
+		}
+		return user, challenge, true
+	}
+
+	if !authenticate(c, tokens) {
+		return nil, nil, false
+	}
+	return currentUser(c), nil, true
+}
+
+// respondMFAError responds to errors of MFA operations and reports whether there was none.
+func respondMFAError(c *gin.Context, err error, msg string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrInvalidMFACode), errors.Is(err, services.ErrInvalidMFAToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"msg": err.Error()})
+	case errors.Is(err, services.ErrMFAAlreadyEnabled), errors.Is(err, services.ErrMFANotEnabled):
+		c.JSON(http.StatusConflict, gin.H{"msg": err.Error()})
+	case errors.Is(err, services.ErrMFAEnforced):
+		c.JSON(http.StatusForbidden, gin.H{"msg": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": msg})
+	}
+	return false
+}
+
+// mfaChallengeResponse is returned by Login instead of tokens when a second step is required.
+func mfaChallengeResponse(token, purpose string, ttl time.Duration) gin.H {
+	resp := gin.H{"mfa_token": token, "expires_in": int64(ttl / time.Second)}
+	if purpose == models.ChallengeEnroll {
+		resp["mfa_enrollment_required"] = true
+	} else {
+		resp["mfa_required"] = true
+	}
+	return resp
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"auth-service/internal/db"
+	"auth-service/internal/mail"
+	"auth-service/internal/models"
+	"auth-service/internal/ratelimit"
+	"auth-service/internal/services"
+	"auth-service/pkg"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB opens a migrated database in a temporary directory, which db.DB points at for the
+// rest of the test.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	if pkg.Logger == nil {
+		pkg.InitLogger()
+	}
+	gin.SetMode(gin.TestMode)
+
+	database, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "users.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := database.AutoMigrate(&models.User{}, &models.RefreshToken{}, &models.RecoveryCode{}, &models.MFAChallenge{}, &models.AccountToken{}, &models.AuthEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	previous := db.DB
+	db.DB = database
+	t.Cleanup(func() { db.DB = previous })
+	return database
+}
+
+// totpNow returns the current TOTP code of a base32 secret.
+func totpNow(t *testing.T, secret string) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var step [8]byte
+	binary.BigEndian.PutUint64(step[:], uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(step[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	return fmt.Sprintf("%06d", binary.BigEndian.Uint32(sum[offset:])&0x7fffffff%1000000)
+}
+
+// postJSON posts body to path and decodes the JSON response.
+func postJSON(t *testing.T, r *gin.Engine, path, body string) (int, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Invalid response %q: %v", w.Body, err)
+	}
+	return w.Code, resp
+}
+
+func TestPasswordChangeRequiresMFA(t *testing.T) {
+	db := newTestDB(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("bootstrap-pass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := &models.User{Username: "root", Email: "root@example.com", Password: string(hash), Role: models.RoleAdmin, EmailVerified: true, MustChangePassword: true, MFAEnabled: true, TOTPSecret: "JBSWY3DPEHPK3PXP"}
+	if err := models.CreateUser(db, admin); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := services.NewTokenService(db, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfa := services.NewMFAService(db, "File Picker", nil, time.Minute)
+	guard := services.NewLoginGuard(db, ratelimit.NewMemoryStore(time.Minute), mail.NewMemorySender(), services.LoginLimits{
+		Window: time.Minute, MaxAccountFailures: 10, MaxIPFailures: 10, Lockout: time.Minute, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond,
+	})
+	passwords, err := services.NewPasswordPolicy(8, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts := services.NewAccountService(db, mail.NewMemorySender(), passwords, "https://auth.example.com/", time.Hour, time.Hour)
+	r := gin.New()
+	r.POST("/api/login", Login(tokens, mfa, guard, accounts))
+	r.POST("/api/login/mfa", LoginMFA(tokens, mfa, guard, accounts))
+
+	// The password alone only leads to the second factor
+	code, resp := postJSON(t, r, "/api/login", `{"username": "root", "password": "bootstrap-pass"}`)
+	if code != http.StatusOK || resp["mfa_token"] == nil || resp["reset_token"] != nil {
+		t.Fatalf("Expected an MFA challenge, got %d: %v", code, resp)
+	}
+
+	code, resp = postJSON(t, r, "/api/login/mfa", `{"mfa_token": "`+resp["mfa_token"].(string)+`", "code": "`+totpNow(t, admin.TOTPSecret)+`"}`)
+	if code != http.StatusOK || resp["password_change_required"] != true || resp["reset_token"] == nil || resp["access_token"] != nil {
+		t.Errorf("Expected a reset token once the code is entered, got %d: %v", code, resp)
+	}
+}
+
+func TestLoginMFAIsThrottled(t *testing.T) {
+	db := newTestDB(t)
+	user := &models.User{Username: "alice", Email: "alice@example.com", Password: "unused", Role: models.RoleEditor, EmailVerified: true, MFAEnabled: true, TOTPSecret: "JBSWY3DPEHPK3PXP"}
+	if err := models.CreateUser(db, user); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := services.NewTokenService(db, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfa := services.NewMFAService(db, "File Picker", nil, time.Minute)
+	guard := services.NewLoginGuard(db, ratelimit.NewMemoryStore(time.Minute), mail.NewMemorySender(), services.LoginLimits{
+		Window: time.Minute, MaxAccountFailures: 1, MaxIPFailures: 10, Lockout: time.Minute, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond,
+	})
+	r := gin.New()
+	r.POST("/api/login/mfa", LoginMFA(tokens, mfa, guard, nil))
+
+	mfaToken, _, err := mfa.StartChallenge(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another attempt has locked the account out
+	guard.Fail(context.Background(), user, user.Username, "10.0.0.9", "wrong password")
+
+	for i := 0; i < 10; i++ {
+		body := strings.NewReader(`{"mfa_token": "` + mfaToken + `", "code": "123456"}`)
+		req := httptest.NewRequest(http.MethodPost, "/api/login/mfa", body)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+			t.Fatalf("Expected codes for a locked out account to be refused, got %d: %s", w.Code, w.Body)
+		}
+	}
+
+	// Refused attempts do not use up the challenge
+	var challenge models.MFAChallenge
+	if err := db.First(&challenge).Error; err != nil || challenge.Attempts != 0 {
+		t.Errorf("Expected the challenge to be unused, got %+v (%v)", challenge, err)
+	}
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"shared/authn"
+)
+
+// Context keys set by Authenticated
+const (
+	userKey   = "user"
+	claimsKey = "claims"
+)
+
+// Authenticated middleware for routes that require a valid access token in the Authorization
+// header. The authenticated user is available to handlers through currentUser.
+func Authenticated(tokens *services.TokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authenticate(c, tokens) {
+			c.Next()
+		}
+	}
+}
+
+// ServiceAuthenticated middleware for routes only the other services may call. It requires a
+// valid access token of a service account, usually one issued for its API key.
+func ServiceAuthenticated(tokens *services.TokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, claims, ok := verifyAccessToken(c, tokens)
+		if !ok {
+			return
+		}
+		if !user.ServiceAccount {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"msg": "Only service accounts may call this route"})
+			return
+		}
+
+		c.Set(userKey, user)
+		c.Set(claimsKey, claims)
+		c.Next()
+	}
+}
+
+// authenticate verifies the access token of a request and stores the user it was issued to, or
+// aborts the request and reports false.
+func authenticate(c *gin.Context, tokens *services.TokenService) bool {
+	user, claims, ok := verifyAccessToken(c, tokens)
+	if !ok {
+		return false
+	}
+
+	// Tokens issued for API keys are for the other services; they cannot manage accounts
+	if claims.Scope != "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"msg": "API keys cannot be used here"})
+		return false
+	}
+
+	c.Set(userKey, user)
+	c.Set(claimsKey, claims)
+	return true
+}
+
+// verifyAccessToken verifies the access token of a request and returns the user it was issued to,
+// or aborts the request and reports false.
+func verifyAccessToken(c *gin.Context, tokens *services.TokenService) (*models.User, *authn.Claims, bool) {
+	token, err := authn.BearerToken(c.GetHeader("Authorization"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "Missing access token"})
+		return nil, nil, false
+	}
+
+	user, claims, err := tokens.Authenticate(token)
+	if errors.Is(err, services.ErrInvalidAccessToken) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "Invalid access token"})
+		return nil, nil, false
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"msg": "Failed to verify access token"})
+		return nil, nil, false
+	}
+	return user, claims, true
+}
+
+// currentUser returns the user authenticated by Authenticated, if any.
+func currentUser(c *gin.Context) *models.User {
+	user, _ := c.Get(userKey)
+	u, _ := user.(*models.User)
+	return u
+}
+
+// currentClaims returns the access token claims verified by Authenticated, if any.
+func currentClaims(c *gin.Context) *authn.Claims {
+	claims, _ := c.Get(claimsKey)
+	cl, _ := claims.(*authn.Claims)
+	return cl
+}
+
+// RequireRole middleware for routes restricted to users with the given role. It must follow
+// Authenticated. The role is read from the user's account rather than the token, so that a
+// demotion takes effect at once.
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := currentUser(c)
+		if user == nil || user.Role != role {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"msg": "Insufficient role"})
+			return
+		}
+		c.Next()
+	}
+}
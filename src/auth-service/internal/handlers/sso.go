@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"auth-service/internal/oidc"
+	"auth-service/internal/services"
+	"auth-service/pkg"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SSOProviders handler for listing the identity providers users can log in with.
+func SSOProviders(sso *services.SSOService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"providers": sso.Providers()})
+	}
+}
+
+// ssoStateCookie holds the state of a login started in the browser, which the callback must carry.
+const ssoStateCookie = "sso_state"
+
+// SSOLogin handler for starting a login with an identity provider. It redirects the user to the
+// provider, which sends them back to SSOCallback, and keeps the login's state in a cookie so that
+// only this browser can complete it.
+func SSOLogin(sso *services.SSOService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authURL, state, err := sso.Begin(c.Request.Context(), c.Param("provider"))
+		if !respondSSOError(c, err, "Failed to start login") {
+			return
+		}
+		setSSOStateCookie(c, state, int(sso.StateTTL().Seconds()))
+		c.Redirect(http.StatusFound, authURL)
+	}
+}
+
+// SSOCallback handler for the redirect back from an identity provider. It logs in the local user
+// of the provider's subject, provisioning one on the first login, and responds like Login: with
+// an access token and a refresh token, or with an mfa_token if local MFA is required.
+func SSOCallback(tokens *services.TokenService, mfa *services.MFAService, sso *services.SSOService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider := c.Param("provider")
+		browserState, _ := c.Cookie(ssoStateCookie)
+		setSSOStateCookie(c, "", -1)
+		if providerError := c.Query("error"); providerError != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"msg": "Identity provider refused the login", "error": providerError, "error_description": c.Query("error_description")})
+			return
+		}
+		code, state := c.Query("code"), c.Query("state")
+		if code == "" || state == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid input"})
+			return
+		}
+
+		user, err := sso.Complete(c.Request.Context(), provider, state, browserState, code)
+		if !respondSSOError(c, err, "Failed to log in") {
+			return
+		}
+		if user.Disabled {
+			c.JSON(http.StatusForbidden, gin.H{"msg": "Account disabled"})
+			return
+		}
+
+		if !sso.TrustsMFA(provider) {
+			mfaToken, purpose, err := mfa.StartChallenge(user)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to start MFA challenge"})
+				return
+			}
+			if purpose != "" {
+				c.JSON(http.StatusOK, mfaChallengeResponse(mfaToken, purpose, mfa.ChallengeTTL()))
+				return
+			}
+		}
+
+		pair, err := tokens.IssueTokens(user)
+		if errors.Is(err, services.ErrAccountDisabled) {
+			c.JSON(http.StatusForbidden, gin.H{"msg": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to create token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, pair)
+	}
+}
+
+// setSSOStateCookie sets the state cookie, which only the provider's callback is sent. It is sent on
+// the top-level redirect back from the provider, but not on requests other sites make. A negative
+// maxAge deletes it.
+func setSSOStateCookie(c *gin.Context, state string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoStateCookie, state, maxAge, "/api/sso/"+c.Param("provider")+"/callback", "", true, true)
+}
+
+// respondSSOError responds to errors of SSO logins and reports whether there was none.
+func respondSSOError(c *gin.Context, err error, msg string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrUnknownProvider):
+		c.JSON(http.StatusNotFound, gin.H{"msg": err.Error()})
+	case errors.Is(err, services.ErrInvalidSSOState):
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+	case errors.Is(err, oidc.ErrInvalidIDToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"msg": oidc.ErrInvalidIDToken.Error()})
+	case errors.Is(err, services.ErrSSOEmailMissing):
+		c.JSON(http.StatusForbidden, gin.H{"msg": err.Error()})
+	case errors.Is(err, services.ErrSSOEmailTaken):
+		c.JSON(http.StatusConflict, gin.H{"msg": err.Error()})
+	case errors.Is(err, services.ErrProviderFailed):
+		pkg.Logger.Warnf("SSO login with %s failed: %v", c.Param("provider"), err)
+		c.JSON(http.StatusBadGateway, gin.H{"msg": services.ErrProviderFailed.Error()})
+	default:
+		pkg.Logger.Errorf("SSO login with %s failed: %v", c.Param("provider"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": msg})
+	}
+	return false
+}
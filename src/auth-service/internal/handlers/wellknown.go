@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"auth-service/pkg"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS handler for publishing the public keys tokens are verified with. Verifiers cache the set,
+// so a new key is published some time before it starts signing tokens.
+func JWKS(c *gin.Context) {
+	keys := make([]pkg.JWK, len(pkg.Keys.Keys))
+	for i, key := range pkg.Keys.Keys {
+		keys[i] = key.JWK()
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// Discovery handler for the OpenID Connect discovery document, which points verifiers at the JWKS.
+func Discovery(issuer, publicURL string) gin.HandlerFunc {
+	baseURL := strings.TrimSuffix(publicURL, "/")
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, gin.H{
+			"issuer":                                issuer,
+			"jwks_uri":                              baseURL + "/.well-known/jwks.json",
+			"response_types_supported":              []string{"token"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": pkg.Keys.Algorithms(),
+			"claims_supported":                      []string{"iss", "aud", "exp", "iat", "sid", "userId", "username", "email", "role"},
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package mail
+
+import (
+	"context"
+	"sync"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers emails. SMTPSender sends them through a mail server; MemorySender keeps them
+// for tests and for running without one.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// MemorySender keeps the messages it is given instead of sending them.
+type MemorySender struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+// NewMemorySender creates an empty MemorySender.
+func NewMemorySender() *MemorySender {
+	return &MemorySender{}
+}
+
+// Send records a message.
+func (s *MemorySender) Send(ctx context.Context, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+// Sent returns the messages recorded so far, oldest first.
+func (s *MemorySender) Sent() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.sent...)
+}
+
+// Reset forgets the messages recorded so far.
+func (s *MemorySender) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = nil
+}
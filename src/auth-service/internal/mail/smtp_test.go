@@ -0,0 +1,74 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// plainSMTPServer accepts one connection and answers like a mail server that does not offer
+// STARTTLS. It returns the address to connect to and the commands it received.
+func plainSMTPServer(t *testing.T) (string, <-chan []string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	commands := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var received []string
+		defer func() { commands <- received }()
+		reader := bufio.NewReader(conn)
+		conn.Write([]byte("220 mail.example.com ESMTP\r\n"))
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.Fields(line + " ")[0])
+			received = append(received, command)
+			switch command {
+			case "EHLO":
+				conn.Write([]byte("250-mail.example.com\r\n250 AUTH PLAIN\r\n"))
+			case "QUIT":
+				conn.Write([]byte("221 bye\r\n"))
+				return
+			default:
+				conn.Write([]byte("250 ok\r\n"))
+			}
+		}
+	}()
+	return listener.Addr().String(), commands
+}
+
+func TestSMTPSenderRequiresSTARTTLS(t *testing.T) {
+	addr, commands := plainSMTPServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+	portNumber, _ := strconv.Atoi(port)
+	sender := NewSMTPSender(SMTPConfig{Host: host, Port: portNumber, Username: "mailer", Password: "secret", From: "no-reply@example.com"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := sender.Send(ctx, Message{To: "alice@example.com", Subject: "Hi", Body: "Hello"})
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("Expected sending without STARTTLS to fail, got %v", err)
+	}
+
+	for _, command := range <-commands {
+		if command == "AUTH" || command == "MAIL" || command == "DATA" {
+			t.Errorf("Expected nothing to be sent in the clear, got %s", command)
+		}
+	}
+}
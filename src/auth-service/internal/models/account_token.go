@@ -0,0 +1,53 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Account token purposes
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+)
+
+// AccountToken is a single-use, expiring token sent to a user by email to verify their address
+// or reset their password. Only a hash of it is stored.
+type AccountToken struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"not null;index"`
+	Purpose   string     `gorm:"not null"`
+	TokenHash string     `gorm:"unique;not null"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time // Set once the token has been used
+	CreatedAt time.Time
+}
+
+// CreateAccountToken stores a new account token.
+func CreateAccountToken(db *gorm.DB, token *AccountToken) error {
+	return db.Create(token).Error
+}
+
+// GetAccountTokenByHash fetches an account token by the hash of its value.
+func GetAccountTokenByHash(db *gorm.DB, tokenHash string, token *AccountToken) error {
+	result := db.Where("token_hash = ?", tokenHash).First(token)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return errors.New("account token not found")
+	}
+	return result.Error
+}
+
+// UseAccountToken marks an account token as used. It reports false if the token was used
+// already, so that concurrent requests cannot both use it.
+func UseAccountToken(db *gorm.DB, id uint, now time.Time) (bool, error) {
+	result := db.Model(&AccountToken{}).Where("id = ? AND used_at IS NULL", id).Update("used_at", now)
+	return result.RowsAffected == 1, result.Error
+}
+
+// DeleteAccountTokens removes a user's tokens with the given purpose, invalidating any that were
+// sent earlier.
+func DeleteAccountTokens(db *gorm.DB, userID uint, purpose string) error {
+	return db.Where("user_id = ? AND purpose = ?", userID, purpose).Delete(&AccountToken{}).Error
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIKey is a long-lived credential of a user or service account for automation, exchanged for
+// short-lived access tokens. Only a hash of the key is stored; Prefix identifies it in listings.
+type APIKey struct {
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"not null;index"`
+	Name       string `gorm:"not null"`
+	Prefix     string `gorm:"not null"`
+	KeyHash    string `gorm:"unique;not null"`
+	SessionID  string `gorm:"unique;not null"` // sid of the access tokens issued for the key
+	Role       string // Role the key acts with, at most the user's; the user's role when empty
+	Scopes     string `gorm:"not null"` // Space-separated scopes the key's access tokens carry
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time `gorm:"index"`
+	LastUsedAt *time.Time
+	LastUsedIP string
+	CreatedBy  uint // User who created the key, an admin for service account keys
+	CreatedAt  time.Time
+}
+
+// CreateAPIKey stores a new API key.
+func CreateAPIKey(db *gorm.DB, key *APIKey) error {
+	return db.Create(key).Error
+}
+
+// GetAPIKeyByHash fetches an API key by the hash of the key.
+func GetAPIKeyByHash(db *gorm.DB, keyHash string, key *APIKey) error {
+	result := db.Where("key_hash = ?", keyHash).First(key)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return errors.New("api key not found")
+	}
+	return result.Error
+}
+
+// GetUserAPIKey fetches an API key of a user by ID.
+func GetUserAPIKey(db *gorm.DB, userID, keyID uint, key *APIKey) error {
+	result := db.Where("id = ? AND user_id = ?", keyID, userID).First(key)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return errors.New("api key not found")
+	}
+	return result.Error
+}
+
+// ListUserAPIKeys lists a user's API keys, newest first.
+func ListUserAPIKeys(db *gorm.DB, userID uint) ([]APIKey, error) {
+	var keys []APIKey
+	err := db.Where("user_id = ?", userID).Order("id DESC").Find(&keys).Error
+	return keys, err
+}
+
+// RevokeAPIKey revokes an API key. Revoking a revoked key does nothing.
+func RevokeAPIKey(db *gorm.DB, keyID uint, now time.Time) error {
+	return db.Model(&APIKey{}).Where("id = ? AND revoked_at IS NULL", keyID).Update("revoked_at", now).Error
+}
+
+// RevokeUserAPIKeys revokes every API key of a user.
+func RevokeUserAPIKeys(db *gorm.DB, userID uint, now time.Time) error {
+	return db.Model(&APIKey{}).Where("user_id = ? AND revoked_at IS NULL", userID).Update("revoked_at", now).Error
+}
+
+// TouchAPIKey records the use of an API key.
+func TouchAPIKey(db *gorm.DB, keyID uint, ip string, now time.Time) error {
+	return db.Model(&APIKey{}).Where("id = ?", keyID).
+		Updates(map[string]interface{}{"last_used_at": now, "last_used_ip": ip}).Error
+}
+
+// ListRevokedAPIKeySessions lists the sessions of API keys revoked after the given time.
+func ListRevokedAPIKeySessions(db *gorm.DB, since time.Time) ([]RevokedSession, error) {
+	var keys []APIKey
+	err := db.Select("session_id", "revoked_at").Where("revoked_at > ?", since).Order("id").Find(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]RevokedSession, len(keys))
+	for i, key := range keys {
+		sessions[i] = RevokedSession{FamilyID: key.SessionID, RevokedAt: *key.RevokedAt}
+	}
+	return sessions, nil
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Authentication event types
+const (
+	EventLoginFailed    = "login_failed"    // Wrong username, password or MFA code
+	EventLoginThrottled = "login_throttled" // Attempt refused by the rate limits
+	EventAccountLocked  = "account_locked"  // Account locked out after too many failures
+)
+
+// AuthEvent is an entry of the append-only log of security-relevant authentication events.
+type AuthEvent struct {
+	ID        uint   `gorm:"primaryKey"`
+	Type      string `gorm:"not null;index"`
+	UserID    uint   `gorm:"index"` // Zero when the username does not belong to an account
+	Username  string `gorm:"index"`
+	ClientIP  string
+	Detail    string
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// RecordAuthEvent appends an event to the log.
+func RecordAuthEvent(db *gorm.DB, event *AuthEvent) error {
+	return db.Create(event).Error
+}
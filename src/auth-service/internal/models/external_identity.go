@@ -0,0 +1,74 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ExternalIdentity links a user to their account at an OpenID provider, identified by the
+// provider's subject claim.
+type ExternalIdentity struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"not null;index"`
+	Provider    string `gorm:"not null;uniqueIndex:idx_external_identity"`
+	Subject     string `gorm:"not null;uniqueIndex:idx_external_identity"`
+	Email       string // Email claim at the last login
+	LastLoginAt time.Time
+	CreatedAt   time.Time
+}
+
+// SSOState is a login started with an OpenID provider and not yet completed. Only a hash of the
+// state sent to the provider is stored.
+type SSOState struct {
+	ID           uint   `gorm:"primaryKey"`
+	StateHash    string `gorm:"unique;not null"`
+	Provider     string `gorm:"not null"`
+	Nonce        string `gorm:"not null"`
+	CodeVerifier string `gorm:"not null"` // PKCE verifier, sent with the authorization code
+	ExpiresAt    time.Time
+	CreatedAt    time.Time
+}
+
+// GetExternalIdentity fetches the identity of a provider's subject.
+func GetExternalIdentity(db *gorm.DB, provider, subject string, identity *ExternalIdentity) error {
+	result := db.Where("provider = ? AND subject = ?", provider, subject).First(identity)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return errors.New("identity not found")
+	}
+	return result.Error
+}
+
+// CreateExternalIdentity links a provider's subject to a user.
+func CreateExternalIdentity(db *gorm.DB, identity *ExternalIdentity) error {
+	return db.Create(identity).Error
+}
+
+// RecordExternalLogin stores the time and email claim of a login through an external identity.
+func RecordExternalLogin(db *gorm.DB, identityID uint, email string, now time.Time) error {
+	return db.Model(&ExternalIdentity{}).Where("id = ?", identityID).
+		Updates(map[string]interface{}{"email": email, "last_login_at": now}).Error
+}
+
+// CreateSSOState stores a started login, dropping expired ones.
+func CreateSSOState(db *gorm.DB, state *SSOState) error {
+	if err := db.Where("expires_at <= ?", time.Now()).Delete(&SSOState{}).Error; err != nil {
+		return err
+	}
+	return db.Create(state).Error
+}
+
+// TakeSSOState fetches and deletes the started login with the given state hash, so that each
+// state is only used once. It reports false if there is none.
+func TakeSSOState(db *gorm.DB, stateHash string, state *SSOState) (bool, error) {
+	result := db.Where("state_hash = ?", stateHash).First(state)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if result.Error != nil {
+		return false, result.Error
+	}
+	deleted := db.Delete(&SSOState{}, state.ID)
+	return deleted.RowsAffected == 1, deleted.Error
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MFA challenge purposes
+const (
+	ChallengeVerify = "verify" // The user must enter a code to finish logging in
+	ChallengeEnroll = "enroll" // The user must enroll in MFA to finish logging in
+)
+
+// RecoveryCode is a single-use code that stands in for a TOTP code. Only a hash of it is stored.
+type RecoveryCode struct {
+	ID       uint   `gorm:"primaryKey"`
+	UserID   uint   `gorm:"not null;index"`
+	CodeHash string `gorm:"not null"`
+	UsedAt   *time.Time
+}
+
+// MFAChallenge is a pending login that has passed the password check and still needs a second
+// factor. Only a hash of its token is stored.
+type MFAChallenge struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"not null;index"`
+	TokenHash string    `gorm:"unique;not null"`
+	Purpose   string    `gorm:"not null"`
+	Attempts  int       `gorm:"not null;default:0"` // Code entries
+	ExpiresAt time.Time `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+// ReplaceRecoveryCodes replaces a user's recovery codes.
+func ReplaceRecoveryCodes(db *gorm.DB, userID uint, codeHashes []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]RecoveryCode, len(codeHashes))
+		for i, hash := range codeHashes {
+			codes[i] = RecoveryCode{UserID: userID, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// UseRecoveryCode marks an unused recovery code of a user as used. It reports false if the user
+// has no such unused code.
+func UseRecoveryCode(db *gorm.DB, userID uint, codeHash string, now time.Time) (bool, error) {
+	result := db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", now)
+	return result.RowsAffected == 1, result.Error
+}
+
+// DeleteRecoveryCodes removes a user's recovery codes.
+func DeleteRecoveryCodes(db *gorm.DB, userID uint) error {
+	return db.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+}
+
+// CreateMFAChallenge stores a new MFA challenge.
+func CreateMFAChallenge(db *gorm.DB, challenge *MFAChallenge) error {
+	return db.Create(challenge).Error
+}
+
+// GetMFAChallengeByHash fetches an MFA challenge by the hash of its token.
+func GetMFAChallengeByHash(db *gorm.DB, tokenHash string, challenge *MFAChallenge) error {
+	result := db.Where("token_hash = ?", tokenHash).First(challenge)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return errors.New("mfa challenge not found")
+	}
+	return result.Error
+}
+
+// ClaimMFAChallengeAttempt counts a code entry for a challenge before the code is checked. It
+// reports false if the challenge has expired or used up its maxAttempts, so that concurrent
+// entries cannot get past the limit.
+func ClaimMFAChallengeAttempt(db *gorm.DB, id uint, maxAttempts int, now time.Time) (bool, error) {
+	result := db.Model(&MFAChallenge{}).
+		Where("id = ? AND attempts < ? AND expires_at > ?", id, maxAttempts, now).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	return result.RowsAffected == 1, result.Error
+}
+
+// DeleteMFAChallenge removes an MFA challenge once it has been completed or abandoned.
+func DeleteMFAChallenge(db *gorm.DB, id uint) error {
+	return db.Delete(&MFAChallenge{}, id).Error
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RefreshToken is a single-use token that is exchanged for a new access token and a new refresh
+// token. The tokens descending from one login form a family, which is the user's session; only a
+// hash of each token is stored.
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"not null;index"`
+	FamilyID  string     `gorm:"not null;index"`
+	TokenHash string     `gorm:"unique;not null"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time // Set once the token has been exchanged
+	RevokedAt *time.Time `gorm:"index"` // Set when the session is logged out or the token family is revoked
+	CreatedAt time.Time
+}
+
+// RevokedSession is a session whose access tokens must no longer be accepted.
+type RevokedSession struct {
+	FamilyID  string
+	RevokedAt time.Time
+}
+
+// CreateRefreshToken stores a new refresh token.
+func CreateRefreshToken(db *gorm.DB, token *RefreshToken) error {
+	return db.Create(token).Error
+}
+
+// GetRefreshTokenByHash fetches a refresh token by the hash of its value.
+func GetRefreshTokenByHash(db *gorm.DB, tokenHash string, token *RefreshToken) error {
+	result := db.Where("token_hash = ?", tokenHash).First(token)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return errors.New("refresh token not found")
+	}
+	return result.Error
+}
+
+// MarkRefreshTokenUsed marks a refresh token as exchanged. It reports false if the token had
+// already been used or revoked, so that a token is only ever exchanged once.
+func MarkRefreshTokenUsed(db *gorm.DB, id uint, now time.Time) (bool, error) {
+	result := db.Model(&RefreshToken{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", id).
+		Update("used_at", now)
+	return result.RowsAffected == 1, result.Error
+}
+
+// RevokeRefreshTokenFamily revokes every token of a session.
+func RevokeRefreshTokenFamily(db *gorm.DB, familyID string, now time.Time) error {
+	return db.Model(&RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", now).Error
+}
+
+// RevokeUserRefreshTokens revokes every session of a user.
+func RevokeUserRefreshTokens(db *gorm.DB, userID uint, now time.Time) error {
+	return db.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
+}
+
+// RevokeOtherRefreshTokens revokes every session of a user except the given one.
+func RevokeOtherRefreshTokens(db *gorm.DB, userID uint, keepFamilyID string, now time.Time) error {
+	return db.Model(&RefreshToken{}).
+		Where("user_id = ? AND family_id <> ? AND revoked_at IS NULL", userID, keepFamilyID).
+		Update("revoked_at", now).Error
+}
+
+// DeleteExpiredRefreshTokens removes a user's refresh tokens that expired before the given time.
+func DeleteExpiredRefreshTokens(db *gorm.DB, userID uint, before time.Time) error {
+	return db.Where("user_id = ? AND expires_at < ?", userID, before).Delete(&RefreshToken{}).Error
+}
+
+// ListRevokedSessions lists the sessions revoked after the given time.
+func ListRevokedSessions(db *gorm.DB, since time.Time) ([]RevokedSession, error) {
+	var tokens []RefreshToken
+	err := db.Select("family_id", "revoked_at").Where("revoked_at > ?", since).Order("id").Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// A family's tokens are revoked together, so any one of them gives the session's revocation time
+	seen := make(map[string]bool)
+	var sessions []RevokedSession
+	for _, token := range tokens {
+		if !seen[token.FamilyID] {
+			seen[token.FamilyID] = true
+			sessions = append(sessions, RevokedSession{FamilyID: token.FamilyID, RevokedAt: *token.RevokedAt})
+		}
+	}
+	return sessions, nil
+}
+
+// IsSessionRevoked reports whether a session has been revoked.
+func IsSessionRevoked(db *gorm.DB, familyID string) (bool, error) {
+	var count int64
+	err := db.Model(&RefreshToken{}).Where("family_id = ? AND revoked_at IS NOT NULL", familyID).Count(&count).Error
+	return count > 0, err
+}
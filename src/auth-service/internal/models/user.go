@@ -2,6 +2,9 @@ package models
 
 import (
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -13,11 +16,117 @@ type User struct {
     Password  string `gorm:"not null"`
     Email     string `gorm:"unique;not null"`
     Role      string `gorm:"not null"` // Role field
+    MFAEnabled   bool   `gorm:"not null;default:false"` // Set once TOTP enrollment is confirmed
+    TOTPSecret   string // Base32 TOTP secret, set when enrollment starts
+    TOTPLastStep int64  // Time step of the last accepted TOTP code, so that codes cannot be replayed
+    EmailVerified bool  `gorm:"not null;default:false"` // Set once the user follows the verification link
+    Disabled      bool  `gorm:"not null;default:false"` // Disabled accounts cannot log in or refresh tokens
+    MustChangePassword bool `gorm:"not null;default:false"` // Set by admins and for the bootstrap admin; cleared when the password is set
+    ServiceAccount     bool `gorm:"not null;default:false"` // Accounts for automation, which only authenticate with API keys
+}
+
+// Roles
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// ValidRole reports whether role is one of the roles users can have.
+func ValidRole(role string) bool {
+	return role == RoleViewer || role == RoleEditor || role == RoleAdmin
+}
+
+// RoleRank orders roles by privilege, from 1 for viewers to 3 for admins. Unknown roles rank 0.
+func RoleRank(role string) int {
+	switch role {
+	case RoleViewer:
+		return 1
+	case RoleEditor:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+// UserQuery filters a user listing. Zero-valued filters are not applied.
+type UserQuery struct {
+	Search         string // Matched against the start of the username or email
+	Role           string
+	Disabled       *bool
+	ServiceAccount *bool
+}
+
+// NormalizeUsername returns the form usernames are stored and looked up in, so that they are
+// unique regardless of case.
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// NormalizeEmail returns the form email addresses are stored and looked up in.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// MigrateEmailVerified adds the email_verified column to an existing users table. Users created
+// before addresses were verified are marked verified, so that they can still log in. It must run
+// before the table is auto-migrated, which would add the column unset.
+func MigrateEmailVerified(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&User{}) || migrator.HasColumn(&User{}, "EmailVerified") {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Migrator().AddColumn(&User{}, "EmailVerified"); err != nil {
+			return err
+		}
+		return tx.Model(&User{}).Where("1 = 1").Update("email_verified", true).Error
+	})
+}
+
+// NormalizeUserKeys stores the usernames and email addresses of existing users in normalized form.
+// Lookups normalize their input, so a user left unnormalized could no longer log in. If any user's
+// normalized username or email would clash with another user's, nothing is changed and an error
+// naming them is returned; they must be renamed by hand before the service can start.
+func NormalizeUserKeys(db *gorm.DB) error {
+	var users []User
+	if err := db.Where("username <> LOWER(TRIM(username)) OR email <> LOWER(TRIM(email))").Find(&users).Error; err != nil {
+		return err
+	}
+
+	var clashes []string
+	for _, user := range users {
+		username, email := NormalizeUsername(user.Username), NormalizeEmail(user.Email)
+		var count int64
+		err := db.Model(&User{}).Where("id <> ? AND (LOWER(TRIM(username)) = ? OR LOWER(TRIM(email)) = ?)", user.ID, username, email).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			clashes = append(clashes, fmt.Sprintf("%d (%s, %s)", user.ID, user.Username, user.Email))
+		}
+	}
+	if len(clashes) > 0 {
+		return fmt.Errorf("users %s clash with other users once their username or email is lowercased and must be renamed",
+			strings.Join(clashes, ", "))
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, user := range users {
+			err := tx.Model(&User{}).Where("id = ?", user.ID).
+				Updates(map[string]interface{}{"username": NormalizeUsername(user.Username), "email": NormalizeEmail(user.Email)}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 // GetUserByUsername fetches a user by username from the database.
 func GetUserByUsername(db *gorm.DB, username string, user *User) error {
-	result := db.Where("username = ?", username).First(user)
+	result := db.Where("username = ?", NormalizeUsername(username)).First(user)
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		return errors.New("user not found")
 	}
@@ -27,4 +136,123 @@ func GetUserByUsername(db *gorm.DB, username string, user *User) error {
 // CreateUser creates a new user in the database.
 func CreateUser(db *gorm.DB, user *User) error {
 	return db.Create(user).Error
-}
\ No newline at end of file
+}
+
+// GetUserByID fetches a user by ID from the database.
+func GetUserByID(db *gorm.DB, id uint, user *User) error {
+	result := db.First(user, id)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return errors.New("user not found")
+	}
+	return result.Error
+}
+
+// UpdateUserMFA stores a user's MFA settings.
+func UpdateUserMFA(db *gorm.DB, user *User) error {
+	return db.Model(user).Select("mfa_enabled", "totp_secret", "totp_last_step").Updates(user).Error
+}
+
+// AcceptTOTPStep records the time step of an accepted TOTP code. It reports false if a code of
+// that or a later step was accepted already, so that each code is only used once.
+func AcceptTOTPStep(db *gorm.DB, userID uint, step int64) (bool, error) {
+	result := db.Model(&User{}).Where("id = ? AND totp_last_step < ?", userID, step).Update("totp_last_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+// GetUserByEmail fetches a user by email address from the database.
+func GetUserByEmail(db *gorm.DB, email string, user *User) error {
+	result := db.Where("email = ?", NormalizeEmail(email)).First(user)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return errors.New("user not found")
+	}
+	return result.Error
+}
+
+// UpdateUserPassword stores a new password hash for a user, which fulfils any pending request to
+// change the password.
+func UpdateUserPassword(db *gorm.DB, userID uint, passwordHash string) error {
+	return db.Model(&User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{"password": passwordHash, "must_change_password": false}).Error
+}
+
+// MarkEmailVerified records that a user has verified their email address.
+func MarkEmailVerified(db *gorm.DB, userID uint) error {
+	return db.Model(&User{}).Where("id = ?", userID).Update("email_verified", true).Error
+}
+
+// SearchUsers lists the page of users matching the query, ordered by ID, along with the number of
+// matching users across all pages.
+func SearchUsers(db *gorm.DB, query UserQuery, offset, limit int) ([]User, int64, error) {
+	tx := db.Model(&User{})
+	if query.Search != "" {
+		prefix := likeEscaper.Replace(query.Search) + "%"
+		tx = tx.Where(`(username LIKE ? ESCAPE '\' OR email LIKE ? ESCAPE '\')`, prefix, prefix)
+	}
+	if query.Role != "" {
+		tx = tx.Where("role = ?", query.Role)
+	}
+	if query.Disabled != nil {
+		tx = tx.Where("disabled = ?", *query.Disabled)
+	}
+	if query.ServiceAccount != nil {
+		tx = tx.Where("service_account = ?", *query.ServiceAccount)
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var users []User
+	err := tx.Order("id").Offset(offset).Limit(limit).Find(&users).Error
+	return users, total, err
+}
+
+// likeEscaper escapes the LIKE wildcards in a literal pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// CountActiveAdmins returns the number of enabled admin accounts of people, not counting service
+// accounts.
+func CountActiveAdmins(db *gorm.DB) (int64, error) {
+	var count int64
+	err := db.Model(&User{}).Where("role = ? AND disabled = ? AND service_account = ?", RoleAdmin, false, false).Count(&count).Error
+	return count, err
+}
+
+// UpdateUserRole changes a user's role.
+func UpdateUserRole(db *gorm.DB, userID uint, role string) error {
+	return db.Model(&User{}).Where("id = ?", userID).Update("role", role).Error
+}
+
+// SetUserDisabled disables or re-enables a user's account.
+func SetUserDisabled(db *gorm.DB, userID uint, disabled bool) error {
+	return db.Model(&User{}).Where("id = ?", userID).Update("disabled", disabled).Error
+}
+
+// SetMustChangePassword requires a user to choose a new password at their next login.
+func SetMustChangePassword(db *gorm.DB, userID uint) error {
+	return db.Model(&User{}).Where("id = ?", userID).Update("must_change_password", true).Error
+}
+
+// RevokeUserCredentials revokes every session and API key of a user, so that nothing issued
+// before keeps working.
+func RevokeUserCredentials(db *gorm.DB, userID uint, now time.Time) error {
+	if err := RevokeUserRefreshTokens(db, userID, now); err != nil {
+		return err
+	}
+	return RevokeUserAPIKeys(db, userID, now)
+}
+
+// DeleteUser removes a user along with their MFA, account tokens and external identities. Their
+// refresh tokens and API keys are revoked rather than deleted, so that their sessions stay listed
+// as revoked until the access tokens expire.
+func DeleteUser(db *gorm.DB, userID uint, now time.Time) error {
+	if err := RevokeUserCredentials(db, userID, now); err != nil {
+		return err
+	}
+	for _, model := range []interface{}{&RecoveryCode{}, &MFAChallenge{}, &AccountToken{}, &ExternalIdentity{}} {
+		if err := db.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return db.Delete(&User{}, userID).Error
+}
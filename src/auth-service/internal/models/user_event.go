@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// User event types
+const (
+	EventUserDeleted = "user.deleted"
+)
+
+// UserEventDelivery is a user event waiting to be delivered to one webhook endpoint. Deliveries
+// are written in the same transaction as the change they announce, so that no event is lost, and
+// retried until the endpoint accepts them.
+type UserEventDelivery struct {
+	ID            uint      `gorm:"primaryKey"`
+	EventID       string    `gorm:"not null;index"` // Shared by the deliveries of one event, so that receivers can deduplicate
+	Endpoint      string    `gorm:"not null"`
+	Payload       string    `gorm:"type:text;not null"` // JSON body of the webhook
+	Attempts      int       `gorm:"not null;default:0"`
+	NextAttemptAt time.Time `gorm:"not null;index"`
+	DeliveredAt   *time.Time
+	FailedAt      *time.Time // Set when the delivery is given up on
+	LastError     string
+	CreatedAt     time.Time
+}
+
+// CreateUserEventDeliveries stores new deliveries.
+func CreateUserEventDeliveries(db *gorm.DB, deliveries []UserEventDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return db.Create(&deliveries).Error
+}
+
+// ListDueUserEventDeliveries retrieves the pending deliveries whose next attempt is due, oldest
+// first.
+func ListDueUserEventDeliveries(db *gorm.DB, now time.Time, limit int) ([]UserEventDelivery, error) {
+	var deliveries []UserEventDelivery
+	err := db.Where("delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?", now).
+		Order("id").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+// SaveUserEventDelivery persists the outcome of a delivery attempt.
+func SaveUserEventDelivery(db *gorm.DB, delivery *UserEventDelivery) error {
+	return db.Save(delivery).Error
+}
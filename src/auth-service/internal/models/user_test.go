@@ -0,0 +1,125 @@
+package models
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB opens an empty database in a temporary directory.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "users.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+func TestMigrateEmailVerifiedMarksExistingUsers(t *testing.T) {
+	db := openTestDB(t)
+	// The users table as it was before email verification
+	err := db.Exec(`CREATE TABLE users (id integer PRIMARY KEY, username text NOT NULL UNIQUE, password text NOT NULL,
+		email text NOT NULL UNIQUE, role text NOT NULL)`).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(`INSERT INTO users (username, password, email, role) VALUES ('alice', 'x', 'alice@example.com', 'editor')`).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MigrateEmailVerified(db); err != nil {
+		t.Fatalf("MigrateEmailVerified failed: %v", err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatal(err)
+	}
+	bob := &User{Username: "bob", Password: "x", Email: "bob@example.com", Role: RoleViewer}
+	if err := CreateUser(db, bob); err != nil {
+		t.Fatal(err)
+	}
+
+	var alice User
+	if err := GetUserByUsername(db, "alice", &alice); err != nil {
+		t.Fatal(err)
+	}
+	if !alice.EmailVerified {
+		t.Error("Expected the existing user to be marked verified")
+	}
+	if err := GetUserByID(db, bob.ID, bob); err != nil || bob.EmailVerified {
+		t.Errorf("Expected a new user to be unverified, got %+v (%v)", bob, err)
+	}
+
+	// Running it again leaves the new user unverified
+	if err := MigrateEmailVerified(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := GetUserByID(db, bob.ID, bob); err != nil || bob.EmailVerified {
+		t.Errorf("Expected a second run to change nothing, got %+v (%v)", bob, err)
+	}
+}
+
+func TestMigrateEmailVerifiedSkipsNewDatabase(t *testing.T) {
+	db := openTestDB(t)
+	if err := MigrateEmailVerified(db); err != nil {
+		t.Fatalf("MigrateEmailVerified failed: %v", err)
+	}
+	if db.Migrator().HasTable(&User{}) {
+		t.Error("Expected the table to be left to the auto-migration")
+	}
+}
+
+func TestNormalizeUserKeys(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatal(err)
+	}
+	// Users stored before usernames and emails were normalized
+	alice := &User{Username: " Alice", Password: "x", Email: "Alice@Example.com", Role: RoleEditor}
+	if err := CreateUser(db, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NormalizeUserKeys(db); err != nil {
+		t.Fatalf("NormalizeUserKeys failed: %v", err)
+	}
+	var stored User
+	if err := GetUserByUsername(db, "ALICE", &stored); err != nil || stored.Email != "alice@example.com" {
+		t.Errorf("Expected the user to be found by any spelling, got %+v (%v)", stored, err)
+	}
+}
+
+func TestNormalizeUserKeysFailsOnClash(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []*User{
+		{Username: "bob", Password: "x", Email: "bob@example.com", Role: RoleViewer},
+		{Username: "Bob", Password: "x", Email: "bob2@example.com", Role: RoleViewer},
+		{Username: "Carol", Password: "x", Email: "carol@example.com", Role: RoleViewer},
+	} {
+		if err := CreateUser(db, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := NormalizeUserKeys(db)
+	if err == nil || !strings.Contains(err.Error(), "Bob") {
+		t.Fatalf("Expected the clashing user to be reported, got %v", err)
+	}
+	// Nothing is changed until the clash is resolved
+	var carol User
+	if err := db.Where("email = ?", "carol@example.com").First(&carol).Error; err != nil || carol.Username != "Carol" {
+		t.Errorf("Expected the other users to be left as they are, got %+v (%v)", carol, err)
+	}
+}
@@ -0,0 +1,345 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidIDToken is returned for ID tokens that fail verification.
+var ErrInvalidIDToken = errors.New("invalid id token")
+
+// Signing algorithms accepted for ID tokens
+var idTokenMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// JWKS keys are fetched again for an unknown kid at most this often
+const jwksRefreshInterval = time.Minute
+
+// The discovery document is fetched again after this long, so that changed endpoints are picked up
+const discoveryTTL = time.Hour
+
+// Config describes an OpenID provider and the client registered with it.
+type Config struct {
+	Name         string // Name the provider is known by in URLs
+	Issuer       string // Issuer URL, where the discovery document is served
+	ClientID     string
+	ClientSecret string       // Empty for public clients
+	RedirectURL  string       // Callback URL registered with the provider
+	Scopes       []string     // Requested scopes; openid is always requested
+	HTTPClient   *http.Client // Client for requests to the provider; one with a 10s timeout if nil
+}
+
+// Provider is an OpenID provider the service logs users in with, using the authorization code
+// flow with PKCE. Its discovery document is fetched on first use and again once it is older than
+// an hour.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu           sync.Mutex
+	discovery    *discovery
+	discovered   time.Time
+	keys         map[string]any
+	keysFetched  time.Time
+	keysFetching chan struct{} // Closed when the JWKS fetch under way completes
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewProvider creates a Provider for config.
+func NewProvider(config Config) *Provider {
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	if !containsString(config.Scopes, "openid") {
+		config.Scopes = append([]string{"openid"}, config.Scopes...)
+	}
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{config: config, client: client}
+}
+
+// Name returns the name the provider is known by.
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+// AuthCodeURL returns the provider URL users are sent to to log in. The provider sends them back
+// to the redirect URL with state, and the ID token it issues carries nonce. codeChallenge is the
+// S256 challenge of the PKCE verifier later passed to Exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return d.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems an authorization code and its PKCE verifier at the token endpoint and returns
+// the verified claims of the ID token, which must carry nonce.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (jwt.MapClaims, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("token response with status %d: %w", resp.StatusCode, err)
+	}
+	if token.Error != "" {
+		return nil, fmt.Errorf("token request failed: %s %s", token.Error, token.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || token.IDToken == "" {
+		return nil, fmt.Errorf("token response with status %d has no id_token", resp.StatusCode)
+	}
+
+	return p.verifyIDToken(ctx, d, token.IDToken, nonce)
+}
+
+// verifyIDToken checks the signature, issuer, audience, lifetime and nonce of an ID token and
+// returns its claims.
+func (p *Provider) verifyIDToken(ctx context.Context, d *discovery, idToken, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods(idTokenMethods),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	// A token issued to several clients must name this one as the authorized party
+	if aud, _ := claims.GetAudience(); len(aud) > 1 {
+		if azp, _ := claims["azp"].(string); azp != p.config.ClientID {
+			return nil, fmt.Errorf("%w: azp mismatch", ErrInvalidIDToken)
+		}
+	}
+	if sub, _ := claims.GetSubject(); sub == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+// discover returns the provider's discovery document, fetching it if it is missing or stale. A
+// stale document is still used if the provider cannot be reached.
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	cached, discovered := p.discovery, p.discovered
+	p.mu.Unlock()
+	if cached != nil && time.Since(discovered) < discoveryTTL {
+		return cached, nil
+	}
+
+	d, err := p.fetchDiscovery(ctx)
+	if err != nil {
+		if cached != nil {
+			return cached, nil
+		}
+		return nil, err
+	}
+	p.mu.Lock()
+	p.discovery, p.discovered = d, time.Now()
+	p.mu.Unlock()
+	return d, nil
+}
+
+func (p *Provider) fetchDiscovery(ctx context.Context) (*discovery, error) {
+	var d discovery
+	if err := p.getJSON(ctx, p.config.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("discovery of %s failed: %w", p.config.Issuer, err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("discovery of %s returned issuer %s", p.config.Issuer, d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery of %s is missing endpoints", p.config.Issuer)
+	}
+	return &d, nil
+}
+
+// key returns the provider's public key with the given kid, fetching the JWKS again if the key
+// is unknown so that key rotations at the provider are picked up. Only one fetch runs at a time,
+// and it runs without holding the lock; callers needing the keys wait for it.
+func (p *Provider) key(ctx context.Context, kid string) (any, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	for p.keysFetching != nil {
+		fetching := p.keysFetching
+		p.mu.Unlock()
+		select {
+		case <-fetching:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		p.mu.Lock()
+	}
+	if key, ok := p.keys[kid]; ok {
+		p.mu.Unlock()
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < jwksRefreshInterval {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	fetching := make(chan struct{})
+	p.keysFetching = fetching
+	p.mu.Unlock()
+
+	keys, err := p.fetchKeys(ctx, d.JWKSURI)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keysFetching = nil
+	close(fetching)
+	if err != nil {
+		return nil, err
+	}
+	p.keys, p.keysFetched = keys, time.Now()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// fetchKeys fetches the signing keys of the provider's JWKS, skipping keys it cannot use.
+func (p *Provider) fetchKeys(ctx context.Context, jwksURI string) (map[string]any, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, jwksURI, &set); err != nil {
+		return nil, fmt.Errorf("fetching JWKS failed: %w", err)
+	}
+	keys := make(map[string]any, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+// NewPKCE returns a random PKCE code verifier and its S256 code challenge.
+func NewPKCE() (verifier, challenge string, err error) {
+	verifier, err = RandomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// RandomString returns n random bytes encoded as URL-safe base64, for states and nonces.
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
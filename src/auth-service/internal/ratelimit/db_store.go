@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Failure is a failed attempt recorded by DBStore.
+type Failure struct {
+	ID  uint      `gorm:"primaryKey"`
+	Key string    `gorm:"not null;index:idx_failure_key_at"`
+	At  time.Time `gorm:"not null;index:idx_failure_key_at"`
+}
+
+// Lockout is a key locked out by DBStore.
+type Lockout struct {
+	Key   string    `gorm:"primaryKey"`
+	Until time.Time `gorm:"not null"`
+}
+
+// DBStore is a Store kept in a Postgres database, so that replicas of the service share the
+// counts. The service's own SQLite database is local to each replica and cannot be used.
+type DBStore struct {
+	db     *gorm.DB
+	window time.Duration
+}
+
+// NewDBStore creates a DBStore and migrates its tables. Failures older than window are deleted
+// as new ones are recorded.
+func NewDBStore(db *gorm.DB, window time.Duration) (*DBStore, error) {
+	if err := db.AutoMigrate(&Failure{}, &Lockout{}); err != nil {
+		return nil, err
+	}
+	return &DBStore{db: db, window: window}, nil
+}
+
+// AddFailure records a failed attempt for key.
+func (s *DBStore) AddFailure(ctx context.Context, key string, at, since time.Time) (int, error) {
+	db := s.db.WithContext(ctx)
+	if err := db.Create(&Failure{Key: key, At: at}).Error; err != nil {
+		return 0, err
+	}
+	if err := db.Where("key = ? AND at < ?", key, at.Add(-s.window)).Delete(&Failure{}).Error; err != nil {
+		return 0, err
+	}
+	return s.Failures(ctx, key, since)
+}
+
+// Failures returns the number of recent failures of key.
+func (s *DBStore) Failures(ctx context.Context, key string, since time.Time) (int, error) {
+	var count int64
+	err := s.db.WithContext(ctx).Model(&Failure{}).Where("key = ? AND at >= ?", key, since).Count(&count).Error
+	return int(count), err
+}
+
+// Clear forgets the failures of key.
+func (s *DBStore) Clear(ctx context.Context, key string) error {
+	return s.db.WithContext(ctx).Where("key = ?", key).Delete(&Failure{}).Error
+}
+
+// Lock locks key out until the given time.
+func (s *DBStore) Lock(ctx context.Context, key string, until time.Time) error {
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"until"}),
+	}).Create(&Lockout{Key: key, Until: until}).Error
+}
+
+// LockedUntil returns the time key is locked out until.
+func (s *DBStore) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	var lockout Lockout
+	err := s.db.WithContext(ctx).Where("key = ?", key).First(&lockout).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, nil
+	}
+	return lockout.Until, err
+}
+
+// Reserve locks key out until the given time unless it is locked out already. The lockout is
+// only taken over once it has expired, in the same statement that checks it.
+func (s *DBStore) Reserve(ctx context.Context, key string, now, until time.Time) (time.Time, error) {
+	result := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"until"}),
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "lockouts.until <= ?", Vars: []interface{}{now}}}},
+	}).Create(&Lockout{Key: key, Until: until})
+	if result.Error != nil {
+		return time.Time{}, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return time.Time{}, nil
+	}
+	return s.LockedUntil(ctx, key)
+}
+
+// Unlock lifts the lockout of key.
+func (s *DBStore) Unlock(ctx context.Context, key string) error {
+	return s.db.WithContext(ctx).Where("key = ?", key).Delete(&Lockout{}).Error
+}
@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store keeps the failed attempts counted against each key, and the keys locked out because of
+// them. MemoryStore suits a single replica; DBStore shares the counts between replicas through
+// a Postgres database.
+type Store interface {
+	// AddFailure records a failed attempt for key and returns the number of failures recorded at
+	// or after since, the start of the sliding window.
+	AddFailure(ctx context.Context, key string, at, since time.Time) (int, error)
+	// Failures returns the number of failures recorded for key at or after since.
+	Failures(ctx context.Context, key string, since time.Time) (int, error)
+	// Clear forgets the failures of key.
+	Clear(ctx context.Context, key string) error
+	// Lock locks key out until the given time.
+	Lock(ctx context.Context, key string, until time.Time) error
+	// LockedUntil returns the time key is locked out until, or the zero time if it is not.
+	LockedUntil(ctx context.Context, key string) (time.Time, error)
+	// Reserve locks key out until the given time unless it is locked out already, atomically, so
+	// that of several callers only one gets the key. It returns the zero time if key was reserved,
+	// or else the time key is locked out until.
+	Reserve(ctx context.Context, key string, now, until time.Time) (time.Time, error)
+	// Unlock lifts the lockout of key.
+	Unlock(ctx context.Context, key string) error
+}
+
+// sweepEvery is how many failures MemoryStore records between removing stale keys.
+const sweepEvery = 1000
+
+// MemoryStore is a Store kept in process memory.
+type MemoryStore struct {
+	mu       sync.Mutex
+	window   time.Duration
+	failures map[string][]time.Time
+	locks    map[string]time.Time
+	adds     int
+}
+
+// NewMemoryStore creates a MemoryStore. Failures older than window are dropped as keys are
+// touched, and periodically for keys that are not.
+func NewMemoryStore(window time.Duration) *MemoryStore {
+	return &MemoryStore{
+		window:   window,
+		failures: make(map[string][]time.Time),
+		locks:    make(map[string]time.Time),
+	}
+}
+
+// AddFailure records a failed attempt for key.
+func (s *MemoryStore) AddFailure(ctx context.Context, key string, at, since time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	times := append(prune(s.failures[key], since), at)
+	s.failures[key] = times
+
+	s.adds++
+	if s.adds%sweepEvery == 0 {
+		s.sweep(at)
+	}
+	return len(times), nil
+}
+
+// Failures returns the number of recent failures of key.
+func (s *MemoryStore) Failures(ctx context.Context, key string, since time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	times := prune(s.failures[key], since)
+	if len(times) == 0 {
+		delete(s.failures, key)
+	} else {
+		s.failures[key] = times
+	}
+	return len(times), nil
+}
+
+// Clear forgets the failures of key.
+func (s *MemoryStore) Clear(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.failures, key)
+	return nil
+}
+
+// Lock locks key out until the given time.
+func (s *MemoryStore) Lock(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.locks[key] = until
+	return nil
+}
+
+// LockedUntil returns the time key is locked out until.
+func (s *MemoryStore) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.locks[key], nil
+}
+
+// Reserve locks key out until the given time unless it is locked out already.
+func (s *MemoryStore) Reserve(ctx context.Context, key string, now, until time.Time) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if locked := s.locks[key]; locked.After(now) {
+		return locked, nil
+	}
+	s.locks[key] = until
+	return time.Time{}, nil
+}
+
+// Unlock lifts the lockout of key.
+func (s *MemoryStore) Unlock(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.locks, key)
+	return nil
+}
+
+// sweep removes the keys with no failures in the window and the expired locks.
+func (s *MemoryStore) sweep(now time.Time) {
+	since := now.Add(-s.window)
+	for key, times := range s.failures {
+		if len(prune(times, since)) == 0 {
+			delete(s.failures, key)
+		}
+	}
+	for key, until := range s.locks {
+		if !until.After(now) {
+			delete(s.locks, key)
+		}
+	}
+}
+
+// prune drops the times before since from a list in ascending order.
+func prune(times []time.Time, since time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(since) {
+		i++
+	}
+	return times[i:]
+}
@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testStores returns a MemoryStore and a DBStore to run the same tests against. The DBStore is
+// kept in SQLite, which supports the same upserts as Postgres.
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "limits.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	dbStore, err := NewDBStore(db, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Store{"memory": NewMemoryStore(time.Minute), "database": dbStore}
+}
+
+func TestStoreCountsFailuresInWindow(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+			store.AddFailure(ctx, "user:alice", now.Add(-2*time.Minute), now.Add(-3*time.Minute))
+			store.AddFailure(ctx, "user:alice", now.Add(-time.Second), now.Add(-time.Minute))
+			count, err := store.AddFailure(ctx, "user:alice", now, now.Add(-time.Minute))
+			if err != nil || count != 2 {
+				t.Errorf("Expected 2 failures in the window, got %d (%v)", count, err)
+			}
+
+			if err := store.Clear(ctx, "user:alice"); err != nil {
+				t.Fatal(err)
+			}
+			if count, _ := store.Failures(ctx, "user:alice", now.Add(-time.Minute)); count != 0 {
+				t.Errorf("Expected no failures once cleared, got %d", count)
+			}
+		})
+	}
+}
+
+func TestStoreReservesOnce(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+			until := now.Add(time.Minute)
+
+			var mu sync.Mutex
+			var wg sync.WaitGroup
+			reserved := 0
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					held, err := store.Reserve(ctx, "attempt:alice", now, until)
+					if err != nil {
+						t.Errorf("Reserve failed: %v", err)
+						return
+					}
+					if held.IsZero() {
+						mu.Lock()
+						reserved++
+						mu.Unlock()
+					} else if !held.Equal(until) {
+						t.Errorf("Expected the key to be held until %v, got %v", until, held)
+					}
+				}()
+			}
+			wg.Wait()
+			if reserved != 1 {
+				t.Fatalf("Expected one reservation, got %d", reserved)
+			}
+
+			// An expired reservation can be taken over, and an unlocked key reserved again
+			if held, err := store.Reserve(ctx, "attempt:alice", until, until.Add(time.Minute)); err != nil || !held.IsZero() {
+				t.Errorf("Expected the expired reservation to be taken over, got %v (%v)", held, err)
+			}
+			if err := store.Unlock(ctx, "attempt:alice"); err != nil {
+				t.Fatal(err)
+			}
+			if held, err := store.Reserve(ctx, "attempt:alice", now, until); err != nil || !held.IsZero() {
+				t.Errorf("Expected the unlocked key to be reserved, got %v (%v)", held, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"auth-service/internal/mail"
+	"auth-service/internal/models"
+	"auth-service/pkg"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidAccountToken is returned for verification and reset tokens that are unknown,
+	// expired or used.
+	ErrInvalidAccountToken = errors.New("invalid or expired token")
+	// ErrWrongPassword is returned when the current password given to change it does not match.
+	ErrWrongPassword = errors.New("current password is incorrect")
+)
+
+// AccountService handles registration, email verification and password resets and changes.
+type AccountService struct {
+	db        *gorm.DB
+	mailer    mail.Sender
+	passwords *PasswordPolicy
+	publicURL string
+	verifyTTL time.Duration
+	resetTTL  time.Duration
+}
+
+// NewAccountService creates an AccountService that sends emails through mailer, with links to
+// the service at publicURL, and checks new passwords against passwords. Email verification tokens
+// are valid for verifyTTL and password reset tokens for resetTTL.
+func NewAccountService(db *gorm.DB, mailer mail.Sender, passwords *PasswordPolicy, publicURL string, verifyTTL, resetTTL time.Duration) *AccountService {
+	return &AccountService{
+		db:        db,
+		mailer:    mailer,
+		passwords: passwords,
+		publicURL: strings.TrimSuffix(publicURL, "/"),
+		verifyTTL: verifyTTL,
+		resetTTL:  resetTTL,
+	}
+}
+
+// Registration holds the details of a new account.
+type Registration struct {
+	Username string
+	Password string
+	Email    string
+	Role     string // Viewer when empty; callers must only pass roles the requester may assign
+}
+
+// Register validates and creates an account and emails the user a verification link. The username
+// and email address are stored normalized. A ValidationError is returned for invalid input, and
+// ErrUsernameTaken or ErrEmailTaken if another account has the username or address.
+func (s *AccountService) Register(ctx context.Context, reg Registration) (*models.User, error) {
+	username, err := ValidateUsername(reg.Username)
+	if err != nil {
+		return nil, err
+	}
+	email, err := ValidateEmail(reg.Email)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.passwords.Check(reg.Password, username, email); err != nil {
+		return nil, err
+	}
+	role := reg.Role
+	if role == "" {
+		role = models.RoleViewer
+	}
+	if !models.ValidRole(role) {
+		return nil, &ValidationError{Field: "role", Message: ErrInvalidRole.Error(), Err: ErrInvalidRole}
+	}
+	if err := s.checkAvailable(username, email); err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(reg.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	user := &models.User{
+		Username: username,
+		Password: string(hashedPassword),
+		Email:    email,
+		Role:     role,
+	}
+	if err := models.CreateUser(s.db, user); err != nil {
+		// Another registration may have taken the username or address since the check
+		if conflict := s.checkAvailable(username, email); conflict != nil {
+			return nil, conflict
+		}
+		return nil, err
+	}
+
+	// The account exists either way; a failed email can be sent again through /api/verify-email/resend
+	if err := s.SendVerification(ctx, user); err != nil {
+		pkg.Logger.Errorf("Failed to send verification email to user %d: %v", user.ID, err)
+	}
+	return user, nil
+}
+
+// checkAvailable returns ErrUsernameTaken or ErrEmailTaken if an account has the username or
+// email address.
+func (s *AccountService) checkAvailable(username, email string) error {
+	var existing models.User
+	if err := models.GetUserByUsername(s.db, username, &existing); err == nil {
+		return ErrUsernameTaken
+	}
+	if err := models.GetUserByEmail(s.db, email, &existing); err == nil {
+		return ErrEmailTaken
+	}
+	return nil
+}
+
+// SendVerification emails a user a link to verify their address. Links sent earlier stop working.
+func (s *AccountService) SendVerification(ctx context.Context, user *models.User) error {
+	token, err := s.newToken(user, models.TokenVerifyEmail, s.verifyTTL)
+	if err != nil {
+		return err
+	}
+
+	link := s.publicURL + "/api/verify-email?token=" + url.QueryEscape(token)
+	return s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nFollow this link to verify your email address:\n\n%s\n\nThe link expires in %s.\n",
+			user.Username, link, describeTTL(s.verifyTTL)),
+	})
+}
+
+// ResendVerification sends a new verification link to the unverified account with the given
+// email address. Unknown and verified addresses are ignored, so that callers cannot tell which
+// addresses have accounts.
+func (s *AccountService) ResendVerification(ctx context.Context, email string) error {
+	var user models.User
+	if err := models.GetUserByEmail(s.db, email, &user); err != nil || user.EmailVerified {
+		return nil
+	}
+	return s.SendVerification(ctx, &user)
+}
+
+// VerifyEmail marks the address of the user a verification token was sent to as verified.
+func (s *AccountService) VerifyEmail(token string) error {
+	accountToken, err := s.useToken(token, models.TokenVerifyEmail)
+	if err != nil {
+		return err
+	}
+
+	if err := models.MarkEmailVerified(s.db, accountToken.UserID); err != nil {
+		return err
+	}
+	pkg.Logger.Infof("User %d verified their email address", accountToken.UserID)
+	return nil
+}
+
+// ForgotPassword emails a password reset token to the account with the given email address.
+// Unknown addresses are ignored, so that callers cannot tell which addresses have accounts.
+func (s *AccountService) ForgotPassword(ctx context.Context, email string) error {
+	var user models.User
+	if err := models.GetUserByEmail(s.db, email, &user); err != nil {
+		return nil
+	}
+	return s.SendPasswordReset(ctx, &user)
+}
+
+// SendPasswordReset emails a user a password reset token. Tokens sent earlier stop working.
+func (s *AccountService) SendPasswordReset(ctx context.Context, user *models.User) error {
+	token, err := s.newToken(user, models.TokenResetPassword, s.resetTTL)
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nA password reset was requested for your account. Use this token to choose a new password:\n\n%s\n\n"+
+			"The token expires in %s. If you did not ask for it, you can ignore this email.\n",
+			user.Username, token, describeTTL(s.resetTTL)),
+	})
+}
+
+// StartRequiredPasswordChange returns a reset token for a user who has just entered their
+// password, and second factor if any, but must choose a new one before logging in, along with its
+// lifetime.
+func (s *AccountService) StartRequiredPasswordChange(user *models.User) (string, time.Duration, error) {
+	token, err := s.newToken(user, models.TokenResetPassword, s.resetTTL)
+	return token, s.resetTTL, err
+}
+
+// ResetPassword sets a new password for the user a reset token was sent to, logs them out of every
+// session and returns the user.
+func (s *AccountService) ResetPassword(token, newPassword string) (*models.User, error) {
+	accountToken, err := s.findToken(token, models.TokenResetPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	// The token stays usable if the password is rejected
+	var user models.User
+	if err := models.GetUserByID(s.db, accountToken.UserID, &user); err != nil {
+		return nil, ErrInvalidAccountToken
+	}
+	if err := s.passwords.Check(newPassword, user.Username, user.Email); err != nil {
+		return nil, err
+	}
+	if err := s.markUsed(accountToken); err != nil {
+		return nil, err
+	}
+
+	if err := s.setPassword(accountToken.UserID, newPassword); err != nil {
+		return nil, err
+	}
+	// The reset link proves control of the mailbox, so the address is verified too
+	if err := models.MarkEmailVerified(s.db, accountToken.UserID); err != nil {
+		return nil, err
+	}
+	if err := models.DeleteAccountTokens(s.db, accountToken.UserID, models.TokenResetPassword); err != nil {
+		pkg.Logger.Warnf("Failed to delete reset tokens of user %d: %v", accountToken.UserID, err)
+	}
+
+	pkg.Logger.Infof("User %d reset their password", accountToken.UserID)
+	if err := models.RevokeUserCredentials(s.db, accountToken.UserID, time.Now()); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ChangePassword sets a new password for a signed in user, who must confirm their current one.
+// Every other session of the user is logged out; the session the change is made from is kept.
+func (s *AccountService) ChangePassword(user *models.User, sessionID, currentPassword, newPassword string) error {
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword)); err != nil {
+		return ErrWrongPassword
+	}
+	if err := s.passwords.Check(newPassword, user.Username, user.Email); err != nil {
+		return err
+	}
+
+	if err := s.setPassword(user.ID, newPassword); err != nil {
+		return err
+	}
+
+	pkg.Logger.Infof("User %d changed their password", user.ID)
+	return models.RevokeOtherRefreshTokens(s.db, user.ID, sessionID, time.Now())
+}
+
+// setPassword hashes and stores a user's new password.
+func (s *AccountService) setPassword(userID uint, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return models.UpdateUserPassword(s.db, userID, string(hashedPassword))
+}
+
+// newToken creates an account token, replacing the user's earlier tokens with the same purpose.
+func (s *AccountService) newToken(user *models.User, purpose string, ttl time.Duration) (string, error) {
+	if err := models.DeleteAccountTokens(s.db, user.ID, purpose); err != nil {
+		return "", err
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	err = models.CreateAccountToken(s.db, &models.AccountToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// useToken checks an account token and marks it as used.
+func (s *AccountService) useToken(token, purpose string) (*models.AccountToken, error) {
+	accountToken, err := s.findToken(token, purpose)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.markUsed(accountToken); err != nil {
+		return nil, err
+	}
+	return accountToken, nil
+}
+
+// findToken returns an account token if it is unused and unexpired, without using it.
+func (s *AccountService) findToken(token, purpose string) (*models.AccountToken, error) {
+	var accountToken models.AccountToken
+	if err := models.GetAccountTokenByHash(s.db, hashToken(token), &accountToken); err != nil {
+		return nil, ErrInvalidAccountToken
+	}
+
+	if accountToken.Purpose != purpose || accountToken.UsedAt != nil || !accountToken.ExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidAccountToken
+	}
+	return &accountToken, nil
+}
+
+// markUsed marks an account token as used, returning ErrInvalidAccountToken if another request
+// used it meanwhile.
+func (s *AccountService) markUsed(accountToken *models.AccountToken) error {
+	used, err := models.UseAccountToken(s.db, accountToken.ID, time.Now())
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidAccountToken
+	}
+	return nil
+}
+
+// describeTTL renders a token lifetime for an email, in hours or minutes.
+func describeTTL(ttl time.Duration) string {
+	if ttl >= time.Hour && ttl%time.Hour == 0 {
+		return fmt.Sprintf("%d hours", ttl/time.Hour)
+	}
+	return fmt.Sprintf("%d minutes", ttl/time.Minute)
+}
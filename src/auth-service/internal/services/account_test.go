@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"auth-service/internal/mail"
+	"auth-service/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// mailedToken matches the tokens sent in account emails, either in a link or on a line of their own.
+var mailedToken = regexp.MustCompile(`(?m)(?:token=|^)([A-Za-z0-9_-]{43})$`)
+
+// newTestAccounts creates an AccountService that keeps its emails in memory.
+func newTestAccounts(t *testing.T, db *gorm.DB) (*AccountService, *mail.MemorySender) {
+	t.Helper()
+	passwords, err := NewPasswordPolicy(8, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailer := mail.NewMemorySender()
+	return NewAccountService(db, mailer, passwords, "https://auth.example.com/", time.Hour, time.Hour), mailer
+}
+
+// lastToken returns the token in the last email sent to address.
+func lastToken(t *testing.T, mailer *mail.MemorySender, address string) string {
+	t.Helper()
+	sent := mailer.Sent()
+	for i := len(sent) - 1; i >= 0; i-- {
+		if sent[i].To != address {
+			continue
+		}
+		match := mailedToken.FindStringSubmatch(sent[i].Body)
+		if match == nil {
+			t.Fatalf("No token in email %q", sent[i].Body)
+		}
+		token, err := url.QueryUnescape(match[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	t.Fatalf("No email sent to %s", address)
+	return ""
+}
+
+func TestRegisterAndVerifyEmail(t *testing.T) {
+	db := newTestDB(t)
+	accounts, mailer := newTestAccounts(t, db)
+
+	user, err := accounts.Register(context.Background(), Registration{Username: "Alice", Password: "quartz-lantern-93", Email: "Alice@Example.com"})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if user.Username != "alice" || user.Email != "alice@example.com" || user.EmailVerified {
+		t.Errorf("Expected a normalized unverified account, got %+v", user)
+	}
+
+	// A new link replaces the first one
+	first := lastToken(t, mailer, "alice@example.com")
+	if err := accounts.ResendVerification(context.Background(), "ALICE@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	token := lastToken(t, mailer, "alice@example.com")
+	if err := accounts.VerifyEmail(first); !errors.Is(err, ErrInvalidAccountToken) {
+		t.Errorf("Expected the replaced link to be rejected, got %v", err)
+	}
+
+	if err := accounts.VerifyEmail(token); err != nil {
+		t.Fatalf("VerifyEmail failed: %v", err)
+	}
+	var stored models.User
+	if err := models.GetUserByID(db, user.ID, &stored); err != nil || !stored.EmailVerified {
+		t.Errorf("Expected the address to be verified, got %+v (%v)", stored, err)
+	}
+	if err := accounts.VerifyEmail(token); !errors.Is(err, ErrInvalidAccountToken) {
+		t.Errorf("Expected the link to work only once, got %v", err)
+	}
+
+	// Verified addresses are not sent new links
+	mailer.Reset()
+	if err := accounts.ResendVerification(context.Background(), "alice@example.com"); err != nil || len(mailer.Sent()) != 0 {
+		t.Errorf("Expected no email for a verified address, got %v (%v)", mailer.Sent(), err)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	db := newTestDB(t)
+	accounts, mailer := newTestAccounts(t, db)
+	user := newTestUser(t, db, "alice", models.RoleEditor)
+	db.Model(user).Update("email_verified", false)
+
+	// Unknown addresses get no email, and the caller cannot tell
+	if err := accounts.ForgotPassword(context.Background(), "nobody@example.com"); err != nil || len(mailer.Sent()) != 0 {
+		t.Fatalf("Expected no email for an unknown address, got %v (%v)", mailer.Sent(), err)
+	}
+
+	if err := accounts.ForgotPassword(context.Background(), user.Email); err != nil {
+		t.Fatalf("ForgotPassword failed: %v", err)
+	}
+	token := lastToken(t, mailer, user.Email)
+	key := newTestAPIKey(t, db, user)
+
+	// A rejected password leaves the token usable
+	var invalid *ValidationError
+	if _, err := accounts.ResetPassword(token, "short"); !errors.As(err, &invalid) {
+		t.Fatalf("Expected the short password to be rejected, got %v", err)
+	}
+	if _, err := accounts.ResetPassword(token, "quartz-lantern-93"); err != nil {
+		t.Fatalf("ResetPassword failed: %v", err)
+	}
+
+	var stored models.User
+	if err := models.GetUserByID(db, user.ID, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("quartz-lantern-93")) != nil {
+		t.Error("Expected the new password to be stored")
+	}
+	if !stored.EmailVerified {
+		t.Error("Expected the reset to verify the address")
+	}
+	checkAPIKeyRevoked(t, db, key)
+	if _, err := accounts.ResetPassword(token, "another-lantern-94"); !errors.Is(err, ErrInvalidAccountToken) {
+		t.Errorf("Expected the token to work only once, got %v", err)
+	}
+}
+
+func TestForcePasswordResetDisablesOldPassword(t *testing.T) {
+	db := newTestDB(t)
+	accounts, mailer := newTestAccounts(t, db)
+	tokens, err := NewTokenService(db, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := NewAdminService(db, tokens, accounts, nil)
+	actor := newTestUser(t, db, "root", models.RoleAdmin)
+	user := newTestUser(t, db, "alice", models.RoleEditor)
+	if err := accounts.setPassword(user.ID, "quartz-lantern-93"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := admin.ForcePasswordReset(context.Background(), actor, user.ID); err != nil {
+		t.Fatalf("ForcePasswordReset failed: %v", err)
+	}
+	var stored models.User
+	if err := models.GetUserByID(db, user.ID, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("quartz-lantern-93")) == nil {
+		t.Error("Expected the old password to stop working")
+	}
+
+	// The emailed token sets the new one
+	if _, err := accounts.ResetPassword(lastToken(t, mailer, user.Email), "another-lantern-94"); err != nil {
+		t.Fatalf("ResetPassword failed: %v", err)
+	}
+	if err := models.GetUserByID(db, user.ID, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("another-lantern-94")) != nil || stored.MustChangePassword {
+		t.Errorf("Expected the new password to be set, got %+v", stored)
+	}
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"auth-service/internal/models"
+	"auth-service/pkg"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrUserNotFound is returned for operations on users that do not exist.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrInvalidRole is returned for roles other than viewer, editor and admin.
+	ErrInvalidRole = errors.New("role must be viewer, editor or admin")
+	// ErrOwnAccount is returned when admins try to demote, disable or delete themselves.
+	ErrOwnAccount = errors.New("admins cannot demote, disable or delete their own account")
+	// ErrLastAdmin is returned for changes that would leave no enabled admin.
+	ErrLastAdmin = errors.New("the last enabled admin cannot be demoted, disabled or deleted")
+)
+
+// Page sizes for ListUsers.
+const (
+	defaultUserPageSize = 50
+	maxUserPageSize     = 500
+)
+
+// AdminService lets admins manage user accounts.
+type AdminService struct {
+	db       *gorm.DB
+	tokens   *TokenService
+	accounts *AccountService
+	events   *UserEventDispatcher
+}
+
+// NewAdminService creates an AdminService. Sessions are revoked through tokens, reset emails sent
+// through accounts, and deletions announced to other services through events.
+func NewAdminService(db *gorm.DB, tokens *TokenService, accounts *AccountService, events *UserEventDispatcher) *AdminService {
+	return &AdminService{db: db, tokens: tokens, accounts: accounts, events: events}
+}
+
+// ListUsers returns a page of the users matching the query, along with the number of matching
+// users across all pages. page is 1-based.
+func (s *AdminService) ListUsers(query models.UserQuery, page, pageSize int) ([]models.User, int64, error) {
+	if pageSize <= 0 {
+		pageSize = defaultUserPageSize
+	}
+	pageSize = min(pageSize, maxUserPageSize)
+	page = max(page, 1)
+
+	return models.SearchUsers(s.db, query, (page-1)*pageSize, pageSize)
+}
+
+// GetUser returns a user.
+func (s *AdminService) GetUser(userID uint) (*models.User, error) {
+	var user models.User
+	if err := models.GetUserByID(s.db, userID, &user); err != nil {
+		return nil, ErrUserNotFound
+	}
+	return &user, nil
+}
+
+// SetRole changes a user's role. The user's sessions and API keys are revoked, so that tokens
+// carrying the old role stop being accepted.
+func (s *AdminService) SetRole(actor *models.User, userID uint, role string) (*models.User, error) {
+	if !models.ValidRole(role) {
+		return nil, ErrInvalidRole
+	}
+	user, err := s.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role == role {
+		return user, nil
+	}
+	if user.ID == actor.ID {
+		return nil, ErrOwnAccount
+	}
+	if err := checkNotLastAdmin(s.db, user); err != nil {
+		return nil, err
+	}
+
+	if err := models.UpdateUserRole(s.db, user.ID, role); err != nil {
+		return nil, err
+	}
+	if err := s.tokens.RevokeUserSessions(user.ID); err != nil {
+		return nil, err
+	}
+
+	pkg.Logger.Infof("Admin %d changed the role of user %d from %q to %q", actor.ID, user.ID, user.Role, role)
+	user.Role = role
+	return user, nil
+}
+
+// SetDisabled disables or re-enables a user's account. Disabling revokes the user's sessions and
+// API keys.
+func (s *AdminService) SetDisabled(actor *models.User, userID uint, disabled bool) (*models.User, error) {
+	user, err := s.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled == disabled {
+		return user, nil
+	}
+	if disabled {
+		if user.ID == actor.ID {
+			return nil, ErrOwnAccount
+		}
+		if err := checkNotLastAdmin(s.db, user); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := models.SetUserDisabled(s.db, user.ID, disabled); err != nil {
+		return nil, err
+	}
+	if disabled {
+		if err := s.tokens.RevokeUserSessions(user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	pkg.Logger.Infof("Admin %d set user %d disabled=%t", actor.ID, user.ID, disabled)
+	user.Disabled = disabled
+	return user, nil
+}
+
+// ForcePasswordReset logs a user out of every session, revokes their API keys and emails them a
+// reset token to choose a new password with. The old password stops working, so that whoever may
+// have learned it cannot choose the new one.
+func (s *AdminService) ForcePasswordReset(ctx context.Context, actor *models.User, userID uint) (*models.User, error) {
+	user, err := s.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Nobody knows the new password, so only the emailed token can set one
+	password, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.accounts.setPassword(user.ID, password); err != nil {
+		return nil, err
+	}
+	if err := models.SetMustChangePassword(s.db, user.ID); err != nil {
+		return nil, err
+	}
+	if err := s.tokens.RevokeUserSessions(user.ID); err != nil {
+		return nil, err
+	}
+	if err := s.accounts.SendPasswordReset(ctx, user); err != nil {
+		pkg.Logger.Errorf("Failed to email password reset to user %d: %v", user.ID, err)
+	}
+
+	pkg.Logger.Infof("Admin %d forced a password reset of user %d", actor.ID, user.ID)
+	user.MustChangePassword = true
+	return user, nil
+}
+
+// DeleteUser deletes a user's account and announces the deletion, so that the other services
+// remove the user's files and grants.
+func (s *AdminService) DeleteUser(actor *models.User, userID uint) error {
+	user, err := s.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user.ID == actor.ID {
+		return ErrOwnAccount
+	}
+	if err := checkNotLastAdmin(s.db, user); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := models.DeleteUser(tx, user.ID, now); err != nil {
+			return err
+		}
+		return s.events.Enqueue(tx, UserEvent{
+			Type:       models.EventUserDeleted,
+			UserID:     user.ID,
+			ActorID:    actor.ID,
+			ActorRole:  actor.Role,
+			OccurredAt: now,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	pkg.Logger.Infof("Admin %d deleted user %d (%s)", actor.ID, user.ID, user.Username)
+	return nil
+}
+
+// serviceAccountEmailDomain holds the placeholder email addresses of service accounts, which
+// receive no mail. The .invalid top-level domain never resolves.
+const serviceAccountEmailDomain = "service-accounts.invalid"
+
+// CreateServiceAccount creates an account for automation with the given name and role. Service
+// accounts cannot log in with a password; they authenticate with API keys created by admins.
+func (s *AdminService) CreateServiceAccount(actor *models.User, name, role string) (*models.User, error) {
+	username, err := ValidateUsername(name)
+	if err != nil {
+		return nil, err
+	}
+	if !models.ValidRole(role) {
+		return nil, &ValidationError{Field: "role", Message: ErrInvalidRole.Error(), Err: ErrInvalidRole}
+	}
+	email := username + "@" + serviceAccountEmailDomain
+	if err := s.accounts.checkAvailable(username, email); err != nil {
+		return nil, err
+	}
+
+	// Nobody knows the password, so it cannot be used to log in
+	password, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	user := &models.User{
+		Username:       username,
+		Password:       string(hashedPassword),
+		Email:          email,
+		Role:           role,
+		EmailVerified:  true,
+		ServiceAccount: true,
+	}
+	if err := models.CreateUser(s.db, user); err != nil {
+		if conflict := s.accounts.checkAvailable(username, email); conflict != nil {
+			return nil, conflict
+		}
+		return nil, err
+	}
+
+	pkg.Logger.Infof("Admin %d created service account %d (%s) with role %q", actor.ID, user.ID, user.Username, role)
+	return user, nil
+}
+
+// GetServiceAccount returns a service account, or ErrUserNotFound if the user is a person.
+func (s *AdminService) GetServiceAccount(userID uint) (*models.User, error) {
+	user, err := s.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.ServiceAccount {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// checkNotLastAdmin refuses to demote, disable or delete the last enabled admin, which would
+// leave nobody able to manage users.
+func checkNotLastAdmin(db *gorm.DB, user *models.User) error {
+	if user.Role != models.RoleAdmin || user.Disabled {
+		return nil
+	}
+	count, err := models.CountActiveAdmins(db)
+	if err != nil {
+		return err
+	}
+	if count <= 1 {
+		return ErrLastAdmin
+	}
+	return nil
+}
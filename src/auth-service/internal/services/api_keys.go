@@ -0,0 +1,213 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"auth-service/internal/models"
+	"auth-service/pkg"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidAPIKey is returned for API keys that are unknown, expired or revoked.
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	// ErrAPIKeyNotFound is returned for API keys the user does not have.
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	// ErrInvalidScope is returned for API keys requested without scopes or with unknown ones.
+	ErrInvalidScope = fmt.Errorf("scopes must be one or more of %s and %s", ScopeRead, ScopeWrite)
+	// ErrRoleTooHigh is returned for API keys requested with a higher role than their user's.
+	ErrRoleTooHigh = errors.New("api key role cannot be higher than the user's role")
+)
+
+// APIKeyPrefix starts every API key, so that verifiers can tell keys from access tokens.
+const APIKeyPrefix = "fsk_"
+
+// Scopes of API keys. Services verifying access tokens allow tokens with the read scope to make
+// read-only requests and tokens with the write scope to make changes.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// APIKeyRequest holds the details of a new API key.
+type APIKeyRequest struct {
+	Name          string
+	Scopes        []string
+	Role          string // Role the key acts with; the user's role when empty
+	ExpiresInDays int    // The default lifetime when zero
+}
+
+// APIKeyToken is an access token issued for an API key. There is no refresh token; the key is
+// exchanged again once the access token expires.
+type APIKeyToken struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// APIKeyService manages the API keys of users and service accounts and exchanges them for
+// access tokens.
+type APIKeyService struct {
+	db         *gorm.DB
+	tokens     *TokenService
+	defaultTTL time.Duration
+	maxTTL     time.Duration
+}
+
+// NewAPIKeyService creates an APIKeyService. Keys are valid for defaultTTL unless requested for
+// longer, up to maxTTL.
+func NewAPIKeyService(db *gorm.DB, tokens *TokenService, defaultTTL, maxTTL time.Duration) *APIKeyService {
+	return &APIKeyService{db: db, tokens: tokens, defaultTTL: defaultTTL, maxTTL: maxTTL}
+}
+
+// Create creates an API key of owner on behalf of creator and returns it along with the key,
+// which is not stored and cannot be shown again.
+func (s *APIKeyService) Create(owner, creator *models.User, req APIKeyRequest) (*models.APIKey, string, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 100 {
+		return nil, "", &ValidationError{Field: "name", Message: "name must be 1 to 100 characters"}
+	}
+	scopes, err := normalizeScopes(req.Scopes)
+	if err != nil {
+		return nil, "", &ValidationError{Field: "scopes", Message: err.Error(), Err: err}
+	}
+	if req.Role != "" {
+		if !models.ValidRole(req.Role) {
+			return nil, "", &ValidationError{Field: "role", Message: ErrInvalidRole.Error(), Err: ErrInvalidRole}
+		}
+		if models.RoleRank(req.Role) > models.RoleRank(owner.Role) {
+			return nil, "", &ValidationError{Field: "role", Message: ErrRoleTooHigh.Error(), Err: ErrRoleTooHigh}
+		}
+	}
+	ttl := time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	if req.ExpiresInDays == 0 {
+		ttl = s.defaultTTL
+	}
+	if ttl <= 0 || ttl > s.maxTTL {
+		return nil, "", &ValidationError{Field: "expires_in_days", Message: fmt.Sprintf("expires_in_days must be 1 to %d", s.maxTTL/(24*time.Hour))}
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return nil, "", err
+	}
+	key := APIKeyPrefix + secret
+
+	apiKey := &models.APIKey{
+		UserID:    owner.ID,
+		Name:      name,
+		Prefix:    key[:len(APIKeyPrefix)+8],
+		KeyHash:   hashToken(key),
+		SessionID: sessionID,
+		Role:      req.Role,
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: time.Now().Add(ttl),
+		CreatedBy: creator.ID,
+	}
+	if err := models.CreateAPIKey(s.db, apiKey); err != nil {
+		return nil, "", err
+	}
+
+	pkg.Logger.Infof("User %d created API key %d (%s) for user %d with scopes %q", creator.ID, apiKey.ID, apiKey.Prefix, owner.ID, apiKey.Scopes)
+	return apiKey, key, nil
+}
+
+// List returns a user's API keys, including expired and revoked ones.
+func (s *APIKeyService) List(userID uint) ([]models.APIKey, error) {
+	return models.ListUserAPIKeys(s.db, userID)
+}
+
+// Revoke revokes an API key of a user. Access tokens issued for it are listed as revoked until
+// they expire.
+func (s *APIKeyService) Revoke(userID, keyID uint) error {
+	var key models.APIKey
+	if err := models.GetUserAPIKey(s.db, userID, keyID, &key); err != nil {
+		return ErrAPIKeyNotFound
+	}
+	if err := models.RevokeAPIKey(s.db, key.ID, time.Now()); err != nil {
+		return err
+	}
+
+	pkg.Logger.Infof("API key %d (%s) of user %d revoked", key.ID, key.Prefix, userID)
+	return nil
+}
+
+// Exchange issues an access token for an API key used from clientIP. The token carries the key's
+// scopes and the lower of the key's and the user's role, so that demoting the user also limits
+// their keys.
+func (s *APIKeyService) Exchange(key, clientIP string) (*APIKeyToken, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+	var apiKey models.APIKey
+	if err := models.GetAPIKeyByHash(s.db, hashToken(key), &apiKey); err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+	now := time.Now()
+	if apiKey.RevokedAt != nil || !apiKey.ExpiresAt.After(now) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var user models.User
+	if err := models.GetUserByID(s.db, apiKey.UserID, &user); err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
+	role := user.Role
+	if apiKey.Role != "" && models.RoleRank(apiKey.Role) < models.RoleRank(role) {
+		role = apiKey.Role
+	}
+
+	accessToken, err := CreateJWT(user.ID, user.Username, user.Email, role, apiKey.SessionID, apiKey.Scopes, user.ServiceAccount, s.tokens.AccessTTL())
+	if err != nil {
+		return nil, err
+	}
+	if err := models.TouchAPIKey(s.db, apiKey.ID, clientIP, now); err != nil {
+		pkg.Logger.Warnf("Failed to record use of API key %d: %v", apiKey.ID, err)
+	}
+
+	return &APIKeyToken{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.tokens.AccessTTL() / time.Second),
+		Scope:       apiKey.Scopes,
+	}, nil
+}
+
+// normalizeScopes checks requested scopes and returns them sorted and without duplicates.
+func normalizeScopes(requested []string) ([]string, error) {
+	var read, write bool
+	for _, scope := range requested {
+		switch strings.TrimSpace(scope) {
+		case ScopeRead:
+			read = true
+		case ScopeWrite:
+			write = true
+		default:
+			return nil, ErrInvalidScope
+		}
+	}
+
+	var scopes []string
+	if read {
+		scopes = append(scopes, ScopeRead)
+	}
+	if write {
+		scopes = append(scopes, ScopeWrite)
+	}
+	if len(scopes) == 0 {
+		return nil, ErrInvalidScope
+	}
+	return scopes, nil
+}
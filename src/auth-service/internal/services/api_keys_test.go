@@ -0,0 +1,60 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"auth-service/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// newTestAPIKey creates a read and write API key of user and returns the key.
+func newTestAPIKey(t *testing.T, db *gorm.DB, user *models.User) string {
+	t.Helper()
+	tokens, err := NewTokenService(db, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := NewAPIKeyService(db, tokens, time.Hour, 24*time.Hour)
+	_, key, err := keys.Create(user, user, APIKeyRequest{Name: "ci", Scopes: []string{ScopeRead, ScopeWrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// checkAPIKeyRevoked fails the test unless key can no longer be exchanged.
+func checkAPIKeyRevoked(t *testing.T, db *gorm.DB, key string) {
+	t.Helper()
+	tokens, err := NewTokenService(db, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := NewAPIKeyService(db, tokens, time.Hour, 24*time.Hour)
+	if _, err := keys.Exchange(key, "10.0.0.1"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected the API key to be revoked, got %v", err)
+	}
+}
+
+func TestRevokeUserSessionsRevokesAPIKeys(t *testing.T) {
+	db := newTestDB(t)
+	tokens, err := NewTokenService(db, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := newTestUser(t, db, "alice", models.RoleEditor)
+	key := newTestAPIKey(t, db, user)
+
+	if err := tokens.RevokeUserSessions(user.ID); err != nil {
+		t.Fatalf("RevokeUserSessions failed: %v", err)
+	}
+	checkAPIKeyRevoked(t, db, key)
+
+	// The key stays listed until access tokens issued for it have expired
+	revoked, err := tokens.RevokedSessions()
+	if err != nil || len(revoked) != 1 {
+		t.Errorf("Expected the key's session to be listed as revoked, got %v (%v)", revoked, err)
+	}
+}
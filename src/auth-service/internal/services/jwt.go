@@ -1,29 +1,74 @@
 package services
 
 import (
+	"errors"
 	"os"
 	"time"
 
 	"auth-service/pkg"
 	"github.com/golang-jwt/jwt/v5"
+	"shared/authn"
 )
 
-// CreateJWT generates a JWT for the provided identity and email.
-func CreateJWT(id uint, username, email, role string) (string, error) {
-    issuer := os.Getenv("JWT_ISSUER")
-    token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+// ErrInvalidAccessToken is returned for access tokens that are malformed, expired or not signed
+// by one of the service's keys.
+var ErrInvalidAccessToken = errors.New("invalid access token")
+
+// CreateJWT generates an access token for the provided identity and email that is valid for ttl.
+// sessionID identifies the login session the token belongs to, so that the token stops being
+// accepted once the session is revoked. Tokens issued for API keys carry the key's scope; other
+// tokens have none and are not restricted. Tokens of service accounts are marked with the svc
+// claim, which lets services that trust them act on behalf of users.
+//
+// The token is signed with the current signing key, named in the kid header so that verifiers can
+// pick the matching public key from the JWKS while keys are rotated.
+func CreateJWT(id uint, username, email, role, sessionID, scope string, serviceAccount bool, ttl time.Duration) (string, error) {
+	issuer := os.Getenv("JWT_ISSUER")
+	now := time.Now()
+	key := pkg.Keys.Signing
+	claims := jwt.MapClaims{
 		"userId":   id,
-        "username": username,
-        "email":    email,
-        "role":     role,
-        "exp":      time.Now().Add(24 * time.Hour).Unix(),
-        "iss":      issuer,
-    })
+		"username": username,
+		"email":    email,
+		"role":     role,
+		"sid":      sessionID,
+		"iat":      now.Unix(),
+		"exp":      now.Add(ttl).Unix(),
+		"iss":      issuer,
+	}
+	if scope != "" {
+		claims["scope"] = scope
+	}
+	if serviceAccount {
+		claims["svc"] = true
+	}
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		claims["aud"] = audience
+	}
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+
+	tokenString, err := token.SignedString(key.Key)
+	if err != nil {
+		return "", err
+	}
 
-    tokenString, err := token.SignedString(pkg.PrivateKey)
-    if err != nil {
-        return "", err
-    }
+	return tokenString, nil
+}
 
-    return tokenString, nil
-}
\ No newline at end of file
+// newAccessVerifier creates a verifier of the access tokens issued by CreateJWT, which checks
+// them against the service's own keys rather than its published JWKS. It does not check whether
+// a token's session has been revoked.
+func newAccessVerifier() (*authn.Verifier, error) {
+	return authn.NewVerifier(authn.Config{
+		Keys: func(kid string) any {
+			key := pkg.Keys.Find(kid)
+			if key == nil {
+				return nil
+			}
+			return key.Key.Public()
+		},
+		Issuer:   os.Getenv("JWT_ISSUER"),
+		Audience: os.Getenv("JWT_AUDIENCE"),
+	})
+}
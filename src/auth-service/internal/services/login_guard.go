@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"auth-service/internal/mail"
+	"auth-service/internal/models"
+	"auth-service/internal/ratelimit"
+	"auth-service/pkg"
+
+	"gorm.io/gorm"
+)
+
+// LoginLimits configures LoginGuard.
+type LoginLimits struct {
+	Window             time.Duration // Sliding window failures are counted in
+	MaxAccountFailures int           // Failures of one account within Window before it is locked out
+	MaxIPFailures      int           // Failures from one client IP within Window before it is refused
+	Lockout            time.Duration // How long accounts are locked out for
+	BaseDelay          time.Duration // Delay after the first failure, doubled with each further one
+	MaxDelay           time.Duration
+}
+
+// ThrottledError is returned when a login attempt is refused by the rate limits.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// LoginGuard protects logins against password guessing. Failed attempts are counted per account
+// and per client IP in a sliding window; each failure is answered more slowly than the last, an
+// account is locked out for a while once it has too many, and a client IP with too many is refused
+// until its failures leave the window. Attempts on an account are taken one at a time, spaced by
+// the same delay, so that parallel guesses are slowed down too. Failures and lockouts are recorded
+// as auth events.
+type LoginGuard struct {
+	db     *gorm.DB
+	store  ratelimit.Store
+	mailer mail.Sender
+	limits LoginLimits
+}
+
+// NewLoginGuard creates a LoginGuard keeping its counts in store. Users are told by email through
+// mailer when their account is locked out.
+func NewLoginGuard(db *gorm.DB, store ratelimit.Store, mailer mail.Sender, limits LoginLimits) *LoginGuard {
+	return &LoginGuard{db: db, store: store, mailer: mailer, limits: limits}
+}
+
+// Check refuses a login attempt with a ThrottledError if the account is locked out, the client IP
+// has failed too often or another attempt on the account is under way. An attempt that is let
+// through holds the account until the delay its failure would earn has passed, or until Succeed or
+// Release is called.
+func (g *LoginGuard) Check(ctx context.Context, username, clientIP string) error {
+	now := time.Now()
+	since := now.Add(-g.limits.Window)
+
+	until, err := g.store.LockedUntil(ctx, accountKey(username))
+	if err != nil {
+		return err
+	}
+	if until.After(now) {
+		g.record(models.EventLoginThrottled, nil, username, clientIP, "account locked")
+		return &ThrottledError{RetryAfter: until.Sub(now)}
+	}
+
+	failures, err := g.store.Failures(ctx, ipKey(clientIP), since)
+	if err != nil {
+		return err
+	}
+	if failures >= g.limits.MaxIPFailures {
+		g.record(models.EventLoginThrottled, nil, username, clientIP, "too many failures from client IP")
+		return &ThrottledError{RetryAfter: g.limits.Window}
+	}
+
+	failures, err = g.store.Failures(ctx, accountKey(username), since)
+	if err != nil {
+		return err
+	}
+	held, err := g.store.Reserve(ctx, attemptKey(username), now, now.Add(g.delay(failures+1)))
+	if err != nil {
+		return err
+	}
+	if held.After(now) {
+		g.record(models.EventLoginThrottled, nil, username, clientIP, "another attempt under way")
+		return &ThrottledError{RetryAfter: held.Sub(now)}
+	}
+	return nil
+}
+
+// Fail records a failed login attempt and returns how long to wait before answering it. user is
+// nil when the username does not belong to an account; the username is still counted, so that
+// responses do not reveal which usernames exist.
+func (g *LoginGuard) Fail(ctx context.Context, user *models.User, username, clientIP, reason string) time.Duration {
+	now := time.Now()
+	since := now.Add(-g.limits.Window)
+	g.record(models.EventLoginFailed, user, username, clientIP, reason)
+
+	if _, err := g.store.AddFailure(ctx, ipKey(clientIP), now, since); err != nil {
+		pkg.Logger.Errorf("Failed to record login failure of %s: %v", clientIP, err)
+	}
+	failures, err := g.store.AddFailure(ctx, accountKey(username), now, since)
+	if err != nil {
+		pkg.Logger.Errorf("Failed to record login failure of %q: %v", username, err)
+		return g.limits.BaseDelay
+	}
+
+	if failures >= g.limits.MaxAccountFailures {
+		g.lock(ctx, user, username, clientIP, failures, now)
+	}
+	return g.delay(failures)
+}
+
+// Succeed forgets the failures of an account once its user has logged in.
+func (g *LoginGuard) Succeed(ctx context.Context, username string) {
+	if err := g.store.Clear(ctx, accountKey(username)); err != nil {
+		pkg.Logger.Errorf("Failed to clear login failures of %q: %v", username, err)
+	}
+	g.Release(ctx, username)
+}
+
+// Release lets the next attempt on an account through straight away, once the current one has
+// passed a step without failing, such as a correct password before the second factor.
+func (g *LoginGuard) Release(ctx context.Context, username string) {
+	if err := g.store.Unlock(ctx, attemptKey(username)); err != nil {
+		pkg.Logger.Errorf("Failed to release login attempt of %q: %v", username, err)
+	}
+}
+
+// Unlock lifts the lockout of an account and forgets its failures, once its user has proven
+// control of the account another way, such as by resetting their password.
+func (g *LoginGuard) Unlock(ctx context.Context, username string) {
+	if err := g.store.Unlock(ctx, accountKey(username)); err != nil {
+		pkg.Logger.Errorf("Failed to lift lockout of %q: %v", username, err)
+	}
+	g.Succeed(ctx, username)
+}
+
+// lock locks an account out and tells its user.
+func (g *LoginGuard) lock(ctx context.Context, user *models.User, username, clientIP string, failures int, now time.Time) {
+	key := accountKey(username)
+	if err := g.store.Lock(ctx, key, now.Add(g.limits.Lockout)); err != nil {
+		pkg.Logger.Errorf("Failed to lock out %q: %v", username, err)
+		return
+	}
+	// The next failure after the lockout starts a new count
+	if err := g.store.Clear(ctx, key); err != nil {
+		pkg.Logger.Errorf("Failed to clear login failures of %q: %v", username, err)
+	}
+
+	g.record(models.EventAccountLocked, user, username, clientIP, fmt.Sprintf("%d failed attempts", failures))
+	pkg.Logger.Warnf("Locked out %q for %s after %d failed login attempts", username, g.limits.Lockout, failures)
+
+	if user == nil {
+		return
+	}
+	err := g.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Your account has been locked",
+		Body: fmt.Sprintf("Hi %s,\n\nYour account was locked for %s after %d failed login attempts, the last one from %s.\n\n"+
+			"If this was not you, reset your password once the lockout ends.\n",
+			user.Username, describeTTL(g.limits.Lockout), failures, clientIP),
+	})
+	if err != nil {
+		pkg.Logger.Errorf("Failed to notify user %d of lockout: %v", user.ID, err)
+	}
+}
+
+// delay returns the progressive delay after the given number of failures.
+func (g *LoginGuard) delay(failures int) time.Duration {
+	delay := g.limits.BaseDelay
+	for i := 1; i < failures && delay < g.limits.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > g.limits.MaxDelay {
+		delay = g.limits.MaxDelay
+	}
+	return delay
+}
+
+// record appends an auth event.
+func (g *LoginGuard) record(eventType string, user *models.User, username, clientIP, detail string) {
+	event := &models.AuthEvent{Type: eventType, Username: username, ClientIP: clientIP, Detail: detail}
+	if user != nil {
+		event.UserID = user.ID
+	}
+	if err := models.RecordAuthEvent(g.db, event); err != nil {
+		pkg.Logger.Errorf("Failed to record %s event for %q: %v", eventType, username, err)
+	}
+}
+
+// accountKey is the store key of an account's failures; usernames are compared case-insensitively
+// so that case variations do not get their own allowance.
+func accountKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+// attemptKey is the store key held while a login attempt on an account is under way.
+func attemptKey(username string) string {
+	return "attempt:" + strings.ToLower(username)
+}
+
+// ipKey is the store key of a client IP's failures.
+func ipKey(clientIP string) string {
+	return "ip:" + clientIP
+}
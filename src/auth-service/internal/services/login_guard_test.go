@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"auth-service/internal/mail"
+	"auth-service/internal/models"
+	"auth-service/internal/ratelimit"
+
+	"gorm.io/gorm"
+)
+
+// newTestGuard creates a LoginGuard counting in memory, with delays of delay.
+func newTestGuard(t *testing.T, db *gorm.DB, delay time.Duration) (*LoginGuard, *mail.MemorySender) {
+	t.Helper()
+	mailer := mail.NewMemorySender()
+	return NewLoginGuard(db, ratelimit.NewMemoryStore(time.Minute), mailer, LoginLimits{
+		Window:             time.Minute,
+		MaxAccountFailures: 3,
+		MaxIPFailures:      5,
+		Lockout:            time.Minute,
+		BaseDelay:          delay,
+		MaxDelay:           delay,
+	}), mailer
+}
+
+// failAttempt makes a login attempt on an account that fails, and waits out its delay.
+func failAttempt(t *testing.T, guard *LoginGuard, user *models.User, username, clientIP string) {
+	t.Helper()
+	ctx := context.Background()
+	if err := guard.Check(ctx, username, clientIP); err != nil {
+		t.Fatalf("Expected the attempt to be allowed, got %v", err)
+	}
+	time.Sleep(guard.Fail(ctx, user, username, clientIP, "wrong password"))
+}
+
+func TestLoginGuardLocksOutAccount(t *testing.T) {
+	db := newTestDB(t)
+	guard, mailer := newTestGuard(t, db, time.Millisecond)
+	user := newTestUser(t, db, "alice", models.RoleEditor)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		failAttempt(t, guard, user, "alice", "10.0.0.1")
+	}
+
+	var throttled *ThrottledError
+	if err := guard.Check(ctx, "alice", "10.0.0.2"); !errors.As(err, &throttled) {
+		t.Fatalf("Expected the account to be locked out from any IP, got %v", err)
+	}
+	if sent := mailer.Sent(); len(sent) != 1 || sent[0].To != user.Email {
+		t.Errorf("Expected the user to be told of the lockout, got %v", sent)
+	}
+
+	var locked int64
+	db.Model(&models.AuthEvent{}).Where("type = ? AND user_id = ?", models.EventAccountLocked, user.ID).Count(&locked)
+	if locked != 1 {
+		t.Errorf("Expected one lockout event, got %d", locked)
+	}
+
+	// Resetting the password lifts the lockout
+	guard.Unlock(ctx, "alice")
+	if err := guard.Check(ctx, "alice", "10.0.0.2"); err != nil {
+		t.Errorf("Expected the lockout to be lifted, got %v", err)
+	}
+}
+
+func TestLoginGuardRefusesParallelAttempts(t *testing.T) {
+	db := newTestDB(t)
+	guard, _ := newTestGuard(t, db, time.Minute)
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := guard.Check(ctx, "alice", "10.0.0.1")
+			var throttled *ThrottledError
+			switch {
+			case err == nil:
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			case !errors.As(err, &throttled):
+				t.Errorf("Unexpected error %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 1 {
+		t.Fatalf("Expected one attempt at a time, got %d", allowed)
+	}
+
+	// Usernames are held regardless of case, and other accounts are not held
+	if err := guard.Check(ctx, "ALICE", "10.0.0.1"); err == nil {
+		t.Error("Expected another spelling of the username to be held too")
+	}
+	if err := guard.Check(ctx, "bob", "10.0.0.1"); err != nil {
+		t.Errorf("Expected another account to be allowed, got %v", err)
+	}
+
+	// A correct password lets the next step through
+	guard.Release(ctx, "alice")
+	if err := guard.Check(ctx, "alice", "10.0.0.1"); err != nil {
+		t.Errorf("Expected the released account to be allowed, got %v", err)
+	}
+}
+
+func TestLoginGuardLimitsClientIP(t *testing.T) {
+	db := newTestDB(t)
+	guard, _ := newTestGuard(t, db, time.Millisecond)
+
+	// Unknown usernames count as well
+	for _, username := range []string{"a1", "a2", "a3", "a4", "a5"} {
+		failAttempt(t, guard, nil, username, "10.0.0.1")
+	}
+
+	var throttled *ThrottledError
+	if err := guard.Check(context.Background(), "alice", "10.0.0.1"); !errors.As(err, &throttled) {
+		t.Errorf("Expected the client IP to be refused, got %v", err)
+	}
+	if err := guard.Check(context.Background(), "alice", "10.0.0.2"); err != nil {
+		t.Errorf("Expected another client IP to be allowed, got %v", err)
+	}
+}
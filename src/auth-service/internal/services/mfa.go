@@ -0,0 +1,294 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"auth-service/internal/models"
+	"auth-service/pkg"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidMFAToken is returned for MFA challenge tokens that are unknown, expired or used up.
+	ErrInvalidMFAToken = errors.New("invalid or expired mfa token")
+	// ErrInvalidMFACode is returned for wrong or reused TOTP and recovery codes.
+	ErrInvalidMFACode = errors.New("invalid mfa code")
+	// ErrMFAAlreadyEnabled is returned when enrolling a user who already uses MFA.
+	ErrMFAAlreadyEnabled = errors.New("mfa is already enabled")
+	// ErrMFANotEnabled is returned for operations that need MFA to be set up first.
+	ErrMFANotEnabled = errors.New("mfa is not enabled")
+	// ErrMFAEnforced is returned when a user whose role requires MFA tries to turn it off.
+	ErrMFAEnforced = errors.New("mfa is required for this role")
+)
+
+const (
+	maxChallengeAttempts = 5
+	recoveryCodeCount    = 10
+)
+
+// MFAService manages TOTP enrollment, recovery codes and the second step of logging in.
+type MFAService struct {
+	db            *gorm.DB
+	issuer        string
+	requiredRoles map[string]bool
+	challengeTTL  time.Duration
+}
+
+// NewMFAService creates an MFAService. issuer is the name authenticator apps show for the
+// account, users with one of requiredRoles must use MFA, and MFA challenges are valid for
+// challengeTTL.
+func NewMFAService(db *gorm.DB, issuer string, requiredRoles []string, challengeTTL time.Duration) *MFAService {
+	roles := make(map[string]bool, len(requiredRoles))
+	for _, role := range requiredRoles {
+		roles[role] = true
+	}
+	return &MFAService{db: db, issuer: issuer, requiredRoles: roles, challengeTTL: challengeTTL}
+}
+
+// ChallengeTTL returns how long MFA challenges are valid for.
+func (s *MFAService) ChallengeTTL() time.Duration {
+	return s.challengeTTL
+}
+
+// StartChallenge returns the MFA challenge a user who has entered their password must complete
+// before tokens are issued, or an empty purpose if the user does not need one. Users with MFA
+// enabled must enter a code; users whose role requires MFA but who have not enrolled yet must
+// enroll.
+func (s *MFAService) StartChallenge(user *models.User) (token, purpose string, err error) {
+	switch {
+	case user.MFAEnabled:
+		purpose = models.ChallengeVerify
+	case s.requiredRoles[user.Role]:
+		purpose = models.ChallengeEnroll
+	default:
+		return "", "", nil
+	}
+
+	token, err = randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	err = models.CreateMFAChallenge(s.db, &models.MFAChallenge{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(s.challengeTTL),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return token, purpose, nil
+}
+
+// Challenge looks up a pending challenge with the given purpose and the user it belongs to.
+func (s *MFAService) Challenge(token, purpose string) (*models.MFAChallenge, *models.User, error) {
+	var challenge models.MFAChallenge
+	if err := models.GetMFAChallengeByHash(s.db, hashToken(token), &challenge); err != nil {
+		return nil, nil, ErrInvalidMFAToken
+	}
+	if challenge.Purpose != purpose || !challenge.ExpiresAt.After(time.Now()) || challenge.Attempts >= maxChallengeAttempts {
+		return nil, nil, ErrInvalidMFAToken
+	}
+
+	var user models.User
+	if err := models.GetUserByID(s.db, challenge.UserID, &user); err != nil {
+		return nil, nil, ErrInvalidMFAToken
+	}
+	return &challenge, &user, nil
+}
+
+// CompleteChallenge checks the code entered for a verify challenge and returns the user, who may
+// now be issued tokens. A challenge allows a few codes to be entered before it must be started
+// over. The user is also returned along with ErrInvalidMFACode, so that the failure can be counted
+// against the account.
+func (s *MFAService) CompleteChallenge(token, code string) (*models.User, error) {
+	challenge, user, err := s.Challenge(token, models.ChallengeVerify)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.claimAttempt(challenge); err != nil {
+		return nil, err
+	}
+
+	if err := s.verifyCode(user, code); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			return user, err
+		}
+		return nil, err
+	}
+
+	if err := models.DeleteMFAChallenge(s.db, challenge.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// FinishEnrollChallenge confirms the enrollment of a user logging in through an enroll challenge
+// and returns their recovery codes. The user may then be issued tokens.
+func (s *MFAService) FinishEnrollChallenge(challenge *models.MFAChallenge, user *models.User, code string) ([]string, error) {
+	if err := s.claimAttempt(challenge); err != nil {
+		return nil, err
+	}
+	codes, err := s.ConfirmEnrollment(user, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := models.DeleteMFAChallenge(s.db, challenge.ID); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// BeginEnrollment generates a new TOTP secret for a user and returns it along with its
+// provisioning URI. MFA is only enabled once a code generated from the secret is confirmed.
+func (s *MFAService) BeginEnrollment(user *models.User) (secret, uri string, err error) {
+	if user.MFAEnabled {
+		return "", "", ErrMFAAlreadyEnabled
+	}
+
+	secret, err = newTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	user.TOTPSecret = secret
+	user.TOTPLastStep = 0
+	if err := models.UpdateUserMFA(s.db, user); err != nil {
+		return "", "", err
+	}
+	return secret, totpURI(s.issuer, user.Username, secret), nil
+}
+
+// ConfirmEnrollment enables MFA for a user once they enter a code from their authenticator app,
+// and returns their recovery codes. The codes are only shown this once.
+func (s *MFAService) ConfirmEnrollment(user *models.User, code string) ([]string, error) {
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrMFANotEnabled
+	}
+	if err := s.verifyTOTP(user, code); err != nil {
+		return nil, err
+	}
+
+	user.MFAEnabled = true
+	if err := models.UpdateUserMFA(s.db, user); err != nil {
+		return nil, err
+	}
+	pkg.Logger.Infof("User %d enabled MFA", user.ID)
+	return s.newRecoveryCodes(user)
+}
+
+// Disable turns off MFA for a user, who must confirm with a current code. Users whose role
+// requires MFA cannot turn it off.
+func (s *MFAService) Disable(user *models.User, code string) error {
+	if !user.MFAEnabled {
+		return ErrMFANotEnabled
+	}
+	if s.requiredRoles[user.Role] {
+		return ErrMFAEnforced
+	}
+	if err := s.verifyCode(user, code); err != nil {
+		return err
+	}
+
+	user.MFAEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPLastStep = 0
+	if err := models.UpdateUserMFA(s.db, user); err != nil {
+		return err
+	}
+	pkg.Logger.Infof("User %d disabled MFA", user.ID)
+	return models.DeleteRecoveryCodes(s.db, user.ID)
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes, after confirming with a current code.
+func (s *MFAService) RegenerateRecoveryCodes(user *models.User, code string) ([]string, error) {
+	if !user.MFAEnabled {
+		return nil, ErrMFANotEnabled
+	}
+	if err := s.verifyCode(user, code); err != nil {
+		return nil, err
+	}
+	return s.newRecoveryCodes(user)
+}
+
+// verifyCode accepts either a TOTP code or an unused recovery code.
+func (s *MFAService) verifyCode(user *models.User, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		return s.verifyTOTP(user, code)
+	}
+
+	used, err := models.UseRecoveryCode(s.db, user.ID, hashToken(normalizeRecoveryCode(code)), time.Now())
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidMFACode
+	}
+	pkg.Logger.Infof("User %d used a recovery code", user.ID)
+	return nil
+}
+
+// verifyTOTP accepts a TOTP code that has not been used before.
+func (s *MFAService) verifyTOTP(user *models.User, code string) error {
+	step, ok := matchTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	accepted, err := models.AcceptTOTPStep(s.db, user.ID, step)
+	if err != nil {
+		return err
+	}
+	if !accepted {
+		return ErrInvalidMFACode
+	}
+	user.TOTPLastStep = step
+	return nil
+}
+
+// claimAttempt counts a code entered for a challenge, or fails with ErrInvalidMFAToken once the
+// challenge has used up its attempts.
+func (s *MFAService) claimAttempt(challenge *models.MFAChallenge) error {
+	claimed, err := models.ClaimMFAChallengeAttempt(s.db, challenge.ID, maxChallengeAttempts, time.Now())
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrInvalidMFAToken
+	}
+	return nil
+}
+
+// newRecoveryCodes replaces a user's recovery codes with new ones and returns them.
+func (s *MFAService) newRecoveryCodes(user *models.User) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashToken(code)
+	}
+
+	if err := models.ReplaceRecoveryCodes(s.db, user.ID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode strips the separators and case users may type a recovery code with.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
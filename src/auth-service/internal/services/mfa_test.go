@@ -0,0 +1,223 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"auth-service/internal/models"
+	"auth-service/pkg"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB opens a migrated database in a temporary directory.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	if pkg.Logger == nil {
+		pkg.InitLogger()
+	}
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "users.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SQLite allows one writer at a time
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	err = db.AutoMigrate(&models.User{}, &models.RefreshToken{}, &models.RecoveryCode{}, &models.MFAChallenge{}, &models.AccountToken{}, &models.AuthEvent{}, &models.APIKey{}, &models.ExternalIdentity{}, &models.SSOState{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestUser stores a user with the given role.
+func newTestUser(t *testing.T, db *gorm.DB, username, role string) *models.User {
+	t.Helper()
+	user := &models.User{Username: username, Email: username + "@example.com", Password: "unused", Role: role, EmailVerified: true}
+	if err := models.CreateUser(db, user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// codeAt returns the TOTP code of secret for the time step offset steps from now.
+func codeAt(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return totpCode(key, time.Now().Unix()/totpPeriod+offset)
+}
+
+// wrongCode returns a code that matches none of the time steps currently accepted for secret.
+func wrongCode(t *testing.T, secret string) string {
+	t.Helper()
+	valid := map[string]bool{}
+	for offset := int64(-totpSkew); offset <= totpSkew; offset++ {
+		valid[codeAt(t, secret, offset)] = true
+	}
+	for _, code := range []string{"000000", "111111", "222222", "333333"} {
+		if !valid[code] {
+			return code
+		}
+	}
+	t.Fatal("no wrong code found")
+	return ""
+}
+
+// enroll enables MFA for a user and returns the recovery codes.
+func enroll(t *testing.T, mfa *MFAService, user *models.User) []string {
+	t.Helper()
+	secret, uri, err := mfa.BeginEnrollment(user)
+	if err != nil {
+		t.Fatalf("BeginEnrollment failed: %v", err)
+	}
+	if !strings.HasPrefix(uri, "otpauth://totp/") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("Unexpected provisioning URI %q", uri)
+	}
+
+	if _, err := mfa.ConfirmEnrollment(user, wrongCode(t, secret)); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("Expected a wrong code to be rejected, got %v", err)
+	}
+	codes, err := mfa.ConfirmEnrollment(user, codeAt(t, secret, -1))
+	if err != nil {
+		t.Fatalf("ConfirmEnrollment failed: %v", err)
+	}
+	return codes
+}
+
+func TestMFAEnrollmentRequiresValidCode(t *testing.T) {
+	db := newTestDB(t)
+	mfa := NewMFAService(db, "File Picker", nil, time.Minute)
+	user := newTestUser(t, db, "alice", models.RoleEditor)
+
+	codes := enroll(t, mfa, user)
+	if len(codes) != recoveryCodeCount {
+		t.Errorf("Expected %d recovery codes, got %d", recoveryCodeCount, len(codes))
+	}
+
+	var stored models.User
+	if err := models.GetUserByID(db, user.ID, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if !stored.MFAEnabled || stored.TOTPSecret == "" {
+		t.Errorf("Expected MFA to be enabled, got %+v", stored)
+	}
+}
+
+func TestMFAChallengeRejectsReplayedCode(t *testing.T) {
+	db := newTestDB(t)
+	mfa := NewMFAService(db, "File Picker", nil, time.Minute)
+	user := newTestUser(t, db, "alice", models.RoleEditor)
+	enroll(t, mfa, user)
+
+	// The code of the step confirmed at enrollment has been used already
+	token, purpose, err := mfa.StartChallenge(user)
+	if err != nil || purpose != models.ChallengeVerify {
+		t.Fatalf("Expected a verify challenge, got %q (%v)", purpose, err)
+	}
+	if _, err := mfa.CompleteChallenge(token, codeAt(t, user.TOTPSecret, -1)); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("Expected a replayed code to be rejected, got %v", err)
+	}
+
+	code := codeAt(t, user.TOTPSecret, 0)
+	if _, err := mfa.CompleteChallenge(token, code); err != nil {
+		t.Fatalf("Expected the current code to be accepted, got %v", err)
+	}
+
+	token, _, err = mfa.StartChallenge(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfa.CompleteChallenge(token, code); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("Expected a code to be accepted only once, got %v", err)
+	}
+}
+
+func TestMFARecoveryCodesAreSingleUse(t *testing.T) {
+	db := newTestDB(t)
+	mfa := NewMFAService(db, "File Picker", nil, time.Minute)
+	user := newTestUser(t, db, "alice", models.RoleEditor)
+	codes := enroll(t, mfa, user)
+
+	// Recovery codes may be typed without the separator and in upper case
+	typed := strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))
+	token, _, err := mfa.StartChallenge(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfa.CompleteChallenge(token, typed); err != nil {
+		t.Fatalf("Expected the recovery code to be accepted, got %v", err)
+	}
+
+	token, _, err = mfa.StartChallenge(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfa.CompleteChallenge(token, codes[0]); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("Expected a used recovery code to be rejected, got %v", err)
+	}
+
+	// Regenerating the codes invalidates the old ones
+	fresh, err := mfa.RegenerateRecoveryCodes(user, codes[1])
+	if err != nil {
+		t.Fatalf("RegenerateRecoveryCodes failed: %v", err)
+	}
+	if _, err := mfa.CompleteChallenge(token, codes[2]); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("Expected an old recovery code to be rejected, got %v", err)
+	}
+	if _, err := mfa.CompleteChallenge(token, fresh[0]); err != nil {
+		t.Errorf("Expected a new recovery code to be accepted, got %v", err)
+	}
+}
+
+func TestMFAChallengeAttemptLimitHoldsUnderConcurrency(t *testing.T) {
+	db := newTestDB(t)
+	mfa := NewMFAService(db, "File Picker", nil, time.Minute)
+	user := newTestUser(t, db, "alice", models.RoleEditor)
+	enroll(t, mfa, user)
+	token, _, err := mfa.StartChallenge(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrong := wrongCode(t, user.TOTPSecret)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	checked := 0
+	for i := 0; i < 4*maxChallengeAttempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := mfa.CompleteChallenge(token, wrong)
+			if errors.Is(err, ErrInvalidMFACode) {
+				mu.Lock()
+				checked++
+				mu.Unlock()
+			} else if !errors.Is(err, ErrInvalidMFAToken) {
+				t.Errorf("Unexpected error %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if checked != maxChallengeAttempts {
+		t.Errorf("Expected %d codes to be checked, got %d", maxChallengeAttempts, checked)
+	}
+	if _, err := mfa.CompleteChallenge(token, codeAt(t, user.TOTPSecret, 0)); !errors.Is(err, ErrInvalidMFAToken) {
+		t.Errorf("Expected the used up challenge to be rejected, got %v", err)
+	}
+}
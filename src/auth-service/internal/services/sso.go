@@ -0,0 +1,378 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"auth-service/internal/models"
+	"auth-service/internal/oidc"
+	"auth-service/pkg"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrUnknownProvider is returned for identity providers that are not configured.
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	// ErrProviderFailed is returned when the identity provider cannot be reached or refuses to
+	// complete a login.
+	ErrProviderFailed = errors.New("login with identity provider failed")
+	// ErrInvalidSSOState is returned for SSO callbacks whose state is unknown, expired or used.
+	ErrInvalidSSOState = errors.New("invalid or expired login state")
+	// ErrSSOEmailMissing is returned when a new user's ID token has no email address.
+	ErrSSOEmailMissing = errors.New("identity provider did not return an email address")
+	// ErrSSOEmailTaken is returned when a new user's email address belongs to a local account
+	// that may not be linked automatically.
+	ErrSSOEmailTaken = errors.New("email address belongs to an existing account")
+)
+
+// SSOProvider is an OpenID provider users can log in with, and how its users map to local ones.
+type SSOProvider struct {
+	OIDC        *oidc.Provider
+	GroupsClaim string            // ID token claim listing the user's groups; dots separate nested claims
+	RoleMapping map[string]string // Roles of group members; the highest applies. When set, roles are synced at every login
+	DefaultRole string            // Role of users in none of the mapped groups
+	LinkByEmail bool              // Link new subjects to the local account with their verified email address
+	TrustMFA    bool              // The provider enforces MFA, so local MFA is not asked for
+}
+
+// SSOService logs users in through external OpenID providers, provisioning local users for new
+// subjects.
+type SSOService struct {
+	db        *gorm.DB
+	tokens    *TokenService
+	providers map[string]*SSOProvider
+	stateTTL  time.Duration
+}
+
+// NewSSOService creates an SSOService for providers. Logins must be completed within stateTTL.
+func NewSSOService(db *gorm.DB, tokens *TokenService, providers []*SSOProvider, stateTTL time.Duration) *SSOService {
+	byName := make(map[string]*SSOProvider, len(providers))
+	for _, provider := range providers {
+		byName[provider.OIDC.Name()] = provider
+	}
+	return &SSOService{db: db, tokens: tokens, providers: byName, stateTTL: stateTTL}
+}
+
+// Providers returns the names of the configured providers.
+func (s *SSOService) Providers() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TrustsMFA reports whether logins through a provider skip local MFA.
+func (s *SSOService) TrustsMFA(name string) bool {
+	provider, ok := s.providers[name]
+	return ok && provider.TrustMFA
+}
+
+// StateTTL returns how long users have to complete a login.
+func (s *SSOService) StateTTL() time.Duration {
+	return s.stateTTL
+}
+
+// Begin starts a login with a provider and returns the URL to send the user to, and the state
+// the login must be completed with. The state must be kept in the user's browser, so that a
+// callback can only complete a login the same browser started.
+func (s *SSOService) Begin(ctx context.Context, name string) (authURL, state string, err error) {
+	provider, ok := s.providers[name]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+
+	state, err = oidc.RandomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := oidc.RandomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err = provider.OIDC.AuthCodeURL(ctx, state, nonce, challenge)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrProviderFailed, err)
+	}
+	err = models.CreateSSOState(s.db, &models.SSOState{
+		StateHash:    hashToken(state),
+		Provider:     name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(s.stateTTL),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, state, nil
+}
+
+// Complete finishes a login with the state and authorization code the provider sent the user
+// back with, and returns the local user of the provider's subject. browserState is the state Begin
+// returned, as kept in the user's browser; it must match state. Users are provisioned on their
+// first login, and their roles follow their groups when the provider maps groups to roles.
+func (s *SSOService) Complete(ctx context.Context, name, state, browserState, code string) (*models.User, error) {
+	provider, ok := s.providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	if browserState == "" || subtle.ConstantTimeCompare([]byte(state), []byte(browserState)) != 1 {
+		return nil, ErrInvalidSSOState
+	}
+
+	var started models.SSOState
+	taken, err := models.TakeSSOState(s.db, hashToken(state), &started)
+	if err != nil {
+		return nil, err
+	}
+	if !taken || started.Provider != name || !started.ExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidSSOState
+	}
+
+	claims, err := provider.OIDC.Exchange(ctx, code, started.CodeVerifier, started.Nonce)
+	if errors.Is(err, oidc.ErrInvalidIDToken) {
+		pkg.Logger.Warnf("Rejected ID token from %s: %v", name, err)
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderFailed, err)
+	}
+	subject, _ := claims.GetSubject()
+	email := models.NormalizeEmail(claimString(claims, "email"))
+
+	user, identity, err := s.findUser(name, provider, subject, email, claimBool(claims, "email_verified"))
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		if user, identity, err = s.provision(name, provider, subject, email, claims); err != nil {
+			return nil, err
+		}
+	} else if err := s.syncRole(name, provider, user, claims); err != nil {
+		return nil, err
+	}
+
+	if err := models.RecordExternalLogin(s.db, identity.ID, email, time.Now()); err != nil {
+		pkg.Logger.Warnf("Failed to record login of user %d through %s: %v", user.ID, name, err)
+	}
+	return user, nil
+}
+
+// findUser returns the local user of a provider's subject, linking the subject to the account
+// with its email address if the provider allows it. Only accounts whose owner has verified the
+// address are linked, and never admin or service accounts. It returns no user if there is none.
+func (s *SSOService) findUser(name string, provider *SSOProvider, subject, email string, emailVerified bool) (*models.User, *models.ExternalIdentity, error) {
+	var identity models.ExternalIdentity
+	if err := models.GetExternalIdentity(s.db, name, subject, &identity); err == nil {
+		var user models.User
+		if err := models.GetUserByID(s.db, identity.UserID, &user); err != nil {
+			return nil, nil, err
+		}
+		return &user, &identity, nil
+	}
+
+	if !provider.LinkByEmail || !emailVerified || email == "" {
+		return nil, nil, nil
+	}
+	var user models.User
+	if err := models.GetUserByEmail(s.db, email, &user); err != nil {
+		return nil, nil, nil
+	}
+	if !user.EmailVerified || user.Role == models.RoleAdmin || user.ServiceAccount {
+		pkg.Logger.Warnf("Refused to link subject %s of %s to user %d by email address", subject, name, user.ID)
+		return nil, nil, nil
+	}
+	identity = models.ExternalIdentity{UserID: user.ID, Provider: name, Subject: subject, Email: email}
+	if err := models.CreateExternalIdentity(s.db, &identity); err != nil {
+		return nil, nil, err
+	}
+	pkg.Logger.Infof("Linked subject %s of %s to user %d by email address", subject, name, user.ID)
+	return &user, &identity, nil
+}
+
+// provision creates the local user of a provider's new subject. The user has a random password,
+// so that they can only log in through the provider until they reset it.
+func (s *SSOService) provision(name string, provider *SSOProvider, subject, email string, claims jwt.MapClaims) (*models.User, *models.ExternalIdentity, error) {
+	if email == "" {
+		return nil, nil, ErrSSOEmailMissing
+	}
+	var existing models.User
+	if err := models.GetUserByEmail(s.db, email, &existing); err == nil {
+		return nil, nil, ErrSSOEmailTaken
+	}
+
+	password, err := randomToken(32)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user := &models.User{
+		Password:      string(hashedPassword),
+		Email:         email,
+		Role:          provider.roleFor(claimStrings(claims, provider.GroupsClaim)),
+		EmailVerified: claimBool(claims, "email_verified"),
+	}
+	identity := &models.ExternalIdentity{Provider: name, Subject: subject, Email: email}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		username, err := availableUsername(tx, claimString(claims, "preferred_username"), email)
+		if err != nil {
+			return err
+		}
+		user.Username = username
+		if err := models.CreateUser(tx, user); err != nil {
+			return err
+		}
+		identity.UserID = user.ID
+		return models.CreateExternalIdentity(tx, identity)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pkg.Logger.Infof("Provisioned user %d (%s) with role %q for subject %s of %s", user.ID, user.Username, user.Role, subject, name)
+	return user, identity, nil
+}
+
+// syncRole sets a user's role to the one their groups map to, if the provider maps groups to
+// roles. The user's sessions and API keys are revoked when the role changes. The last enabled
+// admin keeps their role, so that the provider cannot leave nobody able to manage users.
+func (s *SSOService) syncRole(name string, provider *SSOProvider, user *models.User, claims jwt.MapClaims) error {
+	if len(provider.RoleMapping) == 0 {
+		return nil
+	}
+	role := provider.roleFor(claimStrings(claims, provider.GroupsClaim))
+	if role == user.Role {
+		return nil
+	}
+	if err := checkNotLastAdmin(s.db, user); errors.Is(err, ErrLastAdmin) {
+		pkg.Logger.Warnf("Groups at %s would demote user %d, the last enabled admin, to %q; keeping role %q", name, user.ID, role, user.Role)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := models.UpdateUserRole(s.db, user.ID, role); err != nil {
+		return err
+	}
+	if err := s.tokens.RevokeUserSessions(user.ID); err != nil {
+		return err
+	}
+	pkg.Logger.Infof("Groups at %s changed the role of user %d from %q to %q", name, user.ID, user.Role, role)
+	user.Role = role
+	return nil
+}
+
+// roleFor returns the highest role the groups map to, or the default role.
+func (p *SSOProvider) roleFor(groups []string) string {
+	best := ""
+	for _, group := range groups {
+		if role, ok := p.RoleMapping[group]; ok && models.RoleRank(role) > models.RoleRank(best) {
+			best = role
+		}
+	}
+	if best == "" {
+		return p.DefaultRole
+	}
+	return best
+}
+
+var invalidUsernameChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// availableUsername derives an unused username from the preferred username claim, or else from
+// the local part of the email address, adding a number if it is taken.
+func availableUsername(db *gorm.DB, preferred, email string) (string, error) {
+	base := models.NormalizeUsername(preferred)
+	if base == "" {
+		base, _, _ = strings.Cut(email, "@")
+	}
+	base = invalidUsernameChars.ReplaceAllString(base, "-")
+	base = strings.TrimLeft(base, "._-")
+	if len(base) > maxUsernameLength-4 {
+		base = base[:maxUsernameLength-4]
+	}
+	if len(base) < minUsernameLength {
+		base = "user"
+	}
+
+	for i := 1; i <= 100; i++ {
+		username := base
+		if i > 1 {
+			username = fmt.Sprintf("%s-%d", base, i)
+		}
+		var existing models.User
+		if err := models.GetUserByUsername(db, username, &existing); err != nil {
+			return username, nil
+		}
+	}
+	suffix, err := randomToken(3)
+	if err != nil {
+		return "", err
+	}
+	return base + "-" + strings.ToLower(invalidUsernameChars.ReplaceAllString(suffix, "")), nil
+}
+
+// claimString returns a string claim, or an empty string.
+func claimString(claims jwt.MapClaims, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// claimBool returns a boolean claim, which some providers send as a string.
+func claimBool(claims jwt.MapClaims, name string) bool {
+	switch value := claims[name].(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	}
+	return false
+}
+
+// claimStrings returns a claim holding a list of strings, or a single string. Dots in path
+// separate nested claims, as in realm_access.roles.
+func claimStrings(claims jwt.MapClaims, path string) []string {
+	if path == "" {
+		return nil
+	}
+	var value interface{} = map[string]interface{}(claims)
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"auth-service/internal/models"
+	"auth-service/internal/oidc"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// fakeProvider is an OpenID provider that issues ID tokens with the claims a test sets.
+type fakeProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu          sync.Mutex
+	claims      jwt.MapClaims // Claims of the next ID token, besides iss, aud, iat, exp and nonce
+	nonce       string        // Nonce of the login the next ID token is issued for
+	tokenCalls  int
+	jwksFetches int
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.jwksFetches++
+		p.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.tokenCalls++
+		claims := jwt.MapClaims{"iss": p.server.URL, "aud": "file-picker", "iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix(), "nonce": p.nonce}
+		for name, value := range p.claims {
+			claims[name] = value
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+	})
+	p.server = httptest.NewTLSServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// oidcProvider returns a Provider for the fake provider, which trusts its test certificate.
+func (p *fakeProvider) oidcProvider() *oidc.Provider {
+	return oidc.NewProvider(oidc.Config{
+		Name:        "corp",
+		Issuer:      p.server.URL,
+		ClientID:    "file-picker",
+		RedirectURL: "https://files.example.com/api/sso/corp/callback",
+		HTTPClient:  p.server.Client(),
+	})
+}
+
+// login starts a login and completes it with the ID token claims given, as the browser that
+// started it.
+func (p *fakeProvider) login(t *testing.T, sso *SSOService, claims jwt.MapClaims) (*models.User, error) {
+	t.Helper()
+	authURL, state, err := sso.Begin(context.Background(), "corp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	p.claims, p.nonce = claims, parsed.Query().Get("nonce")
+	p.mu.Unlock()
+	return sso.Complete(context.Background(), "corp", state, state, "code")
+}
+
+// newTestSSO creates an SSOService for provider, which maps the admins and engineering groups.
+func newTestSSO(t *testing.T, db *gorm.DB, provider *fakeProvider, linkByEmail bool) *SSOService {
+	t.Helper()
+	tokens, err := NewTokenService(db, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSSOService(db, tokens, []*SSOProvider{{
+		OIDC:        provider.oidcProvider(),
+		GroupsClaim: "groups",
+		RoleMapping: map[string]string{"admins": models.RoleAdmin, "engineering": models.RoleEditor},
+		DefaultRole: models.RoleViewer,
+		LinkByEmail: linkByEmail,
+	}}, time.Minute)
+}
+
+func TestSSOProvisionsUser(t *testing.T) {
+	db := newTestDB(t)
+	provider := newFakeProvider(t)
+	sso := newTestSSO(t, db, provider, false)
+	claims := jwt.MapClaims{"sub": "u-1", "email": "Dana@Example.com", "email_verified": true, "preferred_username": "dana", "groups": []string{"engineering"}}
+
+	user, err := provider.login(t, sso, claims)
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if user.Username != "dana" || user.Email != "dana@example.com" || user.Role != models.RoleEditor || !user.EmailVerified {
+		t.Errorf("Expected an editor to be provisioned, got %+v", user)
+	}
+
+	// The subject logs in as the same user, and the JWKS is not fetched again
+	again, err := provider.login(t, sso, claims)
+	if err != nil || again.ID != user.ID {
+		t.Errorf("Expected the same user, got %+v (%v)", again, err)
+	}
+	if provider.jwksFetches != 1 {
+		t.Errorf("Expected the JWKS to be fetched once, got %d", provider.jwksFetches)
+	}
+}
+
+func TestSSORefusesStateFromAnotherBrowser(t *testing.T) {
+	db := newTestDB(t)
+	provider := newFakeProvider(t)
+	sso := newTestSSO(t, db, provider, false)
+
+	// An attacker starts a login and gets the victim's browser to follow the callback URL
+	_, state, err := sso.Begin(context.Background(), "corp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, browserState := range []string{"", "other-state"} {
+		if _, err := sso.Complete(context.Background(), "corp", state, browserState, "code"); !errors.Is(err, ErrInvalidSSOState) {
+			t.Errorf("Expected a callback without the browser's state to be refused, got %v", err)
+		}
+	}
+	if provider.tokenCalls != 0 {
+		t.Errorf("Expected the code not to be redeemed, got %d token requests", provider.tokenCalls)
+	}
+}
+
+func TestSSOLinksOnlyVerifiedUnprivilegedAccounts(t *testing.T) {
+	db := newTestDB(t)
+	provider := newFakeProvider(t)
+	sso := newTestSSO(t, db, provider, true)
+
+	newTestUser(t, db, "root", models.RoleAdmin)
+	unverified := newTestUser(t, db, "erin", models.RoleEditor)
+	if err := db.Model(unverified).Update("email_verified", false).Error; err != nil {
+		t.Fatal(err)
+	}
+	frank := newTestUser(t, db, "frank", models.RoleEditor)
+
+	for i, email := range []string{"root@example.com", "erin@example.com"} {
+		claims := jwt.MapClaims{"sub": "u-" + email, "email": email, "email_verified": true, "groups": []string{"admins"}}
+		if _, err := provider.login(t, sso, claims); !errors.Is(err, ErrSSOEmailTaken) {
+			t.Errorf("Expected account %d not to be linked, got %v", i, err)
+		}
+	}
+
+	claims := jwt.MapClaims{"sub": "u-frank", "email": "frank@example.com", "email_verified": true, "groups": []string{"engineering"}}
+	user, err := provider.login(t, sso, claims)
+	if err != nil || user.ID != frank.ID {
+		t.Errorf("Expected the verified editor to be linked, got %+v (%v)", user, err)
+	}
+}
+
+func TestSSOKeepsLastAdmin(t *testing.T) {
+	db := newTestDB(t)
+	provider := newFakeProvider(t)
+	sso := newTestSSO(t, db, provider, false)
+
+	admin := newTestUser(t, db, "root", models.RoleAdmin)
+	if err := models.CreateExternalIdentity(db, &models.ExternalIdentity{UserID: admin.ID, Provider: "corp", Subject: "u-root"}); err != nil {
+		t.Fatal(err)
+	}
+	claims := jwt.MapClaims{"sub": "u-root", "email": "root@example.com", "groups": []string{"engineering"}}
+
+	user, err := provider.login(t, sso, claims)
+	if err != nil || user.Role != models.RoleAdmin {
+		t.Fatalf("Expected the last admin to keep their role, got %+v (%v)", user, err)
+	}
+
+	// With another admin, the groups apply
+	newTestUser(t, db, "root2", models.RoleAdmin)
+	user, err = provider.login(t, sso, claims)
+	if err != nil || user.Role != models.RoleEditor {
+		t.Errorf("Expected the admin to be demoted, got %+v (%v)", user, err)
+	}
+}
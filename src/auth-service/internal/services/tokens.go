@@ -0,0 +1,239 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"auth-service/internal/models"
+	"auth-service/pkg"
+
+	"gorm.io/gorm"
+	"shared/authn"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned for refresh tokens that are unknown, expired or revoked.
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when a refresh token is presented a second time. The
+	// token may have been stolen, so the whole session is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reused, session revoked")
+	// ErrAccountDisabled is returned when tokens are requested for a disabled account.
+	ErrAccountDisabled = errors.New("account disabled")
+)
+
+// TokenPair is the result of a login or a refresh.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"` // Lifetime of the access token in seconds
+}
+
+// TokenService issues short-lived access tokens and the rotating refresh tokens used to renew them.
+type TokenService struct {
+	db         *gorm.DB
+	verifier   *authn.Verifier
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+// NewTokenService creates a TokenService issuing access tokens valid for accessTTL and refresh
+// tokens valid for refreshTTL.
+func NewTokenService(db *gorm.DB, accessTTL, refreshTTL time.Duration) (*TokenService, error) {
+	verifier, err := newAccessVerifier()
+	if err != nil {
+		return nil, err
+	}
+	return &TokenService{db: db, verifier: verifier, accessTTL: accessTTL, refreshTTL: refreshTTL}, nil
+}
+
+// AccessTTL returns how long access tokens are valid for.
+func (s *TokenService) AccessTTL() time.Duration {
+	return s.accessTTL
+}
+
+// IssueTokens starts a new session for a user who has just logged in.
+func (s *TokenService) IssueTokens(user *models.User) (*TokenPair, error) {
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
+
+	familyID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	// Expired tokens of earlier sessions are no longer needed, not even to detect reuse
+	if err := models.DeleteExpiredRefreshTokens(s.db, user.ID, time.Now()); err != nil {
+		pkg.Logger.Warnf("Failed to delete expired refresh tokens of user %d: %v", user.ID, err)
+	}
+
+	return s.issue(s.db, user, familyID)
+}
+
+// Refresh exchanges a refresh token for a new token pair in the same session. Each refresh token
+// can be exchanged once; presenting one again revokes the session, since either the client or an
+// attacker holds a stolen copy. Tokens of revoked sessions are refused even if they were issued
+// while the session was being revoked.
+func (s *TokenService) Refresh(refreshToken string) (*TokenPair, error) {
+	var token models.RefreshToken
+	if err := models.GetRefreshTokenByHash(s.db, hashToken(refreshToken), &token); err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	now := time.Now()
+	if token.RevokedAt != nil || !token.ExpiresAt.After(now) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// The token is used up, the session checked and the next token issued in one transaction, so
+	// that a token is not issued in a session revoked by then
+	var pair *TokenPair
+	var reused, revoked bool
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		used, err := models.MarkRefreshTokenUsed(tx, token.ID, now)
+		if err != nil || !used {
+			reused = !used
+			return err
+		}
+		if revoked, err = models.IsSessionRevoked(tx, token.FamilyID); err != nil || revoked {
+			return err
+		}
+
+		var user models.User
+		if err := models.GetUserByID(tx, token.UserID, &user); err != nil || user.Disabled {
+			return ErrInvalidRefreshToken
+		}
+		pair, err = s.issue(tx, &user, token.FamilyID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case reused:
+		pkg.Logger.Warnf("Refresh token reused for user %d, revoking session %s", token.UserID, token.FamilyID)
+		if err := models.RevokeRefreshTokenFamily(s.db, token.FamilyID, now); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	case revoked:
+		// The token was issued while the session was being revoked and escaped it
+		pkg.Logger.Warnf("Refresh token of revoked session %s presented for user %d, revoking it", token.FamilyID, token.UserID)
+		if err := models.RevokeRefreshTokenFamily(s.db, token.FamilyID, now); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+	return pair, nil
+}
+
+// Logout revokes the session a refresh token belongs to. Logging out of an unknown or already
+// revoked session does nothing.
+func (s *TokenService) Logout(refreshToken string) error {
+	var token models.RefreshToken
+	if err := models.GetRefreshTokenByHash(s.db, hashToken(refreshToken), &token); err != nil {
+		return nil
+	}
+
+	pkg.Logger.Infof("User %d logged out of session %s", token.UserID, token.FamilyID)
+	return models.RevokeRefreshTokenFamily(s.db, token.FamilyID, time.Now())
+}
+
+// Authenticate verifies an access token and returns the user it was issued to. Tokens of revoked
+// sessions and disabled accounts are rejected straight away rather than once they expire.
+func (s *TokenService) Authenticate(accessToken string) (*models.User, *authn.Claims, error) {
+	claims, err := s.verifier.Verify(accessToken)
+	if err != nil || claims.SessionID == "" {
+		return nil, nil, ErrInvalidAccessToken
+	}
+
+	revoked, err := models.IsSessionRevoked(s.db, claims.SessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if revoked {
+		return nil, nil, ErrInvalidAccessToken
+	}
+
+	var user models.User
+	if err := models.GetUserByID(s.db, claims.UserID, &user); err != nil || user.Disabled {
+		return nil, nil, ErrInvalidAccessToken
+	}
+	return &user, claims, nil
+}
+
+// RevokeUserSessions logs a user out of every session and revokes their API keys, so that a
+// password reset, role change or disabled account leaves no credential of the user working.
+func (s *TokenService) RevokeUserSessions(userID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return models.RevokeUserCredentials(tx, userID, time.Now())
+	})
+}
+
+// RevokedSessions lists the sessions, and API keys, revoked recently enough that access tokens
+// issued for them may not have expired yet. Services verifying access tokens reject those whose
+// sid is listed.
+func (s *TokenService) RevokedSessions() ([]models.RevokedSession, error) {
+	since := time.Now().Add(-s.accessTTL)
+	sessions, err := models.ListRevokedSessions(s.db, since)
+	if err != nil {
+		return nil, err
+	}
+	keySessions, err := models.ListRevokedAPIKeySessions(s.db, since)
+	if err != nil {
+		return nil, err
+	}
+	return append(sessions, keySessions...), nil
+}
+
+// issue creates an access token and a refresh token in the given session, storing the refresh
+// token with db.
+func (s *TokenService) issue(db *gorm.DB, user *models.User, familyID string) (*TokenPair, error) {
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	err = models.CreateRefreshToken(db, &models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: time.Now().Add(s.refreshTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := CreateJWT(user.ID, user.Username, user.Email, user.Role, familyID, "", false, s.accessTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.accessTTL / time.Second),
+	}, nil
+}
+
+// randomToken returns n random bytes encoded for use in URLs and JSON.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hash under which a token is stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
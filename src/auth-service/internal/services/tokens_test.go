@@ -0,0 +1,69 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"auth-service/internal/models"
+	"auth-service/pkg"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// useTestSigningKey signs access tokens with a fresh Ed25519 key for the rest of the test.
+func useTestSigningKey(t *testing.T) {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &pkg.SigningKey{ID: "test", Method: jwt.SigningMethodEdDSA, Key: private}
+	previous := pkg.Keys
+	pkg.Keys = &pkg.KeySet{Signing: key, Keys: []*pkg.SigningKey{key}}
+	t.Cleanup(func() { pkg.Keys = previous })
+}
+
+func TestRefreshRejectsRevokedSession(t *testing.T) {
+	useTestSigningKey(t)
+	db := newTestDB(t)
+	user := newTestUser(t, db, "alice", "viewer")
+	tokens, err := NewTokenService(db, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := tokens.IssueTokens(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := tokens.Refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The session was revoked while the second token was being issued, so only the first was
+	// marked revoked
+	err = db.Model(&models.RefreshToken{}).Where("token_hash = ?", hashToken(first.RefreshToken)).Update("revoked_at", time.Now()).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tokens.Refresh(second.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("Expected the escaped token to be refused, got %v", err)
+	}
+	var token models.RefreshToken
+	if err := models.GetRefreshTokenByHash(db, hashToken(second.RefreshToken), &token); err != nil {
+		t.Fatal(err)
+	}
+	if token.RevokedAt == nil {
+		t.Error("Expected the escaped token to be revoked")
+	}
+	var count int64
+	db.Model(&models.RefreshToken{}).Where("family_id = ?", token.FamilyID).Count(&count)
+	if count != 2 {
+		t.Errorf("Expected no token to be issued in the revoked session, got %d tokens", count)
+	}
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"auth-service/internal/models"
+	"auth-service/pkg"
+
+	"gorm.io/gorm"
+)
+
+// Delivery retry schedule: the wait doubles after each failed attempt, up to maxDeliveryBackoff,
+// and a delivery is given up on after maxDeliveryAttempts.
+const (
+	initialDeliveryBackoff = 10 * time.Second
+	maxDeliveryBackoff     = time.Hour
+	maxDeliveryAttempts    = 30
+	deliveryBatchSize      = 50
+)
+
+// UserEvent is a change to a user account announced to other services.
+type UserEvent struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	UserID     uint      `json:"user_id"`
+	ActorID    uint      `json:"actor_id"`   // The admin who made the change
+	ActorRole  string    `json:"actor_role"` // The admin's role, which receivers check before acting
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// UserEventDispatcher delivers user events to the webhook endpoints of other services, such as
+// the file-picker-service, which purges the data of deleted users. Events are stored in an outbox
+// table along with the change that caused them and delivered in the background, with retries.
+// Each request is signed: X-Event-Signature holds "sha256=" and the hex HMAC-SHA256, keyed with
+// the shared secret, of the X-Event-Timestamp header, a dot and the body.
+type UserEventDispatcher struct {
+	db        *gorm.DB
+	endpoints []string
+	secret    string
+	client    *http.Client
+}
+
+// NewUserEventDispatcher creates a UserEventDispatcher delivering to endpoints, signed with secret.
+func NewUserEventDispatcher(db *gorm.DB, endpoints []string, secret string) *UserEventDispatcher {
+	return &UserEventDispatcher{
+		db:        db,
+		endpoints: endpoints,
+		secret:    secret,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Enqueue stores an event for delivery to every endpoint through db, which should be the
+// transaction making the change the event announces.
+func (d *UserEventDispatcher) Enqueue(db *gorm.DB, event UserEvent) error {
+	if event.ID == "" {
+		id, err := randomToken(16)
+		if err != nil {
+			return err
+		}
+		event.ID = id
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	deliveries := make([]models.UserEventDelivery, len(d.endpoints))
+	for i, endpoint := range d.endpoints {
+		deliveries[i] = models.UserEventDelivery{
+			EventID:       event.ID,
+			Endpoint:      endpoint,
+			Payload:       string(payload),
+			NextAttemptAt: event.OccurredAt,
+		}
+	}
+	return models.CreateUserEventDeliveries(db, deliveries)
+}
+
+// Start delivers due events every interval until stop is closed.
+func (d *UserEventDispatcher) Start(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := d.DeliverDue(); err != nil {
+				pkg.Logger.Errorf("Failed to deliver user events: %v", err)
+			}
+		}
+	}
+}
+
+// DeliverDue attempts every delivery whose next attempt is due.
+func (d *UserEventDispatcher) DeliverDue() error {
+	deliveries, err := models.ListDueUserEventDeliveries(d.db, time.Now(), deliveryBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for i := range deliveries {
+		delivery := &deliveries[i]
+		err := d.post(delivery)
+
+		now := time.Now()
+		delivery.Attempts++
+		switch {
+		case err == nil:
+			delivery.DeliveredAt = &now
+			delivery.LastError = ""
+		case delivery.Attempts >= maxDeliveryAttempts:
+			delivery.FailedAt = &now
+			delivery.LastError = err.Error()
+			pkg.Logger.Errorf("Giving up on user event %s for %s after %d attempts: %v", delivery.EventID, delivery.Endpoint, delivery.Attempts, err)
+		default:
+			delivery.NextAttemptAt = now.Add(deliveryBackoff(delivery.Attempts))
+			delivery.LastError = err.Error()
+			pkg.Logger.Warnf("Failed to deliver user event %s to %s, retrying at %s: %v", delivery.EventID, delivery.Endpoint, delivery.NextAttemptAt.Format(time.RFC3339), err)
+		}
+
+		if err := models.SaveUserEventDelivery(d.db, delivery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// post sends a delivery to its endpoint.
+func (d *UserEventDispatcher) post(delivery *models.UserEventDelivery) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(d.secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write([]byte(delivery.Payload))
+
+	req, err := http.NewRequest(http.MethodPost, delivery.Endpoint, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Timestamp", timestamp)
+	req.Header.Set("X-Event-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return nil
+}
+
+// deliveryBackoff returns the wait before the next attempt after the given number of attempts.
+func deliveryBackoff(attempts int) time.Duration {
+	backoff := initialDeliveryBackoff
+	for i := 1; i < attempts && backoff < maxDeliveryBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxDeliveryBackoff)
+}
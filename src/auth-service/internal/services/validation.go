@@ -0,0 +1,161 @@
+package services
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"auth-service/internal/models"
+)
+
+var (
+	// ErrWeakPassword is wrapped by the ValidationError returned for new passwords the password
+	// policy rejects.
+	ErrWeakPassword = errors.New("password is too weak")
+	// ErrUsernameTaken is returned when registering a username that is already in use.
+	ErrUsernameTaken = errors.New("username is already taken")
+	// ErrEmailTaken is returned when registering an email address that is already in use.
+	ErrEmailTaken = errors.New("email address is already registered")
+)
+
+// ValidationError is returned for user input that breaks the rules for its field.
+type ValidationError struct {
+	Field   string
+	Message string
+	Err     error // Sentinel error the failure falls under, if any
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Limits of usernames, email addresses and passwords
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 32
+	maxEmailLength    = 254
+	maxPasswordBytes  = 72 // bcrypt ignores anything longer
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// ValidateUsername normalizes a username and checks it against the username rules: 3 to 32
+// lowercase letters, digits, dots, underscores and hyphens, starting with a letter or digit.
+func ValidateUsername(username string) (string, error) {
+	username = models.NormalizeUsername(username)
+	if n := utf8.RuneCountInString(username); n < minUsernameLength || n > maxUsernameLength {
+		return "", &ValidationError{Field: "username", Message: fmt.Sprintf("username must be %d to %d characters", minUsernameLength, maxUsernameLength)}
+	}
+	if !usernamePattern.MatchString(username) {
+		return "", &ValidationError{Field: "username", Message: "username may only contain letters, digits, dots, underscores and hyphens, and must start with a letter or digit"}
+	}
+	return username, nil
+}
+
+// ValidateEmail normalizes an email address and checks that it is a plain address with a domain.
+func ValidateEmail(email string) (string, error) {
+	email = models.NormalizeEmail(email)
+	invalid := &ValidationError{Field: "email", Message: "email address is invalid"}
+	if email == "" || len(email) > maxEmailLength {
+		return "", invalid
+	}
+
+	// Display names and comments are accepted by the parser but not as an account address
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", invalid
+	}
+	at := strings.LastIndex(email, "@")
+	domain := email[at+1:]
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "", invalid
+	}
+	return email, nil
+}
+
+//go:embed breached_passwords.txt
+var breachedPasswords string
+
+// PasswordPolicy decides which new passwords are strong enough.
+type PasswordPolicy struct {
+	minLength int
+	breached  map[string]struct{}
+}
+
+// NewPasswordPolicy creates a PasswordPolicy requiring at least minLength characters. Passwords on
+// the built-in list of breached passwords, or in breachedFile if given, are rejected.
+func NewPasswordPolicy(minLength int, breachedFile string) (*PasswordPolicy, error) {
+	p := &PasswordPolicy{minLength: minLength, breached: make(map[string]struct{})}
+	if err := p.addBreached(strings.NewReader(breachedPasswords)); err != nil {
+		return nil, err
+	}
+
+	if breachedFile != "" {
+		f, err := os.Open(breachedFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := p.addBreached(f); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", breachedFile, err)
+		}
+	}
+	return p, nil
+}
+
+// addBreached adds the passwords of a list with one password per line. Blank lines and lines
+// starting with # are skipped.
+func (p *PasswordPolicy) addBreached(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.breached[strings.ToLower(line)] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+// Breached returns the number of passwords on the breach list.
+func (p *PasswordPolicy) Breached() int {
+	return len(p.breached)
+}
+
+// Check returns a ValidationError if password is too short or too long, is a known breached
+// password, or is made from the user's username or email address.
+func (p *PasswordPolicy) Check(password, username, email string) error {
+	weak := func(msg string) error {
+		return &ValidationError{Field: "password", Message: msg, Err: ErrWeakPassword}
+	}
+
+	if utf8.RuneCountInString(password) < p.minLength {
+		return weak(fmt.Sprintf("password must be at least %d characters", p.minLength))
+	}
+	if len(password) > maxPasswordBytes {
+		return weak(fmt.Sprintf("password must be at most %d bytes", maxPasswordBytes))
+	}
+
+	lower := strings.ToLower(password)
+	if _, ok := p.breached[lower]; ok {
+		return weak("password is too common, it appears in known data breaches")
+	}
+	localPart, _, _ := strings.Cut(strings.ToLower(email), "@")
+	for _, personal := range []string{strings.ToLower(username), localPart} {
+		if len(personal) >= minUsernameLength && strings.Contains(lower, personal) {
+			return weak("password must not contain the username or email address")
+		}
+	}
+	return nil
+}
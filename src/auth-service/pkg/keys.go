@@ -0,0 +1,180 @@
+package pkg
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is a private key tokens are signed with, identified by the kid header of the tokens.
+type SigningKey struct {
+	ID     string // RFC 7638 thumbprint of the public key
+	Method jwt.SigningMethod
+	Key    crypto.Signer
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv,omitempty"` // OKP keys
+	X   string `json:"x,omitempty"`   // OKP keys
+	N   string `json:"n,omitempty"`   // RSA keys
+	E   string `json:"e,omitempty"`   // RSA keys
+}
+
+// KeySet holds the key new tokens are signed with and every key whose tokens are still accepted.
+type KeySet struct {
+	Signing *SigningKey
+	Keys    []*SigningKey
+}
+
+// Keys stores the loaded signing keys
+var Keys *KeySet
+
+// LoadKeys loads every PEM private key in keyDir, RSA or Ed25519, and signs new tokens with the one
+// in signingKeyFile. The other keys are only published, so that a key can be rotated in before it
+// signs anything and rotated out once the last tokens it signed have expired.
+func LoadKeys(keyDir, signingKeyFile string) error {
+	paths, err := filepath.Glob(filepath.Join(keyDir, "*.pem"))
+	if err != nil {
+		return fmt.Errorf("failed to list private keys: %w", err)
+	}
+	sort.Strings(paths)
+
+	keySet := &KeySet{}
+	for _, path := range paths {
+		key, err := loadSigningKey(path)
+		if err != nil {
+			return err
+		}
+		keySet.Keys = append(keySet.Keys, key)
+		if filepath.Base(path) == signingKeyFile {
+			keySet.Signing = key
+		}
+	}
+	if keySet.Signing == nil {
+		return fmt.Errorf("signing key %s not found in %s", signingKeyFile, keyDir)
+	}
+
+	// Assign to the global Keys variable
+	Keys = keySet
+	return nil
+}
+
+// loadSigningKey reads a PEM private key from a file.
+func loadSigningKey(path string) (*SigningKey, error) {
+	// Read the private key file
+	keyData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key %s: %w", path, err)
+	}
+
+	// Decode the PEM block
+	block, _ := pem.Decode(keyData)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block containing the private key %s", path)
+	}
+
+	// Parse the private key, PKCS #1 for RSA or PKCS #8 for RSA and Ed25519
+	var parsed any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %w", path, err)
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{ID: thumbprint(rsaJWK(&key.PublicKey)), Method: jwt.SigningMethodRS256, Key: key}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{ID: thumbprint(ed25519JWK(key.Public().(ed25519.PublicKey))), Method: jwt.SigningMethodEdDSA, Key: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T in %s", parsed, path)
+	}
+}
+
+// JWK returns the public key in JSON Web Key format.
+func (k *SigningKey) JWK() JWK {
+	var jwk JWK
+	switch public := k.Key.Public().(type) {
+	case *rsa.PublicKey:
+		jwk = rsaJWK(public)
+	case ed25519.PublicKey:
+		jwk = ed25519JWK(public)
+	}
+	jwk.Use = "sig"
+	jwk.Alg = k.Method.Alg()
+	jwk.Kid = k.ID
+	return jwk
+}
+
+// Find returns the key with the given ID, or nil if there is none.
+func (s *KeySet) Find(kid string) *SigningKey {
+	for _, key := range s.Keys {
+		if key.ID == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+// Algorithms returns the signing algorithms of the keys.
+func (s *KeySet) Algorithms() []string {
+	var algorithms []string
+	seen := make(map[string]bool)
+	for _, key := range s.Keys {
+		if alg := key.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algorithms = append(algorithms, alg)
+		}
+	}
+	return algorithms
+}
+
+func rsaJWK(key *rsa.PublicKey) JWK {
+	return JWK{
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ed25519JWK(key ed25519.PublicKey) JWK {
+	return JWK{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(key)}
+}
+
+// thumbprint computes the RFC 7638 thumbprint of a public key: the hash of its required members
+// in lexicographic order, which json.Marshal produces for maps.
+func thumbprint(jwk JWK) string {
+	members := map[string]string{"kty": jwk.Kty}
+	switch jwk.Kty {
+	case "RSA":
+		members["n"], members["e"] = jwk.N, jwk.E
+	case "OKP":
+		members["crv"], members["x"] = jwk.Crv, jwk.X
+	}
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -1,19 +1,26 @@
 package main
 
 import (
+	_ "expvar" // Registers the provider call metrics at /debug/vars
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"file-downloader-service/config"
+	"file-downloader-service/internal/clients"
 	"file-downloader-service/internal/handlers"
 	"file-downloader-service/internal/pkg"
+	"file-downloader-service/internal/services"
 	"file-downloader-service/proto/generated/filedownloader"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
+
+	"shared/authn"
+	"shared/authn/grpcauth"
 )
 
 func main() {
@@ -32,10 +39,40 @@ func main() {
 		pkg.Logger.Fatalf("Failed to listen on port %s: %v", config.AppConfig.Port, err)
 	}
 
-	grpcServer := grpc.NewServer()
+	// Initialize the cloud provider and notification clients
+	googleDriveClient := clients.NewGoogleDriveClient(config.AppConfig.GoogleDriveAPIKey, config.AppConfig.GoogleDriveAPIURL)
+	dropboxClient := clients.NewDropboxClient(config.AppConfig.DropboxToken, config.AppConfig.DropboxAPIURL)
+	notificationClient, err := clients.NewNotificationClient(config.AppConfig.NotificationServiceAddr)
+	if err != nil {
+		pkg.Logger.Fatalf("Failed to create notification client: %v", err)
+	}
+
+	downloaderService := services.NewFileDownloaderService(googleDriveClient, dropboxClient, notificationClient)
+
+	// Verify the access tokens of callers, which handlers can read with authn.FromContext
+	var serverOptions []grpc.ServerOption
+	if config.AppConfig.AuthJWKSURL != "" {
+		verifier, err := authn.NewVerifier(authn.Config{
+			JWKSURL:            config.AppConfig.AuthJWKSURL,
+			RevokedSessionsURL: config.AppConfig.AuthRevokedSessionsURL,
+			TokenURL:           config.AppConfig.AuthTokenURL,
+			ServiceAPIKey:      config.AppConfig.ServiceAPIKey,
+			Issuer:             config.AppConfig.JWTIssuer,
+			Audience:           config.AppConfig.JWTAudience,
+		})
+		if err != nil {
+			pkg.Logger.Fatalf("Failed to create token verifier: %v", err)
+		}
+		defer verifier.Close()
+		serverOptions = grpcauth.ServerOptions(verifier, grpcauth.Options{AllowAnonymous: !config.AppConfig.JWTRequired, Exempt: []string{"/grpc.reflection."}})
+	} else {
+		pkg.Logger.Warn("AUTH_JWKS_URL is not set, access tokens are not verified")
+	}
+
+	grpcServer := grpc.NewServer(serverOptions...)
 
 	// Register the FileDownloaderService
-	filedownloader.RegisterFileDownloaderServiceServer(grpcServer, &handlers.FileDownloaderHandler{})
+	filedownloader.RegisterFileDownloaderServiceServer(grpcServer, handlers.NewFileDownloaderHandler(downloaderService))
 
 	// Enable gRPC reflection for easier development and debugging
 	reflection.Register(grpcServer)
@@ -48,6 +85,14 @@ func main() {
 		}
 	}()
 
+	// Expose provider call metrics (attempts, retries, failures, circuit breaker trips)
+	go func() {
+		pkg.Logger.Infof("Metrics are available on port %s at /debug/vars", config.AppConfig.MetricsPort)
+		if err := http.ListenAndServe(fmt.Sprintf(":%s", config.AppConfig.MetricsPort), nil); err != nil {
+			pkg.Logger.Errorf("Failed to serve metrics: %v", err)
+		}
+	}()
+
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -10,6 +10,18 @@ import (
 type Config struct {
 	Port                   string
 	NotificationServiceAddr string
+	MetricsPort            string
+	GoogleDriveAPIKey      string
+	DropboxToken           string
+	GoogleDriveAPIURL      string // Base URL of the Drive API, Google's if empty
+	DropboxAPIURL          string // Base URL of the Dropbox API, Dropbox's if empty
+	AuthJWKSURL            string // The auth-service JWKS; calls are not authenticated if empty
+	AuthRevokedSessionsURL string // The auth-service revoked sessions list, optional
+	AuthTokenURL           string // The auth-service token endpoint, where ServiceAPIKey is exchanged
+	ServiceAPIKey          string // API key of the service's account, required to fetch revoked sessions
+	JWTIssuer              string
+	JWTAudience            string
+	JWTRequired            bool // Reject calls without a token, instead of only those with an invalid one
 }
 
 var AppConfig Config
@@ -26,6 +38,18 @@ func LoadConfig() error {
 	AppConfig = Config{
 		Port:                   getEnv("FILE_DOWNLOADER_SERVICE_PORT", "50052"),
 		NotificationServiceAddr: getEnv("NOTIFICATION_SERVICE_ADDRESS", "localhost:50054"),
+		MetricsPort:            getEnv("METRICS_PORT", "9090"),
+		GoogleDriveAPIKey:      getEnv("GOOGLE_DRIVE_API_KEY", ""),
+		DropboxToken:           getEnv("DROPBOX_TOKEN", ""),
+		GoogleDriveAPIURL:      getEnv("GOOGLE_DRIVE_API_URL", ""),
+		DropboxAPIURL:          getEnv("DROPBOX_API_URL", ""),
+		AuthJWKSURL:            getEnv("AUTH_JWKS_URL", ""),
+		AuthRevokedSessionsURL: getEnv("AUTH_REVOKED_SESSIONS_URL", ""),
+		AuthTokenURL:           getEnv("AUTH_TOKEN_URL", ""),
+		ServiceAPIKey:          getEnv("SERVICE_API_KEY", ""),
+		JWTIssuer:              getEnv("JWT_ISSUER", ""),
+		JWTAudience:            getEnv("JWT_AUDIENCE", ""),
+		JWTRequired:            getEnv("JWT_REQUIRED", "false") == "true",
 	}
 
 	return nil
@@ -1,57 +1,266 @@
 package clients
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"path/filepath"
+	"path"
+	"strings"
+
+	"file-downloader-service/internal/models"
 
 	"github.com/dropbox/dropbox-sdk-go-unofficial/v6/dropbox"
 	"github.com/dropbox/dropbox-sdk-go-unofficial/v6/dropbox/files"
 )
 
+// uploadChunkSize is the size of each request in a chunked upload session. Files larger than
+// this are uploaded in several requests so that no single request has to carry the whole file.
+const uploadChunkSize = 8 << 20
+
 type DropboxClient struct {
-	client files.Client
+	config  dropbox.Config // Settings shared by the clients acting with callers' tokens
+	client  files.Client
+	retrier *Retrier
 }
 
-// NewDropboxClient creates a new DropboxClient with the provided access token.
-func NewDropboxClient(accessToken string) *DropboxClient {
+// NewDropboxClient creates a new DropboxClient with the provided access token. The Dropbox API is
+// reached at baseURL, or at Dropbox's when it is empty.
+func NewDropboxClient(accessToken, baseURL string) *DropboxClient {
 	config := dropbox.Config{
-		Token:    accessToken,
 		LogLevel: dropbox.LogInfo, // Change this to dropbox.LogOff to disable logging
 	}
-	client := files.New(config)
+	if baseURL != "" {
+		baseURL = strings.TrimSuffix(baseURL, "/")
+		config.URLGenerator = func(hostType, namespace, route string) string {
+			return fmt.Sprintf("%s/2/%s/%s", baseURL, namespace, route)
+		}
+	}
+
+	d := &DropboxClient{
+		config:  config,
+		retrier: NewRetrier("dropbox", DefaultRetryPolicy()),
+	}
+	d.client = d.clientFor(accessToken)
+	return d
+}
+
+// clientFor returns a Dropbox client acting with the caller's access token, falling back to the
+// service-wide client when no token is supplied.
+func (d *DropboxClient) clientFor(authToken string) files.Client {
+	if authToken == "" && d.client != nil {
+		return d.client
+	}
+	config := d.config
+	config.Token = authToken
+	return files.New(config)
+}
+
+// DownloadFile downloads a file from Dropbox, saves it locally and returns its metadata.
+// Transient failures are retried; the local file only appears once the download is complete.
+func (d *DropboxClient) DownloadFile(fileID, authToken string) (*models.File, error) {
+	var file *models.File
+	err := d.retrier.Do(func() error {
+		// Make a request to download the file from Dropbox
+		response, content, err := d.clientFor(authToken).Download(files.NewDownloadArg(fileID))
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+
+		// Save the file locally
+		filePath, written, err := saveAtomically(response.Name, content)
+		if err != nil {
+			return err
+		}
+
+		file = &models.File{
+			FileName: response.Name,
+			FilePath: filePath,
+			FileSize: written,
+			Status:   "completed",
+			Provider: "dropbox",
+			RemoteID: response.Id,
+			Revision: response.Rev,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file from Dropbox: %v", err)
+	}
+
+	log.Printf("File %s downloaded successfully to %s", file.FileName, file.FilePath)
+	return file, nil
+}
+
+// ListChanges returns the changes in a Dropbox folder since the given cursor using
+// list_folder (first call) and list_folder/continue (subsequent calls), together with the
+// cursor to resume from.
+func (d *DropboxClient) ListChanges(folderPath, cursor, authToken string) ([]models.RemoteChange, string, error) {
+	client := d.clientFor(authToken)
 
-	return &DropboxClient{
-		client: client,
+	var changes []models.RemoteChange
+	for {
+		var result *files.ListFolderResult
+		err := d.retrier.Do(func() error {
+			var err error
+			if cursor == "" {
+				result, err = client.ListFolder(files.NewListFolderArg(folderPath))
+			} else {
+				result, err = client.ListFolderContinue(files.NewListFolderContinueArg(cursor))
+			}
+			return err
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to list Dropbox folder changes: %v", err)
+		}
+
+		for _, entry := range result.Entries {
+			switch e := entry.(type) {
+			case *files.FileMetadata:
+				changes = append(changes, models.RemoteChange{RemoteID: e.Id, Name: e.Name, Revision: e.Rev})
+			case *files.FolderMetadata:
+				changes = append(changes, models.RemoteChange{RemoteID: e.Id, Name: e.Name, IsFolder: true})
+			case *files.DeletedMetadata:
+				// Deleted entries carry no ID, so report them by their lower-cased path
+				changes = append(changes, models.RemoteChange{RemoteID: e.PathLower, Name: e.Name, Deleted: true})
+			}
+		}
+
+		cursor = result.Cursor
+		if !result.HasMore {
+			return changes, cursor, nil
+		}
 	}
 }
 
-// DownloadFile downloads a file from Dropbox and saves it locally.
-func (d *DropboxClient) DownloadFile(fileID, authToken string) (string, error) {
-	// Make a request to download the file from Dropbox
-	arg := files.NewDownloadArg(fileID)
-	response, content, err := d.client.Download(arg)
+// UploadFile uploads a local file into a Dropbox folder. When a base revision is given the upload
+// only overwrites that revision; if the remote file changed in the meantime Dropbox keeps both
+// versions by storing the upload as a conflicted copy.
+func (d *DropboxClient) UploadFile(folderPath, fileName, localPath, remoteID, baseRevision, authToken string) (*models.File, bool, error) {
+	content, err := os.Open(localPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to download file from Dropbox: %v", err)
+		return nil, false, fmt.Errorf("unable to open local file: %v", err)
 	}
 	defer content.Close()
 
-	// Save the file locally
-	filePath := filepath.Join("./downloads", response.Name)
-	outFile, err := os.Create(filePath)
+	info, err := content.Stat()
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to read local file: %v", err)
+	}
+
+	arg := files.NewUploadArg(path.Join(folderPath, fileName))
+	if baseRevision != "" {
+		arg.Mode = &files.WriteMode{Tagged: dropbox.Tagged{Tag: files.WriteModeUpdate}, Update: baseRevision}
+	}
+	arg.Autorename = true
+
+	contentHash, err := dropboxContentHash(content)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to read local file: %v", err)
+	}
+
+	var metadata *files.FileMetadata
+	client := d.clientFor(authToken)
+	attempt := 0
+	err = d.retrier.Do(func() error {
+		attempt++
+		if attempt > 1 {
+			// The failed attempt may have been stored even though its response was lost. Sending
+			// the file again would then conflict with our own revision, so an identical file at
+			// the path is taken as that attempt's result.
+			if stored := storedUpload(client, arg.Path, contentHash); stored != nil {
+				metadata = stored
+				return nil
+			}
+		}
+
+		// Every attempt sends the whole file again
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		var err error
+		if info.Size() > uploadChunkSize {
+			metadata, err = uploadInSession(client, &arg.CommitInfo, content, uint64(info.Size()))
+		} else {
+			metadata, err = client.Upload(arg, content)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to upload file to Dropbox: %v", err)
+	}
+
+	log.Printf("File %s uploaded successfully to Dropbox as %s", localPath, metadata.PathDisplay)
+	return &models.File{
+		FileName: metadata.Name,
+		FilePath: localPath,
+		FileSize: int64(metadata.Size),
+		Status:   "completed",
+		Provider: "dropbox",
+		RemoteID: metadata.Id,
+		Revision: metadata.Rev,
+	}, metadata.Name != fileName, nil
+}
+
+// storedUpload returns the file at the path if its content has the given Dropbox content hash.
+func storedUpload(client files.Client, filePath, contentHash string) *files.FileMetadata {
+	stored, err := client.GetMetadata(files.NewGetMetadataArg(filePath))
 	if err != nil {
-		return "", fmt.Errorf("unable to create local file: %v", err)
+		return nil
+	}
+	file, ok := stored.(*files.FileMetadata)
+	if !ok || file.ContentHash != contentHash {
+		return nil
+	}
+	return file
+}
+
+// dropboxContentHash computes the Dropbox content hash of the content: the SHA-256 of the
+// concatenated SHA-256 hashes of its 4 MiB blocks.
+func dropboxContentHash(content io.ReadSeeker) (string, error) {
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
 	}
-	defer outFile.Close()
 
-	// Write the downloaded file to the local file system
-	_, err = io.Copy(outFile, content)
+	overall := sha256.New()
+	block := make([]byte, 4<<20)
+	for {
+		n, err := io.ReadFull(content, block)
+		if n > 0 {
+			sum := sha256.Sum256(block[:n])
+			overall.Write(sum[:])
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return hex.EncodeToString(overall.Sum(nil)), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// uploadInSession uploads large content through a Dropbox upload session, sending it in chunks of
+// uploadChunkSize and committing the file with the last chunk.
+func uploadInSession(client files.Client, commit *files.CommitInfo, content io.Reader, size uint64) (*files.FileMetadata, error) {
+	session, err := client.UploadSessionStart(files.NewUploadSessionStartArg(), io.LimitReader(content, uploadChunkSize))
 	if err != nil {
-		return "", fmt.Errorf("unable to save file locally: %v", err)
+		return nil, err
 	}
 
-	log.Printf("File %s downloaded successfully to %s", response.Name, filePath)
-	return filePath, nil
-}
\ No newline at end of file
+	offset := uint64(uploadChunkSize)
+	for size-offset > uploadChunkSize {
+		cursor := files.NewUploadSessionCursor(session.SessionId, offset)
+		err = client.UploadSessionAppendV2(files.NewUploadSessionAppendArg(cursor), io.LimitReader(content, uploadChunkSize))
+		if err != nil {
+			return nil, err
+		}
+		offset += uploadChunkSize
+	}
+
+	cursor := files.NewUploadSessionCursor(session.SessionId, offset)
+	return client.UploadSessionFinish(files.NewUploadSessionFinishArg(cursor, commit), content)
+}
@@ -0,0 +1,183 @@
+package clients
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"file-downloader-service/internal/models"
+)
+
+// newFakeDropbox starts a fake Dropbox API with the given routes and returns a client of it.
+func newFakeDropbox(t *testing.T, mux *http.ServeMux) *DropboxClient {
+	t.Helper()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return NewDropboxClient("service-token", server.URL)
+}
+
+// dropboxArg decodes the argument of a content endpoint call, which Dropbox sends in a header.
+func dropboxArg(t *testing.T, r *http.Request) map[string]interface{} {
+	t.Helper()
+	var arg map[string]interface{}
+	if err := json.Unmarshal([]byte(r.Header.Get("Dropbox-API-Arg")), &arg); err != nil {
+		t.Fatalf("Invalid Dropbox-API-Arg: %v", err)
+	}
+	return arg
+}
+
+func TestDropboxListChangesFollowsCursor(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /2/files/list_folder", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer user-token" {
+			t.Errorf("Expected the caller's token, got %q", r.Header.Get("Authorization"))
+		}
+		writeJSON(w, map[string]interface{}{
+			"entries": []map[string]interface{}{
+				{".tag": "file", "id": "id:a", "name": "a.txt", "rev": "015a"},
+				{".tag": "folder", "id": "id:sub", "name": "sub"},
+			},
+			"cursor":   "cursor-1",
+			"has_more": true,
+		})
+	})
+	mux.HandleFunc("POST /2/files/list_folder/continue", func(w http.ResponseWriter, r *http.Request) {
+		var arg map[string]string
+		json.NewDecoder(r.Body).Decode(&arg)
+		if arg["cursor"] != "cursor-1" {
+			t.Errorf("Unexpected cursor %q", arg["cursor"])
+		}
+		writeJSON(w, map[string]interface{}{
+			"entries": []map[string]interface{}{
+				{".tag": "deleted", "name": "b.txt", "path_lower": "/shared/b.txt"},
+			},
+			"cursor":   "cursor-2",
+			"has_more": false,
+		})
+	})
+	client := newFakeDropbox(t, mux)
+
+	changes, cursor, err := client.ListChanges("/shared", "", "user-token")
+	if err != nil {
+		t.Fatalf("ListChanges failed: %v", err)
+	}
+	if cursor != "cursor-2" {
+		t.Errorf("Expected cursor-2, got %q", cursor)
+	}
+
+	want := []models.RemoteChange{
+		{RemoteID: "id:a", Name: "a.txt", Revision: "015a"},
+		{RemoteID: "id:sub", Name: "sub", IsFolder: true},
+		{RemoteID: "/shared/b.txt", Name: "b.txt", Deleted: true},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("Expected %d changes, got %v", len(want), changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("Change %d: expected %+v, got %+v", i, want[i], changes[i])
+		}
+	}
+}
+
+func TestDropboxDownloadFile(t *testing.T) {
+	inTempDir(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /2/files/download", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer service-token" {
+			t.Errorf("Expected the service token, got %q", r.Header.Get("Authorization"))
+		}
+		if path := dropboxArg(t, r)["path"]; path != "id:a" {
+			t.Errorf("Unexpected path %v", path)
+		}
+		w.Header().Set("Dropbox-API-Result", `{"id": "id:a", "name": "a.txt", "rev": "015a"}`)
+		io.WriteString(w, "hello dropbox")
+	})
+	client := newFakeDropbox(t, mux)
+
+	file, err := client.DownloadFile("id:a", "")
+	if err != nil {
+		t.Fatalf("DownloadFile failed: %v", err)
+	}
+	if file.RemoteID != "id:a" || file.Revision != "015a" || file.FileSize != int64(len("hello dropbox")) {
+		t.Errorf("Unexpected file: %+v", file)
+	}
+	content, err := os.ReadFile(file.FilePath)
+	if err != nil || string(content) != "hello dropbox" {
+		t.Errorf("Expected the downloaded content, got %q (%v)", content, err)
+	}
+}
+
+func TestDropboxUploadReportsConflictedCopy(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(localPath, []byte("local edit"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /2/files/upload", func(w http.ResponseWriter, r *http.Request) {
+		arg := dropboxArg(t, r)
+		mode, _ := arg["mode"].(map[string]interface{})
+		if arg["path"] != "/shared/notes.txt" || mode["update"] != "base" || arg["autorename"] != true {
+			t.Errorf("Unexpected upload argument: %v", arg)
+		}
+		// The file changed remotely, so Dropbox renames the upload
+		writeJSON(w, map[string]interface{}{"id": "id:copy", "name": "notes (conflicted copy).txt", "rev": "016b", "size": 10})
+	})
+	client := newFakeDropbox(t, mux)
+
+	file, conflict, err := client.UploadFile("/shared", "notes.txt", localPath, "id:notes", "base", "user-token")
+	if err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	if !conflict || file.RemoteID != "id:copy" || file.Revision != "016b" {
+		t.Errorf("Expected a conflicted copy, got %+v (conflict %v)", file, conflict)
+	}
+}
+
+func TestDropboxRetriedUploadFindsStoredAttempt(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(localPath, []byte("local edit"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	local, err := os.Open(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contentHash, err := dropboxContentHash(local)
+	local.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uploads := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /2/files/upload", func(w http.ResponseWriter, r *http.Request) {
+		// The upload is stored, but the response is lost
+		uploads++
+		http.Error(w, "upstream timeout", http.StatusBadGateway)
+	})
+	mux.HandleFunc("POST /2/files/get_metadata", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			".tag": "file", "id": "id:notes", "name": "notes.txt", "rev": "016b", "size": 10, "content_hash": contentHash,
+		})
+	})
+	client := newFakeDropbox(t, mux)
+	client.retrier = NewRetrier("dropbox-test", RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, FailureThreshold: 10})
+
+	file, conflict, err := client.UploadFile("/shared", "notes.txt", localPath, "id:notes", "base", "user-token")
+	if err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	if uploads != 1 {
+		t.Errorf("Expected the stored attempt not to be sent again, got %d uploads", uploads)
+	}
+	if conflict || file.Revision != "016b" {
+		t.Errorf("Expected the stored revision without a conflict, got %+v (conflict %v)", file, conflict)
+	}
+}
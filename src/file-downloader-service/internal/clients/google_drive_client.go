@@ -7,57 +7,290 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"file-downloader-service/internal/models"
+
+	"golang.org/x/oauth2"
 	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
 type GoogleDriveClient struct {
-	service *drive.Service
+	service  *drive.Service
+	endpoint []option.ClientOption // Points services at a non-default API URL, if configured
+	retrier  *Retrier
 }
 
-// NewGoogleDriveClient creates a new GoogleDriveClient with the provided API key.
-func NewGoogleDriveClient(apiKey string) *GoogleDriveClient {
+// NewGoogleDriveClient creates a new GoogleDriveClient with the provided API key. The Drive API is
+// reached at baseURL, or at Google's when it is empty.
+func NewGoogleDriveClient(apiKey, baseURL string) *GoogleDriveClient {
+	var endpoint []option.ClientOption
+	if baseURL != "" {
+		endpoint = append(endpoint, option.WithEndpoint(strings.TrimSuffix(baseURL, "/")+"/drive/v3/"))
+	}
+
 	ctx := context.Background()
-	service, err := drive.NewService(ctx, option.WithAPIKey(apiKey))
+	service, err := drive.NewService(ctx, append(endpoint, option.WithAPIKey(apiKey))...)
 	if err != nil {
 		log.Fatalf("Unable to create Google Drive service: %v", err)
 	}
 
 	return &GoogleDriveClient{
-		service: service,
+		service:  service,
+		endpoint: endpoint,
+		retrier:  NewRetrier("google_drive", DefaultRetryPolicy()),
+	}
+}
+
+// driveFileFields are the file fields needed to track content revisions.
+const driveFileFields = "id, name, parents, trashed, mimeType, size, headRevisionId, version"
+
+// serviceFor returns a Drive service acting with the caller's OAuth access token, falling back to
+// the service-wide client when no token is supplied.
+func (g *GoogleDriveClient) serviceFor(authToken string) (*drive.Service, error) {
+	if authToken == "" {
+		return g.service, nil
+	}
+	tokenSource := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: authToken})
+	return drive.NewService(context.Background(), append(g.endpoint, option.WithTokenSource(tokenSource))...)
+}
+
+// driveRevision returns the revision of a Drive file. Google-native documents have no head
+// revision, so the file version is used instead.
+func driveRevision(file *drive.File) string {
+	if file.HeadRevisionId != "" {
+		return file.HeadRevisionId
+	}
+	return fmt.Sprintf("%d", file.Version)
+}
+
+// DownloadFile downloads a file from Google Drive, saves it locally and returns its metadata.
+// Transient failures are retried; the local file only appears once the download is complete.
+func (g *GoogleDriveClient) DownloadFile(fileID, authToken string) (*models.File, error) {
+	service, err := g.serviceFor(authToken)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create Google Drive service: %v", err)
+	}
+
+	var file *models.File
+	err = g.retrier.Do(func() error {
+		// Create a request to get the file metadata
+		metadata, err := service.Files.Get(fileID).Fields("id", "name", "headRevisionId", "version").Do()
+		if err != nil {
+			return fmt.Errorf("unable to retrieve file metadata: %w", err)
+		}
+
+		// Create a download request for the file
+		response, err := service.Files.Get(fileID).Download()
+		if err != nil {
+			return fmt.Errorf("unable to download file: %w", err)
+		}
+		defer response.Body.Close()
+
+		// Save the file locally
+		filePath, written, err := saveAtomically(metadata.Name, response.Body)
+		if err != nil {
+			return err
+		}
+
+		file = &models.File{
+			FileName: metadata.Name,
+			FilePath: filePath,
+			FileSize: written,
+			Status:   "completed",
+			Provider: "google_drive",
+			RemoteID: metadata.Id,
+			Revision: driveRevision(metadata),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("File %s downloaded successfully to %s", file.FileName, file.FilePath)
+	return file, nil
+}
+
+// ListChanges returns the changes in a Google Drive folder since the given cursor. The first call
+// lists the folder contents and records a start page token; later calls page through changes.list.
+// Changes to files outside the folder are reported as deletions, since a file moved out of the
+// folder cannot be told apart from one that was never in it.
+func (g *GoogleDriveClient) ListChanges(folderID, cursor, authToken string) ([]models.RemoteChange, string, error) {
+	service, err := g.serviceFor(authToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to create Google Drive service: %v", err)
+	}
+
+	if cursor == "" {
+		return g.listFolder(service, folderID)
+	}
+
+	var changes []models.RemoteChange
+	pageToken := cursor
+	for {
+		var list *drive.ChangeList
+		err := g.retrier.Do(func() error {
+			var err error
+			list, err = service.Changes.List(pageToken).
+				Fields("nextPageToken", "newStartPageToken", "changes(fileId, removed, file("+driveFileFields+"))").
+				Do()
+			return err
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to list Google Drive changes: %v", err)
+		}
+
+		for _, change := range list.Changes {
+			if change.Removed || change.File == nil {
+				changes = append(changes, models.RemoteChange{RemoteID: change.FileId, Deleted: true})
+				continue
+			}
+			if !inFolder(change.File, folderID) {
+				// The file may have been moved out of the folder, which looks like any change
+				// elsewhere in the drive. Report it as deleted by ID only, so that callers that
+				// fall back to matching deletions by name cannot confuse it with their files.
+				changes = append(changes, models.RemoteChange{RemoteID: change.File.Id, Deleted: true})
+				continue
+			}
+			changes = append(changes, models.RemoteChange{
+				RemoteID: change.File.Id,
+				Name:     change.File.Name,
+				Revision: driveRevision(change.File),
+				Deleted:  change.File.Trashed,
+				IsFolder: change.File.MimeType == "application/vnd.google-apps.folder",
+			})
+		}
+
+		if list.NewStartPageToken != "" {
+			return changes, list.NewStartPageToken, nil
+		}
+		pageToken = list.NextPageToken
 	}
 }
 
-// DownloadFile downloads a file from Google Drive and saves it locally.
-func (g *GoogleDriveClient) DownloadFile(fileID, authToken string) (string, error) {
-	// Create a request to get the file metadata
-	file, err := g.service.Files.Get(fileID).Fields("name").Do()
+// listFolder lists the current contents of a Drive folder and returns the page token from which
+// subsequent changes should be read.
+func (g *GoogleDriveClient) listFolder(service *drive.Service, folderID string) ([]models.RemoteChange, string, error) {
+	// Take the start token first so changes made while listing are not lost
+	var startToken *drive.StartPageToken
+	err := g.retrier.Do(func() error {
+		var err error
+		startToken, err = service.Changes.GetStartPageToken().Do()
+		return err
+	})
 	if err != nil {
-		return "", fmt.Errorf("unable to retrieve file metadata: %v", err)
+		return nil, "", fmt.Errorf("unable to get Google Drive start page token: %v", err)
 	}
 
-	// Create a download request for the file
-	response, err := g.service.Files.Get(fileID).Download()
+	var changes []models.RemoteChange
+	query := fmt.Sprintf("'%s' in parents and trashed = false", folderID)
+	err = g.retrier.Do(func() error {
+		// A retried listing starts over from the first page
+		changes = nil
+		return service.Files.List().Q(query).Fields("nextPageToken", "files("+driveFileFields+")").
+			Pages(context.Background(), func(list *drive.FileList) error {
+				for _, file := range list.Files {
+					changes = append(changes, models.RemoteChange{
+						RemoteID: file.Id,
+						Name:     file.Name,
+						Revision: driveRevision(file),
+						IsFolder: file.MimeType == "application/vnd.google-apps.folder",
+					})
+				}
+				return nil
+			})
+	})
 	if err != nil {
-		return "", fmt.Errorf("unable to download file: %v", err)
+		return nil, "", fmt.Errorf("unable to list Google Drive folder: %v", err)
 	}
-	defer response.Body.Close()
 
-	// Save the file locally
-	filePath := filepath.Join("./downloads", file.Name)
-	outFile, err := os.Create(filePath)
+	return changes, startToken.StartPageToken, nil
+}
+
+// inFolder reports whether a Drive file is a direct child of the folder.
+func inFolder(file *drive.File, folderID string) bool {
+	for _, parent := range file.Parents {
+		if parent == folderID {
+			return true
+		}
+	}
+	return false
+}
+
+// UploadFile uploads a local file into a Google Drive folder. An existing remote file is only
+// overwritten if it is still at the base revision; otherwise the upload is stored as a separate
+// conflicted copy so that both versions are kept.
+func (g *GoogleDriveClient) UploadFile(folderID, fileName, localPath, remoteID, baseRevision, authToken string) (*models.File, bool, error) {
+	service, err := g.serviceFor(authToken)
 	if err != nil {
-		return "", fmt.Errorf("unable to create local file: %v", err)
+		return nil, false, fmt.Errorf("unable to create Google Drive service: %v", err)
 	}
-	defer outFile.Close()
 
-	// Write the downloaded file to the local file system
-	_, err = io.Copy(outFile, response.Body)
+	content, err := os.Open(localPath)
 	if err != nil {
-		return "", fmt.Errorf("unable to save file locally: %v", err)
+		return nil, false, fmt.Errorf("unable to open local file: %v", err)
+	}
+	defer content.Close()
+
+	conflict := false
+	if remoteID != "" {
+		var current *drive.File
+		err := g.retrier.Do(func() error {
+			var err error
+			current, err = service.Files.Get(remoteID).Fields(driveFileFields).Do()
+			return err
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("unable to retrieve file metadata: %v", err)
+		}
+		conflict = current.Trashed || driveRevision(current) != baseRevision
 	}
 
-	log.Printf("File %s downloaded successfully to %s", file.Name, filePath)
-	return filePath, nil
-}
\ No newline at end of file
+	// Content larger than one chunk is sent as a resumable upload in uploadChunkSize pieces
+	chunkSize := googleapi.ChunkSize(uploadChunkSize)
+
+	var file *drive.File
+	err = g.retrier.Do(func() error {
+		// Every attempt sends the whole file again
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		var err error
+		if remoteID != "" && !conflict {
+			file, err = service.Files.Update(remoteID, &drive.File{}).Media(content, chunkSize).Fields(driveFileFields).Do()
+		} else {
+			name := fileName
+			if conflict {
+				name = conflictedCopyName(fileName)
+			}
+			file, err = service.Files.Create(&drive.File{Name: name, Parents: []string{folderID}}).
+				Media(content, chunkSize).Fields(driveFileFields).Do()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to upload file to Google Drive: %v", err)
+	}
+
+	log.Printf("File %s uploaded successfully to Google Drive as %s", localPath, file.Name)
+	return &models.File{
+		FileName: file.Name,
+		FilePath: localPath,
+		FileSize: file.Size,
+		Status:   "completed",
+		Provider: "google_drive",
+		RemoteID: file.Id,
+		Revision: driveRevision(file),
+	}, conflict, nil
+}
+
+// conflictedCopyName derives the name under which a conflicting version of a file is kept,
+// following Dropbox's "name (conflicted copy).ext" convention.
+func conflictedCopyName(fileName string) string {
+	ext := filepath.Ext(fileName)
+	return fmt.Sprintf("%s (conflicted copy)%s", fileName[:len(fileName)-len(ext)], ext)
+}
@@ -0,0 +1,168 @@
+package clients
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"file-downloader-service/internal/models"
+)
+
+// inTempDir runs the rest of the test in a temporary working directory, so that downloads are
+// saved there.
+func inTempDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// writeJSON responds with v encoded as JSON.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// newFakeDrive starts a fake Drive API with the given routes and returns a client of it.
+func newFakeDrive(t *testing.T, mux *http.ServeMux) *GoogleDriveClient {
+	t.Helper()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return NewGoogleDriveClient("test-key", server.URL)
+}
+
+func TestDriveListChangesReportsFilesMovedOut(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /drive/v3/changes", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("pageToken") != "cursor-1" {
+			t.Errorf("Unexpected page token %q", r.URL.Query().Get("pageToken"))
+		}
+		writeJSON(w, map[string]interface{}{
+			"newStartPageToken": "cursor-2",
+			"changes": []map[string]interface{}{
+				{"fileId": "in", "file": map[string]interface{}{"id": "in", "name": "kept.txt", "parents": []string{"folder"}, "headRevisionId": "r2"}},
+				{"fileId": "out", "file": map[string]interface{}{"id": "out", "name": "moved.txt", "parents": []string{"elsewhere"}}},
+				{"fileId": "gone", "removed": true},
+			},
+		})
+	})
+	client := newFakeDrive(t, mux)
+
+	changes, cursor, err := client.ListChanges("folder", "cursor-1", "user-token")
+	if err != nil {
+		t.Fatalf("ListChanges failed: %v", err)
+	}
+	if cursor != "cursor-2" {
+		t.Errorf("Expected cursor-2, got %q", cursor)
+	}
+
+	want := []models.RemoteChange{
+		{RemoteID: "in", Name: "kept.txt", Revision: "r2"},
+		{RemoteID: "out", Deleted: true},
+		{RemoteID: "gone", Deleted: true},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("Expected %d changes, got %v", len(want), changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("Change %d: expected %+v, got %+v", i, want[i], changes[i])
+		}
+	}
+}
+
+func TestDriveListChangesListsFolderFirst(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /drive/v3/changes/startPageToken", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"startPageToken": "cursor-1"})
+	})
+	mux.HandleFunc("GET /drive/v3/files", func(w http.ResponseWriter, r *http.Request) {
+		if q := r.URL.Query().Get("q"); !strings.Contains(q, "'folder' in parents") {
+			t.Errorf("Unexpected query %q", q)
+		}
+		writeJSON(w, map[string]interface{}{
+			"files": []map[string]interface{}{
+				{"id": "a", "name": "a.txt", "version": "3"},
+				{"id": "sub", "name": "sub", "mimeType": "application/vnd.google-apps.folder"},
+			},
+		})
+	})
+	client := newFakeDrive(t, mux)
+
+	changes, cursor, err := client.ListChanges("folder", "", "")
+	if err != nil {
+		t.Fatalf("ListChanges failed: %v", err)
+	}
+	if cursor != "cursor-1" {
+		t.Errorf("Expected cursor-1, got %q", cursor)
+	}
+	if len(changes) != 2 || changes[0].Revision != "3" || !changes[1].IsFolder {
+		t.Errorf("Unexpected changes: %+v", changes)
+	}
+}
+
+func TestDriveDownloadFile(t *testing.T) {
+	inTempDir(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /drive/v3/files/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("alt") == "media" {
+			io.WriteString(w, "hello drive")
+			return
+		}
+		writeJSON(w, map[string]string{"id": r.PathValue("id"), "name": "hello.txt", "headRevisionId": "r1"})
+	})
+	client := newFakeDrive(t, mux)
+
+	file, err := client.DownloadFile("file-1", "user-token")
+	if err != nil {
+		t.Fatalf("DownloadFile failed: %v", err)
+	}
+	if file.RemoteID != "file-1" || file.Revision != "r1" || file.FileSize != int64(len("hello drive")) {
+		t.Errorf("Unexpected file: %+v", file)
+	}
+	content, err := os.ReadFile(file.FilePath)
+	if err != nil || string(content) != "hello drive" {
+		t.Errorf("Expected the downloaded content, got %q (%v)", content, err)
+	}
+}
+
+func TestDriveUploadKeepsConflictedCopy(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(localPath, []byte("local edit"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /drive/v3/files/{id}", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"id": r.PathValue("id"), "name": "notes.txt", "headRevisionId": "remote-edit"})
+	})
+	mux.HandleFunc("PATCH /upload/drive/v3/files/{id}", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("The remote file was overwritten despite its newer revision")
+	})
+	mux.HandleFunc("POST /upload/drive/v3/files", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), "notes (conflicted copy).txt") || !strings.Contains(string(body), "local edit") {
+			t.Errorf("Unexpected upload: %s", body)
+		}
+		writeJSON(w, map[string]string{"id": "copy", "name": "notes (conflicted copy).txt", "headRevisionId": "c1"})
+	})
+	client := newFakeDrive(t, mux)
+
+	file, conflict, err := client.UploadFile("folder", "notes.txt", localPath, "remote", "base", "user-token")
+	if err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	if !conflict || file.RemoteID != "copy" || file.FileName != "notes (conflicted copy).txt" {
+		t.Errorf("Expected a conflicted copy, got %+v (conflict %v)", file, conflict)
+	}
+}
@@ -13,12 +13,14 @@ type NotificationClient struct {
 	client notification.NotificationServiceClient
 }
 
-// NewNotificationClient creates a new gRPC client for the Notification Service.
-func NewNotificationClient(notificationServiceAddress string) (*NotificationClient, error) {
+// NewNotificationClient creates a new gRPC client for the Notification Service. Extra dial
+// options are applied after the default ones.
+func NewNotificationClient(notificationServiceAddress string, opts ...grpc.DialOption) (*NotificationClient, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	conn, err := grpc.DialContext(ctx, notificationServiceAddress, grpc.WithInsecure(), grpc.WithBlock())
+	opts = append([]grpc.DialOption{grpc.WithInsecure(), grpc.WithBlock()}, opts...)
+	conn, err := grpc.DialContext(ctx, notificationServiceAddress, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to notification service: %v", err)
 	}
@@ -28,7 +30,7 @@ func NewNotificationClient(notificationServiceAddress string) (*NotificationClie
 }
 
 // SendNotification sends a notification after a file download is completed.
-func (n *NotificationClient) SendNotification(userID, message string) error {
+func (n *NotificationClient) SendNotification(userID uint64, message string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -0,0 +1,202 @@
+package clients
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/v6/dropbox/auth"
+	"google.golang.org/api/googleapi"
+)
+
+// Provider call metrics, keyed by provider and published through expvar at /debug/vars.
+var (
+	providerAttempts     = expvar.NewMap("provider_attempts")      // Every call made to a provider
+	providerRetries      = expvar.NewMap("provider_retries")       // Calls repeated after a transient failure
+	providerFailures     = expvar.NewMap("provider_failures")      // Calls that failed, transient or not
+	providerCircuitTrips = expvar.NewMap("provider_circuit_trips") // Times the circuit breaker opened
+	providerRejected     = expvar.NewMap("provider_rejected")      // Calls refused while the circuit was open
+)
+
+// ErrCircuitOpen is returned without calling the provider while its circuit breaker is open.
+var ErrCircuitOpen = errors.New("provider temporarily unavailable: circuit breaker open")
+
+// RetryPolicy controls how transient provider failures are retried.
+type RetryPolicy struct {
+	MaxAttempts      int           // Total attempts per call, including the first one
+	BaseDelay        time.Duration // Delay before the first retry; doubled on every further retry
+	MaxDelay         time.Duration // Upper bound for a single delay, including Retry-After hints
+	FailureThreshold int           // Consecutive transient failures that open the circuit
+	OpenTimeout      time.Duration // How long the circuit stays open before a trial call is allowed
+}
+
+// DefaultRetryPolicy returns the policy used when none is configured.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:      4,
+		BaseDelay:        500 * time.Millisecond,
+		MaxDelay:         30 * time.Second,
+		FailureThreshold: 5,
+		OpenTimeout:      30 * time.Second,
+	}
+}
+
+// Retrier retries calls to a single provider with exponential backoff and full jitter, honors
+// Retry-After hints on rate-limited responses, and stops calling the provider altogether while
+// its circuit breaker is open.
+type Retrier struct {
+	provider string
+	policy   RetryPolicy
+
+	mu        sync.Mutex
+	failures  int       // Consecutive transient failures
+	openUntil time.Time // Zero while the circuit is closed
+	probing   bool      // A half-open trial call is in flight
+}
+
+// NewRetrier creates a Retrier with its own circuit breaker for the given provider.
+func NewRetrier(provider string, policy RetryPolicy) *Retrier {
+	return &Retrier{provider: provider, policy: policy}
+}
+
+// Do runs op until it succeeds, fails permanently, or runs out of attempts. Only transient
+// failures (network errors, 5xx and 429 responses) are retried and count towards the breaker.
+func (r *Retrier) Do(op func() error) error {
+	var err error
+	for attempt := 1; attempt <= r.policy.MaxAttempts; attempt++ {
+		if err := r.allow(); err != nil {
+			providerRejected.Add(r.provider, 1)
+			return err
+		}
+
+		providerAttempts.Add(r.provider, 1)
+		err = op()
+		transient, retryAfter := classifyError(err)
+		r.record(err, transient)
+		if err == nil {
+			return nil
+		}
+
+		providerFailures.Add(r.provider, 1)
+		if !transient || attempt == r.policy.MaxAttempts {
+			break
+		}
+
+		delay := r.backoff(attempt, retryAfter)
+		log.Printf("Transient %s failure (attempt %d/%d), retrying in %s: %v", r.provider, attempt, r.policy.MaxAttempts, delay, err)
+		providerRetries.Add(r.provider, 1)
+		time.Sleep(delay)
+	}
+	return err
+}
+
+// allow reports whether a call may be made. Once the open timeout has passed a single trial call
+// is let through; its outcome closes or re-opens the circuit.
+func (r *Retrier) allow() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.openUntil.IsZero() {
+		return nil
+	}
+	if time.Now().Before(r.openUntil) || r.probing {
+		return fmt.Errorf("%s: %w", r.provider, ErrCircuitOpen)
+	}
+	r.probing = true
+	return nil
+}
+
+// record updates the breaker with the outcome of a call. Permanent errors, such as a missing file
+// or an invalid token, say nothing about the provider's health, so they neither count towards the
+// breaker nor close it.
+func (r *Retrier) record(err error, transient bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.probing = false
+	if err == nil {
+		r.failures = 0
+		r.openUntil = time.Time{}
+		return
+	}
+	if !transient {
+		return
+	}
+
+	r.failures++
+	if r.failures >= r.policy.FailureThreshold {
+		if r.openUntil.IsZero() || time.Now().After(r.openUntil) {
+			providerCircuitTrips.Add(r.provider, 1)
+			log.Printf("Circuit breaker for %s opened after %d consecutive failures", r.provider, r.failures)
+		}
+		r.openUntil = time.Now().Add(r.policy.OpenTimeout)
+	}
+}
+
+// backoff returns the delay before the next attempt: the provider's Retry-After hint if it sent
+// one, otherwise a random delay up to BaseDelay*2^(attempt-1).
+func (r *Retrier) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return min(retryAfter, r.policy.MaxDelay)
+	}
+
+	ceiling := r.policy.BaseDelay << (attempt - 1)
+	if ceiling <= 0 || ceiling > r.policy.MaxDelay {
+		ceiling = r.policy.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// classifyError reports whether a provider error is transient and how long the provider asked us
+// to wait before retrying.
+func classifyError(err error) (bool, time.Duration) {
+	if err == nil {
+		return false, 0
+	}
+
+	var rateLimited auth.RateLimitAPIError
+	if errors.As(err, &rateLimited) {
+		var retryAfter time.Duration
+		if rateLimited.RateLimitError != nil {
+			retryAfter = time.Duration(rateLimited.RateLimitError.RetryAfter) * time.Second
+		}
+		return true, retryAfter
+	}
+
+	var serverError auth.ServerError
+	if errors.As(err, &serverError) {
+		return true, 0
+	}
+
+	var apiError *googleapi.Error
+	if errors.As(err, &apiError) {
+		if apiError.Code == http.StatusTooManyRequests || apiError.Code >= 500 {
+			return true, parseRetryAfter(apiError.Header.Get("Retry-After"))
+		}
+		return false, 0
+	}
+
+	var netError net.Error
+	return errors.As(err, &netError), 0
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
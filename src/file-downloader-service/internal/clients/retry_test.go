@@ -0,0 +1,57 @@
+package clients
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/v6/dropbox/auth"
+)
+
+// testPolicy makes a single attempt per call and opens the circuit after two transient failures.
+var testPolicy = RetryPolicy{MaxAttempts: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, FailureThreshold: 2, OpenTimeout: time.Hour}
+
+func TestRetrierOpensAfterTransientFailures(t *testing.T) {
+	r := NewRetrier("test", testPolicy)
+	transient := func() error { return auth.ServerError{} }
+
+	r.Do(transient)
+	r.Do(transient)
+	if err := r.Do(func() error { return nil }); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected the circuit to be open, got %v", err)
+	}
+}
+
+func TestRetrierPermanentErrorKeepsFailureCount(t *testing.T) {
+	r := NewRetrier("test", testPolicy)
+	transient := func() error { return auth.ServerError{} }
+	permanent := errors.New("file not found")
+
+	r.Do(transient)
+	if err := r.Do(func() error { return permanent }); err != permanent {
+		t.Fatalf("Expected the permanent error, got %v", err)
+	}
+	r.Do(transient)
+	if err := r.Do(func() error { return nil }); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected a permanent error not to reset the failure count, got %v", err)
+	}
+}
+
+func TestRetrierPermanentErrorDoesNotCloseCircuit(t *testing.T) {
+	policy := testPolicy
+	policy.OpenTimeout = time.Millisecond
+	r := NewRetrier("test", policy)
+	transient := func() error { return auth.ServerError{} }
+
+	r.Do(transient)
+	r.Do(transient)
+	time.Sleep(2 * time.Millisecond)
+
+	// The trial call fails permanently, so the next call is a trial again rather than the circuit
+	// closing; a transient failure then re-opens it at once
+	r.Do(func() error { return errors.New("invalid token") })
+	r.Do(transient)
+	if err := r.Do(func() error { return nil }); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected the circuit to stay open, got %v", err)
+	}
+}
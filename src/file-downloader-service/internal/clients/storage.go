@@ -0,0 +1,44 @@
+package clients
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// downloadDir is where downloaded files are stored.
+const downloadDir = "./downloads"
+
+// saveAtomically writes content to name inside downloadDir. The data is written to a temporary
+// file in the same directory and renamed into place only once it is complete, so a failed or
+// interrupted download never leaves a truncated file behind.
+func saveAtomically(name string, content io.Reader) (string, int64, error) {
+	if err := os.MkdirAll(downloadDir, 0755); err != nil {
+		return "", 0, fmt.Errorf("unable to create download directory: %v", err)
+	}
+
+	tmpFile, err := os.CreateTemp(downloadDir, "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return "", 0, fmt.Errorf("unable to create local file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name()) // No-op once the file has been renamed
+
+	written, err := io.Copy(tmpFile, content)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("unable to save file locally: %w", err)
+	}
+
+	filePath := filepath.Join(downloadDir, filepath.Base(name))
+	if err := os.Rename(tmpFile.Name(), filePath); err != nil {
+		return "", 0, fmt.Errorf("unable to move file into place: %v", err)
+	}
+
+	return filePath, written, nil
+}
@@ -26,14 +26,77 @@ func (h *FileDownloaderHandler) DownloadFile(ctx context.Context, req *filedownl
 	log.Printf("Received request to download file: %s from provider: %s", req.FileId, req.Provider)
 
 	// Call the service to download the file
-	filePath, err := h.downloaderService.DownloadFile(req.FileId, req.Provider, req.AuthToken)
+	file, err := h.downloaderService.DownloadFile(req.UserId, req.FileId, req.Provider, req.AuthToken)
 	if err != nil {
 		log.Printf("Failed to download file: %v", err)
 		return nil, err
 	}
 
-	// Return the file path in the response
+	// Return the local path and provenance of the downloaded file
 	return &filedownloader.DownloadFileResponse{
-		FilePath: filePath,
+		FilePath: file.FilePath,
+		FileName: file.FileName,
+		FileSize: file.FileSize,
+		RemoteId: file.RemoteID,
+		Revision: file.Revision,
+	}, nil
+}
+
+// ListRemoteChanges handles the gRPC request for the changes in a cloud folder since a cursor.
+func (h *FileDownloaderHandler) ListRemoteChanges(ctx context.Context, req *filedownloader.ListRemoteChangesRequest) (*filedownloader.ListRemoteChangesResponse, error) {
+	log.Printf("Received request to list changes in folder: %s from provider: %s", req.FolderId, req.Provider)
+
+	changes, cursor, err := h.downloaderService.ListRemoteChanges(req.Provider, req.AuthToken, req.FolderId, req.Cursor)
+	if err != nil {
+		log.Printf("Failed to list remote changes: %v", err)
+		return nil, err
+	}
+
+	resp := &filedownloader.ListRemoteChangesResponse{Cursor: cursor}
+	for _, change := range changes {
+		resp.Changes = append(resp.Changes, &filedownloader.RemoteChange{
+			RemoteId: change.RemoteID,
+			Name:     change.Name,
+			Revision: change.Revision,
+			Deleted:  change.Deleted,
+			IsFolder: change.IsFolder,
+		})
+	}
+	return resp, nil
+}
+
+// UploadFile handles the gRPC request for uploading a local file to cloud storage.
+func (h *FileDownloaderHandler) UploadFile(ctx context.Context, req *filedownloader.UploadFileRequest) (*filedownloader.UploadFileResponse, error) {
+	log.Printf("Received request to upload file: %s to provider: %s", req.FilePath, req.Provider)
+
+	file, conflict, err := h.downloaderService.UploadFile(req.Provider, req.AuthToken, req.FolderId, req.FileName, req.FilePath, req.RemoteId, req.BaseRevision)
+	if err != nil {
+		log.Printf("Failed to upload file: %v", err)
+		return nil, err
+	}
+
+	return &filedownloader.UploadFileResponse{
+		RemoteId: file.RemoteID,
+		FileName: file.FileName,
+		Revision: file.Revision,
+		Conflict: conflict,
+	}, nil
+}
+
+// ExportFile handles the gRPC request for exporting a stored file to cloud storage.
+func (h *FileDownloaderHandler) ExportFile(ctx context.Context, req *filedownloader.ExportFileRequest) (*filedownloader.ExportFileResponse, error) {
+	log.Printf("Received request to export file: %s to provider: %s", req.FilePath, req.Provider)
+
+	file, err := h.downloaderService.ExportFile(req.UserId, req.Provider, req.AuthToken, req.FolderId, req.FileName, req.FilePath)
+	if err != nil {
+		log.Printf("Failed to export file: %v", err)
+		return nil, err
+	}
+
+	return &filedownloader.ExportFileResponse{
+		RemoteId: file.RemoteID,
+		FileName: file.FileName,
+		Revision: file.Revision,
+		FileSize: file.FileSize,
 	}, nil
 }
\ No newline at end of file
@@ -0,0 +1,236 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"file-downloader-service/internal/clients"
+	"file-downloader-service/internal/handlers"
+	"file-downloader-service/internal/models"
+	"file-downloader-service/internal/services"
+	"file-downloader-service/proto/generated/filedownloader"
+	"file-downloader-service/proto/generated/notification"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeProvider is a cloud storage provider serving files from memory.
+type fakeProvider struct {
+	files     map[string]*models.File
+	uploadErr error
+	uploads   []string
+}
+
+func (p *fakeProvider) DownloadFile(fileID, authToken string) (*models.File, error) {
+	file, ok := p.files[fileID]
+	if !ok {
+		return nil, errors.New("file not found")
+	}
+	return file, nil
+}
+
+func (p *fakeProvider) ListChanges(folderID, cursor, authToken string) ([]models.RemoteChange, string, error) {
+	return nil, cursor, nil
+}
+
+func (p *fakeProvider) UploadFile(folderID, fileName, localPath, remoteID, baseRevision, authToken string) (*models.File, bool, error) {
+	if p.uploadErr != nil {
+		return nil, false, p.uploadErr
+	}
+	p.uploads = append(p.uploads, fileName)
+	return &models.File{FileName: fileName, FilePath: localPath, RemoteID: "remote-" + fileName, Revision: "1"}, false, nil
+}
+
+// fakeNotificationServer records the notifications it receives.
+type fakeNotificationServer struct {
+	notification.UnimplementedNotificationServiceServer
+
+	mu   sync.Mutex
+	sent []*notification.SendNotificationRequest
+	fail bool
+}
+
+func (s *fakeNotificationServer) SendNotification(ctx context.Context, req *notification.SendNotificationRequest) (*notification.SendNotificationResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return nil, errors.New("notification service unavailable")
+	}
+	s.sent = append(s.sent, req)
+	return &notification.SendNotificationResponse{Success: true}, nil
+}
+
+func (s *fakeNotificationServer) notifications() []*notification.SendNotificationRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*notification.SendNotificationRequest(nil), s.sent...)
+}
+
+// serve starts a gRPC server on an in-memory listener and returns a dial option connecting to it.
+func serve(t *testing.T, register func(*grpc.Server)) grpc.DialOption {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	register(server)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	return grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	})
+}
+
+// newDownloader starts the downloader service with fake providers and a fake notification
+// service, both over gRPC, and returns a client of it.
+func newDownloader(t *testing.T, drive, dropbox *fakeProvider, notifications *fakeNotificationServer) filedownloader.FileDownloaderServiceClient {
+	t.Helper()
+	notificationDialer := serve(t, func(s *grpc.Server) {
+		notification.RegisterNotificationServiceServer(s, notifications)
+	})
+	notificationClient, err := clients.NewNotificationClient("passthrough:///notification", notificationDialer)
+	if err != nil {
+		t.Fatalf("Failed to create notification client: %v", err)
+	}
+
+	downloaderService := services.NewFileDownloaderService(drive, dropbox, notificationClient)
+	downloaderDialer := serve(t, func(s *grpc.Server) {
+		filedownloader.RegisterFileDownloaderServiceServer(s, handlers.NewFileDownloaderHandler(downloaderService))
+	})
+	conn, err := grpc.NewClient("passthrough:///downloader", downloaderDialer, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial downloader: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return filedownloader.NewFileDownloaderServiceClient(conn)
+}
+
+func TestDownloadFileNotifiesUser(t *testing.T) {
+	drive := &fakeProvider{files: map[string]*models.File{
+		"drive-1": {FileName: "report.pdf", FilePath: "downloads/report.pdf", FileSize: 42, RemoteID: "drive-1", Revision: "r7"},
+	}}
+	notifications := &fakeNotificationServer{}
+	client := newDownloader(t, drive, &fakeProvider{}, notifications)
+
+	resp, err := client.DownloadFile(context.Background(), &filedownloader.DownloadFileRequest{
+		FileId:   "drive-1",
+		Provider: "google_drive",
+		UserId:   17,
+	})
+	if err != nil {
+		t.Fatalf("DownloadFile failed: %v", err)
+	}
+	if resp.FilePath != "downloads/report.pdf" || resp.FileSize != 42 || resp.RemoteId != "drive-1" || resp.Revision != "r7" {
+		t.Errorf("Unexpected response: %v", resp)
+	}
+
+	sent := notifications.notifications()
+	if len(sent) != 1 {
+		t.Fatalf("Expected 1 notification, got %d", len(sent))
+	}
+	if sent[0].UserId != 17 || !strings.Contains(sent[0].Message, "drive-1") {
+		t.Errorf("Unexpected notification: %v", sent[0])
+	}
+}
+
+func TestDownloadFileUsesRequestedProvider(t *testing.T) {
+	dropbox := &fakeProvider{files: map[string]*models.File{
+		"id:abc": {FileName: "notes.txt", FilePath: "downloads/notes.txt", RemoteID: "id:abc"},
+	}}
+	notifications := &fakeNotificationServer{}
+	client := newDownloader(t, &fakeProvider{}, dropbox, notifications)
+
+	if _, err := client.DownloadFile(context.Background(), &filedownloader.DownloadFileRequest{FileId: "id:abc", Provider: "google_drive", UserId: 3}); err == nil {
+		t.Error("Expected a download of a Dropbox file from Google Drive to fail")
+	}
+	if _, err := client.DownloadFile(context.Background(), &filedownloader.DownloadFileRequest{FileId: "id:abc", Provider: "dropbox", UserId: 3}); err != nil {
+		t.Fatalf("DownloadFile failed: %v", err)
+	}
+	if _, err := client.DownloadFile(context.Background(), &filedownloader.DownloadFileRequest{FileId: "id:abc", Provider: "box", UserId: 3}); err == nil {
+		t.Error("Expected an unsupported provider to fail")
+	}
+
+	if sent := notifications.notifications(); len(sent) != 1 || sent[0].UserId != 3 {
+		t.Errorf("Expected one notification for user 3, got %v", sent)
+	}
+}
+
+func TestDownloadFileFailsWithoutNotification(t *testing.T) {
+	drive := &fakeProvider{files: map[string]*models.File{
+		"drive-1": {FileName: "report.pdf", FilePath: "downloads/report.pdf"},
+	}}
+	client := newDownloader(t, drive, &fakeProvider{}, &fakeNotificationServer{fail: true})
+
+	_, err := client.DownloadFile(context.Background(), &filedownloader.DownloadFileRequest{FileId: "drive-1", Provider: "google_drive", UserId: 17})
+	if err == nil {
+		t.Error("Expected the download to fail when the user cannot be notified")
+	}
+}
+
+func TestExportFileNotifiesUser(t *testing.T) {
+	dropbox := &fakeProvider{}
+	notifications := &fakeNotificationServer{}
+	client := newDownloader(t, &fakeProvider{}, dropbox, notifications)
+
+	resp, err := client.ExportFile(context.Background(), &filedownloader.ExportFileRequest{
+		UserId:   9,
+		Provider: "dropbox",
+		FolderId: "/exports",
+		FilePath: "uploads/summary.csv",
+	})
+	if err != nil {
+		t.Fatalf("ExportFile failed: %v", err)
+	}
+	if resp.FileName != "summary.csv" || resp.RemoteId != "remote-summary.csv" {
+		t.Errorf("Unexpected response: %v", resp)
+	}
+	if len(dropbox.uploads) != 1 {
+		t.Errorf("Expected 1 upload, got %d", len(dropbox.uploads))
+	}
+
+	sent := notifications.notifications()
+	if len(sent) != 1 || sent[0].UserId != 9 || !strings.Contains(sent[0].Message, "exported") {
+		t.Errorf("Unexpected notifications: %v", sent)
+	}
+}
+
+func TestExportFileNotifiesFailure(t *testing.T) {
+	drive := &fakeProvider{uploadErr: errors.New("quota exceeded")}
+	notifications := &fakeNotificationServer{}
+	client := newDownloader(t, drive, &fakeProvider{}, notifications)
+
+	_, err := client.ExportFile(context.Background(), &filedownloader.ExportFileRequest{
+		UserId:   9,
+		Provider: "google_drive",
+		FilePath: "uploads/summary.csv",
+	})
+	if err == nil {
+		t.Fatal("Expected the export to fail")
+	}
+
+	sent := notifications.notifications()
+	if len(sent) != 1 || sent[0].UserId != 9 || !strings.Contains(sent[0].Message, "failed") {
+		t.Errorf("Unexpected notifications: %v", sent)
+	}
+}
+
+func TestExportStandsWhenNotificationFails(t *testing.T) {
+	drive := &fakeProvider{}
+	client := newDownloader(t, drive, &fakeProvider{}, &fakeNotificationServer{fail: true})
+
+	if _, err := client.ExportFile(context.Background(), &filedownloader.ExportFileRequest{
+		UserId:   9,
+		Provider: "google_drive",
+		FilePath: "uploads/summary.csv",
+	}); err != nil {
+		t.Fatalf("Expected the export to succeed, got %v", err)
+	}
+	if len(drive.uploads) != 1 {
+		t.Errorf("Expected 1 upload, got %d", len(drive.uploads))
+	}
+}
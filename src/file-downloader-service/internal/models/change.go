@@ -0,0 +1,10 @@
+package models
+
+// RemoteChange describes a single entry reported by a cloud provider's change feed.
+type RemoteChange struct {
+	RemoteID string // Canonical file ID in the cloud provider
+	Name     string // File name
+	Revision string // Provider revision of the file content
+	Deleted  bool   // True if the file was removed from the watched folder
+	IsFolder bool   // True if the entry is a folder
+}
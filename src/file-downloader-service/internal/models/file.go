@@ -14,6 +14,9 @@ type File struct {
 	FilePath  string         `gorm:"not null" json:"file_path"`
 	FileSize  int64          `gorm:"not null" json:"file_size"`
 	Status    string         `gorm:"not null" json:"status"` // e.g., downloading, completed, failed
+	Provider  string         `json:"provider"`                // Cloud provider the file was imported from
+	RemoteID  string         `json:"remote_id"`               // Canonical file ID in the cloud provider
+	Revision  string         `json:"revision"`                // Provider revision of the downloaded content
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`         // Soft delete support
@@ -3,19 +3,33 @@ package services
 import (
 	"fmt"
 	"log"
+	"path/filepath"
 
-	"file-downloader-service/internal/clients"
+	"file-downloader-service/internal/models"
 )
 
+// StorageProvider is a cloud storage provider files are downloaded from and uploaded to.
+// clients.GoogleDriveClient and clients.DropboxClient implement it.
+type StorageProvider interface {
+	DownloadFile(fileID, authToken string) (*models.File, error)
+	ListChanges(folderID, cursor, authToken string) ([]models.RemoteChange, string, error)
+	UploadFile(folderID, fileName, localPath, remoteID, baseRevision, authToken string) (*models.File, bool, error)
+}
+
+// Notifier sends notifications to users. clients.NotificationClient implements it.
+type Notifier interface {
+	SendNotification(userID uint64, message string) error
+}
+
 // FileDownloaderService handles the logic for downloading files from cloud storage.
 type FileDownloaderService struct {
-	googleDriveClient  *clients.GoogleDriveClient
-	dropboxClient      *clients.DropboxClient
-	notificationClient *clients.NotificationClient
+	googleDriveClient  StorageProvider
+	dropboxClient      StorageProvider
+	notificationClient Notifier
 }
 
 // NewFileDownloaderService creates a new instance of FileDownloaderService.
-func NewFileDownloaderService(googleDriveClient *clients.GoogleDriveClient, dropboxClient *clients.DropboxClient, notificationClient *clients.NotificationClient) *FileDownloaderService {
+func NewFileDownloaderService(googleDriveClient, dropboxClient StorageProvider, notificationClient Notifier) *FileDownloaderService {
 	return &FileDownloaderService{
 		googleDriveClient:  googleDriveClient,
 		dropboxClient:      dropboxClient,
@@ -23,33 +37,87 @@ func NewFileDownloaderService(googleDriveClient *clients.GoogleDriveClient, drop
 	}
 }
 
-// DownloadFile downloads a file from the specified cloud provider and saves it locally.
-func (s *FileDownloaderService) DownloadFile(fileID, provider, authToken string) (string, error) {
-	var filePath string
+// DownloadFile downloads a file from the specified cloud provider, saves it locally and
+// returns the metadata callers need to register the import.
+func (s *FileDownloaderService) DownloadFile(userID uint64, fileID, provider, authToken string) (*models.File, error) {
+	var file *models.File
 	var err error
 
 	// Download the file based on the provider (Google Drive or Dropbox)
 	switch provider {
 	case "google_drive":
-		filePath, err = s.googleDriveClient.DownloadFile(fileID, authToken)
+		file, err = s.googleDriveClient.DownloadFile(fileID, authToken)
 	case "dropbox":
-		filePath, err = s.dropboxClient.DownloadFile(fileID, authToken)
+		file, err = s.dropboxClient.DownloadFile(fileID, authToken)
 	default:
-		return "", fmt.Errorf("unsupported provider: %s", provider)
+		return nil, fmt.Errorf("unsupported provider: %s", provider)
 	}
 
 	if err != nil {
 		log.Printf("Error downloading file: %v", err)
-		return "", err
+		return nil, err
 	}
 
 	// Notify the user that the file has been downloaded
-	err = s.notificationClient.SendNotification("user_id_placeholder", fmt.Sprintf("File %s has been downloaded to %s", fileID, filePath))
+	err = s.notificationClient.SendNotification(userID, fmt.Sprintf("File %s has been downloaded to %s", fileID, file.FilePath))
+	if err != nil {
+		log.Printf("Failed to send notification: %v", err)
+		return nil, err
+	}
+
+	log.Printf("File %s downloaded successfully to %s", fileID, file.FilePath)
+	return file, nil
+}
+
+// ListRemoteChanges returns the changes in a cloud folder since the given provider cursor.
+func (s *FileDownloaderService) ListRemoteChanges(provider, authToken, folderID, cursor string) ([]models.RemoteChange, string, error) {
+	switch provider {
+	case "google_drive":
+		return s.googleDriveClient.ListChanges(folderID, cursor, authToken)
+	case "dropbox":
+		return s.dropboxClient.ListChanges(folderID, cursor, authToken)
+	default:
+		return nil, "", fmt.Errorf("unsupported provider: %s", provider)
+	}
+}
+
+// UploadFile uploads a local file to a cloud folder, reporting whether it had to be stored as a
+// conflicted copy because the remote file changed since the base revision.
+func (s *FileDownloaderService) UploadFile(provider, authToken, folderID, fileName, filePath, remoteID, baseRevision string) (*models.File, bool, error) {
+	switch provider {
+	case "google_drive":
+		return s.googleDriveClient.UploadFile(folderID, fileName, filePath, remoteID, baseRevision, authToken)
+	case "dropbox":
+		return s.dropboxClient.UploadFile(folderID, fileName, filePath, remoteID, baseRevision, authToken)
+	default:
+		return nil, false, fmt.Errorf("unsupported provider: %s", provider)
+	}
+}
+
+// ExportFile pushes a stored file to the user's cloud storage and notifies the user when the
+// export completes or fails. Exports never overwrite remote files; a name clash is resolved by
+// the provider keeping both files.
+func (s *FileDownloaderService) ExportFile(userID uint64, provider, authToken, folderID, fileName, filePath string) (*models.File, error) {
+	if fileName == "" {
+		fileName = filepath.Base(filePath)
+	}
+
+	file, _, err := s.UploadFile(provider, authToken, folderID, fileName, filePath, "", "")
+	if err != nil {
+		log.Printf("Error exporting file: %v", err)
+		if notifyErr := s.notificationClient.SendNotification(userID, fmt.Sprintf("Export of %s to %s failed", fileName, provider)); notifyErr != nil {
+			log.Printf("Failed to send notification: %v", notifyErr)
+		}
+		return nil, err
+	}
+
+	// Notify the user that the file has been exported; the export stands even if the
+	// notification cannot be sent
+	err = s.notificationClient.SendNotification(userID, fmt.Sprintf("File %s has been exported to %s as %s", fileName, provider, file.FileName))
 	if err != nil {
 		log.Printf("Failed to send notification: %v", err)
-		return "", err
 	}
 
-	log.Printf("File %s downloaded successfully to %s", fileID, filePath)
-	return filePath, nil
+	log.Printf("File %s exported successfully to %s as %s", filePath, provider, file.RemoteID)
+	return file, nil
 }
\ No newline at end of file
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"google.golang.org/grpc"
@@ -10,8 +11,12 @@ import (
 	"file-picker-service/config"
 	"file-picker-service/internal/db"
 	"file-picker-service/internal/handlers"
+	"file-picker-service/internal/models"
 	"file-picker-service/internal/pkg"
 	"file-picker-service/internal/services"
+
+	"shared/authn"
+	"shared/authn/ginauth"
 )
 
 func main() {
@@ -21,17 +26,26 @@ func main() {
 	// Initialize the logger
 	pkg.InitLogger()
 
-	// Initialize the database connection
+	// Initialize the database connection, once stored provider tokens can be decrypted
+	if err := models.SetTokenKey(cfg.TokenKey); err != nil {
+		log.Fatalf("Invalid token encryption key: %v", err)
+	}
 	db.InitDatabase()
 
 
 	// Initialize gRPC clients for communication with other services
 
-	permissionsClient, err := services.NewPermissionClient(cfg.GrpcAddresses.PermissionsAddress)
+	// Calls carry the user's access token, or the picker's service account key outside requests
+	if cfg.Auth.ServiceAPIKey == "" {
+		log.Println("SERVICE_API_KEY is not set, cloud sync, share links and user cleanup cannot reach the Permissions Service")
+	}
+	permissionsClient, err := services.NewPermissionClient(cfg.GrpcAddresses.PermissionsAddress, cfg.Auth.ServiceAPIKey,
+		time.Duration(cfg.PermissionCache.TTL)*time.Second, time.Duration(cfg.PermissionCache.NegativeTTL)*time.Second)
 
 	if err != nil {
 		log.Fatalf("Failed to create permissions client: %v", err)
 	}
+	defer permissionsClient.Close()
 
 	downloaderClient, err := services.NewDownloaderClient(cfg.GrpcAddresses.FileDownloaderAddress)
 	if err != nil {
@@ -52,15 +66,68 @@ func main() {
 	defer notificationConn.Close()
 	notificationClient := services.NewNotificationClient(notificationConn)
 
+	fileService := services.NewFileService(*permissionsClient, *downloaderClient, *transformationClient, *notificationClient, cfg.UploadDir)
+
+	// Periodically reconcile picker folders bound to cloud folders
+	syncService := services.NewSyncService(fileService, *downloaderClient, *notificationClient)
+	stopSync := make(chan struct{})
+	defer close(stopSync)
+	go syncService.Start(time.Duration(cfg.SyncInterval)*time.Second, stopSync)
+
+	shareLinkService := services.NewShareLinkService(*permissionsClient)
+	userCleanupService := services.NewUserCleanupService(*permissionsClient)
+
 	// Set up the Gin router
 	router := gin.Default()
 
+	// Share link password failures are counted per client IP, taken from X-Forwarded-For only
+	// when the request comes from a trusted proxy
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	// Every API route requires a valid access token, which sets the user ID and role
+	verifier, err := authn.NewVerifier(authn.Config{
+		JWKSURL:            cfg.Auth.JWKSURL,
+		RevokedSessionsURL: cfg.Auth.RevokedSessionsURL,
+		TokenURL:           cfg.Auth.TokenURL,
+		ServiceAPIKey:      cfg.Auth.ServiceAPIKey,
+		Issuer:             cfg.Auth.Issuer,
+		Audience:           cfg.Auth.Audience,
+	})
+	if err != nil {
+		log.Fatalf("Failed to create token verifier: %v", err)
+	}
+	defer verifier.Close()
+	api := router.Group("/api", ginauth.Middleware(verifier))
+
 	// Register routes and handlers
-	router.POST("/api/upload", handlers.FileUploadHandler(permissionsClient))
-	router.GET("/api/files", handlers.ListFilesHandler(permissionsClient))
-	router.POST("/api/files/download", handlers.FileDownloadHandler(permissionsClient, downloaderClient))
-	router.POST("/api/transform/:id", handlers.FileTransformationHandler(permissionsClient, transformationClient, notificationClient))
-	router.POST("/api/files/share", handlers.AddPermissionsHandler(permissionsClient))
+	api.POST("/upload", handlers.FileUploadHandler(fileService))
+	api.GET("/files", handlers.ListFilesHandler(fileService))
+	api.POST("/files/download", handlers.FileDownloadHandler(fileService))
+	api.POST("/transform/:id", handlers.FileTransformationHandler(permissionsClient, transformationClient, notificationClient))
+	api.POST("/files/:id/export", handlers.FileExportHandler(fileService))
+	api.POST("/files/share", handlers.AddPermissionsHandler(permissionsClient))
+	api.DELETE("/files/:id/share/:userId", handlers.RevokePermissionsHandler(permissionsClient))
+	api.GET("/files/:id/permissions", handlers.ListFilePermissionsHandler(permissionsClient))
+	api.POST("/groups", handlers.CreateGroupHandler(permissionsClient))
+	api.GET("/groups/:id/members", handlers.ListGroupMembersHandler(permissionsClient))
+	api.POST("/groups/:id/members", handlers.AddGroupMemberHandler(permissionsClient))
+	api.DELETE("/groups/:id/members/:memberId", handlers.RemoveGroupMemberHandler(permissionsClient))
+	api.POST("/share-links", handlers.CreateShareLinkHandler(shareLinkService))
+	api.GET("/share-links", handlers.ListShareLinksHandler(shareLinkService))
+	api.DELETE("/share-links/:id", handlers.RevokeShareLinkHandler(shareLinkService))
+	api.GET("/audit", handlers.AuditLogHandler(permissionsClient))
+	api.GET("/audit/export", handlers.ExportAuditLogHandler(permissionsClient))
+	api.POST("/sync", handlers.CreateSyncBindingHandler(syncService))
+	api.GET("/sync/:id", handlers.SyncStatusHandler(syncService))
+
+	// Share links are opened without signing in
+	router.GET("/s/:token", handlers.OpenShareLinkHandler(shareLinkService))
+	router.GET("/s/:token/:fileId", handlers.ShareLinkFileHandler(shareLinkService))
+
+	// User events are delivered by the auth-service, which signs them with the shared secret
+	router.POST("/internal/user-events", handlers.UserEventHandler(userCleanupService, cfg.UserEventSecret))
 
 	// Start the server
 	port := cfg.ServerPort
@@ -1,18 +1,44 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
 
 // Config holds all the configuration values required by the service
 type Config struct {
-	ServerPort    string
-	DatabaseURL   string
-	GrpcAddresses GrpcConfig
+	ServerPort      string
+	TrustedProxies  []string // Proxies whose X-Forwarded-For is trusted for the client IP
+	DatabaseURL     string
+	GrpcAddresses   GrpcConfig
+	SyncInterval    int // Seconds between reconciliations of synced cloud folders
+	UploadDir       string // Directory uploaded files are stored in, one file per file ID
+	PermissionCache PermissionCacheConfig
+	Auth            AuthConfig
+	UserEventSecret string // Secret the auth-service signs user event webhooks with
+	TokenKey        []byte // AES-256 key cloud provider tokens are encrypted with at rest
+}
+
+// AuthConfig controls the verification of the access tokens issued by the auth-service
+type AuthConfig struct {
+	JWKSURL            string // The auth-service /.well-known/jwks.json
+	RevokedSessionsURL string // The auth-service /api/revoked-sessions, optional
+	TokenURL           string // The auth-service /api/token, optional; API keys are accepted when set
+	Issuer             string // Required iss claim
+	Audience           string // Required aud claim, optional
+	ServiceAPIKey      string // API key of the picker's service account, for calls made outside a user's request
+}
+
+// PermissionCacheConfig controls the cache of permission decisions
+type PermissionCacheConfig struct {
+	TTL         int // Seconds a granted decision is cached; 0 disables the cache
+	NegativeTTL int // Seconds a denied decision is cached
 }
 
 // GrpcConfig holds the addresses for gRPC communication with other services
@@ -34,6 +60,7 @@ func LoadConfig() *Config {
 	// Load essential environment variables
 	cfg := &Config{
 		ServerPort:  getEnv("SERVER_PORT", "8080"),            // Default to port 8080 if not specified
+		TrustedProxies: getListEnv("TRUSTED_PROXIES", ""),
 		DatabaseURL: getEnv("DATABASE_URL", ""),               // Required database connection string
 		GrpcAddresses: GrpcConfig{
 			FileDownloaderAddress: getEnv("GRPC_FILE_DOWNLOADER_ADDRESS", ""),
@@ -41,7 +68,28 @@ func LoadConfig() *Config {
 			NotificationAddress:   getEnv("GRPC_NOTIFICATION_ADDRESS", ""),
 			TransformationsAddress: getEnv("GRPC_TRANSFORMATIONS_ADDRESS", ""),
 		},
+		SyncInterval: getEnvAsInt("SYNC_INTERVAL_SECONDS", 300), // Default to reconciling every 5 minutes
+		UploadDir:    getEnv("UPLOAD_DIR", "./uploads"),
+		PermissionCache: PermissionCacheConfig{
+			TTL:         getEnvAsInt("PERMISSION_CACHE_TTL_SECONDS", 5),
+			NegativeTTL: getEnvAsInt("PERMISSION_CACHE_NEGATIVE_TTL_SECONDS", 1),
+		},
+		Auth: AuthConfig{
+			JWKSURL:            getEnv("AUTH_JWKS_URL", ""),
+			RevokedSessionsURL: getEnv("AUTH_REVOKED_SESSIONS_URL", ""),
+			TokenURL:           getEnv("AUTH_TOKEN_URL", ""),
+			Issuer:             getEnv("JWT_ISSUER", ""),
+			Audience:           getEnv("JWT_AUDIENCE", ""),
+			ServiceAPIKey:      getEnv("SERVICE_API_KEY", ""),
+		},
+		UserEventSecret: getEnv("USER_EVENT_SECRET", ""),
+	}
+
+	tokenKey, err := base64.StdEncoding.DecodeString(getEnv("TOKEN_ENCRYPTION_KEY", ""))
+	if err != nil || len(tokenKey) != 32 {
+		log.Fatal("TOKEN_ENCRYPTION_KEY environment variable is required and must be 32 base64-encoded bytes")
 	}
+	cfg.TokenKey = tokenKey
 
 	// Ensure all necessary environment variables are set
 	validateConfig(cfg)
@@ -59,6 +107,30 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getListEnv retrieves a comma-separated environment variable as a list, skipping empty items
+func getListEnv(key, defaultValue string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnv(key, defaultValue), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvAsInt retrieves an integer environment variable or returns a default value if not set or invalid
+func getEnvAsInt(key string, defaultValue int) int {
+	if value, exists := os.LookupEnv(key); exists {
+		intValue, err := strconv.Atoi(value)
+		if err != nil {
+			log.Printf("Invalid value for %s, defaulting to %d", key, defaultValue)
+			return defaultValue
+		}
+		return intValue
+	}
+	return defaultValue
+}
+
 // validateConfig ensures that all required environment variables are present
 func validateConfig(cfg *Config) {
 	if cfg.DatabaseURL == "" {
@@ -73,4 +145,13 @@ func validateConfig(cfg *Config) {
 	if cfg.GrpcAddresses.NotificationAddress == "" {
 		log.Fatal("GRPC_NOTIFICATION_ADDRESS environment variable is required")
 	}
+	if cfg.Auth.JWKSURL == "" {
+		log.Fatal("AUTH_JWKS_URL environment variable is required")
+	}
+	if cfg.Auth.Issuer == "" {
+		log.Fatal("JWT_ISSUER environment variable is required")
+	}
+	if cfg.UserEventSecret == "" || cfg.UserEventSecret == "change-me" {
+		log.Fatal("USER_EVENT_SECRET environment variable is required and must not be the example value")
+	}
 }
\ No newline at end of file
@@ -52,12 +52,22 @@ func InitDatabase() (*gorm.DB, error) {
 func runMigrations() error {
 	log.Println("Running database migrations...")
 
-	// Migrate the schema for User and File models
-	err := DB.AutoMigrate(&models.User{}, &models.File{})
+	// Migrate the schema for User, File, SyncBinding, ShareLink and ShareLinkPasswordFailure models
+	err := DB.AutoMigrate(&models.User{}, &models.File{}, &models.SyncBinding{}, &models.ShareLink{}, &models.ShareLinkPasswordFailure{})
 	if err != nil {
 		return fmt.Errorf("failed to run migrations: %v", err)
 	}
 
+	// Files stored before MIME types were recorded get them from their names
+	if err := models.BackfillMimeTypes(DB); err != nil {
+		return fmt.Errorf("failed to backfill file MIME types: %v", err)
+	}
+
+	// Provider tokens stored before they were encrypted are encrypted in place
+	if err := models.SealSyncTokens(DB); err != nil {
+		return fmt.Errorf("failed to encrypt sync tokens: %v", err)
+	}
+
 	log.Println("Database migrations completed successfully.")
 	return nil
 }
\ No newline at end of file
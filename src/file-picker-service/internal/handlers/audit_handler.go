@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"file-picker-service/internal/pkg"
+	"file-picker-service/internal/services"
+	"file-picker-service/proto/generated/permission"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditEntry is the JSON form of a permission audit log entry.
+type auditEntry struct {
+	ID          uint64    `json:"id"`
+	Time        time.Time `json:"time"`
+	Action      string    `json:"action"`
+	ActorID     uint64    `json:"actor_id"`
+	SubjectType string    `json:"subject_type,omitempty"`
+	SubjectID   string    `json:"subject_id,omitempty"`
+	ObjectType  string    `json:"object_type,omitempty"`
+	ObjectID    string    `json:"object_id,omitempty"`
+	Permission  string    `json:"permission,omitempty"`
+	Decision    string    `json:"decision,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+func newAuditEntry(entry *permission.AuditEntry) auditEntry {
+	return auditEntry{
+		ID:          entry.Id,
+		Time:        time.UnixMilli(entry.Time).UTC(),
+		Action:      entry.Action,
+		ActorID:     entry.ActorId,
+		SubjectType: entry.SubjectType,
+		SubjectID:   entry.SubjectId,
+		ObjectType:  entry.ObjectType,
+		ObjectID:    entry.ObjectId,
+		Permission:  entry.Permission,
+		Decision:    entry.Decision,
+		Reason:      entry.Reason,
+	}
+}
+
+// AuditLogHandler returns a page of the permission audit log to users whose role may audit. The
+// log can be filtered with start and end (RFC 3339), actor_id, subject_type, subject_id, file_id
+// and action, and paged with page_size and page_token.
+func AuditLogHandler(permissionsClient *services.PermissionClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := parseAuditQuery(c)
+		if err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
+
+		resp, err := permissionsClient.QueryAuditLog(c.Request.Context(), req)
+		if err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
+
+		entries := make([]auditEntry, len(resp.Entries))
+		for i, entry := range resp.Entries {
+			entries[i] = newAuditEntry(entry)
+		}
+		c.JSON(http.StatusOK, gin.H{"entries": entries, "next_page_token": resp.NextPageToken})
+	}
+}
+
+// ExportAuditLogHandler streams every audit log entry matching the same filters as AuditLogHandler
+// as JSON lines, for compliance reviews.
+func ExportAuditLogHandler(permissionsClient *services.PermissionClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := parseAuditQuery(c)
+		if err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
+
+		// Fetch the first page before writing anything so that errors still get a proper status
+		resp, err := permissionsClient.QueryAuditLog(c.Request.Context(), req)
+		if err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
+
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", `attachment; filename="audit-log.jsonl"`)
+		c.Status(http.StatusOK)
+
+		encoder := json.NewEncoder(c.Writer)
+		for {
+			for _, entry := range resp.Entries {
+				if err := encoder.Encode(newAuditEntry(entry)); err != nil {
+					pkg.Logger.WithError(err).Warn("Audit log export aborted by the client")
+					return
+				}
+			}
+			c.Writer.Flush()
+
+			if resp.NextPageToken == "" {
+				return
+			}
+			req.PageToken = resp.NextPageToken
+			if resp, err = permissionsClient.QueryAuditLog(c.Request.Context(), req); err != nil {
+				// The status has been sent already, so the export ends short
+				pkg.Logger.WithError(err).Error("Audit log export failed")
+				return
+			}
+		}
+	}
+}
+
+// parseAuditQuery reads the filters and page of an audit log query from the query string.
+func parseAuditQuery(c *gin.Context) (*permission.QueryAuditLogRequest, error) {
+	req := &permission.QueryAuditLogRequest{
+		RequesterId: uint64(c.GetUint("userId")),
+		Role:        c.GetString("role"),
+		SubjectType: c.Query("subject_type"),
+		SubjectId:   c.Query("subject_id"),
+		ObjectId:    c.Query("file_id"),
+		Action:      c.Query("action"),
+		PageToken:   c.Query("page_token"),
+	}
+
+	if value := c.Query("actor_id"); value != "" {
+		actorID, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, pkg.BadRequestError("invalid actor_id")
+		}
+		req.ActorId = actorID
+	}
+	start, err := timeQuery(c, "start")
+	if err != nil {
+		return nil, err
+	}
+	if start != nil {
+		req.StartTime = start.Unix()
+	}
+	end, err := timeQuery(c, "end")
+	if err != nil {
+		return nil, err
+	}
+	if end != nil {
+		req.EndTime = end.Unix()
+	}
+
+	if value := c.Query("page_size"); value != "" {
+		pageSize, err := strconv.Atoi(value)
+		if err != nil || pageSize < 1 {
+			return nil, pkg.BadRequestError("invalid page_size")
+		}
+		req.PageSize = int32(min(pageSize, 1000))
+	}
+
+	return req, nil
+}
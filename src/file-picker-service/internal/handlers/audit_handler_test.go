@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"file-picker-service/internal/pkg"
+	"file-picker-service/proto/generated/permission"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newAuditRouter serves ExportAuditLogHandler on GET /audit/export for user 1 with the given role.
+func newAuditRouter(t *testing.T, role string, entries ...*permission.AuditEntry) (*gin.Engine, func() []*permission.QueryAuditLogRequest) {
+	t.Helper()
+	if pkg.Logger == nil {
+		pkg.InitLogger()
+	}
+	permissionsClient, _, permissions := newTestClients(t)
+	permissions.SetAuditLog(entries...)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/audit/export", func(c *gin.Context) {
+		c.Set("userId", uint(1))
+		c.Set("role", role)
+	}, ExportAuditLogHandler(permissionsClient))
+	return router, permissions.AuditQueries
+}
+
+func TestExportAuditLogStreamsEveryPage(t *testing.T) {
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	var entries []*permission.AuditEntry
+	for i := 1; i <= 5; i++ {
+		entries = append(entries, &permission.AuditEntry{
+			Id:         uint64(i),
+			Time:       base.Add(time.Duration(i) * time.Minute).UnixMilli(),
+			Action:     "check",
+			ActorId:    2,
+			ObjectType: "file",
+			ObjectId:   "f1",
+			Decision:   "allow",
+		})
+	}
+	router, queries := newAuditRouter(t, "admin", entries...)
+
+	req := httptest.NewRequest(http.MethodGet, "/audit/export?file_id=f1&action=check&actor_id=2&start=2026-03-01T00:00:00Z&page_size=2", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the export, got %d: %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/x-ndjson" {
+		t.Errorf("Expected JSON lines, got %q", got)
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="audit-log.jsonl"` {
+		t.Errorf("Expected an attachment, got %q", got)
+	}
+
+	// One JSON object per line, for every entry across the pages
+	var lines []auditEntry
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Expected a JSON object per line, got %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, entry)
+	}
+	if len(lines) != len(entries) {
+		t.Fatalf("Expected %d lines, got %d", len(entries), len(lines))
+	}
+	for i, entry := range lines {
+		want := newAuditEntry(entries[i])
+		if entry != want {
+			t.Errorf("Expected line %d to be %+v, got %+v", i, want, entry)
+		}
+	}
+
+	// Every page is fetched with the same filters
+	requests := queries()
+	if len(requests) != 3 {
+		t.Fatalf("Expected 3 pages to be fetched, got %d", len(requests))
+	}
+	for i, query := range requests {
+		if query.ObjectId != "f1" || query.Action != "check" || query.ActorId != 2 || query.StartTime != base.Add(-12*time.Hour).Unix() || query.PageSize != 2 || query.RequesterId != 1 {
+			t.Errorf("Unexpected query %+v", query)
+		}
+		if wantToken := []string{"", "2", "4"}[i]; query.PageToken != wantToken {
+			t.Errorf("Expected page %d to be fetched with token %q, got %q", i, wantToken, query.PageToken)
+		}
+	}
+}
+
+func TestExportAuditLogReportsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		role  string
+		query string
+		want  int
+	}{
+		{name: "role may not audit", role: "viewer", want: http.StatusForbidden},
+		{name: "invalid actor", role: "admin", query: "?actor_id=me", want: http.StatusBadRequest},
+		{name: "invalid start", role: "admin", query: "?start=yesterday", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, _ := newAuditRouter(t, tt.role, &permission.AuditEntry{Id: 1, Action: "check"})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/audit/export"+tt.query, nil))
+			if w.Code != tt.want {
+				t.Errorf("Expected %d, got %d: %s", tt.want, w.Code, w.Body)
+			}
+			if w.Header().Get("Content-Disposition") != "" {
+				t.Error("Expected no export to be started")
+			}
+		})
+	}
+}
@@ -3,20 +3,22 @@ package handlers
 import (
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"time"
 
+	"file-picker-service/internal/models"
+	"file-picker-service/internal/pkg"
 	"file-picker-service/internal/services"
 
 	"github.com/gin-gonic/gin"
 )
 
 // FileUploadHandler handles file uploads from the user.
-func FileUploadHandler(permissionsClient *services.PermissionClient) gin.HandlerFunc {
+func FileUploadHandler(fileService *services.FileService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Extract user ID and other fields from JWT token (assumed handled by middleware/Istio)
+		// User ID and role are set from the verified JWT by the auth middleware
 		userID := c.GetUint("userId")
 
-		fileId := c.Param("id")
-
 		// Handle file upload
 		file, err := c.FormFile("file")
 		if err != nil {
@@ -38,36 +40,125 @@ func FileUploadHandler(permissionsClient *services.PermissionClient) gin.Handler
 			return
 		}
 
-		(&services.FileService{}).SaveFile(fileId, userID, file.Filename, data)
+		// Optional picker folder to place the file in
+		folderID := c.PostForm("folder_id")
+
+		saved, err := fileService.SaveFile(c.Request.Context(), userID, c.GetString("role"), folderID, file.Filename, data)
+		if err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
 
-		c.JSON(http.StatusOK, gin.H{"msg": "File uploaded successfully"})
+		c.JSON(http.StatusOK, gin.H{"msg": "File uploaded successfully", "file_id": saved.ID})
 	}
 }
 
-// ListFilesHandler lists the files that the user has access to.
-func ListFilesHandler(permissionsClient *services.PermissionClient) gin.HandlerFunc {
+// Page sizes for file listings.
+const (
+	defaultFilePageSize = 50
+	maxFilePageSize     = 200
+)
+
+// ListFilesHandler lists a page of the files that the user has access to. The listing can be
+// filtered with name_prefix, mime_type, owner_id, created_after and created_before (RFC 3339),
+// sorted with sort (name, created_at or updated_at) and order (asc or desc), and paged with page
+// and page_size.
+func ListFilesHandler(fileService *services.FileService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Extract user ID from JWT token
 		userID := c.GetUint("userId")
 
-		files, error := (&services.FileService{}).ListFiles(userID)
-		if error != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"msg": "Failed to list files"})
+		query, err := parseFileQuery(c)
+		if err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
+
+		files, total, err := fileService.ListFiles(c.Request.Context(), userID, c.GetString("role"), c.ClientIP(), query)
+		if err != nil {
+			pkg.HandleError(c, err)
 			return
 		}
-		
-		c.JSON(http.StatusOK, gin.H{"files": files})
+
+		c.JSON(http.StatusOK, gin.H{"files": files, "total": total, "page": query.Page, "page_size": query.PageSize})
 	}
 }
 
+// parseFileQuery reads the filters, sort order and page of a file listing from the query string.
+func parseFileQuery(c *gin.Context) (models.FileQuery, error) {
+	query := models.FileQuery{
+		NamePrefix: c.Query("name_prefix"),
+		MimeType:   c.Query("mime_type"),
+		SortBy:     c.DefaultQuery("sort", "name"),
+		Page:       1,
+		PageSize:   defaultFilePageSize,
+	}
+
+	if _, ok := models.FileSortFields[query.SortBy]; !ok {
+		return query, pkg.BadRequestError("sort must be one of name, created_at or updated_at")
+	}
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return query, pkg.BadRequestError("order must be asc or desc")
+	}
+
+	if value := c.Query("owner_id"); value != "" {
+		ownerID, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return query, pkg.BadRequestError("invalid owner_id")
+		}
+		query.OwnerID = uint(ownerID)
+	}
+	var err error
+	if query.CreatedAfter, err = timeQuery(c, "created_after"); err != nil {
+		return query, err
+	}
+	if query.CreatedBefore, err = timeQuery(c, "created_before"); err != nil {
+		return query, err
+	}
+
+	if value := c.Query("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return query, pkg.BadRequestError("invalid page")
+		}
+		query.Page = page
+	}
+	if value := c.Query("page_size"); value != "" {
+		pageSize, err := strconv.Atoi(value)
+		if err != nil || pageSize < 1 {
+			return query, pkg.BadRequestError("invalid page_size")
+		}
+		query.PageSize = min(pageSize, maxFilePageSize)
+	}
+
+	return query, nil
+}
+
+// timeQuery parses an optional RFC 3339 time from the query string.
+func timeQuery(c *gin.Context, name string) (*time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, pkg.BadRequestError("invalid " + name + ", expected an RFC 3339 time")
+	}
+	return &t, nil
+}
+
 type FileDownloadRequest struct {
 	FileID    string `json:"file_id"`
 	Provider  string `json:"provider"`  // e.g., "google_drive", "dropbox"
 	AuthToken string `json:"auth_token"`
 }
 
-// FileDownloadHandler handles requests to download a file from cloud storage.
-func FileDownloadHandler(permissionsClient *services.PermissionClient, downloaderClient *services.DownloaderClient) gin.HandlerFunc {
+// FileDownloadHandler handles requests to import a file from cloud storage into the user's files.
+func FileDownloadHandler(fileService *services.FileService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req FileDownloadRequest
 
@@ -79,25 +170,55 @@ func FileDownloadHandler(permissionsClient *services.PermissionClient, downloade
 			return
 		}
 
-		// Call the service to download the file
-		filePath, err := (&services.FileService{}).DownloadFile(userID, req.FileID, req.Provider, req.AuthToken)
+		// Call the service to download and register the file
+		file, err := fileService.DownloadFile(c.Request.Context(), userID, c.GetString("role"), req.FileID, req.Provider, req.AuthToken)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		// Respond with the local file path where the file was saved
-		c.JSON(http.StatusOK, gin.H{"file_path": filePath})
+		// Respond with the catalog entry created for the imported file
+		c.JSON(http.StatusOK, gin.H{"file_id": file.ID, "file_path": file.FilePath, "revision": file.RemoteRevision})
 	}
 }
 
-// AddPermissionsHandler allows file owners to share files with another user.
+// FileExportHandler handles requests to export a stored file to the user's cloud storage.
+func FileExportHandler(fileService *services.FileService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Provider  string `json:"provider"`   // e.g., "google_drive", "dropbox"
+			AuthToken string `json:"auth_token"` // Access token for the user's cloud account
+			FolderID  string `json:"folder_id"`  // Destination folder ID (Drive) or path (Dropbox)
+			FileName  string `json:"file_name"`  // Optional name for the exported file
+		}
+
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		userID := c.GetUint("userId")
+
+		export, err := fileService.ExportFile(c.Request.Context(), userID, c.GetString("role"), c.ClientIP(), c.Param("id"), req.Provider, req.AuthToken, req.FolderID, req.FileName)
+		if err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"remote_id": export.RemoteId, "file_name": export.FileName, "revision": export.Revision, "file_size": export.FileSize})
+	}
+}
+
+// AddPermissionsHandler allows file owners to share files with another user or a group.
 func AddPermissionsHandler(permissionsClient *services.PermissionClient) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
-			SharedUserID uint64   `json:"shared_user_id"` // ID of the user to share the file with
-			FileIDs      []string `json:"file_ids"`       // List of file IDs to share
-			Permissions  []string `json:"permissions"`    // List of permissions (read, write, delete)
+			SharedUserID  uint64     `json:"shared_user_id"`  // ID of the user to share the file with
+			SharedGroupID uint64     `json:"shared_group_id"` // ID of the group to share the file with, instead of a user
+			FileIDs       []string   `json:"file_ids"`        // List of file IDs to share
+			Permissions   []string   `json:"permissions"`     // List of permissions (read, write, delete)
+			ExpiresAt     *time.Time `json:"expires_at"`      // Optional RFC 3339 time at which the share expires
+			Condition     string     `json:"condition"`       // Optional condition, e.g. "ip in 10.0.0.0/8"
 		}
 
 		// Parse the request body
@@ -106,15 +227,69 @@ func AddPermissionsHandler(permissionsClient *services.PermissionClient) gin.Han
 			return
 		}
 
-		// Extract owner ID from JWT (assuming user_id is available via middleware/Istio)
+		// Extract owner ID from the verified JWT
 		ownerID := c.GetUint("userId")
 
-		// Use the FileService to add permissions for the shared user
-		if err := (&services.FileService{}).ShareFilePermissions(uint64(ownerID), req.SharedUserID, req.FileIDs, req.Permissions); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		opts := services.ShareOptions{ExpiresAt: req.ExpiresAt, Condition: req.Condition}
+
+		// Members of the group, including those of nested groups, gain access to the files
+		if req.SharedGroupID != 0 {
+			if err := permissionsClient.ShareFileWithGroup(c.Request.Context(), uint64(ownerID), req.SharedGroupID, req.FileIDs, req.Permissions, opts); err != nil {
+				pkg.HandleError(c, err)
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"message": "Permissions updated successfully"})
+			return
+		}
+
+		// Add permissions for the shared user
+		if err := permissionsClient.ShareFilePermissions(c.Request.Context(), uint64(ownerID), req.SharedUserID, req.FileIDs, req.Permissions, opts); err != nil {
+			pkg.HandleError(c, err)
 			return
 		}
 
 		c.JSON(http.StatusOK, gin.H{"message": "Permissions updated successfully"})
 	}
-}
\ No newline at end of file
+}
+
+// RevokePermissionsHandler allows file owners to revoke a user's access to a file. Add type=group
+// to revoke a group's access instead.
+func RevokePermissionsHandler(permissionsClient *services.PermissionClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		subjectID, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		ownerID := c.GetUint("userId")
+
+		userID, groupID := subjectID, uint64(0)
+		if c.Query("type") == "group" {
+			userID, groupID = 0, subjectID
+		}
+
+		if err := permissionsClient.RevokePermission(c.Request.Context(), uint64(ownerID), c.Param("id"), userID, groupID); err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Permission revoked successfully"})
+	}
+}
+
+// ListFilePermissionsHandler lists who has access to a file the user owns.
+func ListFilePermissionsHandler(permissionsClient *services.PermissionClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ownerID := c.GetUint("userId")
+
+		grants, err := permissionsClient.ListFilePermissions(c.Request.Context(), uint64(ownerID), c.Param("id"))
+		if err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"permissions": grants})
+	}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"file-picker-service/internal/models"
+	"file-picker-service/internal/services"
+	"file-picker-service/internal/testutil"
+	"file-picker-service/proto/generated/notification"
+	"file-picker-service/proto/generated/permission"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+
+	"shared/authn"
+)
+
+// newTestClients connects a PermissionClient and a NotificationClient to fake Permission and
+// Notification services. Calls made outside a request carry the service token "picker-key".
+func newTestClients(t *testing.T) (*services.PermissionClient, *services.NotificationClient, *testutil.Permissions) {
+	t.Helper()
+	permissions := testutil.NewPermissions()
+	addr := testutil.Serve(t, func(s *grpc.Server) {
+		permission.RegisterPermissionServiceServer(s, permissions)
+		notification.RegisterNotificationServiceServer(s, &testutil.Notifications{})
+	})
+
+	permissionsClient, err := services.NewPermissionClient(addr, "picker-key", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { permissionsClient.Close() })
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return permissionsClient, services.NewNotificationClient(conn), permissions
+}
+
+// newUploadRouter serves FileUploadHandler on POST /upload for user 7, signed in with the access
+// token "user-token", storing uploads in uploadDir.
+func newUploadRouter(t *testing.T, uploadDir string) (*gin.Engine, *testutil.Permissions) {
+	t.Helper()
+	permissionsClient, notificationClient, permissions := newTestClients(t)
+	fileService := services.NewFileService(*permissionsClient, services.DownloaderClient{}, services.TransformationClient{}, *notificationClient, uploadDir)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/upload", func(c *gin.Context) {
+		c.Set("userId", uint(7))
+		c.Set("role", "editor")
+		c.Request = c.Request.WithContext(authn.NewTokenContext(c.Request.Context(), "user-token"))
+	}, FileUploadHandler(fileService))
+	return router, permissions
+}
+
+// upload posts a file to the router and returns the ID of the file created.
+func upload(t *testing.T, router *gin.Engine, fileName string, content []byte) string {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the upload to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		FileID string `json:"file_id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.FileID
+}
+
+func TestUploadCreatesNewFileEachTime(t *testing.T) {
+	database := testutil.OpenDB(t)
+	uploadDir := t.TempDir()
+	router, permissions := newUploadRouter(t, uploadDir)
+
+	// Two uploads of the same name are two files, neither overwriting the other
+	contents := [][]byte{[]byte("first"), []byte("second")}
+	var ids []string
+	for _, content := range contents {
+		ids = append(ids, upload(t, router, "report.txt", content))
+	}
+	if ids[0] == "" || ids[1] == "" || ids[0] == ids[1] {
+		t.Fatalf("Expected two distinct file IDs, got %q", ids)
+	}
+
+	for i, id := range ids {
+		var file models.File
+		if err := database.First(&file, "id = ?", id).Error; err != nil {
+			t.Fatalf("Expected file %s to be saved: %v", id, err)
+		}
+		if file.FilePath != filepath.Join(uploadDir, id) || file.FileName != "report.txt" || file.OwnerID != 7 {
+			t.Errorf("Unexpected file %+v", file)
+		}
+		content, err := os.ReadFile(file.FilePath)
+		if err != nil || !bytes.Equal(content, contents[i]) {
+			t.Errorf("Expected file %s to hold %q, got %q (%v)", id, contents[i], content, err)
+		}
+	}
+
+	// The picker, not the uploader, makes the uploader owner of each file
+	updates := permissions.Updates()
+	if len(updates) != 2 {
+		t.Fatalf("Expected 2 owner grants, got %d", len(updates))
+	}
+	for i, update := range updates {
+		if !update.IsOwner || update.OwnerId != 7 || len(update.FileIds) != 1 || update.FileIds[0] != ids[i] {
+			t.Errorf("Unexpected grant %+v for file %s", update, ids[i])
+		}
+	}
+	for _, token := range permissions.UpdateTokens() {
+		if token != "Bearer picker-key" {
+			t.Errorf("Expected owner grants to carry the service token, got %q", token)
+		}
+	}
+}
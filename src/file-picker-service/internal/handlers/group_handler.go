@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"file-picker-service/internal/pkg"
+	"file-picker-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateGroupHandler creates a group owned by the user, who becomes its first member.
+func CreateGroupHandler(permissionsClient *services.PermissionClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Name string `json:"name"` // Display name of the group
+		}
+
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		userID := c.GetUint("userId")
+
+		group, err := permissionsClient.CreateGroup(c.Request.Context(), uint64(userID), req.Name)
+		if err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"group": group})
+	}
+}
+
+// AddGroupMemberHandler adds a user or a nested group to a group the user owns.
+func AddGroupMemberHandler(permissionsClient *services.PermissionClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		groupID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+			return
+		}
+
+		var req struct {
+			UserID  uint64 `json:"user_id"`  // A user to add
+			GroupID uint64 `json:"group_id"` // A group whose members are added, instead of a user
+		}
+
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		userID := c.GetUint("userId")
+
+		if err := permissionsClient.AddGroupMember(c.Request.Context(), uint64(userID), groupID, req.UserID, req.GroupID); err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Group member added successfully"})
+	}
+}
+
+// RemoveGroupMemberHandler removes a user, or a nested group when type=group is given, from a
+// group the user owns.
+func RemoveGroupMemberHandler(permissionsClient *services.PermissionClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		groupID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+			return
+		}
+
+		memberID, err := strconv.ParseUint(c.Param("memberId"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid member ID"})
+			return
+		}
+
+		userID := c.GetUint("userId")
+
+		memberUserID, memberGroupID := memberID, uint64(0)
+		if c.Query("type") == "group" {
+			memberUserID, memberGroupID = 0, memberID
+		}
+
+		if err := permissionsClient.RemoveGroupMember(c.Request.Context(), uint64(userID), groupID, memberUserID, memberGroupID); err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Group member removed successfully"})
+	}
+}
+
+// ListGroupMembersHandler lists the users and nested groups directly in a group.
+func ListGroupMembersHandler(permissionsClient *services.PermissionClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		groupID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+			return
+		}
+
+		userID := c.GetUint("userId")
+
+		members, err := permissionsClient.ListGroupMembers(c.Request.Context(), uint64(userID), groupID)
+		if err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"user_ids": members.UserIds, "group_ids": members.GroupIds})
+	}
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+
+	"file-picker-service/internal/models"
+	"file-picker-service/internal/pkg"
+	"file-picker-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sharePasswordHeader carries the password of a password-protected share link. A header keeps
+// the password out of URLs and access logs.
+const sharePasswordHeader = "X-Share-Password"
+
+// CreateShareLinkHandler creates a link giving anyone who holds it access to a file or folder.
+func CreateShareLinkHandler(shareLinkService *services.ShareLinkService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req services.ShareLinkRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		ownerID := c.GetUint("userId")
+
+		link, token, err := shareLinkService.CreateLink(c.Request.Context(), ownerID, req)
+		if err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
+
+		// The token is only ever returned here
+		c.JSON(http.StatusCreated, gin.H{"link": link, "token": token, "url": "/s/" + token})
+	}
+}
+
+// ListShareLinksHandler lists the share links the user created.
+func ListShareLinksHandler(shareLinkService *services.ShareLinkService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ownerID := c.GetUint("userId")
+
+		links, err := shareLinkService.ListLinks(ownerID)
+		if err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"links": links})
+	}
+}
+
+// RevokeShareLinkHandler deletes a share link the user created.
+func RevokeShareLinkHandler(shareLinkService *services.ShareLinkService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		linkID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share link ID"})
+			return
+		}
+
+		ownerID := c.GetUint("userId")
+
+		if err := shareLinkService.RevokeLink(ownerID, uint(linkID)); err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Share link revoked successfully"})
+	}
+}
+
+// OpenShareLinkHandler serves a share link without authentication. File links stream the file;
+// folder links list the files that can be fetched with ShareLinkFileHandler.
+func OpenShareLinkHandler(shareLinkService *services.ShareLinkService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		link, err := shareLinkService.OpenLink(c.Param("token"), c.GetHeader(sharePasswordHeader), c.ClientIP())
+		if err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
+
+		if link.FolderID == "" {
+			serveShareLinkFile(c, shareLinkService, link, "")
+			return
+		}
+
+		files, err := shareLinkService.LinkFiles(c.Request.Context(), link)
+		if err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
+
+		listing := make([]gin.H, len(files))
+		for i, file := range files {
+			listing[i] = gin.H{"id": file.ID, "file_name": file.FileName, "mime_type": file.MimeType}
+		}
+		c.JSON(http.StatusOK, gin.H{"folder_id": link.FolderID, "files": listing})
+	}
+}
+
+// ShareLinkFileHandler serves one file of a folder share link without authentication.
+func ShareLinkFileHandler(shareLinkService *services.ShareLinkService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		link, err := shareLinkService.OpenLink(c.Param("token"), c.GetHeader(sharePasswordHeader), c.ClientIP())
+		if err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
+
+		serveShareLinkFile(c, shareLinkService, link, c.Param("fileId"))
+	}
+}
+
+// serveShareLinkFile streams a file served through a share link. Read links show the file inline
+// and download links send it as an attachment.
+func serveShareLinkFile(c *gin.Context, shareLinkService *services.ShareLinkService, link *models.ShareLink, fileID string) {
+	file, err := shareLinkService.OpenLinkFile(c.Request.Context(), link, fileID)
+	if err != nil {
+		pkg.HandleError(c, err)
+		return
+	}
+
+	disposition := "inline"
+	if link.Permission == models.ShareLinkDownload {
+		disposition = "attachment"
+	}
+	streamFile(c, file, disposition)
+}
+
+// inlineContentTypes are the types a read link shows in the browser. Anything else, such as HTML
+// or SVG, could run script on the picker's origin and is sent as an attachment.
+var inlineContentTypes = map[string]bool{
+	"text/plain": true,
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+	"audio/mpeg": true,
+	"audio/ogg":  true,
+	"video/mp4":  true,
+	"video/webm": true,
+}
+
+// streamFile sends a stored file to the client without reading it into memory. Files whose type
+// is not in inlineContentTypes are always sent as an opaque attachment, and the browser is told
+// neither to guess the type nor to run anything in the response.
+func streamFile(c *gin.Context, file *models.File, disposition string) {
+	content, err := os.Open(file.FilePath)
+	if err != nil {
+		pkg.HandleError(c, pkg.NotFoundError("file content not found"))
+		return
+	}
+	defer content.Close()
+
+	info, err := content.Stat()
+	if err != nil {
+		pkg.HandleError(c, err)
+		return
+	}
+
+	contentType := file.MimeType
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || !inlineContentTypes[mediaType] {
+		contentType = "application/octet-stream"
+		disposition = "attachment"
+	}
+
+	c.DataFromReader(http.StatusOK, info.Size(), contentType, content, map[string]string{
+		"Content-Disposition":     mime.FormatMediaType(disposition, map[string]string{"filename": file.FileName}),
+		"X-Content-Type-Options":  "nosniff",
+		"Content-Security-Policy": "sandbox",
+	})
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"file-picker-service/internal/models"
+	"file-picker-service/internal/services"
+	"file-picker-service/internal/testutil"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newShareLinkRouter serves the share link routes, and creates a read link to a stored file of
+// user 7 with the given name and content.
+func newShareLinkRouter(t *testing.T, fileName, content, password string) (*gin.Engine, string) {
+	t.Helper()
+	database := testutil.OpenDB(t)
+	permissionsClient, _, _ := newTestClients(t)
+	shareLinkService := services.NewShareLinkService(*permissionsClient)
+
+	file := &models.File{ID: "file-1", FileName: fileName, OwnerID: 7, FilePath: filepath.Join(t.TempDir(), "file-1")}
+	if err := os.WriteFile(file.FilePath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.SaveFileMetadata(database, file); err != nil {
+		t.Fatal(err)
+	}
+	_, token, err := shareLinkService.CreateLink(context.Background(), 7, services.ShareLinkRequest{FileID: file.ID, Password: password})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	if err := router.SetTrustedProxies(nil); err != nil {
+		t.Fatal(err)
+	}
+	router.GET("/s/:token", OpenShareLinkHandler(shareLinkService))
+	return router, token
+}
+
+// openLink opens a share link from a client IP with a password.
+func openLink(router *gin.Engine, token, clientIP, password string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/s/"+token, nil)
+	req.RemoteAddr = clientIP + ":40000"
+	// Ignored, as the request does not come from a trusted proxy
+	req.Header.Set("X-Forwarded-For", "192.0.2.99")
+	if password != "" {
+		req.Header.Set(sharePasswordHeader, password)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestShareLinkServesActiveContentAsAttachment(t *testing.T) {
+	tests := []struct {
+		fileName    string
+		contentType string
+		disposition string
+	}{
+		{"page.html", "application/octet-stream", `attachment; filename=page.html`},
+		{"logo.svg", "application/octet-stream", `attachment; filename=logo.svg`},
+		{"photo.png", "image/png", `inline; filename=photo.png`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fileName, func(t *testing.T) {
+			router, token := newShareLinkRouter(t, tt.fileName, "<script>alert(1)</script>", "")
+
+			w := openLink(router, token, "10.0.0.1", "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected the file, got %d: %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Expected Content-Type %q, got %q", tt.contentType, got)
+			}
+			if got := w.Header().Get("Content-Disposition"); got != tt.disposition {
+				t.Errorf("Expected Content-Disposition %q, got %q", tt.disposition, got)
+			}
+			if w.Header().Get("X-Content-Type-Options") != "nosniff" || w.Header().Get("Content-Security-Policy") != "sandbox" {
+				t.Errorf("Expected nosniff and a sandbox CSP, got %v", w.Header())
+			}
+		})
+	}
+}
+
+func TestShareLinkPasswordLockoutIsPerClient(t *testing.T) {
+	router, token := newShareLinkRouter(t, "notes.txt", "hello", "open-sesame")
+
+	for i := 0; i < 5; i++ {
+		if w := openLink(router, token, "10.0.0.1", "guess"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected a wrong password to be refused, got %d", w.Code)
+		}
+	}
+	if w := openLink(router, token, "10.0.0.1", "open-sesame"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the guessing client to be locked out, got %d", w.Code)
+	}
+
+	// Other recipients still open the link
+	if w := openLink(router, token, "10.0.0.2", "open-sesame"); w.Code != http.StatusOK || w.Body.String() != "hello" {
+		t.Errorf("Expected another client to open the link, got %d: %s", w.Code, w.Body)
+	}
+}
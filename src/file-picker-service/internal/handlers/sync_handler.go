@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"file-picker-service/internal/pkg"
+	"file-picker-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateSyncBindingHandler binds one of the user's picker folders to a cloud folder.
+func CreateSyncBindingHandler(syncService *services.SyncService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			FolderID       string `json:"folder_id"`        // Picker folder to sync
+			Provider       string `json:"provider"`         // e.g., "google_drive", "dropbox"
+			RemoteFolderID string `json:"remote_folder_id"` // Folder ID (Drive) or path (Dropbox)
+			AuthToken      string `json:"auth_token"`       // Access token for the cloud provider
+		}
+
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		userID := c.GetUint("userId")
+
+		binding, err := syncService.CreateBinding(c.Request.Context(), userID, c.GetString("role"), req.FolderID, req.Provider, req.RemoteFolderID, req.AuthToken)
+		if err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"sync": binding})
+	}
+}
+
+// SyncStatusHandler reports the state of a folder binding.
+func SyncStatusHandler(syncService *services.SyncService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bindingID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sync ID"})
+			return
+		}
+
+		userID := c.GetUint("userId")
+
+		binding, err := syncService.GetBinding(userID, uint(bindingID))
+		if err != nil {
+			pkg.HandleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"sync": binding})
+	}
+}
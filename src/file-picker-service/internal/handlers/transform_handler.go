@@ -23,7 +23,7 @@ func FileTransformationHandler(permissionsClient *services.PermissionClient, tra
 
 
 		// Check if user has permission to transform the file
-		hasPermission, err := permissionsClient.CheckPermission(userID, "transform", fileID)
+		hasPermission, err := permissionsClient.CheckPermissionAs(c.Request.Context(), userID, c.GetString("role"), "transform", fileID, c.ClientIP())
 		if err != nil || !hasPermission {
 			c.JSON(http.StatusForbidden, gin.H{"msg": "You do not have permission to transform this file"})
 			return
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"file-picker-service/internal/pkg"
+	"file-picker-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Headers of the user event webhook sent by the auth-service.
+const (
+	eventSignatureHeader = "X-Event-Signature"
+	eventTimestampHeader = "X-Event-Timestamp"
+)
+
+// maxEventSkew is how old or how far in the future a webhook timestamp may be.
+const maxEventSkew = 5 * time.Minute
+
+// userEvent is a change to a user account published by the auth-service.
+type userEvent struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"` // "user.deleted"
+	UserID    uint   `json:"user_id"`
+	ActorID   uint64 `json:"actor_id"`   // The admin who made the change
+	ActorRole string `json:"actor_role"` // The admin's role
+}
+
+// UserEventHandler receives the user events the auth-service delivers by webhook. Requests must be
+// signed with the shared secret: the X-Event-Signature header holds "sha256=" and the hex HMAC-SHA256
+// of the X-Event-Timestamp header, a dot and the body. A deleted user's data is purged; other event
+// types are acknowledged and ignored. Failures are answered with 5xx so that the event is retried.
+func UserEventHandler(cleanupService *services.UserCleanupService, secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+		if err != nil {
+			pkg.HandleError(c, pkg.BadRequestError("Failed to read event"))
+			return
+		}
+		if !validEventSignature(secret, c.GetHeader(eventTimestampHeader), c.GetHeader(eventSignatureHeader), body, time.Now()) {
+			pkg.HandleError(c, pkg.NewAPIError(http.StatusUnauthorized, "Invalid event signature"))
+			return
+		}
+
+		var event userEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			pkg.HandleError(c, pkg.BadRequestError("Invalid event"))
+			return
+		}
+
+		switch event.Type {
+		case "user.deleted":
+			if event.UserID == 0 {
+				pkg.HandleError(c, pkg.BadRequestError("Invalid event"))
+				return
+			}
+			if err := cleanupService.PurgeUser(c.Request.Context(), event.ActorID, event.ActorRole, event.UserID); err != nil {
+				pkg.HandleError(c, err)
+				return
+			}
+		default:
+			pkg.Logger.Infof("Ignoring user event %s of type %s", event.ID, event.Type)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Event processed"})
+	}
+}
+
+// validEventSignature checks the signature of a webhook request and that it was signed recently.
+func validEventSignature(secret, timestamp, signature string, body []byte, now time.Time) bool {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := now.Sub(time.Unix(unix, 0)); skew > maxEventSkew || skew < -maxEventSkew {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
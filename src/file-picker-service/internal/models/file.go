@@ -1,44 +1,250 @@
 package models
 
 import (
+	"mime"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // File represents the metadata of an uploaded file.
 type File struct {
-	ID        string      `gorm:"primaryKey"`
-	FileName  string    `gorm:"not null"`
-	FilePath  string    `gorm:"not null"`
-	OwnerID   uint      `gorm:"not null"`  // ID of the user who owns the file
-	IsShared  bool      `gorm:"default:false"`
-	CreatedAt time.Time `gorm:"autoCreateTime"`
-	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+	ID             string    `gorm:"primaryKey"`
+	FileName       string    `gorm:"not null"`
+	FilePath       string    `gorm:"not null"`
+	OwnerID        uint      `gorm:"not null"`  // ID of the user who owns the file
+	IsShared       bool      `gorm:"default:false"`
+	MimeType       string    `gorm:"index"` // Derived from the file name when the file is stored
+	FolderID       string    `gorm:"index"` // Picker folder the file belongs to (empty for the root)
+	Provider       string    `gorm:"index:idx_file_remote"` // Cloud provider the file was imported from (empty for uploads)
+	RemoteID       string    `gorm:"index:idx_file_remote"` // File ID in the cloud provider
+	RemoteRevision string    // Provider revision of the imported content
+	SyncedAt       time.Time // Last time the file was reconciled with a synced cloud folder
+	CreatedAt      time.Time `gorm:"autoCreateTime"`
+	UpdatedAt      time.Time `gorm:"autoUpdateTime"`
 }
 
-// SaveFileMetadata stores the metadata of a newly uploaded file in the database.
-func SaveFileMetadata(db *gorm.DB, fileId string, fileName string, ownerID uint) error {
-	file := File{
-		ID: fileId,
-		FileName: fileName,
-		FilePath: "./uploads/" + fileName,  // Assuming this is where files are saved
-		OwnerID:  ownerID,
-		IsShared: false,  // Initially, the file is not shared
+// SaveFileMetadata stores the metadata of a newly uploaded or imported file in the database.
+func SaveFileMetadata(db *gorm.DB, file *File) error {
+	if file.FilePath == "" {
+		file.FilePath = "./uploads/" + file.FileName // Assuming this is where uploaded files are saved
 	}
+	file.IsShared = false // Initially, the file is not shared
+	if file.MimeType == "" {
+		file.MimeType = mimeTypeOf(file.FileName)
+	}
+
+	return db.Create(file).Error
+}
+
+// mimeTypeOf derives the MIME type of a file from its name, without parameters such as charset so
+// that the type can be filtered on exactly.
+func mimeTypeOf(fileName string) string {
+	mimeType, _, _ := strings.Cut(mime.TypeByExtension(filepath.Ext(fileName)), ";")
+	return mimeType
+}
+
+// BackfillMimeTypes derives the MIME type of the files stored before types were recorded.
+func BackfillMimeTypes(db *gorm.DB) error {
+	var files []File
+	return db.Select("id", "file_name").Where("mime_type IS NULL OR mime_type = ''").
+		FindInBatches(&files, 500, func(tx *gorm.DB, batch int) error {
+			for _, file := range files {
+				if mimeType := mimeTypeOf(file.FileName); mimeType != "" {
+					if err := db.Model(&File{}).Where("id = ?", file.ID).Update("mime_type", mimeType).Error; err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}).Error
+}
+
+// GetFile retrieves a file's metadata by ID.
+func GetFile(db *gorm.DB, fileID string) (*File, error) {
+	var file File
+	err := db.Where("id = ?", fileID).First(&file).Error
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// GetImportedFile looks up a file the owner previously imported from a cloud provider.
+func GetImportedFile(db *gorm.DB, ownerID uint, provider string, remoteID string) (*File, error) {
+	var file File
+	err := db.Where("owner_id = ? AND provider = ? AND remote_id = ?", ownerID, provider, remoteID).First(&file).Error
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
 
-	return db.Create(&file).Error
+// UpdateImportedRevision records a newer revision of an already imported file.
+func UpdateImportedRevision(db *gorm.DB, fileID string, filePath string, revision string) error {
+	return db.Model(&File{}).Where("id = ?", fileID).Updates(map[string]interface{}{
+		"file_path":       filePath,
+		"remote_revision": revision,
+	}).Error
 }
 
-// ListFilesByUser lists all files that belong to or are shared with the user.
-func ListFilesByUser(db *gorm.DB, userID uint) ([]File, error) {
+// ListFilesInFolder lists the files an owner keeps in a picker folder.
+func ListFilesInFolder(db *gorm.DB, ownerID uint, folderID string) ([]File, error) {
 	var files []File
-	err := db.Where("owner_id = ? OR is_shared = true", userID).Find(&files).Error
+	err := db.Where("owner_id = ? AND folder_id = ?", ownerID, folderID).Find(&files).Error
 	return files, err
 }
 
+// MarkFileSynced records that a file matches the given remote revision as of syncedAt.
+// The update timestamp is pinned to syncedAt so the file does not look locally modified.
+func MarkFileSynced(db *gorm.DB, fileID string, provider string, remoteID string, revision string, syncedAt time.Time) error {
+	return db.Model(&File{}).Where("id = ?", fileID).Updates(map[string]interface{}{
+		"provider":        provider,
+		"remote_id":       remoteID,
+		"remote_revision": revision,
+		"synced_at":       syncedAt,
+		"updated_at":      syncedAt,
+	}).Error
+}
+
+// RebaseFile points a locally modified file at a different remote file or revision without
+// touching its timestamps, so the local edit is still uploaded on the next reconciliation.
+func RebaseFile(db *gorm.DB, fileID string, fileName string, remoteID string, revision string) error {
+	return db.Model(&File{}).Where("id = ?", fileID).UpdateColumns(map[string]interface{}{
+		"file_name":       fileName,
+		"remote_id":       remoteID,
+		"remote_revision": revision,
+	}).Error
+}
+
+// IsModifiedSinceSync reports whether a file changed locally after it was last reconciled.
+func (f *File) IsModifiedSinceSync() bool {
+	return f.RemoteRevision == "" || f.UpdatedAt.After(f.SyncedAt)
+}
+
+// DeleteFile removes a file from the catalog.
+func DeleteFile(db *gorm.DB, fileID string) error {
+	return db.Where("id = ?", fileID).Delete(&File{}).Error
+}
+
+// ListOwnedFiles retrieves every file owned by the user.
+func ListOwnedFiles(db *gorm.DB, ownerID uint) ([]File, error) {
+	var files []File
+	err := db.Where("owner_id = ?", ownerID).Find(&files).Error
+	return files, err
+}
+
+// DeleteOwnedFiles removes every file owned by the user from the catalog.
+func DeleteOwnedFiles(db *gorm.DB, ownerID uint) error {
+	return db.Where("owner_id = ?", ownerID).Delete(&File{}).Error
+}
+
+// FileSortFields maps the fields a file listing can be sorted by to their columns.
+var FileSortFields = map[string]string{
+	"name":       "file_name",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+}
+
+// FileQuery filters, sorts and pages a file listing. Zero-valued filters are not applied.
+type FileQuery struct {
+	NamePrefix    string
+	MimeType      string // Matched exactly, e.g. "application/pdf"
+	OwnerID       uint
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	SortBy        string // A key of FileSortFields, "name" by default
+	Descending    bool
+	Page          int // 1-based
+	PageSize      int
+}
+
+// likeEscaper escapes the LIKE wildcards in a literal pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// maxInlineFileIDs is the most file IDs ListFilesByID sends as query parameters. Longer lists,
+// which would exceed the database's limit on bind parameters, are joined through a temporary table.
+const maxInlineFileIDs = 1000
+
+// ListFilesByID lists the page of files among fileIDs matching the query, along with the number
+// of matching files across all pages.
+func ListFilesByID(db *gorm.DB, fileIDs []string, query FileQuery) ([]File, int64, error) {
+	if len(fileIDs) <= maxInlineFileIDs {
+		return listFiles(db.Model(&File{}).Where("id IN ?", fileIDs), query)
+	}
+
+	var files []File
+	var total int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("CREATE TEMPORARY TABLE listed_file_ids (id text PRIMARY KEY) ON COMMIT DROP").Error; err != nil {
+			return err
+		}
+		rows := make([]map[string]interface{}, len(fileIDs))
+		for i, id := range fileIDs {
+			rows[i] = map[string]interface{}{"id": id}
+		}
+		err := tx.Table("listed_file_ids").Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(rows, maxInlineFileIDs).Error
+		if err != nil {
+			return err
+		}
+
+		files, total, err = listFiles(tx.Model(&File{}).Where("id IN (SELECT id FROM listed_file_ids)"), query)
+		return err
+	})
+	return files, total, err
+}
+
+// ListAllFiles lists the page of files in the catalog matching the query, along with the number
+// of matching files across all pages.
+func ListAllFiles(db *gorm.DB, query FileQuery) ([]File, int64, error) {
+	return listFiles(db.Model(&File{}), query)
+}
+
+// listFiles applies a query's filters, sort order and page to tx.
+func listFiles(tx *gorm.DB, query FileQuery) ([]File, int64, error) {
+	if query.NamePrefix != "" {
+		tx = tx.Where(`file_name LIKE ? ESCAPE '\'`, likeEscaper.Replace(query.NamePrefix)+"%")
+	}
+	if query.MimeType != "" {
+		tx = tx.Where("mime_type = ?", query.MimeType)
+	}
+	if query.OwnerID != 0 {
+		tx = tx.Where("owner_id = ?", query.OwnerID)
+	}
+	if query.CreatedAfter != nil {
+		tx = tx.Where("created_at >= ?", *query.CreatedAfter)
+	}
+	if query.CreatedBefore != nil {
+		tx = tx.Where("created_at < ?", *query.CreatedBefore)
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	column, ok := FileSortFields[query.SortBy]
+	if !ok {
+		column = FileSortFields["name"]
+	}
+	direction := " ASC"
+	if query.Descending {
+		direction = " DESC"
+	}
+
+	var files []File
+	err := tx.Order(column + direction).Order("id").
+		Offset((query.Page - 1) * query.PageSize).
+		Limit(query.PageSize).
+		Find(&files).Error
+	return files, total, err
+}
+
 func GetFilePath(db *gorm.DB, fileID string) (string, error) {
 	var file File
 	err := db.First(&file, fileID).Error
 	return file.FilePath, err
-}
\ No newline at end of file
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// sealedPrefix marks stored values encrypted with the token key, so that tokens stored in plain
+// text before encryption was introduced can still be read.
+const sealedPrefix = "sealed:v1:"
+
+// tokenCipher encrypts sealed tokens. It is set once at startup by SetTokenKey.
+var tokenCipher cipher.AEAD
+
+// SetTokenKey sets the AES-256 key sealed tokens are encrypted with. It must be called before
+// the database is used.
+func SetTokenKey(key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("token key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	tokenCipher, err = cipher.NewGCM(block)
+	return err
+}
+
+// SealedToken is a credential stored encrypted with AES-GCM. It holds the plain text in memory;
+// only the database column is encrypted.
+type SealedToken string
+
+// Value encrypts the token for storage.
+func (t SealedToken) Value() (driver.Value, error) {
+	if t == "" {
+		return "", nil
+	}
+	if tokenCipher == nil {
+		return nil, errors.New("token key is not set")
+	}
+	nonce := make([]byte, tokenCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := tokenCipher.Seal(nonce, nonce, []byte(t), nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Scan decrypts a stored token. Values without the sealed prefix are read as plain text.
+func (t *SealedToken) Scan(value interface{}) error {
+	var stored string
+	switch v := value.(type) {
+	case nil:
+		*t = ""
+		return nil
+	case string:
+		stored = v
+	case []byte:
+		stored = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into a sealed token", value)
+	}
+
+	if !strings.HasPrefix(stored, sealedPrefix) {
+		*t = SealedToken(stored)
+		return nil
+	}
+	if tokenCipher == nil {
+		return errors.New("token key is not set")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, sealedPrefix))
+	if err != nil || len(sealed) < tokenCipher.NonceSize() {
+		return errors.New("malformed sealed token")
+	}
+	nonce, ciphertext := sealed[:tokenCipher.NonceSize()], sealed[tokenCipher.NonceSize():]
+	plain, err := tokenCipher.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return errors.New("sealed token cannot be decrypted with the token key")
+	}
+	*t = SealedToken(plain)
+	return nil
+}
+
+// SealSyncTokens encrypts the provider tokens of folder bindings stored before tokens were
+// encrypted.
+func SealSyncTokens(db *gorm.DB) error {
+	var bindings []SyncBinding
+	err := db.Select("id", "auth_token").
+		Where("auth_token <> '' AND auth_token NOT LIKE ?", sealedPrefix+"%").
+		Find(&bindings).Error
+	if err != nil {
+		return err
+	}
+
+	for _, binding := range bindings {
+		err := db.Model(&SyncBinding{}).Where("id = ?", binding.ID).
+			UpdateColumn("auth_token", binding.AuthToken).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
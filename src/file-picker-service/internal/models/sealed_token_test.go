@@ -0,0 +1,61 @@
+package models
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSealedTokenRoundTrip(t *testing.T) {
+	if err := SetTokenKey(bytes.Repeat([]byte{7}, 32)); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := SealedToken("provider-token").Value()
+	if err != nil {
+		t.Fatalf("Value failed: %v", err)
+	}
+	if s := stored.(string); !strings.HasPrefix(s, sealedPrefix) || strings.Contains(s, "provider-token") {
+		t.Fatalf("Expected an encrypted value, got %q", s)
+	}
+
+	var token SealedToken
+	if err := token.Scan(stored); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if token != "provider-token" {
+		t.Errorf("Expected the original token, got %q", token)
+	}
+}
+
+func TestSealedTokenReadsPlainText(t *testing.T) {
+	if err := SetTokenKey(bytes.Repeat([]byte{7}, 32)); err != nil {
+		t.Fatal(err)
+	}
+
+	var token SealedToken
+	if err := token.Scan([]byte("legacy-token")); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if token != "legacy-token" {
+		t.Errorf("Expected the stored token, got %q", token)
+	}
+}
+
+func TestSealedTokenRejectsOtherKey(t *testing.T) {
+	if err := SetTokenKey(bytes.Repeat([]byte{7}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := SealedToken("provider-token").Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetTokenKey(bytes.Repeat([]byte{8}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	var token SealedToken
+	if err := token.Scan(stored); err == nil {
+		t.Error("Expected a token sealed with another key to be rejected")
+	}
+}
@@ -0,0 +1,133 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Share link permission levels
+const (
+	ShareLinkRead     = "read"     // The file is shown inline
+	ShareLinkDownload = "download" // The file is sent as an attachment
+)
+
+// ShareLink gives anyone holding its token access to a file, or to the files in one of the
+// owner's picker folders, without signing in. Only a hash of the token is stored.
+type ShareLink struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	TokenHash     string     `gorm:"uniqueIndex;not null" json:"-"`
+	OwnerID       uint       `gorm:"not null;index" json:"owner_id"`
+	FileID        string     `json:"file_id,omitempty"`   // Set for links to a single file
+	FolderID      string     `json:"folder_id,omitempty"` // Set for links to a picker folder
+	Permission    string     `gorm:"not null" json:"permission"`
+	PasswordHash  string     `json:"-"` // bcrypt hash, empty for links without a password
+	HasPassword   bool       `gorm:"-" json:"has_password"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	MaxDownloads  int        `json:"max_downloads,omitempty"` // 0 for no limit
+	DownloadCount int        `gorm:"not null;default:0" json:"download_count"`
+	CreatedAt     time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// ShareLinkPasswordFailure counts the wrong passwords a client IP sent for a share link. Clients
+// are locked out one by one, so that whoever guesses cannot lock out the link's other recipients.
+type ShareLinkPasswordFailure struct {
+	LinkID      uint   `gorm:"primaryKey;autoIncrement:false"`
+	ClientIP    string `gorm:"primaryKey"`
+	Failures    int    `gorm:"not null;default:0"` // Wrong passwords since the last lockout
+	LockedUntil *time.Time                          // Passwords from the client are refused until this time
+}
+
+// AfterFind fills in the fields derived from stored ones.
+func (l *ShareLink) AfterFind(tx *gorm.DB) error {
+	l.HasPassword = l.PasswordHash != ""
+	return nil
+}
+
+// Expired reports whether the link has expired at the given time.
+func (l *ShareLink) Expired(now time.Time) bool {
+	return l.ExpiresAt != nil && !l.ExpiresAt.After(now)
+}
+
+// CreateShareLink stores a new share link.
+func CreateShareLink(db *gorm.DB, link *ShareLink) error {
+	link.HasPassword = link.PasswordHash != ""
+	return db.Create(link).Error
+}
+
+// GetShareLinkByTokenHash retrieves the share link with the given token hash.
+func GetShareLinkByTokenHash(db *gorm.DB, tokenHash string) (*ShareLink, error) {
+	var link ShareLink
+	err := db.Where("token_hash = ?", tokenHash).First(&link).Error
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// ListShareLinks lists the share links created by the owner, newest first.
+func ListShareLinks(db *gorm.DB, ownerID uint) ([]ShareLink, error) {
+	var links []ShareLink
+	err := db.Where("owner_id = ?", ownerID).Order("created_at DESC").Find(&links).Error
+	return links, err
+}
+
+// DeleteShareLink removes a share link created by the owner, along with its password failures, and
+// reports whether it existed.
+func DeleteShareLink(db *gorm.DB, ownerID uint, linkID uint) (bool, error) {
+	result := db.Where("id = ? AND owner_id = ?", linkID, ownerID).Delete(&ShareLink{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	return true, db.Where("link_id = ?", linkID).Delete(&ShareLinkPasswordFailure{}).Error
+}
+
+// DeleteUserShareLinks removes every share link created by the owner, along with their password
+// failures.
+func DeleteUserShareLinks(db *gorm.DB, ownerID uint) error {
+	links := db.Model(&ShareLink{}).Select("id").Where("owner_id = ?", ownerID)
+	if err := db.Where("link_id IN (?)", links).Delete(&ShareLinkPasswordFailure{}).Error; err != nil {
+		return err
+	}
+	return db.Where("owner_id = ?", ownerID).Delete(&ShareLink{}).Error
+}
+
+// CountShareLinkDownload records a download through a link and reports whether the link's
+// download limit allowed it. The check and the increment are one statement, so concurrent
+// downloads cannot exceed the limit.
+func CountShareLinkDownload(db *gorm.DB, linkID uint) (bool, error) {
+	result := db.Model(&ShareLink{}).
+		Where("id = ? AND (max_downloads = 0 OR download_count < max_downloads)", linkID).
+		UpdateColumn("download_count", gorm.Expr("download_count + 1"))
+	return result.RowsAffected > 0, result.Error
+}
+
+// ShareLinkPasswordLocked reports whether passwords for a link from a client IP are refused at the
+// given time.
+func ShareLinkPasswordLocked(db *gorm.DB, linkID uint, clientIP string, now time.Time) (bool, error) {
+	var count int64
+	err := db.Model(&ShareLinkPasswordFailure{}).
+		Where("link_id = ? AND client_ip = ? AND locked_until > ?", linkID, clientIP, now).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// RecordShareLinkPasswordFailure counts a wrong password for a link from a client IP. When the
+// count reaches maxFailures the client is locked out until lockedUntil and the count starts over.
+// The increment and the check are one statement, so concurrent attempts cannot skip the lockout.
+func RecordShareLinkPasswordFailure(db *gorm.DB, linkID uint, clientIP string, maxFailures int, lockedUntil time.Time) error {
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "link_id"}, {Name: "client_ip"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"failures":     gorm.Expr("CASE WHEN share_link_password_failures.failures + 1 >= ? THEN 0 ELSE share_link_password_failures.failures + 1 END", maxFailures),
+			"locked_until": gorm.Expr("CASE WHEN share_link_password_failures.failures + 1 >= ? THEN ? ELSE share_link_password_failures.locked_until END", maxFailures, lockedUntil),
+		}),
+	}).Create(&ShareLinkPasswordFailure{LinkID: linkID, ClientIP: clientIP, Failures: 1}).Error
+}
+
+// ResetShareLinkPasswordFailures forgets the wrong passwords a client IP sent for a link, after a
+// correct one.
+func ResetShareLinkPasswordFailures(db *gorm.DB, linkID uint, clientIP string) error {
+	return db.Where("link_id = ? AND client_ip = ?", linkID, clientIP).Delete(&ShareLinkPasswordFailure{}).Error
+}
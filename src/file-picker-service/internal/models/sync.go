@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Sync binding states
+const (
+	SyncStatusPending = "pending" // Created, never reconciled
+	SyncStatusSyncing = "syncing" // Reconciliation in progress
+	SyncStatusIdle    = "idle"    // Last reconciliation succeeded
+	SyncStatusError   = "error"   // Last reconciliation failed, see LastError
+)
+
+// SyncBinding binds a picker folder to a folder in a cloud provider so that changes are
+// reconciled in both directions.
+type SyncBinding struct {
+	ID             uint        `gorm:"primaryKey" json:"id"`
+	OwnerID        uint        `gorm:"not null;uniqueIndex:idx_sync_folder" json:"owner_id"`
+	FolderID       string      `gorm:"not null;uniqueIndex:idx_sync_folder" json:"folder_id"` // Picker folder being synced
+	Provider       string      `gorm:"not null" json:"provider"`                              // e.g., "google_drive", "dropbox"
+	RemoteFolderID string      `gorm:"not null" json:"remote_folder_id"`                      // Folder ID or path in the cloud provider
+	AuthToken      SealedToken `gorm:"not null" json:"-"`                                     // Access token for the cloud provider, encrypted at rest
+	Cursor         string      `json:"-"`                                                     // Provider change cursor to resume from
+	Status         string      `gorm:"not null;default:pending" json:"status"`
+	LastError      string      `json:"last_error,omitempty"`
+	LastSyncedAt   *time.Time  `json:"last_synced_at,omitempty"`
+	Imported       int         `json:"imported"`  // Files downloaded during the last reconciliation
+	Uploaded       int         `json:"uploaded"`  // Files uploaded during the last reconciliation
+	Deleted        int         `json:"deleted"`   // Files removed during the last reconciliation
+	Conflicts      int         `json:"conflicts"` // Conflicting changes kept as separate copies during the last reconciliation
+	CreatedAt      time.Time   `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt      time.Time   `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// CreateSyncBinding stores a new folder binding.
+func CreateSyncBinding(db *gorm.DB, binding *SyncBinding) error {
+	binding.Status = SyncStatusPending
+	return db.Create(binding).Error
+}
+
+// GetSyncBinding retrieves a folder binding owned by the user.
+func GetSyncBinding(db *gorm.DB, ownerID uint, bindingID uint) (*SyncBinding, error) {
+	var binding SyncBinding
+	err := db.Where("id = ? AND owner_id = ?", bindingID, ownerID).First(&binding).Error
+	if err != nil {
+		return nil, err
+	}
+	return &binding, nil
+}
+
+// ListSyncBindings retrieves every folder binding that should be reconciled.
+func ListSyncBindings(db *gorm.DB) ([]SyncBinding, error) {
+	var bindings []SyncBinding
+	err := db.Find(&bindings).Error
+	return bindings, err
+}
+
+// DeleteUserSyncBindings removes every folder binding owned by the user.
+func DeleteUserSyncBindings(db *gorm.DB, ownerID uint) error {
+	return db.Where("owner_id = ?", ownerID).Delete(&SyncBinding{}).Error
+}
+
+// SaveSyncBinding persists the cursor, status and counters of a folder binding.
+func SaveSyncBinding(db *gorm.DB, binding *SyncBinding) error {
+	return db.Save(binding).Error
+}
@@ -28,7 +28,9 @@ func NewDownloaderClient(downloaderServiceAddress string) (*DownloaderClient, er
 	return &DownloaderClient{client: client}, nil
 }
 
-func (d *DownloaderClient) DownloadFile(fileID, provider, authToken string) (string, error) {
+// DownloadFile asks the file-downloader-service to import a cloud file on behalf of a user and
+// returns the local path together with its provenance.
+func (d *DownloaderClient) DownloadFile(userID uint, fileID, provider, authToken string) (*filedownloader.DownloadFileResponse, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -36,12 +38,80 @@ func (d *DownloaderClient) DownloadFile(fileID, provider, authToken string) (str
 		FileId:    fileID,
 		Provider:  provider,
 		AuthToken: authToken,
+		UserId:    uint64(userID),
 	}
 
 	resp, err := d.client.DownloadFile(ctx, req)
 	if err != nil {
-		return "", fmt.Errorf("failed to download file: %v", err)
+		return nil, fmt.Errorf("failed to download file: %v", err)
 	}
 
-	return resp.FilePath, nil
+	return resp, nil
+}
+
+// ListRemoteChanges returns the changes in a cloud folder since the given provider cursor,
+// along with the cursor to resume from.
+func (d *DownloaderClient) ListRemoteChanges(provider, authToken, folderID, cursor string) (*filedownloader.ListRemoteChangesResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req := &filedownloader.ListRemoteChangesRequest{
+		Provider:  provider,
+		AuthToken: authToken,
+		FolderId:  folderID,
+		Cursor:    cursor,
+	}
+
+	resp, err := d.client.ListRemoteChanges(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote changes: %v", err)
+	}
+
+	return resp, nil
+}
+
+// UploadFile asks the file-downloader-service to push a local file to a cloud folder.
+func (d *DownloaderClient) UploadFile(provider, authToken, folderID, fileName, filePath, remoteID, baseRevision string) (*filedownloader.UploadFileResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req := &filedownloader.UploadFileRequest{
+		Provider:     provider,
+		AuthToken:    authToken,
+		FolderId:     folderID,
+		FileName:     fileName,
+		FilePath:     filePath,
+		RemoteId:     remoteID,
+		BaseRevision: baseRevision,
+	}
+
+	resp, err := d.client.UploadFile(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload file: %v", err)
+	}
+
+	return resp, nil
+}
+
+// ExportFile asks the file-downloader-service to push a stored file to the user's cloud storage.
+// Large files are uploaded in chunks, so the call is given a generous deadline.
+func (d *DownloaderClient) ExportFile(userID uint, provider, authToken, folderID, fileName, filePath string) (*filedownloader.ExportFileResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	req := &filedownloader.ExportFileRequest{
+		UserId:    uint64(userID),
+		Provider:  provider,
+		AuthToken: authToken,
+		FolderId:  folderID,
+		FileName:  fileName,
+		FilePath:  filePath,
+	}
+
+	resp, err := d.client.ExportFile(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export file: %v", err)
+	}
+
+	return resp, nil
 }
\ No newline at end of file
@@ -1,11 +1,18 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"file-picker-service/internal/db"
 	"file-picker-service/internal/models"
+	"file-picker-service/internal/pkg"
+	"file-picker-service/proto/generated/filedownloader"
+	"net/http"
 	"os"
 	"path/filepath"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // FileService provides core business logic for file operations.
@@ -14,99 +21,190 @@ type FileService struct {
 	downloaderClient     DownloaderClient
 	transformationClient TransformationClient
 	notificationClient   NotificationClient
+	uploadDir            string // Directory uploaded files are stored in, named by file ID
 }
 
-// NewFileService creates a new FileService instance with required gRPC clients.
-func NewFileService(permissionsClient PermissionClient, downloaderClient DownloaderClient, transformationClient TransformationClient, notificationClient NotificationClient) *FileService {
+// NewFileService creates a new FileService instance with required gRPC clients. Uploaded files
+// are stored in uploadDir.
+func NewFileService(permissionsClient PermissionClient, downloaderClient DownloaderClient, transformationClient TransformationClient, notificationClient NotificationClient, uploadDir string) *FileService {
 	return &FileService{
 		permissionsClient:    permissionsClient,
 		downloaderClient:     downloaderClient,
 		transformationClient: transformationClient,
 		notificationClient:   notificationClient,
+		uploadDir:            uploadDir,
 	}
 }
 
-// SaveFile handles saving the uploaded file and metadata to the database and storage.
-func (s *FileService) SaveFile(fileId string, userID uint, fileName string, fileData []byte) error {
+// SaveFile handles saving the uploaded file and metadata to the database and storage. Each upload
+// is a new file with a fresh ID, stored under that ID so that files with the same name do not
+// overwrite each other.
+func (s *FileService) SaveFile(ctx context.Context, userID uint, role string, folderID string, fileName string, fileData []byte) (*models.File, error) {
 
-	// Check write permissions via Permissions Service
-	hasPermission, err := s.permissionsClient.CheckPermission(userID, "write", "")
-	if err != nil || !hasPermission {
-		return errors.New("user does not have write permission")
+	// Check that the user's role may upload via Permissions Service
+	hasPermission, err := s.permissionsClient.CheckGlobalPermission(ctx, userID, role, "write")
+	if err != nil {
+		return nil, err
+	}
+	if !hasPermission {
+		return nil, pkg.NewAPIError(http.StatusForbidden, "user does not have write permission")
 	}
 
-	//Give owner permission to the user who uploaded the file
-	err = s.permissionsClient.GrantOwnerPermissions(uint64(userID), []string{fileId})
-	if err != nil {
-		return err
+	// Save the file to local storage before it is listed, so that it is never listed without content
+	file := &models.File{ID: uuid.NewString(), FileName: fileName, OwnerID: userID, FolderID: folderID}
+	file.FilePath = filepath.Join(s.uploadDir, file.ID)
+	if err := os.MkdirAll(s.uploadDir, 0750); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(file.FilePath, fileData, 0640); err != nil {
+		return nil, err
+	}
+
+	// Save file metadata to the database and give owner permission to the user who uploaded the file
+	if err := s.registerFile(ctx, file); err != nil {
+		os.Remove(file.FilePath)
+		return nil, err
 	}
 
-	// Save file metadata to the database
-	err = models.SaveFileMetadata(db.DB, fileId, fileName, userID)
+	// Notify the user that the file has been saved
+	err = s.notificationClient.SendNotification(userID, "Your file has been saved.")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Save the file to local storage (or cloud storage if configured)
-	filePath := filepath.Join("./uploads", fileName)
-	err = os.WriteFile(filePath, fileData, 0644)
+	return file, nil
+}
+
+// ListFiles retrieves a page of the files the user can read, whether owned, shared directly, shared
+// with one of the user's groups or inherited from a shared folder, along with the number of
+// matching files across all pages. Roles that may read every file, such as admins, list the
+// whole catalog.
+func (s *FileService) ListFiles(ctx context.Context, userID uint, role string, clientIP string, query models.FileQuery) ([]models.File, int64, error) {
+	// The Permissions Service decides which files are readable; the catalog only filters them
+	fileIDs, allFiles, err := s.permissionsClient.ListAccessibleFileIDs(ctx, userID, role, "read", clientIP)
 	if err != nil {
-		return err
+		return nil, 0, err
+	}
+	if allFiles {
+		return models.ListAllFiles(db.DB, query)
+	}
+	if len(fileIDs) == 0 {
+		return []models.File{}, 0, nil
 	}
 
-	// Notify the user that the file has been saved
-	err = s.notificationClient.SendNotification(userID, "Your file has been saved.")
+	// Fetch the matching page of files from the database
+	files, total, err := models.ListFilesByID(db.DB, fileIDs, query)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
 
-	return nil
+	return files, total, nil
 }
 
-// ListFiles retrieves a list of files that the user has access to (owned or shared).
-func (s *FileService) ListFiles(userID uint) ([]models.File, error) {
-	// Check read permissions via Permissions Service
-	hasPermission, err := s.permissionsClient.CheckPermission(userID, "read", "")
-	if err != nil || !hasPermission {
-		return nil, errors.New("user does not have read permission")
+// DownloadFile imports a file from cloud storage using the File-Downloader-Service and
+// registers it in the file catalog so the user can list, share and transform it.
+// Re-importing a file the user already owns refreshes its revision instead of creating a duplicate.
+func (s *FileService) DownloadFile(ctx context.Context, userID uint, role string, fileID, provider, authToken string) (*models.File, error) {
+	// Importing creates a new file, so it requires the same write permission as an upload
+	hasPermission, err := s.permissionsClient.CheckGlobalPermission(ctx, userID, role, "write")
+	if err != nil {
+		return nil, err
+	}
+	if !hasPermission {
+		return nil, pkg.NewAPIError(http.StatusForbidden, "user does not have permission to upload this file")
+	}
+
+	// Use the File-Downloader-Service to fetch the file
+	download, err := s.downloaderClient.DownloadFile(userID, fileID, provider, authToken)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteID := download.RemoteId
+	if remoteID == "" {
+		remoteID = fileID
+	}
+
+	file, err := models.GetImportedFile(db.DB, userID, provider, remoteID)
+	switch {
+	case err == nil:
+		// The file was imported before; point the catalog entry at the new revision
+		err = models.UpdateImportedRevision(db.DB, file.ID, download.FilePath, download.Revision)
+		if err != nil {
+			return nil, err
+		}
+		file.FilePath = download.FilePath
+		file.RemoteRevision = download.Revision
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		file = &models.File{
+			ID:             uuid.NewString(),
+			FileName:       download.FileName,
+			FilePath:       download.FilePath,
+			OwnerID:        userID,
+			Provider:       provider,
+			RemoteID:       remoteID,
+			RemoteRevision: download.Revision,
+		}
+
+		err = s.registerFile(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
 	}
 
-	// Fetch the list of files from the database
-	files, err := models.ListFilesByUser(db.DB, userID)
+	// Notify the user that the import has finished
+	err = s.notificationClient.SendNotification(userID, "Your file "+file.FileName+" has been imported.")
 	if err != nil {
 		return nil, err
 	}
 
-	return files, nil
+	return file, nil
 }
 
-// DownloadFile retrieves the file using the File-Downloader-Service.
-func (s *FileService) DownloadFile(userID uint, fileID, provider, authToken string) (string, error) {
-	// Check read permission for the file
-	hasPermission, err := s.permissionsClient.CheckPermission(userID, "write", fileID)
+// ExportFile pushes a stored file to the user's Dropbox or Google Drive account. Transformations
+// write their output over the stored file, so exporting a transformed file sends the transformed
+// content. The File-Downloader-Service notifies the user once the export completes.
+func (s *FileService) ExportFile(ctx context.Context, userID uint, role string, clientIP string, fileID, provider, authToken, folderID, fileName string) (*filedownloader.ExportFileResponse, error) {
+	// Exporting copies the file out of the system, so it requires download permission
+	hasPermission, err := s.permissionsClient.CheckPermissionAs(ctx, userID, role, "download", fileID, clientIP)
 	if err != nil || !hasPermission {
-		return "", errors.New("user does not have permission to upload this file")
+		return nil, pkg.NewAPIError(http.StatusForbidden, "user does not have permission to export this file")
 	}
 
-	// Use the File-Downloader-Service to get the file path
-	filePath, err := s.downloaderClient.DownloadFile(fileID, provider, authToken)
+	file, err := models.GetFile(db.DB, fileID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, pkg.NotFoundError("file not found")
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	//Notify the user that the download has started
-	err = s.notificationClient.SendNotification(userID, "Your file download has started.")
-	if err != nil {
-		return "", err
+	if fileName == "" {
+		fileName = file.FileName
 	}
 
-	return filePath, nil
+	return s.downloaderClient.ExportFile(userID, provider, authToken, folderID, fileName, file.FilePath)
+}
+
+// registerFile adds a file to the catalog and gives its owner full permissions. The catalog entry
+// is written in a transaction that is only committed once the owner has been granted, and rolled
+// back when the grant fails. A grant is only left without its file if the commit itself fails,
+// and then on a fresh file ID that nothing else refers to.
+func (s *FileService) registerFile(ctx context.Context, file *models.File) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := models.SaveFileMetadata(tx, file); err != nil {
+			return err
+		}
+		return s.permissionsClient.GrantOwnerPermissions(ctx, uint64(file.OwnerID), []string{file.ID})
+	})
 }
 
 // TransformFile requests a file transformation and notifies the user.
-func (s *FileService) TransformFile(userID uint, fileID string, filePath string, transformationType string) error {
-	// Check permission to transform the file
-	hasPermission, err := s.permissionsClient.CheckPermission(userID, "transform", fileID)
+func (s *FileService) TransformFile(ctx context.Context, userID uint, role string, clientIP string, fileID string, filePath string, transformationType string) error {
+	// Check permission to transform the file, applying the role policy and IP-restricted shares
+	hasPermission, err := s.permissionsClient.CheckPermissionAs(ctx, userID, role, "transform", fileID, clientIP)
 	if err != nil || !hasPermission {
 		return errors.New("user does not have permission to transform this file")
 	}
@@ -126,9 +224,9 @@ func (s *FileService) TransformFile(userID uint, fileID string, filePath string,
 	return nil
 }
 
-func (s *FileService) ShareFilePermissions(ownerID, sharedUserID uint64, fileIDs []string, permissions []string) error {
+func (s *FileService) ShareFilePermissions(ctx context.Context, ownerID, sharedUserID uint64, fileIDs []string, permissions []string, opts ShareOptions) error {
 	// Use the Permission Client to update permissions for the shared user
-	err := s.permissionsClient.ShareFilePermissions(ownerID, sharedUserID, fileIDs, permissions)
+	err := s.permissionsClient.ShareFilePermissions(ctx, ownerID, sharedUserID, fileIDs, permissions, opts)
 	if err != nil {
 		return errors.New("failed to update permissions for shared user: %v")
 	}
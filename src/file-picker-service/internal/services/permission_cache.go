@@ -0,0 +1,140 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"file-picker-service/proto/generated/permission"
+)
+
+// maxCachedDecisions bounds the number of decisions a decisionCache holds.
+const maxCachedDecisions = 100000
+
+// decisionKey identifies a permission decision. The role is part of the key because the role
+// policy applies to it, and the client address because grants can be restricted to IP ranges.
+type decisionKey struct {
+	userID     uint
+	role       string
+	fileID     string
+	permission string
+	clientIP   string
+}
+
+// cachedDecision is a permission decision and the time it stops being served.
+type cachedDecision struct {
+	allowed bool
+	expires time.Time
+}
+
+// decisionCache holds recent permission decisions so that hot paths avoid a round-trip to the
+// Permission Service. Granted decisions are kept for ttl and denied ones for negativeTTL.
+//
+// Decisions are only served while the cache is live, that is while the client is watching the
+// Permission Service's change stream, which drops the decisions a change may affect. Every
+// invalidation bumps the generation, and decisions fetched before an invalidation are not stored,
+// so a check racing a revocation cannot cache the revoked access.
+type decisionCache struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	negativeTTL time.Duration
+	decisions   map[decisionKey]cachedDecision
+	generation  uint64
+	live        bool
+}
+
+// newDecisionCache creates an empty cache, which serves nothing until it is made live.
+func newDecisionCache(ttl, negativeTTL time.Duration) *decisionCache {
+	return &decisionCache{
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		decisions:   make(map[decisionKey]cachedDecision),
+	}
+}
+
+// get returns the cached decision for key, if there is one that has not expired.
+func (c *decisionCache) get(key decisionKey) (allowed bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.live {
+		return false, false
+	}
+	decision, ok := c.decisions[key]
+	if !ok || time.Now().After(decision.expires) {
+		return false, false
+	}
+	return decision.allowed, true
+}
+
+// currentGeneration returns the generation to pass to put for a decision about to be fetched.
+func (c *decisionCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// put stores a decision fetched at the given generation, unless the cache was invalidated since.
+// A decision resting on grants that expire is kept no longer than validUntil, unless it is zero.
+func (c *decisionCache) put(key decisionKey, allowed bool, validUntil time.Time, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.live || generation != c.generation {
+		return
+	}
+
+	if len(c.decisions) >= maxCachedDecisions {
+		c.evictExpired()
+		if len(c.decisions) >= maxCachedDecisions {
+			c.decisions = make(map[decisionKey]cachedDecision)
+		}
+	}
+
+	ttl := c.ttl
+	if !allowed {
+		ttl = c.negativeTTL
+	}
+	expires := time.Now().Add(ttl)
+	if !validUntil.IsZero() && validUntil.Before(expires) {
+		expires = validUntil
+	}
+	c.decisions[key] = cachedDecision{allowed: allowed, expires: expires}
+}
+
+// invalidate drops the decisions a change may affect.
+func (c *decisionCache) invalidate(change *permission.PermissionChange) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if change.ResetAll {
+		c.decisions = make(map[decisionKey]cachedDecision)
+		return
+	}
+	for key := range c.decisions {
+		if (change.FileId != "" && key.fileID == change.FileId) || (change.UserId != 0 && uint64(key.userID) == change.UserId) {
+			delete(c.decisions, key)
+		}
+	}
+}
+
+// setLive starts or stops serving decisions. Either way the cache starts over empty, as changes
+// may have been missed while it was not watching.
+func (c *decisionCache) setLive(live bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.live = live
+	c.decisions = make(map[decisionKey]cachedDecision)
+}
+
+// evictExpired drops the decisions that have expired. The caller must hold mu.
+func (c *decisionCache) evictExpired() {
+	now := time.Now()
+	for key, decision := range c.decisions {
+		if now.After(decision.expires) {
+			delete(c.decisions, key)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"file-picker-service/proto/generated/permission"
+)
+
+// newLiveCache creates a live decisionCache keeping granted decisions for a minute and denied ones
+// for ten seconds.
+func newLiveCache() *decisionCache {
+	cache := newDecisionCache(time.Minute, 10*time.Second)
+	cache.setLive(true)
+	return cache
+}
+
+func TestDecisionCacheServesOnlyWhileLive(t *testing.T) {
+	cache := newDecisionCache(time.Minute, time.Minute)
+	key := decisionKey{userID: 1, role: "editor", fileID: "f1", permission: "read"}
+
+	cache.put(key, true, time.Time{}, cache.currentGeneration())
+	if _, ok := cache.get(key); ok {
+		t.Fatal("Expected a cache that is not live to store nothing")
+	}
+
+	cache.setLive(true)
+	cache.put(key, true, time.Time{}, cache.currentGeneration())
+	if allowed, ok := cache.get(key); !ok || !allowed {
+		t.Fatalf("Expected the decision to be served, got %v %v", allowed, ok)
+	}
+
+	// Changes may be missed while not watching, so going live again starts over
+	cache.setLive(false)
+	cache.setLive(true)
+	if _, ok := cache.get(key); ok {
+		t.Error("Expected the cache to start over empty")
+	}
+}
+
+func TestDecisionCachePutIgnoresStaleGeneration(t *testing.T) {
+	cache := newLiveCache()
+	key := decisionKey{userID: 1, role: "editor", fileID: "f1", permission: "read"}
+
+	// The decision was fetched before a revocation on another file, and is still dropped
+	generation := cache.currentGeneration()
+	cache.invalidate(&permission.PermissionChange{FileId: "f2"})
+	cache.put(key, true, time.Time{}, generation)
+	if _, ok := cache.get(key); ok {
+		t.Fatal("Expected a decision fetched before an invalidation not to be stored")
+	}
+
+	cache.put(key, true, time.Time{}, cache.currentGeneration())
+	if _, ok := cache.get(key); !ok {
+		t.Fatal("Expected a decision fetched after the invalidation to be stored")
+	}
+}
+
+func TestDecisionCacheExpiry(t *testing.T) {
+	cache := newLiveCache()
+	granted := decisionKey{userID: 1, fileID: "f1", permission: "read"}
+	denied := decisionKey{userID: 1, fileID: "f2", permission: "read"}
+	expiring := decisionKey{userID: 1, fileID: "f3", permission: "read"}
+	generation := cache.currentGeneration()
+	cache.put(granted, true, time.Time{}, generation)
+	cache.put(denied, false, time.Time{}, generation)
+	cache.put(expiring, true, time.Now().Add(time.Second), generation)
+
+	expires := func(key decisionKey) time.Duration {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		return time.Until(cache.decisions[key].expires)
+	}
+	if d := expires(granted); d < 59*time.Second || d > time.Minute {
+		t.Errorf("Expected a granted decision to be kept for the TTL, got %v", d)
+	}
+	if d := expires(denied); d < 9*time.Second || d > 10*time.Second {
+		t.Errorf("Expected a denied decision to be kept for the negative TTL, got %v", d)
+	}
+	if d := expires(expiring); d > time.Second {
+		t.Errorf("Expected a decision on expiring grants to be kept until they expire, got %v", d)
+	}
+
+	cache.put(expiring, true, time.Now().Add(-time.Second), cache.currentGeneration())
+	if _, ok := cache.get(expiring); ok {
+		t.Error("Expected an expired decision not to be served")
+	}
+}
+
+func TestDecisionCacheInvalidate(t *testing.T) {
+	keys := []decisionKey{
+		{userID: 1, fileID: "f1", permission: "read"},
+		{userID: 1, fileID: "f2", permission: "read"},
+		{userID: 2, fileID: "f1", permission: "write"},
+		{userID: 2, fileID: "f2", permission: "read", clientIP: "10.0.0.1"},
+	}
+	tests := []struct {
+		name   string
+		change *permission.PermissionChange
+		kept   []bool // By key
+	}{
+		{name: "file", change: &permission.PermissionChange{FileId: "f1"}, kept: []bool{false, true, false, true}},
+		{name: "user", change: &permission.PermissionChange{UserId: 2}, kept: []bool{true, true, false, false}},
+		{name: "file and user", change: &permission.PermissionChange{FileId: "f2", UserId: 2}, kept: []bool{true, false, false, false}},
+		{name: "reset", change: &permission.PermissionChange{ResetAll: true}, kept: []bool{false, false, false, false}},
+		{name: "unrelated", change: &permission.PermissionChange{FileId: "f3", UserId: 3}, kept: []bool{true, true, true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newLiveCache()
+			for _, key := range keys {
+				cache.put(key, true, time.Time{}, cache.currentGeneration())
+			}
+
+			generation := cache.currentGeneration()
+			cache.invalidate(tt.change)
+			if cache.currentGeneration() == generation {
+				t.Error("Expected the invalidation to bump the generation")
+			}
+			for i, key := range keys {
+				if _, ok := cache.get(key); ok != tt.kept[i] {
+					t.Errorf("Expected %+v kept: %v, got %v", key, tt.kept[i], ok)
+				}
+			}
+		})
+	}
+}
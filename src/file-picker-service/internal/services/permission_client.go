@@ -3,40 +3,187 @@ package services
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"time"
 
+	"file-picker-service/internal/pkg"
 	"file-picker-service/proto/generated/permission"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"shared/authn"
+	"shared/authn/grpcauth"
 )
 
 type PermissionClient struct {
-	client permission.PermissionServiceClient
-	conn   *grpc.ClientConn // Store connection for proper shutdown
+	client    permission.PermissionServiceClient
+	conn      *grpc.ClientConn                // Store connection for proper shutdown
+	cache     *decisionCache                  // Nil when decisions are not cached
+	stopWatch context.CancelFunc              // Stops watching permission changes
+	hits      chan *permission.CachedDecision // Decisions served from the cache, waiting to be audited
+	stopHits  chan struct{}                   // Closed to audit the last cached decisions and stop
+	hitsDone  chan struct{}                   // Closed once the last cached decisions are audited
 }
 
+// Bounds on the delay before the permission change stream is watched again after failing.
+const (
+	minWatchBackoff = time.Second
+	maxWatchBackoff = 30 * time.Second
+)
+
+// Decisions served from the cache are sent to the Permission Service's audit log in batches.
+// When too many are waiting, checks skip the cache and are audited by the Permission Service.
+const (
+	maxPendingHits = 10000
+	hitBatchSize   = 1000 // The most the Permission Service records per call
+	hitFlushPeriod = time.Second
+)
+
 // NewPermissionClient creates a new gRPC client for the Permission Service using the recommended grpc.NewClientConn.
-func NewPermissionClient(permissionServiceAddress string) (*PermissionClient, error) {
+// Calls carry the access token of the request they are made for, found in their context, so that
+// the Permission Service acts for the signed-in user. Calls made outside a user's request carry
+// serviceToken, the API key of the picker's service account, and act for the user they name.
+// When cacheTTL is positive, permission decisions are cached, granted ones for cacheTTL and
+// denied ones for negativeCacheTTL, and dropped as the Permission Service reports changes or the
+// grants they rest on expire. Decisions served from the cache are still audited.
+func NewPermissionClient(permissionServiceAddress, serviceToken string, cacheTTL, negativeCacheTTL time.Duration) (*PermissionClient, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	// Use NewClientConn to create the connection
-	conn, err := grpc.DialContext(ctx, permissionServiceAddress, grpc.WithInsecure(), grpc.WithBlock(), grpc.WithTimeout(5*time.Second))
+	dialOptions := append(grpcauth.DialOptions(serviceToken), grpc.WithInsecure(), grpc.WithBlock(), grpc.WithTimeout(5*time.Second))
+	conn, err := grpc.DialContext(ctx, permissionServiceAddress, dialOptions...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to permission service: %v", err)
 	}
 
 	client := permission.NewPermissionServiceClient(conn)
-	return &PermissionClient{client: client, conn: conn}, nil
+	p := &PermissionClient{client: client, conn: conn, stopWatch: func() {}}
+
+	if cacheTTL > 0 {
+		p.cache = newDecisionCache(cacheTTL, negativeCacheTTL)
+		p.hits = make(chan *permission.CachedDecision, maxPendingHits)
+		p.stopHits = make(chan struct{})
+		p.hitsDone = make(chan struct{})
+		go p.auditHits()
+
+		watchCtx, stopWatch := context.WithCancel(context.Background())
+		p.stopWatch = stopWatch
+		go p.watchChanges(watchCtx)
+	}
+
+	return p, nil
 }
 
-// Close gracefully closes the gRPC connection
+// Close gracefully closes the gRPC connection, once the decisions served from the cache are audited
 func (p *PermissionClient) Close() error {
+	p.stopWatch()
+	if p.hits != nil {
+		close(p.stopHits)
+		<-p.hitsDone
+	}
 	return p.conn.Close()
 }
 
-// GrantOwnerPermissions grants full permissions to the owner of the file.
-func (p *PermissionClient) GrantOwnerPermissions(ownerID uint64, fileIDs []string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// auditHits sends the decisions served from the cache to the Permission Service's audit log in
+// batches, until stopHits is closed.
+func (p *PermissionClient) auditHits() {
+	defer close(p.hitsDone)
+
+	ticker := time.NewTicker(hitFlushPeriod)
+	defer ticker.Stop()
+
+	var batch []*permission.CachedDecision
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := p.client.RecordCachedDecisions(ctx, &permission.RecordCachedDecisionsRequest{Decisions: batch}); err != nil {
+			pkg.Logger.WithError(err).Errorf("Failed to audit %d cached permission decisions", len(batch))
+		}
+		batch = nil
+	}
+
+	add := func(hit *permission.CachedDecision) {
+		batch = append(batch, hit)
+		if len(batch) == hitBatchSize {
+			flush()
+		}
+	}
+
+	for {
+		select {
+		case hit := <-p.hits:
+			add(hit)
+		case <-ticker.C:
+			flush()
+		case <-p.stopHits:
+			for {
+				select {
+				case hit := <-p.hits:
+					add(hit)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// watchChanges keeps the decision cache live by following the Permission Service's change stream,
+// reconnecting with backoff whenever the stream fails, until ctx is cancelled. Decisions are not
+// served from the cache while the stream is down.
+func (p *PermissionClient) watchChanges(ctx context.Context) {
+	backoff := minWatchBackoff
+	for {
+		err := p.followChanges(ctx)
+		p.cache.setLive(false)
+		if ctx.Err() != nil {
+			return
+		}
+		pkg.Logger.WithError(err).Warn("Permission change stream failed, not caching permission decisions")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxWatchBackoff)
+	}
+}
+
+// followChanges applies the changes from one change stream to the cache until the stream fails.
+func (p *PermissionClient) followChanges(ctx context.Context) error {
+	stream, err := p.client.WatchPermissionChanges(ctx, &permission.WatchPermissionChangesRequest{})
+	if err != nil {
+		return err
+	}
+	// The Permission Service sends headers once the watch is registered
+	if _, err := stream.Header(); err != nil {
+		return err
+	}
+
+	p.cache.setLive(true)
+	pkg.Logger.Info("Watching permission changes, caching permission decisions")
+
+	for {
+		change, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		p.cache.invalidate(change)
+	}
+}
+
+// GrantOwnerPermissions grants full permissions to the owner of the file. Only the picker may
+// claim new files for their owner, so the call is always made with the picker's service token,
+// never the user's access token.
+func (p *PermissionClient) GrantOwnerPermissions(ctx context.Context, ownerID uint64, fileIDs []string) error {
+	ctx, cancel := context.WithTimeout(authn.NewTokenContext(ctx, ""), 5*time.Second)
 	defer cancel()
 
 	req := &permission.UpdatePermissionRequest{
@@ -58,9 +205,49 @@ func (p *PermissionClient) GrantOwnerPermissions(ownerID uint64, fileIDs []strin
 	return nil
 }
 
+// ShareOptions restrict a share. The zero value shares without expiry or condition.
+type ShareOptions struct {
+	ExpiresAt *time.Time // When the share stops applying, nil for never
+	Condition string     // e.g. "ip in 10.0.0.0/8 && permission != download"
+}
+
+// expiresAtUnix returns the expiry as Unix time, or 0 for shares that never expire.
+func (o ShareOptions) expiresAtUnix() int64 {
+	if o.ExpiresAt == nil {
+		return 0
+	}
+	return o.ExpiresAt.Unix()
+}
+
+// ShareFileWithGroup grants permissions on the specified files to every member of a group.
+func (p *PermissionClient) ShareFileWithGroup(ctx context.Context, ownerID, groupID uint64, fileIDs []string, permissions []string, opts ShareOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req := &permission.UpdatePermissionRequest{
+		OwnerId:       ownerID,
+		SharedGroupId: groupID,
+		FileIds:       fileIDs,
+		Permissions:   permissions,
+		ExpiresAt:     opts.expiresAtUnix(),
+		Condition:     opts.Condition,
+	}
+
+	resp, err := p.client.UpdatePermission(ctx, req)
+	if err != nil {
+		return permissionError(err, "failed to update permissions for group")
+	}
+
+	if !resp.Success {
+		return fmt.Errorf("permission service responded with error: %s", resp.Message)
+	}
+
+	return nil
+}
+
 // ShareFilePermissions grants permissions to a shared user for specified files.
-func (p *PermissionClient) ShareFilePermissions(ownerID, sharedUserID uint64, fileIDs []string, permissions []string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (p *PermissionClient) ShareFilePermissions(ctx context.Context, ownerID, sharedUserID uint64, fileIDs []string, permissions []string, opts ShareOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	req := &permission.UpdatePermissionRequest{
@@ -69,11 +256,13 @@ func (p *PermissionClient) ShareFilePermissions(ownerID, sharedUserID uint64, fi
 		FileIds:      fileIDs,
 		Permissions:  permissions, // Permissions to grant (read, write, etc.)
 		IsOwner:      false,        // The file is being shared, not owned by this user
+		ExpiresAt:    opts.expiresAtUnix(),
+		Condition:    opts.Condition,
 	}
 
 	resp, err := p.client.UpdatePermission(ctx, req)
 	if err != nil {
-		return fmt.Errorf("failed to update permissions for shared user: %v", err)
+		return permissionError(err, "failed to update permissions for shared user")
 	}
 
 	if !resp.Success {
@@ -83,14 +272,77 @@ func (p *PermissionClient) ShareFilePermissions(ownerID, sharedUserID uint64, fi
 	return nil
 }
 
-func (p *PermissionClient) CheckPermission(userID uint, permissionType string, fileID string) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (p *PermissionClient) CheckPermission(ctx context.Context, userID uint, permissionType string, fileID string) (bool, error) {
+	return p.CheckPermissionAs(ctx, userID, "", permissionType, fileID, "")
+}
+
+// CheckGlobalPermission checks whether the user's role allows an action not tied to a file, such
+// as uploading ("write").
+func (p *PermissionClient) CheckGlobalPermission(ctx context.Context, userID uint, role string, permissionType string) (bool, error) {
+	return p.CheckPermissionAs(ctx, userID, role, permissionType, "", "")
+}
+
+// ListAccessibleFileIDs lists the IDs of the files the user holds a permission on, directly,
+// through groups or through folders. When the user's role holds the permission on every file, no
+// IDs are returned and allFiles is true.
+func (p *PermissionClient) ListAccessibleFileIDs(ctx context.Context, userID uint, role string, permissionType string, clientIP string) (fileIDs []string, allFiles bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	resp, err := p.client.ListAccessibleFileIDs(ctx, &permission.ListAccessibleFileIDsRequest{
+		UserId:     uint64(userID),
+		Permission: permissionType,
+		ClientIp:   clientIP,
+		Role:       role,
+	})
+	if err != nil {
+		return nil, false, permissionError(err, "failed to list accessible files")
+	}
+
+	return resp.FileIds, resp.AllFiles, nil
+}
+
+// BatchCheckPermission checks a permission on several files at once and returns the result for
+// each file ID.
+func (p *PermissionClient) BatchCheckPermission(ctx context.Context, userID uint, permissionType string, fileIDs []string, clientIP string) (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	resp, err := p.client.BatchCheckPermission(ctx, &permission.BatchCheckPermissionRequest{
+		UserId:     uint64(userID),
+		FileIds:    fileIDs,
+		Permission: permissionType,
+		ClientIp:   clientIP,
+	})
+	if err != nil {
+		return nil, permissionError(err, "failed to check permissions")
+	}
+
+	return resp.Results, nil
+}
+
+// CheckPermissionAs checks a permission for a request made by a user with the given role from
+// clientIP. The role policy of the Permission Service applies to the role, and shares restricted
+// to IP ranges are evaluated against clientIP; either may be empty.
+func (p *PermissionClient) CheckPermissionAs(ctx context.Context, userID uint, role string, permissionType string, fileID string, clientIP string) (bool, error) {
+	key := decisionKey{userID: userID, role: role, fileID: fileID, permission: permissionType, clientIP: clientIP}
+	var generation uint64
+	if p.cache != nil {
+		if allowed, ok := p.cache.get(key); ok && p.recordHit(key, allowed) {
+			return allowed, nil
+		}
+		generation = p.cache.currentGeneration()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	req := &permission.CheckPermissionRequest{
 		UserId:         uint64(userID),
 		FileId:         fileID,
 		Permission: permissionType,
+		ClientIp:   clientIP,
+		Role:       role,
 	}
 
 	resp, err := p.client.CheckPermission(ctx, req)
@@ -98,5 +350,179 @@ func (p *PermissionClient) CheckPermission(userID uint, permissionType string, f
 		return false, fmt.Errorf("failed to check permission: %v", err)
 	}
 
+	if p.cache != nil {
+		var validUntil time.Time
+		if resp.ValidUntil != 0 {
+			validUntil = time.Unix(resp.ValidUntil, 0)
+		}
+		p.cache.put(key, resp.HasPermission, validUntil, generation)
+	}
 	return resp.HasPermission, nil
-}
\ No newline at end of file
+}
+
+// recordHit queues a decision served from the cache to be audited, and reports whether it was
+// queued; when too many are waiting it is not, and the decision must be checked instead.
+func (p *PermissionClient) recordHit(key decisionKey, allowed bool) bool {
+	hit := &permission.CachedDecision{
+		UserId:        uint64(key.userID),
+		FileId:        key.fileID,
+		Permission:    key.permission,
+		HasPermission: allowed,
+		Time:          time.Now().UnixMilli(),
+	}
+	select {
+	case p.hits <- hit:
+		return true
+	default:
+		return false
+	}
+}
+
+// RevokePermission removes the access a user, or a group when groupID is set, has on a file.
+func (p *PermissionClient) RevokePermission(ctx context.Context, ownerID uint64, fileID string, userID, groupID uint64) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req := &permission.RevokePermissionRequest{
+		OwnerId: ownerID,
+		FileId:  fileID,
+		UserId:  userID,
+		GroupId: groupID,
+	}
+
+	if _, err := p.client.RevokePermission(ctx, req); err != nil {
+		return permissionError(err, "failed to revoke permission")
+	}
+
+	return nil
+}
+
+// ListFilePermissions returns who has been granted access to a file owned by ownerID.
+func (p *PermissionClient) ListFilePermissions(ctx context.Context, ownerID uint64, fileID string) ([]*permission.FileGrant, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	resp, err := p.client.ListFilePermissions(ctx, &permission.ListFilePermissionsRequest{OwnerId: ownerID, FileId: fileID})
+	if err != nil {
+		return nil, permissionError(err, "failed to list file permissions")
+	}
+
+	return resp.Grants, nil
+}
+
+// CreateGroup creates a group owned by ownerID.
+func (p *PermissionClient) CreateGroup(ctx context.Context, ownerID uint64, name string) (*permission.Group, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	resp, err := p.client.CreateGroup(ctx, &permission.CreateGroupRequest{OwnerId: ownerID, Name: name})
+	if err != nil {
+		return nil, permissionError(err, "failed to create group")
+	}
+
+	return resp.Group, nil
+}
+
+// AddGroupMember adds a user, or the members of another group, to a group owned by ownerID.
+func (p *PermissionClient) AddGroupMember(ctx context.Context, ownerID, groupID, userID, memberGroupID uint64) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req := &permission.GroupMemberRequest{
+		OwnerId:       ownerID,
+		GroupId:       groupID,
+		UserId:        userID,
+		MemberGroupId: memberGroupID,
+	}
+
+	if _, err := p.client.AddGroupMember(ctx, req); err != nil {
+		return permissionError(err, "failed to add group member")
+	}
+
+	return nil
+}
+
+// RemoveGroupMember removes a user or a nested group from a group owned by ownerID.
+func (p *PermissionClient) RemoveGroupMember(ctx context.Context, ownerID, groupID, userID, memberGroupID uint64) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req := &permission.GroupMemberRequest{
+		OwnerId:       ownerID,
+		GroupId:       groupID,
+		UserId:        userID,
+		MemberGroupId: memberGroupID,
+	}
+
+	if _, err := p.client.RemoveGroupMember(ctx, req); err != nil {
+		return permissionError(err, "failed to remove group member")
+	}
+
+	return nil
+}
+
+// ListGroupMembers returns the users and nested groups directly in a group.
+func (p *PermissionClient) ListGroupMembers(ctx context.Context, userID, groupID uint64) (*permission.ListGroupMembersResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	resp, err := p.client.ListGroupMembers(ctx, &permission.ListGroupMembersRequest{UserId: userID, GroupId: groupID})
+	if err != nil {
+		return nil, permissionError(err, "failed to list group members")
+	}
+
+	return resp, nil
+}
+
+// QueryAuditLog returns a page of the permission audit log. The requester's role must be allowed
+// to audit.
+func (p *PermissionClient) QueryAuditLog(ctx context.Context, req *permission.QueryAuditLogRequest) (*permission.QueryAuditLogResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	resp, err := p.client.QueryAuditLog(ctx, req)
+	if err != nil {
+		return nil, permissionError(err, "failed to query audit log")
+	}
+
+	return resp, nil
+}
+
+// PurgeUser removes a deleted user's grants, ownerships and group memberships, and every grant on
+// the files and folders they owned, on behalf of the admin who deleted them. The call is made with
+// the picker's service token, which vouches for the admin.
+func (p *PermissionClient) PurgeUser(ctx context.Context, requesterID uint64, role string, userID uint64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	resp, err := p.client.PurgeUser(ctx, &permission.PurgeUserRequest{
+		RequesterId: requesterID,
+		Role:        role,
+		UserId:      userID,
+	})
+	if err != nil {
+		return 0, permissionError(err, "failed to purge user permissions")
+	}
+
+	return resp.Removed, nil
+}
+
+// permissionError converts a gRPC error from the permission service into an API error with a
+// matching HTTP status, so that handlers can report it with pkg.HandleError.
+func permissionError(err error, message string) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return fmt.Errorf("%s: %v", message, err)
+	}
+
+	switch st.Code() {
+	case codes.InvalidArgument:
+		return pkg.BadRequestError(st.Message())
+	case codes.NotFound:
+		return pkg.NotFoundError(st.Message())
+	case codes.PermissionDenied:
+		return pkg.NewAPIError(http.StatusForbidden, st.Message())
+	default:
+		return fmt.Errorf("%s: %v", message, err)
+	}
+}
@@ -0,0 +1,274 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"file-picker-service/internal/db"
+	"file-picker-service/internal/models"
+	"file-picker-service/internal/pkg"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	shareTokenBytes      = 32               // Random bytes in a share link token
+	maxLinkPasswordFails = 5                // Wrong passwords from a client IP before it is locked out
+	linkPasswordLockout  = 15 * time.Minute // How long a link refuses passwords from a locked out client
+)
+
+// ShareLinkRequest describes a share link to create. Exactly one of FileID and FolderID is set.
+type ShareLinkRequest struct {
+	FileID       string     `json:"file_id"`       // File to share
+	FolderID     string     `json:"folder_id"`     // Or: picker folder whose files are shared
+	Permission   string     `json:"permission"`    // "read" (default) or "download"
+	Password     string     `json:"password"`      // Optional password required to open the link
+	ExpiresAt    *time.Time `json:"expires_at"`    // Optional RFC 3339 time at which the link expires
+	MaxDownloads int        `json:"max_downloads"` // Optional limit on the files served, 0 for none
+}
+
+// ShareLinkService manages links that give access to files without signing in.
+type ShareLinkService struct {
+	permissionsClient PermissionClient
+}
+
+// NewShareLinkService creates a new ShareLinkService.
+func NewShareLinkService(permissionsClient PermissionClient) *ShareLinkService {
+	return &ShareLinkService{permissionsClient: permissionsClient}
+}
+
+// CreateLink creates a share link owned by the user and returns it along with its token, which
+// is not stored and cannot be retrieved later. Sharing a file requires the permissions the link
+// needs to serve it, see linkPermissions; folders can only be shared by their owner.
+func (s *ShareLinkService) CreateLink(ctx context.Context, ownerID uint, req ShareLinkRequest) (*models.ShareLink, string, error) {
+	if (req.FileID == "") == (req.FolderID == "") {
+		return nil, "", pkg.BadRequestError("exactly one of file_id and folder_id is required")
+	}
+	if req.Permission == "" {
+		req.Permission = models.ShareLinkRead
+	}
+	if req.Permission != models.ShareLinkRead && req.Permission != models.ShareLinkDownload {
+		return nil, "", pkg.BadRequestError("permission must be read or download")
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, "", pkg.BadRequestError("expires_at must be in the future")
+	}
+	if req.MaxDownloads < 0 {
+		return nil, "", pkg.BadRequestError("max_downloads must not be negative")
+	}
+
+	if req.FileID != "" {
+		for _, permissionType := range linkPermissions(&models.ShareLink{Permission: req.Permission}) {
+			allowed, err := s.permissionsClient.CheckPermission(ctx, ownerID, permissionType, req.FileID)
+			if err != nil {
+				return nil, "", err
+			}
+			if !allowed {
+				return nil, "", pkg.NewAPIError(http.StatusForbidden, "user may not share this file by link")
+			}
+		}
+	} else {
+		files, err := models.ListFilesInFolder(db.DB, ownerID, req.FolderID)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(files) == 0 {
+			return nil, "", pkg.NotFoundError("folder not found")
+		}
+	}
+
+	token, tokenHash, err := newShareToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	link := &models.ShareLink{
+		TokenHash:    tokenHash,
+		OwnerID:      ownerID,
+		FileID:       req.FileID,
+		FolderID:     req.FolderID,
+		Permission:   req.Permission,
+		ExpiresAt:    req.ExpiresAt,
+		MaxDownloads: req.MaxDownloads,
+	}
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, "", err
+		}
+		link.PasswordHash = string(hash)
+	}
+
+	if err := models.CreateShareLink(db.DB, link); err != nil {
+		return nil, "", err
+	}
+
+	pkg.Logger.Infof("User %d created share link %d", ownerID, link.ID)
+	return link, token, nil
+}
+
+// ListLinks lists the share links the user created.
+func (s *ShareLinkService) ListLinks(ownerID uint) ([]models.ShareLink, error) {
+	return models.ListShareLinks(db.DB, ownerID)
+}
+
+// RevokeLink deletes a share link the user created. The link stops working immediately.
+func (s *ShareLinkService) RevokeLink(ownerID uint, linkID uint) error {
+	deleted, err := models.DeleteShareLink(db.DB, ownerID, linkID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return pkg.NotFoundError("share link not found")
+	}
+
+	pkg.Logger.Infof("User %d revoked share link %d", ownerID, linkID)
+	return nil
+}
+
+// OpenLink looks up the link for a token, checking that it has not expired and that the
+// password, if the link has one, is correct. After maxLinkPasswordFails wrong passwords from a
+// client IP, the link refuses every password from it for linkPasswordLockout, so that passwords
+// cannot be guessed and guessing does not lock out the link's other recipients.
+func (s *ShareLinkService) OpenLink(token, password, clientIP string) (*models.ShareLink, error) {
+	link, err := models.GetShareLinkByTokenHash(db.DB, hashShareToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, pkg.NotFoundError("share link not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if link.Expired(time.Now()) {
+		return nil, pkg.NewAPIError(http.StatusGone, "share link has expired")
+	}
+	if link.PasswordHash == "" {
+		return link, nil
+	}
+
+	now := time.Now()
+	locked, err := models.ShareLinkPasswordLocked(db.DB, link.ID, clientIP, now)
+	if err != nil {
+		return nil, err
+	}
+	if locked {
+		return nil, pkg.NewAPIError(http.StatusTooManyRequests, "too many incorrect passwords, try again later")
+	}
+	if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+		if password != "" {
+			if err := models.RecordShareLinkPasswordFailure(db.DB, link.ID, clientIP, maxLinkPasswordFails, now.Add(linkPasswordLockout)); err != nil {
+				return nil, err
+			}
+		}
+		return nil, pkg.NewAPIError(http.StatusUnauthorized, "share link password is missing or incorrect")
+	}
+	if err := models.ResetShareLinkPasswordFailures(db.DB, link.ID, clientIP); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// LinkFiles lists the files a folder link gives access to: the files in the folder that the
+// link's owner may still share and serve as the link does. Links are opened without signing in,
+// so the checks are made for the owner with the picker's service token.
+func (s *ShareLinkService) LinkFiles(ctx context.Context, link *models.ShareLink) ([]models.File, error) {
+	files, err := models.ListFilesInFolder(db.DB, link.OwnerID, link.FolderID)
+	if err != nil || len(files) == 0 {
+		return files, err
+	}
+
+	fileIDs := make([]string, len(files))
+	for i, file := range files {
+		fileIDs[i] = file.ID
+	}
+	visible := files
+	for _, permissionType := range linkPermissions(link) {
+		allowed, err := s.permissionsClient.BatchCheckPermission(ctx, link.OwnerID, permissionType, fileIDs, "")
+		if err != nil {
+			return nil, err
+		}
+		kept := visible[:0]
+		for _, file := range visible {
+			if allowed[file.ID] {
+				kept = append(kept, file)
+			}
+		}
+		visible = kept
+	}
+	return visible, nil
+}
+
+// OpenLinkFile returns a file served through a link, counting it against the link's download
+// limit. For file links fileID may be empty; for folder links it names a file in the folder.
+// Access ends when the link's owner can no longer share the file or serve it as the link does.
+func (s *ShareLinkService) OpenLinkFile(ctx context.Context, link *models.ShareLink, fileID string) (*models.File, error) {
+	if link.FileID != "" {
+		if fileID != "" && fileID != link.FileID {
+			return nil, pkg.NotFoundError("file not found")
+		}
+		fileID = link.FileID
+	}
+
+	file, err := models.GetFile(db.DB, fileID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, pkg.NotFoundError("file not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if link.FolderID != "" && (file.FolderID != link.FolderID || file.OwnerID != link.OwnerID) {
+		return nil, pkg.NotFoundError("file not found")
+	}
+
+	for _, permissionType := range linkPermissions(link) {
+		allowed, err := s.permissionsClient.CheckPermission(ctx, link.OwnerID, permissionType, file.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, pkg.NotFoundError("file not found")
+		}
+	}
+
+	counted, err := models.CountShareLinkDownload(db.DB, link.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !counted {
+		return nil, pkg.NewAPIError(http.StatusGone, "share link download limit reached")
+	}
+	return file, nil
+}
+
+// linkPermissions returns the permissions the owner of a link must still hold on a file for the
+// link to serve it. Read links show the file inline, but its content still leaves the system, so
+// every link also needs the download permission.
+func linkPermissions(link *models.ShareLink) []string {
+	if link.Permission == models.ShareLinkDownload {
+		return []string{"share", models.ShareLinkDownload}
+	}
+	return []string{"share", link.Permission, models.ShareLinkDownload}
+}
+
+// newShareToken returns a random share link token and the hash under which it is stored.
+func newShareToken() (string, string, error) {
+	raw := make([]byte, shareTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashShareToken(token), nil
+}
+
+// hashShareToken returns the hash under which a share link token is stored.
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,368 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"file-picker-service/internal/db"
+	"file-picker-service/internal/models"
+	"file-picker-service/internal/pkg"
+	"file-picker-service/proto/generated/filedownloader"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SyncService keeps picker folders and cloud folders in step. Each reconciliation reads the
+// provider change feed from the stored cursor, applies remote changes locally, then pushes local
+// changes to the provider. When a file changed on both sides the remote version is imported as a
+// conflicted copy so that neither edit is lost.
+type SyncService struct {
+	fileService        *FileService
+	downloaderClient   DownloaderClient
+	notificationClient NotificationClient
+}
+
+// NewSyncService creates a new SyncService on top of the file catalog.
+func NewSyncService(fileService *FileService, downloaderClient DownloaderClient, notificationClient NotificationClient) *SyncService {
+	return &SyncService{
+		fileService:        fileService,
+		downloaderClient:   downloaderClient,
+		notificationClient: notificationClient,
+	}
+}
+
+// CreateBinding binds a picker folder owned by the user to a cloud folder. Syncing adds files to
+// the folder and copies its files out of the system, so it requires the write permission and the
+// download permission on every file already in the folder.
+func (s *SyncService) CreateBinding(ctx context.Context, userID uint, role string, folderID, provider, remoteFolderID, authToken string) (*models.SyncBinding, error) {
+	if folderID == "" || remoteFolderID == "" {
+		return nil, pkg.BadRequestError("folder_id and remote_folder_id are required")
+	}
+	if provider != "google_drive" && provider != "dropbox" {
+		return nil, pkg.BadRequestError(fmt.Sprintf("unsupported provider: %s", provider))
+	}
+
+	hasPermission, err := s.fileService.permissionsClient.CheckGlobalPermission(ctx, userID, role, "write")
+	if err != nil {
+		return nil, err
+	}
+	if !hasPermission {
+		return nil, pkg.NewAPIError(http.StatusForbidden, "user does not have permission to sync folders")
+	}
+
+	files, err := models.ListFilesInFolder(db.DB, userID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	downloadable, err := s.downloadableFiles(ctx, userID, files)
+	if err != nil {
+		return nil, err
+	}
+	if len(downloadable) != len(files) {
+		return nil, pkg.NewAPIError(http.StatusForbidden, "user does not have permission to download every file in the folder")
+	}
+
+	binding := &models.SyncBinding{
+		OwnerID:        userID,
+		FolderID:       folderID,
+		Provider:       provider,
+		RemoteFolderID: remoteFolderID,
+		AuthToken:      models.SealedToken(authToken),
+	}
+	if err := models.CreateSyncBinding(db.DB, binding); err != nil {
+		return nil, err
+	}
+
+	return binding, nil
+}
+
+// GetBinding returns the status of a folder binding owned by the user.
+func (s *SyncService) GetBinding(userID uint, bindingID uint) (*models.SyncBinding, error) {
+	binding, err := models.GetSyncBinding(db.DB, userID, bindingID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, pkg.NotFoundError("sync binding not found")
+	}
+	return binding, err
+}
+
+// Start reconciles every binding on the given interval until stop is closed.
+func (s *SyncService) Start(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.SyncAll()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// SyncAll reconciles every folder binding, recording failures on the binding itself.
+func (s *SyncService) SyncAll() {
+	bindings, err := models.ListSyncBindings(db.DB)
+	if err != nil {
+		pkg.Logger.Errorf("Failed to list sync bindings: %v", err)
+		return
+	}
+
+	for i := range bindings {
+		if err := s.Sync(&bindings[i]); err != nil {
+			pkg.Logger.Errorf("Failed to sync folder %s with %s: %v", bindings[i].FolderID, bindings[i].Provider, err)
+		}
+	}
+}
+
+// Sync runs a single two-way reconciliation for a binding.
+func (s *SyncService) Sync(binding *models.SyncBinding) error {
+	binding.Status = models.SyncStatusSyncing
+	binding.Imported, binding.Uploaded, binding.Deleted, binding.Conflicts = 0, 0, 0, 0
+	if err := models.SaveSyncBinding(db.DB, binding); err != nil {
+		return err
+	}
+
+	cursor, err := s.reconcile(binding)
+	if err != nil {
+		binding.Status = models.SyncStatusError
+		binding.LastError = err.Error()
+		if saveErr := models.SaveSyncBinding(db.DB, binding); saveErr != nil {
+			pkg.Logger.Errorf("Failed to record sync error: %v", saveErr)
+		}
+		return err
+	}
+
+	now := time.Now()
+	binding.Cursor = cursor
+	binding.Status = models.SyncStatusIdle
+	binding.LastError = ""
+	binding.LastSyncedAt = &now
+	if err := models.SaveSyncBinding(db.DB, binding); err != nil {
+		return err
+	}
+
+	if binding.Conflicts > 0 {
+		message := fmt.Sprintf("%d conflicting change(s) in folder %s were kept as conflicted copies.", binding.Conflicts, binding.FolderID)
+		if err := s.notificationClient.SendNotification(binding.OwnerID, message); err != nil {
+			pkg.Logger.Warnf("Failed to notify user %d about sync conflicts: %v", binding.OwnerID, err)
+		}
+	}
+
+	return nil
+}
+
+// reconcile applies remote changes locally, then pushes local changes, and returns the cursor the
+// next reconciliation should resume from. The cursor is only advanced once both passes succeed.
+func (s *SyncService) reconcile(binding *models.SyncBinding) (string, error) {
+	changes, err := s.downloaderClient.ListRemoteChanges(binding.Provider, string(binding.AuthToken), binding.RemoteFolderID, binding.Cursor)
+	if err != nil {
+		return "", err
+	}
+
+	files, err := models.ListFilesInFolder(db.DB, binding.OwnerID, binding.FolderID)
+	if err != nil {
+		return "", err
+	}
+
+	byRemoteID := make(map[string]*models.File)
+	byName := make(map[string]*models.File)
+	for i := range files {
+		if files[i].Provider != binding.Provider {
+			continue
+		}
+		if files[i].RemoteID != "" {
+			byRemoteID[files[i].RemoteID] = &files[i]
+		}
+		byName[strings.ToLower(files[i].FileName)] = &files[i]
+	}
+
+	for _, change := range changes.Changes {
+		if change.IsFolder {
+			continue
+		}
+
+		local := byRemoteID[change.RemoteId]
+		if local == nil && change.Deleted {
+			// Some providers only report deletions by path, so fall back to the file name
+			local = byName[strings.ToLower(change.Name)]
+		}
+
+		if err := s.applyRemoteChange(binding, change, local); err != nil {
+			return "", err
+		}
+	}
+
+	// Re-read the folder so rebased files and conflicted copies are pushed in the same pass
+	files, err = models.ListFilesInFolder(db.DB, binding.OwnerID, binding.FolderID)
+	if err != nil {
+		return "", err
+	}
+	var modified []models.File
+	for _, file := range files {
+		if !file.IsModifiedSinceSync() {
+			continue
+		}
+		if file.Provider != "" && file.Provider != binding.Provider {
+			// Imported from a different provider; it belongs to that provider's copy
+			continue
+		}
+		modified = append(modified, file)
+	}
+
+	// Uploading copies a file out of the system, so files the owner may no longer download stay
+	// local. Reconciliation runs outside any request, so the check is made with the service token.
+	pushed, err := s.downloadableFiles(context.Background(), binding.OwnerID, modified)
+	if err != nil {
+		return "", err
+	}
+	if skipped := len(modified) - len(pushed); skipped > 0 {
+		pkg.Logger.Warnf("Not uploading %d file(s) of folder %s that user %d may not download", skipped, binding.FolderID, binding.OwnerID)
+	}
+	for i := range pushed {
+		if err := s.pushLocalChange(binding, &pushed[i]); err != nil {
+			return "", err
+		}
+	}
+
+	return changes.Cursor, nil
+}
+
+// applyRemoteChange brings a single remote change into the picker folder.
+func (s *SyncService) applyRemoteChange(binding *models.SyncBinding, change *filedownloader.RemoteChange, local *models.File) error {
+	switch {
+	case change.Deleted:
+		if local == nil {
+			return nil
+		}
+		if local.IsModifiedSinceSync() {
+			// Keep the local edit; it is uploaded again as a new remote file
+			binding.Conflicts++
+			return models.RebaseFile(db.DB, local.ID, local.FileName, "", "")
+		}
+		binding.Deleted++
+		return models.DeleteFile(db.DB, local.ID)
+
+	case local == nil:
+		_, err := s.importRemoteFile(binding, change, change.Name)
+		if err == nil {
+			binding.Imported++
+		}
+		return err
+
+	case local.RemoteRevision == change.Revision:
+		// Our own upload, or a change we have already seen
+		return nil
+
+	case !local.IsModifiedSinceSync():
+		download, err := s.downloaderClient.DownloadFile(binding.OwnerID, change.RemoteId, binding.Provider, string(binding.AuthToken))
+		if err != nil {
+			return err
+		}
+		if err := models.UpdateImportedRevision(db.DB, local.ID, download.FilePath, download.Revision); err != nil {
+			return err
+		}
+		binding.Imported++
+		return models.MarkFileSynced(db.DB, local.ID, binding.Provider, change.RemoteId, download.Revision, time.Now())
+
+	default:
+		// Both sides changed: import the remote version as a conflicted copy and rebase the local
+		// file on the latest remote revision so the local edit overwrites it on the next upload
+		if _, err := s.importRemoteFile(binding, change, conflictedCopyName(change.Name)); err != nil {
+			return err
+		}
+		binding.Conflicts++
+		return models.RebaseFile(db.DB, local.ID, local.FileName, change.RemoteId, change.Revision)
+	}
+}
+
+// importRemoteFile downloads a remote file into the picker folder under the given name. Conflicted
+// copies are not linked to the remote file so that they are uploaded as new files.
+func (s *SyncService) importRemoteFile(binding *models.SyncBinding, change *filedownloader.RemoteChange, name string) (*models.File, error) {
+	download, err := s.downloaderClient.DownloadFile(binding.OwnerID, change.RemoteId, binding.Provider, string(binding.AuthToken))
+	if err != nil {
+		return nil, err
+	}
+
+	file := &models.File{
+		ID:       uuid.NewString(),
+		FileName: name,
+		FilePath: download.FilePath,
+		OwnerID:  binding.OwnerID,
+		FolderID: binding.FolderID,
+		Provider: binding.Provider,
+	}
+	if name == change.Name {
+		file.RemoteID = change.RemoteId
+		file.RemoteRevision = download.Revision
+		file.SyncedAt = time.Now()
+	}
+
+	// Reconciliation runs outside any request, so the grant is made with the service token
+	if err := s.fileService.registerFile(context.Background(), file); err != nil {
+		return nil, err
+	}
+	if file.RemoteID != "" {
+		return file, models.MarkFileSynced(db.DB, file.ID, binding.Provider, file.RemoteID, file.RemoteRevision, file.SyncedAt)
+	}
+	return file, nil
+}
+
+// pushLocalChange uploads a new or modified local file to the cloud folder.
+func (s *SyncService) pushLocalChange(binding *models.SyncBinding, file *models.File) error {
+	upload, err := s.downloaderClient.UploadFile(binding.Provider, string(binding.AuthToken), binding.RemoteFolderID,
+		file.FileName, file.FilePath, file.RemoteID, file.RemoteRevision)
+	if err != nil {
+		return err
+	}
+
+	if upload.Conflict {
+		// The remote file changed after the change feed was read and the upload was stored as a
+		// separate copy; follow that copy locally and leave the remote edit to the next pass
+		binding.Conflicts++
+		if err := models.RebaseFile(db.DB, file.ID, upload.FileName, upload.RemoteId, upload.Revision); err != nil {
+			return err
+		}
+	} else {
+		binding.Uploaded++
+	}
+
+	return models.MarkFileSynced(db.DB, file.ID, binding.Provider, upload.RemoteId, upload.Revision, time.Now())
+}
+
+// downloadableFiles returns the files the user has the download permission on.
+func (s *SyncService) downloadableFiles(ctx context.Context, userID uint, files []models.File) ([]models.File, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	fileIDs := make([]string, len(files))
+	for i, file := range files {
+		fileIDs[i] = file.ID
+	}
+	allowed, err := s.fileService.permissionsClient.BatchCheckPermission(ctx, userID, "download", fileIDs, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var downloadable []models.File
+	for _, file := range files {
+		if allowed[file.ID] {
+			downloadable = append(downloadable, file)
+		}
+	}
+	return downloadable, nil
+}
+
+// conflictedCopyName derives the name under which a conflicting version of a file is kept,
+// following Dropbox's "name (conflicted copy).ext" convention.
+func conflictedCopyName(fileName string) string {
+	ext := filepath.Ext(fileName)
+	return fmt.Sprintf("%s (conflicted copy)%s", strings.TrimSuffix(fileName, ext), ext)
+}
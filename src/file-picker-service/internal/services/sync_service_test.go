@@ -0,0 +1,348 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"file-picker-service/internal/models"
+	"file-picker-service/internal/testutil"
+	"file-picker-service/proto/generated/filedownloader"
+	"file-picker-service/proto/generated/notification"
+	"file-picker-service/proto/generated/permission"
+
+	"google.golang.org/grpc"
+	"gorm.io/gorm"
+)
+
+// syncTest is a SyncService connected to fake Permission, File-Downloader and Notification
+// services, with a binding of user 7's folder "docs" to a Dropbox folder.
+type syncTest struct {
+	db            *gorm.DB
+	sync          *SyncService
+	downloader    *testutil.Downloader
+	notifications *testutil.Notifications
+	binding       *models.SyncBinding
+}
+
+func newSyncTest(t *testing.T) *syncTest {
+	t.Helper()
+	st := &syncTest{db: testutil.OpenDB(t), downloader: testutil.NewDownloader(), notifications: &testutil.Notifications{}}
+	addr := testutil.Serve(t, func(s *grpc.Server) {
+		permission.RegisterPermissionServiceServer(s, testutil.NewPermissions())
+		filedownloader.RegisterFileDownloaderServiceServer(s, st.downloader)
+		notification.RegisterNotificationServiceServer(s, st.notifications)
+	})
+
+	permissionsClient, err := NewPermissionClient(addr, "picker-key", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { permissionsClient.Close() })
+	downloaderClient, err := NewDownloaderClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	notificationClient := NewNotificationClient(conn)
+
+	fileService := NewFileService(*permissionsClient, *downloaderClient, TransformationClient{}, *notificationClient, t.TempDir())
+	st.sync = NewSyncService(fileService, *downloaderClient, *notificationClient)
+
+	st.binding = &models.SyncBinding{OwnerID: 7, FolderID: "docs", Provider: "dropbox", RemoteFolderID: "/docs", AuthToken: "cloud-token", Cursor: "c1"}
+	if err := models.CreateSyncBinding(st.db, st.binding); err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+// addFile stores a file of the synced folder. Files with a remote ID were synced at the given
+// revision, and were edited locally since if modified is set.
+func (st *syncTest) addFile(t *testing.T, id, name, remoteID, revision string, modified bool) {
+	t.Helper()
+	file := &models.File{ID: id, FileName: name, FilePath: "./uploads/" + id, OwnerID: 7, FolderID: "docs"}
+	if err := models.SaveFileMetadata(st.db, file); err != nil {
+		t.Fatal(err)
+	}
+	if remoteID == "" {
+		return
+	}
+	syncedAt := time.Now().Add(-time.Hour)
+	if err := models.MarkFileSynced(st.db, id, "dropbox", remoteID, revision, syncedAt); err != nil {
+		t.Fatal(err)
+	}
+	if modified {
+		if err := st.db.Model(&models.File{}).Where("id = ?", id).UpdateColumn("updated_at", time.Now()).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// files returns the files of the synced folder by name.
+func (st *syncTest) files(t *testing.T) map[string]models.File {
+	t.Helper()
+	files, err := models.ListFilesInFolder(st.db, 7, "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]models.File)
+	for _, file := range files {
+		byName[file.FileName] = file
+	}
+	return byName
+}
+
+func TestSyncAppliesRemoteChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		local   func(t *testing.T, st *syncTest)
+		changes []*filedownloader.RemoteChange
+		check   func(t *testing.T, st *syncTest)
+	}{
+		{
+			name:    "deletion of a file never imported",
+			changes: []*filedownloader.RemoteChange{{RemoteId: "r1", Name: "notes.txt", Deleted: true}},
+			check: func(t *testing.T, st *syncTest) {
+				if files := st.files(t); len(files) != 0 || st.binding.Deleted != 0 {
+					t.Errorf("Expected nothing to change, got %v and %d deleted", files, st.binding.Deleted)
+				}
+			},
+		},
+		{
+			name: "deletion of an unmodified file",
+			local: func(t *testing.T, st *syncTest) {
+				st.addFile(t, "f1", "notes.txt", "r1", "v1", false)
+			},
+			changes: []*filedownloader.RemoteChange{{RemoteId: "r1", Name: "notes.txt", Deleted: true}},
+			check: func(t *testing.T, st *syncTest) {
+				if files := st.files(t); len(files) != 0 || st.binding.Deleted != 1 {
+					t.Errorf("Expected the file to be deleted, got %v and %d deleted", files, st.binding.Deleted)
+				}
+			},
+		},
+		{
+			name: "deletion reported by path",
+			local: func(t *testing.T, st *syncTest) {
+				st.addFile(t, "f1", "Notes.txt", "id:r1", "v1", false)
+			},
+			changes: []*filedownloader.RemoteChange{{RemoteId: "/docs/notes.txt", Name: "notes.txt", Deleted: true}},
+			check: func(t *testing.T, st *syncTest) {
+				if files := st.files(t); len(files) != 0 || st.binding.Deleted != 1 {
+					t.Errorf("Expected the file to be deleted, got %v and %d deleted", files, st.binding.Deleted)
+				}
+			},
+		},
+		{
+			name: "deletion of a modified file",
+			local: func(t *testing.T, st *syncTest) {
+				st.addFile(t, "f1", "notes.txt", "r1", "v1", true)
+			},
+			changes: []*filedownloader.RemoteChange{{RemoteId: "r1", Name: "notes.txt", Deleted: true}},
+			check: func(t *testing.T, st *syncTest) {
+				// The local edit is kept and uploaded as a new remote file
+				file := st.files(t)["notes.txt"]
+				if file.ID != "f1" || file.RemoteID != "new-1" || file.RemoteRevision != "up-1" {
+					t.Errorf("Expected the file to be uploaded again, got %+v", file)
+				}
+				uploads := st.downloader.Uploads()
+				if len(uploads) != 1 || uploads[0].RemoteId != "" || uploads[0].FilePath != "./uploads/f1" {
+					t.Errorf("Expected the file to be uploaded as a new file, got %v", uploads)
+				}
+				if st.binding.Conflicts != 1 || st.binding.Uploaded != 1 || st.binding.Deleted != 0 {
+					t.Errorf("Unexpected counts %+v", st.binding)
+				}
+			},
+		},
+		{
+			name:    "new remote file",
+			changes: []*filedownloader.RemoteChange{{RemoteId: "r1", Name: "notes.txt", Revision: "v1"}},
+			check: func(t *testing.T, st *syncTest) {
+				file := st.files(t)["notes.txt"]
+				if file.RemoteID != "r1" || file.RemoteRevision != "v1" || file.FilePath != "./downloads/r1-v1" || file.Provider != "dropbox" {
+					t.Errorf("Expected the remote file to be imported, got %+v", file)
+				}
+				if file.IsModifiedSinceSync() {
+					t.Error("Expected the imported file to be in sync")
+				}
+				if uploads := st.downloader.Uploads(); len(uploads) != 0 || st.binding.Imported != 1 {
+					t.Errorf("Expected one import and no upload, got %d imported and %v", st.binding.Imported, uploads)
+				}
+			},
+		},
+		{
+			name: "revision already seen",
+			local: func(t *testing.T, st *syncTest) {
+				st.addFile(t, "f1", "notes.txt", "r1", "v1", false)
+			},
+			changes: []*filedownloader.RemoteChange{{RemoteId: "r1", Name: "notes.txt", Revision: "v1"}},
+			check: func(t *testing.T, st *syncTest) {
+				if file := st.files(t)["notes.txt"]; file.FilePath != "./uploads/f1" || file.RemoteRevision != "v1" {
+					t.Errorf("Expected the file to be left alone, got %+v", file)
+				}
+				if uploads := st.downloader.Uploads(); len(uploads) != 0 || st.binding.Imported != 0 {
+					t.Errorf("Expected no import and no upload, got %d imported and %v", st.binding.Imported, uploads)
+				}
+			},
+		},
+		{
+			name: "remote edit of an unmodified file",
+			local: func(t *testing.T, st *syncTest) {
+				st.addFile(t, "f1", "notes.txt", "r1", "v1", false)
+			},
+			changes: []*filedownloader.RemoteChange{{RemoteId: "r1", Name: "notes.txt", Revision: "v2"}},
+			check: func(t *testing.T, st *syncTest) {
+				file := st.files(t)["notes.txt"]
+				if file.ID != "f1" || file.FilePath != "./downloads/r1-v2" || file.RemoteRevision != "v2" || file.IsModifiedSinceSync() {
+					t.Errorf("Expected the new revision to be downloaded, got %+v", file)
+				}
+				if uploads := st.downloader.Uploads(); len(uploads) != 0 || st.binding.Imported != 1 {
+					t.Errorf("Expected one import and no upload, got %d imported and %v", st.binding.Imported, uploads)
+				}
+			},
+		},
+		{
+			name: "edits on both sides",
+			local: func(t *testing.T, st *syncTest) {
+				st.addFile(t, "f1", "notes.txt", "r1", "v1", true)
+			},
+			changes: []*filedownloader.RemoteChange{{RemoteId: "r1", Name: "notes.txt", Revision: "v2"}},
+			check: func(t *testing.T, st *syncTest) {
+				files := st.files(t)
+				conflicted, ok := files["notes (conflicted copy).txt"]
+				if !ok || conflicted.FilePath != "./downloads/r1-v2" {
+					t.Fatalf("Expected the remote version to be kept as a conflicted copy, got %v", files)
+				}
+				// The local edit overwrites the latest remote revision, and the copy is uploaded
+				// as a new file
+				uploads := st.downloader.Uploads()
+				if len(uploads) != 2 {
+					t.Fatalf("Expected 2 uploads, got %v", uploads)
+				}
+				for _, upload := range uploads {
+					switch upload.FileName {
+					case "notes.txt":
+						if upload.RemoteId != "r1" || upload.BaseRevision != "v2" || upload.FilePath != "./uploads/f1" {
+							t.Errorf("Expected the local edit to be uploaded over revision v2, got %+v", upload)
+						}
+					case "notes (conflicted copy).txt":
+						if upload.RemoteId != "" {
+							t.Errorf("Expected the conflicted copy to be uploaded as a new file, got %+v", upload)
+						}
+					default:
+						t.Errorf("Unexpected upload %+v", upload)
+					}
+				}
+				if st.binding.Conflicts != 1 || st.binding.Uploaded != 2 {
+					t.Errorf("Unexpected counts %+v", st.binding)
+				}
+				if messages := st.notifications.Messages(); len(messages) != 1 {
+					t.Errorf("Expected the owner to be told about the conflict, got %q", messages)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newSyncTest(t)
+			if tt.local != nil {
+				tt.local(t, st)
+			}
+			st.downloader.SetChanges("c2", tt.changes...)
+
+			if err := st.sync.Sync(st.binding); err != nil {
+				t.Fatal(err)
+			}
+			if st.binding.Status != models.SyncStatusIdle {
+				t.Errorf("Expected the binding to be idle, got %s: %s", st.binding.Status, st.binding.LastError)
+			}
+			tt.check(t, st)
+		})
+	}
+}
+
+func TestSyncPushesLocalChanges(t *testing.T) {
+	t.Run("new file", func(t *testing.T) {
+		st := newSyncTest(t)
+		st.addFile(t, "f1", "notes.txt", "", "", false)
+
+		if err := st.sync.Sync(st.binding); err != nil {
+			t.Fatal(err)
+		}
+		uploads := st.downloader.Uploads()
+		if len(uploads) != 1 || uploads[0].RemoteId != "" || uploads[0].FolderId != "/docs" || uploads[0].AuthToken != "cloud-token" {
+			t.Fatalf("Expected the file to be uploaded to the cloud folder, got %v", uploads)
+		}
+		file := st.files(t)["notes.txt"]
+		if file.RemoteID != "new-1" || file.RemoteRevision != "up-1" || file.Provider != "dropbox" || file.IsModifiedSinceSync() {
+			t.Errorf("Expected the file to be in sync with the upload, got %+v", file)
+		}
+		if st.binding.Uploaded != 1 || st.binding.Conflicts != 0 {
+			t.Errorf("Unexpected counts %+v", st.binding)
+		}
+
+		// Nothing changed since, so nothing is uploaded again
+		if err := st.sync.Sync(st.binding); err != nil {
+			t.Fatal(err)
+		}
+		if uploads := st.downloader.Uploads(); len(uploads) != 1 {
+			t.Errorf("Expected no further upload, got %v", uploads)
+		}
+	})
+
+	t.Run("remote file changed during the upload", func(t *testing.T) {
+		st := newSyncTest(t)
+		st.addFile(t, "f1", "notes.txt", "r1", "v1", true)
+		st.downloader.SetUploadConflict(true)
+
+		if err := st.sync.Sync(st.binding); err != nil {
+			t.Fatal(err)
+		}
+		uploads := st.downloader.Uploads()
+		if len(uploads) != 1 || uploads[0].RemoteId != "r1" || uploads[0].BaseRevision != "v1" {
+			t.Fatalf("Expected the edit to be uploaded over revision v1, got %v", uploads)
+		}
+		// The file follows the copy the upload was kept as
+		files := st.files(t)
+		file, ok := files["notes.txt (conflicted copy)"]
+		if !ok || file.ID != "f1" || file.RemoteID != "copy-1" || file.RemoteRevision != "up-1" || file.IsModifiedSinceSync() {
+			t.Fatalf("Expected the file to follow the conflicted copy, got %v", files)
+		}
+		if st.binding.Conflicts != 1 || st.binding.Uploaded != 0 {
+			t.Errorf("Unexpected counts %+v", st.binding)
+		}
+		if messages := st.notifications.Messages(); len(messages) != 1 {
+			t.Errorf("Expected the owner to be told about the conflict, got %q", messages)
+		}
+	})
+}
+
+func TestSyncAdvancesCursor(t *testing.T) {
+	st := newSyncTest(t)
+	st.downloader.SetChanges("c2", &filedownloader.RemoteChange{RemoteId: "r1", Name: "notes.txt", Revision: "v1"})
+
+	if err := st.sync.Sync(st.binding); err != nil {
+		t.Fatal(err)
+	}
+	binding, err := models.GetSyncBinding(st.db, 7, st.binding.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binding.Cursor != "c2" || binding.Status != models.SyncStatusIdle || binding.LastSyncedAt == nil {
+		t.Errorf("Expected the stored binding to resume from c2, got %+v", binding)
+	}
+
+	// The next reconciliation resumes from the new cursor
+	st.downloader.SetChanges("c3")
+	if err := st.sync.Sync(binding); err != nil {
+		t.Fatal(err)
+	}
+	if cursors := st.downloader.Cursors(); len(cursors) != 2 || cursors[0] != "c1" || cursors[1] != "c2" {
+		t.Errorf("Expected the change feed to be read from c1, then c2, got %q", cursors)
+	}
+	if binding.Cursor != "c3" || binding.Imported != 0 {
+		t.Errorf("Expected the binding to resume from c3 with nothing imported, got %+v", binding)
+	}
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"file-picker-service/internal/db"
+	"file-picker-service/internal/models"
+	"file-picker-service/internal/pkg"
+
+	"gorm.io/gorm"
+)
+
+// UserCleanupService removes the data of users deleted in the auth-service.
+type UserCleanupService struct {
+	permissionsClient PermissionClient
+}
+
+// NewUserCleanupService creates a new UserCleanupService.
+func NewUserCleanupService(permissionsClient PermissionClient) *UserCleanupService {
+	return &UserCleanupService{permissionsClient: permissionsClient}
+}
+
+// PurgeUser removes a deleted user's files, share links and folder bindings, and has the
+// permission service drop their grants and every grant on the files and folders they owned, which
+// it finds itself. actorID and actorRole identify the admin who deleted the user. The grants go
+// first, so that a failure never leaves grants on files that no longer exist; purging a user
+// again finishes an interrupted purge.
+func (s *UserCleanupService) PurgeUser(ctx context.Context, actorID uint64, actorRole string, userID uint) error {
+	removed, err := s.permissionsClient.PurgeUser(ctx, actorID, actorRole, uint64(userID))
+	if err != nil {
+		return err
+	}
+
+	files, err := models.ListOwnedFiles(db.DB, userID)
+	if err != nil {
+		return err
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := models.DeleteUserShareLinks(tx, userID); err != nil {
+			return err
+		}
+		if err := models.DeleteUserSyncBindings(tx, userID); err != nil {
+			return err
+		}
+		return models.DeleteOwnedFiles(tx, userID)
+	})
+	if err != nil {
+		return err
+	}
+
+	// The stored content goes last, once nothing refers to it any more
+	for _, file := range files {
+		if err := os.Remove(file.FilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			pkg.Logger.Warnf("Failed to remove stored file %s of deleted user %d: %v", file.FilePath, userID, err)
+		}
+	}
+
+	pkg.Logger.Infof("Purged deleted user %d: %d files, %d permission tuples", userID, len(files), removed)
+	return nil
+}
@@ -0,0 +1,303 @@
+// Package testutil provides the test database and the fake gRPC services the picker's tests run
+// against: a Permission Service, a File-Downloader-Service and a Notification Service that answer
+// from memory and record the calls they receive.
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"file-picker-service/internal/db"
+	"file-picker-service/internal/models"
+	"file-picker-service/internal/pkg"
+	"file-picker-service/proto/generated/filedownloader"
+	"file-picker-service/proto/generated/notification"
+	"file-picker-service/proto/generated/permission"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// OpenDB points db.DB at a migrated SQLite database in a temporary directory for the rest of the
+// test, and sets the key cloud tokens are sealed with.
+func OpenDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	if pkg.Logger == nil {
+		pkg.InitLogger()
+	}
+	if err := models.SetTokenKey(bytes.Repeat([]byte{7}, 32)); err != nil {
+		t.Fatal(err)
+	}
+
+	database, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "picker.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SQLite allows one writer at a time
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := database.AutoMigrate(&models.User{}, &models.File{}, &models.SyncBinding{}, &models.ShareLink{}, &models.ShareLinkPasswordFailure{}); err != nil {
+		t.Fatal(err)
+	}
+	previous := db.DB
+	db.DB = database
+	t.Cleanup(func() { db.DB = previous })
+	return database
+}
+
+// Serve starts a gRPC server on a local port for the rest of the test, with the services register
+// adds, and returns its address.
+func Serve(t *testing.T, register func(*grpc.Server)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	register(server)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener.Addr().String()
+}
+
+// Permissions is a Permission Service that allows every check but the ones denied, and records
+// the grants it is asked to make. Its audit log holds the entries set with SetAuditLog, which
+// admins may query.
+type Permissions struct {
+	permission.UnimplementedPermissionServiceServer
+
+	mu           sync.Mutex
+	denied       map[string]bool // By permission and file ID
+	updates      []*permission.UpdatePermissionRequest
+	tokens       []string // Authorization metadata of each UpdatePermission call
+	auditLog     []*permission.AuditEntry
+	auditQueries []*permission.QueryAuditLogRequest
+}
+
+// NewPermissions creates a Permissions that allows everything.
+func NewPermissions() *Permissions {
+	return &Permissions{denied: make(map[string]bool)}
+}
+
+// Deny makes checks of a permission on a file fail.
+func (p *Permissions) Deny(permissionType, fileID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.denied[permissionType+" "+fileID] = true
+}
+
+// Updates returns the UpdatePermission requests received so far.
+func (p *Permissions) Updates() []*permission.UpdatePermissionRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*permission.UpdatePermissionRequest(nil), p.updates...)
+}
+
+// UpdateTokens returns the authorization metadata UpdatePermission calls carried, in order.
+func (p *Permissions) UpdateTokens() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.tokens...)
+}
+
+// SetAuditLog sets the entries of the audit log.
+func (p *Permissions) SetAuditLog(entries ...*permission.AuditEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.auditLog = entries
+}
+
+// AuditQueries returns the QueryAuditLog requests received so far.
+func (p *Permissions) AuditQueries() []*permission.QueryAuditLogRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*permission.QueryAuditLogRequest(nil), p.auditQueries...)
+}
+
+func (p *Permissions) allowed(permissionType, fileID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.denied[permissionType+" "+fileID]
+}
+
+func (p *Permissions) CheckPermission(ctx context.Context, req *permission.CheckPermissionRequest) (*permission.CheckPermissionResponse, error) {
+	return &permission.CheckPermissionResponse{HasPermission: p.allowed(req.Permission, req.FileId)}, nil
+}
+
+func (p *Permissions) BatchCheckPermission(ctx context.Context, req *permission.BatchCheckPermissionRequest) (*permission.BatchCheckPermissionResponse, error) {
+	results := make(map[string]bool, len(req.FileIds))
+	for _, fileID := range req.FileIds {
+		results[fileID] = p.allowed(req.Permission, fileID)
+	}
+	return &permission.BatchCheckPermissionResponse{Results: results}, nil
+}
+
+func (p *Permissions) UpdatePermission(ctx context.Context, req *permission.UpdatePermissionRequest) (*permission.UpdatePermissionResponse, error) {
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			token = values[0]
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.updates = append(p.updates, req)
+	p.tokens = append(p.tokens, token)
+	return &permission.UpdatePermissionResponse{Success: true}, nil
+}
+
+// QueryAuditLog returns a page of the whole audit log, ignoring the filters. The page token is the
+// index of the first entry of the page.
+func (p *Permissions) QueryAuditLog(ctx context.Context, req *permission.QueryAuditLogRequest) (*permission.QueryAuditLogResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.auditQueries = append(p.auditQueries, req)
+	if req.Role != "admin" {
+		return nil, status.Error(codes.PermissionDenied, "user may not query the audit log")
+	}
+
+	start := 0
+	if req.PageToken != "" {
+		var err error
+		if start, err = strconv.Atoi(req.PageToken); err != nil || start > len(p.auditLog) {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+	}
+	pageSize := int(req.PageSize)
+	if pageSize == 0 {
+		pageSize = 100
+	}
+	end := min(start+pageSize, len(p.auditLog))
+
+	resp := &permission.QueryAuditLogResponse{Entries: p.auditLog[start:end]}
+	if end < len(p.auditLog) {
+		resp.NextPageToken = strconv.Itoa(end)
+	}
+	return resp, nil
+}
+
+// Notifications is a Notification Service that records the messages it is asked to send.
+type Notifications struct {
+	notification.UnimplementedNotificationServiceServer
+
+	mu       sync.Mutex
+	messages []string
+}
+
+// Messages returns the messages sent so far.
+func (n *Notifications) Messages() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.messages...)
+}
+
+func (n *Notifications) SendNotification(ctx context.Context, req *notification.SendNotificationRequest) (*notification.SendNotificationResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.messages = append(n.messages, req.Message)
+	return &notification.SendNotificationResponse{Success: true}, nil
+}
+
+// Downloader is a File-Downloader-Service in front of a cloud folder held in memory. The change
+// feed returns the changes set with SetChanges, and uploads are recorded.
+type Downloader struct {
+	filedownloader.UnimplementedFileDownloaderServiceServer
+
+	mu             sync.Mutex
+	changes        []*filedownloader.RemoteChange
+	cursor         string
+	revisions      map[string]string // Current revision of each remote file, by remote ID
+	cursors        []string          // Cursors the change feed was read from
+	uploads        []*filedownloader.UploadFileRequest
+	uploadConflict bool
+}
+
+// NewDownloader creates a Downloader with an empty cloud folder.
+func NewDownloader() *Downloader {
+	return &Downloader{revisions: make(map[string]string)}
+}
+
+// SetChanges sets the changes the next reads of the change feed return, and the cursor they
+// return. Files changed and not deleted are downloaded at the revision of their change.
+func (d *Downloader) SetChanges(cursor string, changes ...*filedownloader.RemoteChange) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.changes, d.cursor = changes, cursor
+	for _, change := range changes {
+		if !change.Deleted {
+			d.revisions[change.RemoteId] = change.Revision
+		}
+	}
+}
+
+// SetUploadConflict makes uploads report that the remote file changed, so the upload was kept as
+// a separate copy.
+func (d *Downloader) SetUploadConflict(conflict bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.uploadConflict = conflict
+}
+
+// Cursors returns the cursors the change feed was read from.
+func (d *Downloader) Cursors() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.cursors...)
+}
+
+// Uploads returns the uploads received so far.
+func (d *Downloader) Uploads() []*filedownloader.UploadFileRequest {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*filedownloader.UploadFileRequest(nil), d.uploads...)
+}
+
+func (d *Downloader) ListRemoteChanges(ctx context.Context, req *filedownloader.ListRemoteChangesRequest) (*filedownloader.ListRemoteChangesResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cursors = append(d.cursors, req.Cursor)
+	return &filedownloader.ListRemoteChangesResponse{Changes: d.changes, Cursor: d.cursor}, nil
+}
+
+func (d *Downloader) DownloadFile(ctx context.Context, req *filedownloader.DownloadFileRequest) (*filedownloader.DownloadFileResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	revision := d.revisions[req.FileId]
+	return &filedownloader.DownloadFileResponse{
+		FilePath: "./downloads/" + req.FileId + "-" + revision,
+		FileName: req.FileId,
+		RemoteId: req.FileId,
+		Revision: revision,
+	}, nil
+}
+
+func (d *Downloader) UploadFile(ctx context.Context, req *filedownloader.UploadFileRequest) (*filedownloader.UploadFileResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.uploads = append(d.uploads, req)
+	n := strconv.Itoa(len(d.uploads))
+	if d.uploadConflict {
+		return &filedownloader.UploadFileResponse{RemoteId: "copy-" + n, FileName: req.FileName + " (conflicted copy)", Revision: "up-" + n, Conflict: true}, nil
+	}
+	remoteID := req.RemoteId
+	if remoteID == "" {
+		remoteID = "new-" + n
+	}
+	d.revisions[remoteID] = "up-" + n
+	return &filedownloader.UploadFileResponse{RemoteId: remoteID, FileName: req.FileName, Revision: "up-" + n}, nil
+}
@@ -19,7 +19,10 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	FileDownloaderService_DownloadFile_FullMethodName = "/filedownloader.FileDownloaderService/DownloadFile"
+	FileDownloaderService_DownloadFile_FullMethodName      = "/filedownloader.FileDownloaderService/DownloadFile"
+	FileDownloaderService_ListRemoteChanges_FullMethodName = "/filedownloader.FileDownloaderService/ListRemoteChanges"
+	FileDownloaderService_UploadFile_FullMethodName        = "/filedownloader.FileDownloaderService/UploadFile"
+	FileDownloaderService_ExportFile_FullMethodName        = "/filedownloader.FileDownloaderService/ExportFile"
 )
 
 // FileDownloaderServiceClient is the client API for FileDownloaderService service.
@@ -29,6 +32,9 @@ const (
 // File Downloader Service definition
 type FileDownloaderServiceClient interface {
 	DownloadFile(ctx context.Context, in *DownloadFileRequest, opts ...grpc.CallOption) (*DownloadFileResponse, error)
+	ListRemoteChanges(ctx context.Context, in *ListRemoteChangesRequest, opts ...grpc.CallOption) (*ListRemoteChangesResponse, error)
+	UploadFile(ctx context.Context, in *UploadFileRequest, opts ...grpc.CallOption) (*UploadFileResponse, error)
+	ExportFile(ctx context.Context, in *ExportFileRequest, opts ...grpc.CallOption) (*ExportFileResponse, error)
 }
 
 type fileDownloaderServiceClient struct {
@@ -49,6 +55,36 @@ func (c *fileDownloaderServiceClient) DownloadFile(ctx context.Context, in *Down
 	return out, nil
 }
 
+func (c *fileDownloaderServiceClient) ListRemoteChanges(ctx context.Context, in *ListRemoteChangesRequest, opts ...grpc.CallOption) (*ListRemoteChangesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListRemoteChangesResponse)
+	err := c.cc.Invoke(ctx, FileDownloaderService_ListRemoteChanges_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileDownloaderServiceClient) UploadFile(ctx context.Context, in *UploadFileRequest, opts ...grpc.CallOption) (*UploadFileResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UploadFileResponse)
+	err := c.cc.Invoke(ctx, FileDownloaderService_UploadFile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileDownloaderServiceClient) ExportFile(ctx context.Context, in *ExportFileRequest, opts ...grpc.CallOption) (*ExportFileResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ExportFileResponse)
+	err := c.cc.Invoke(ctx, FileDownloaderService_ExportFile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // FileDownloaderServiceServer is the server API for FileDownloaderService service.
 // All implementations must embed UnimplementedFileDownloaderServiceServer
 // for forward compatibility.
@@ -56,6 +92,9 @@ func (c *fileDownloaderServiceClient) DownloadFile(ctx context.Context, in *Down
 // File Downloader Service definition
 type FileDownloaderServiceServer interface {
 	DownloadFile(context.Context, *DownloadFileRequest) (*DownloadFileResponse, error)
+	ListRemoteChanges(context.Context, *ListRemoteChangesRequest) (*ListRemoteChangesResponse, error)
+	UploadFile(context.Context, *UploadFileRequest) (*UploadFileResponse, error)
+	ExportFile(context.Context, *ExportFileRequest) (*ExportFileResponse, error)
 	mustEmbedUnimplementedFileDownloaderServiceServer()
 }
 
@@ -69,6 +108,15 @@ type UnimplementedFileDownloaderServiceServer struct{}
 func (UnimplementedFileDownloaderServiceServer) DownloadFile(context.Context, *DownloadFileRequest) (*DownloadFileResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DownloadFile not implemented")
 }
+func (UnimplementedFileDownloaderServiceServer) ListRemoteChanges(context.Context, *ListRemoteChangesRequest) (*ListRemoteChangesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRemoteChanges not implemented")
+}
+func (UnimplementedFileDownloaderServiceServer) UploadFile(context.Context, *UploadFileRequest) (*UploadFileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UploadFile not implemented")
+}
+func (UnimplementedFileDownloaderServiceServer) ExportFile(context.Context, *ExportFileRequest) (*ExportFileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExportFile not implemented")
+}
 func (UnimplementedFileDownloaderServiceServer) mustEmbedUnimplementedFileDownloaderServiceServer() {}
 func (UnimplementedFileDownloaderServiceServer) testEmbeddedByValue()                               {}
 
@@ -108,6 +156,60 @@ func _FileDownloaderService_DownloadFile_Handler(srv interface{}, ctx context.Co
 	return interceptor(ctx, in, info, handler)
 }
 
+func _FileDownloaderService_ListRemoteChanges_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRemoteChangesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileDownloaderServiceServer).ListRemoteChanges(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileDownloaderService_ListRemoteChanges_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileDownloaderServiceServer).ListRemoteChanges(ctx, req.(*ListRemoteChangesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileDownloaderService_UploadFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UploadFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileDownloaderServiceServer).UploadFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileDownloaderService_UploadFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileDownloaderServiceServer).UploadFile(ctx, req.(*UploadFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileDownloaderService_ExportFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExportFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileDownloaderServiceServer).ExportFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileDownloaderService_ExportFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileDownloaderServiceServer).ExportFile(ctx, req.(*ExportFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // FileDownloaderService_ServiceDesc is the grpc.ServiceDesc for FileDownloaderService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -119,6 +221,18 @@ var FileDownloaderService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DownloadFile",
 			Handler:    _FileDownloaderService_DownloadFile_Handler,
 		},
+		{
+			MethodName: "ListRemoteChanges",
+			Handler:    _FileDownloaderService_ListRemoteChanges_Handler,
+		},
+		{
+			MethodName: "UploadFile",
+			Handler:    _FileDownloaderService_UploadFile_Handler,
+		},
+		{
+			MethodName: "ExportFile",
+			Handler:    _FileDownloaderService_ExportFile_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "file_downloader.proto",
@@ -12,6 +12,8 @@ import (
 	"notification-service/proto/generated/notification"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
+	"shared/authn"
+	"shared/authn/grpcauth"
 )
 
 func main() {
@@ -40,8 +42,28 @@ func main() {
 		webSocketHandler.ServeWebSocket(w, r)
 	})
 
+	// Verify the access tokens of callers, which handlers can read with authn.FromContext
+	var serverOptions []grpc.ServerOption
+	if cfg.AuthJWKSURL != "" {
+		verifier, err := authn.NewVerifier(authn.Config{
+			JWKSURL:            cfg.AuthJWKSURL,
+			RevokedSessionsURL: cfg.AuthRevokedSessionsURL,
+			TokenURL:           cfg.AuthTokenURL,
+			ServiceAPIKey:      cfg.ServiceAPIKey,
+			Issuer:             cfg.JWTIssuer,
+			Audience:           cfg.JWTAudience,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create token verifier: %v", err)
+		}
+		defer verifier.Close()
+		serverOptions = grpcauth.ServerOptions(verifier, grpcauth.Options{AllowAnonymous: !cfg.JWTRequired, Exempt: []string{"/grpc.reflection."}})
+	} else {
+		log.Println("AUTH_JWKS_URL is not set, access tokens are not verified")
+	}
+
 	// Set up the gRPC server
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(serverOptions...)
 
 	// Register the NotificationHandler (gRPC service) with the server
 	notification.RegisterNotificationServiceServer(grpcServer, &handlers.NotificationHandler{
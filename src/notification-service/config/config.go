@@ -11,6 +11,14 @@ import (
 type Config struct {
 	Port     string // WebSocket server port
 	GRPCPort string // gRPC server port
+
+	AuthJWKSURL            string // The auth-service JWKS; calls are not authenticated if empty
+	AuthRevokedSessionsURL string // The auth-service revoked sessions list, optional
+	AuthTokenURL           string // The auth-service token endpoint, where ServiceAPIKey is exchanged
+	ServiceAPIKey          string // API key of the service's account, required to fetch revoked sessions
+	JWTIssuer              string
+	JWTAudience            string
+	JWTRequired            bool // Reject calls without a token, instead of only those with an invalid one
 }
 
 // LoadConfig loads environment variables from .env file (if present) or system envs
@@ -35,5 +43,13 @@ func LoadConfig() *Config {
 	return &Config{
 		Port:     port,
 		GRPCPort: grpcPort,
+
+		AuthJWKSURL:            os.Getenv("AUTH_JWKS_URL"),
+		AuthRevokedSessionsURL: os.Getenv("AUTH_REVOKED_SESSIONS_URL"),
+		AuthTokenURL:           os.Getenv("AUTH_TOKEN_URL"),
+		ServiceAPIKey:          os.Getenv("SERVICE_API_KEY"),
+		JWTIssuer:              os.Getenv("JWT_ISSUER"),
+		JWTAudience:            os.Getenv("JWT_AUDIENCE"),
+		JWTRequired:            os.Getenv("JWT_REQUIRED") == "true",
 	}
 }
\ No newline at end of file
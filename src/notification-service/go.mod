@@ -18,3 +18,9 @@ require (
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20240604185151-ef581f913117 // indirect
 	google.golang.org/protobuf v1.34.1
 )
+
+require github.com/golang-jwt/jwt/v5 v5.2.1 // indirect
+
+require shared/authn v0.0.0
+
+replace shared/authn => ../shared/authn
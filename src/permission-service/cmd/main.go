@@ -1,20 +1,42 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"permission-service/config"
+	"permission-service/internal/clients"
 	"permission-service/internal/db"
 	"permission-service/internal/handlers"
+	"permission-service/internal/models"
 	"permission-service/utils/logger"
 	"permission-service/internal/services"
 	"permission-service/proto/generated/permission"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
+
+	"shared/authn"
+	"shared/authn/grpcauth"
 )
 
+// Methods that make no changes, which tokens issued for API keys may call with the read scope
+var readOnlyMethods = []string{
+	"/permission.PermissionService/CheckPermission",
+	"/permission.PermissionService/BatchCheckPermission",
+	"/permission.PermissionService/ListAccessibleFileIDs",
+	permission.PermissionService_WatchPermissionChanges_FullMethodName,
+	"/permission.PermissionService/ListFilePermissions",
+	"/permission.PermissionService/ListUserGrants",
+	"/permission.PermissionService/ListGroupMembers",
+	"/permission.PermissionService/QueryAuditLog",
+}
+
 func main() {
 	// Initialize the logger
 	logger.Init()
@@ -30,8 +52,65 @@ func main() {
 	}
 	defer db.CloseDB()
 
+	// Create the relation tuple table and carry over grants from the legacy permissions table
+	if err := db.Migrate(&models.RelationTuple{}, &models.Group{}, &models.AuditEntry{}); err != nil {
+		log.Fatalf("Failed to run migrations: %v", err)
+	}
+	if err := models.ProtectAuditLog(db.DBConn); err != nil {
+		log.Fatalf("Failed to protect the audit log: %v", err)
+	}
+	if err := models.MigrateLegacyPermissions(db.DBConn); err != nil {
+		log.Fatalf("Failed to migrate legacy permissions: %v", err)
+	}
+
+	// Owners are notified when the grants they made expire
+	notificationClient, err := clients.NewNotificationClient(cfg.NotificationServiceAddr)
+	if err != nil {
+		log.Fatalf("Failed to create notification client: %v", err)
+	}
+	defer notificationClient.Close()
+
+	// Load the role policy
+	policyStore, err := services.NewPolicyStore(cfg.PolicyFile)
+	if err != nil {
+		log.Fatalf("Failed to load role policy: %v", err)
+	}
+
 	// Initialize the permission service
-	permissionService := services.NewPermissionService(db.DBConn)
+	permissionService := services.NewPermissionService(db.DBConn, cfg.CheckMaxDepth, notificationClient, policyStore)
+
+	// Share permission changes with the other replicas, so that their watchers hear of them too
+	stopListening, cancelListening := context.WithCancel(context.Background())
+	defer cancelListening()
+	permissionService.ListenForChanges(stopListening, cfg.DBUrl)
+
+	// Reload the role policy when the file changes, or right away on SIGHUP
+	stopPolicyWatch := make(chan struct{})
+	defer close(stopPolicyWatch)
+	go permissionService.WatchPolicy(time.Duration(cfg.PolicyReloadInterval)*time.Second, stopPolicyWatch)
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if err := permissionService.ReloadPolicy(); err != nil {
+				logger.Error.Println("Failed to reload role policy:", err)
+			}
+		}
+	}()
+
+	// Write the audited check decisions in batches; the last ones are written on shutdown
+	stopAuditWriter := make(chan struct{})
+	auditWriterDone := make(chan struct{})
+	go func() {
+		permissionService.StartAuditWriter(time.Second, stopAuditWriter)
+		close(auditWriterDone)
+	}()
+
+	// Periodically delete expired grants
+	stopSweeper := make(chan struct{})
+	defer close(stopSweeper)
+	go permissionService.StartSweeper(time.Duration(cfg.SweepInterval)*time.Second, stopSweeper)
 
 	// Set up the gRPC server
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%s", cfg.Port))
@@ -40,7 +119,29 @@ func main() {
 		log.Fatalf("Failed to listen on port %s: %v", cfg.Port, err)
 	}
 
-	grpcServer := grpc.NewServer()
+	// Verify the access tokens of callers, which handlers read with authn.FromContext
+	var serverOptions []grpc.ServerOption
+	if cfg.AuthJWKSURL != "" {
+		verifier, err := authn.NewVerifier(authn.Config{
+			JWKSURL:            cfg.AuthJWKSURL,
+			RevokedSessionsURL: cfg.AuthRevokedSessionsURL,
+			TokenURL:           cfg.AuthTokenURL,
+			ServiceAPIKey:      cfg.ServiceAPIKey,
+			Issuer:             cfg.JWTIssuer,
+			Audience:           cfg.JWTAudience,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create token verifier: %v", err)
+		}
+		defer verifier.Close()
+		serverOptions = grpcauth.ServerOptions(verifier, grpcauth.Options{AllowAnonymous: !cfg.JWTRequired, Exempt: []string{"/grpc.reflection."}, ReadOnly: readOnlyMethods})
+	} else if cfg.JWTRequired {
+		log.Fatalf("AUTH_JWKS_URL must be set, or JWT_REQUIRED set to false to trust the user IDs and roles in requests")
+	} else {
+		logger.Warning.Println("AUTH_JWKS_URL is not set, access tokens are not verified and requests are trusted")
+	}
+
+	grpcServer := grpc.NewServer(serverOptions...)
 
 	// Register the PermissionService with the gRPC server
 	permission.RegisterPermissionServiceServer(grpcServer, &handlers.PermissionHandler{
@@ -52,9 +153,21 @@ func main() {
 
 	logger.Info.Println("Permission Service is running on port", cfg.Port)
 
+	// Finish the calls in progress on SIGINT or SIGTERM
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-shutdown
+		logger.Info.Println("Shutting down Permission Service")
+		grpcServer.GracefulStop()
+	}()
+
 	// Start the gRPC server
 	if err := grpcServer.Serve(listener); err != nil {
 		logger.Error.Println("Failed to serve gRPC server:", err)
 		log.Fatalf("Failed to serve gRPC server: %v", err)
 	}
+
+	close(stopAuditWriter)
+	<-auditWriterDone
 }
\ No newline at end of file
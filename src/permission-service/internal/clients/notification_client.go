@@ -0,0 +1,50 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"permission-service/proto/generated/notification"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// NotificationClient sends notifications to users through the Notification Service.
+type NotificationClient struct {
+	client notification.NotificationServiceClient
+	conn   *grpc.ClientConn
+}
+
+// NewNotificationClient creates a gRPC client for the Notification Service. The connection is
+// established lazily, so the permission service can start while notifications are unavailable.
+func NewNotificationClient(notificationServiceAddress string) (*NotificationClient, error) {
+	conn, err := grpc.NewClient(notificationServiceAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create notification service client: %v", err)
+	}
+
+	return &NotificationClient{client: notification.NewNotificationServiceClient(conn), conn: conn}, nil
+}
+
+// Close closes the connection to the Notification Service.
+func (n *NotificationClient) Close() error {
+	return n.conn.Close()
+}
+
+// SendNotification sends a message to a user.
+func (n *NotificationClient) SendNotification(userID uint64, message string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := n.client.SendNotification(ctx, &notification.SendNotificationRequest{
+		UserId:  userID,
+		Message: message,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send notification: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"permission-service/utils/logger"
+	"permission-service/proto/generated/permission"
+)
+
+// CreateGroup creates a group owned by the caller.
+func (h *PermissionHandler) CreateGroup(ctx context.Context, req *permission.CreateGroupRequest) (*permission.CreateGroupResponse, error) {
+	ownerID, _, err := h.caller(ctx, req.OwnerId, "")
+	if err != nil {
+		return nil, statusError(err)
+	}
+	logger.Info.Println("Received CreateGroup request from user:", ownerID, "for group:", req.Name)
+
+	group, err := h.PermissionService.CreateGroup(ownerID, req.Name)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return &permission.CreateGroupResponse{
+		Group: &permission.Group{
+			Id:      group.ID,
+			Name:    group.Name,
+			OwnerId: group.OwnerID,
+		},
+	}, nil
+}
+
+// AddGroupMember adds a user or a nested group to a group.
+func (h *PermissionHandler) AddGroupMember(ctx context.Context, req *permission.GroupMemberRequest) (*permission.GroupMemberResponse, error) {
+	ownerID, _, err := h.caller(ctx, req.OwnerId, "")
+	if err != nil {
+		return nil, statusError(err)
+	}
+	logger.Info.Println("Received AddGroupMember request from user:", ownerID, "for group:", req.GroupId)
+
+	err = h.PermissionService.AddGroupMember(ownerID, req.GroupId, req.UserId, req.MemberGroupId)
+	if err != nil {
+		return &permission.GroupMemberResponse{
+			Success: false,
+			Message: "Failed to add group member",
+		}, statusError(err)
+	}
+
+	return &permission.GroupMemberResponse{
+		Success: true,
+		Message: "Group member added successfully",
+	}, nil
+}
+
+// RemoveGroupMember removes a user or a nested group from a group.
+func (h *PermissionHandler) RemoveGroupMember(ctx context.Context, req *permission.GroupMemberRequest) (*permission.GroupMemberResponse, error) {
+	ownerID, _, err := h.caller(ctx, req.OwnerId, "")
+	if err != nil {
+		return nil, statusError(err)
+	}
+	logger.Info.Println("Received RemoveGroupMember request from user:", ownerID, "for group:", req.GroupId)
+
+	err = h.PermissionService.RemoveGroupMember(ownerID, req.GroupId, req.UserId, req.MemberGroupId)
+	if err != nil {
+		return &permission.GroupMemberResponse{
+			Success: false,
+			Message: "Failed to remove group member",
+		}, statusError(err)
+	}
+
+	return &permission.GroupMemberResponse{
+		Success: true,
+		Message: "Group member removed successfully",
+	}, nil
+}
+
+// ListGroupMembers lists the users and nested groups directly in a group.
+func (h *PermissionHandler) ListGroupMembers(ctx context.Context, req *permission.ListGroupMembersRequest) (*permission.ListGroupMembersResponse, error) {
+	userID, _, err := h.caller(ctx, req.UserId, "")
+	if err != nil {
+		return nil, statusError(err)
+	}
+	logger.Info.Println("Received ListGroupMembers request from user:", userID, "for group:", req.GroupId)
+
+	userIDs, groupIDs, err := h.PermissionService.ListGroupMembers(userID, req.GroupId)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return &permission.ListGroupMembersResponse{
+		UserIds:  userIDs,
+		GroupIds: groupIDs,
+	}, nil
+}
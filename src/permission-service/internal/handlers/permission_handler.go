@@ -2,9 +2,19 @@ package handlers
 
 import (
 	"context"
+	stderrors "errors"
+	"permission-service/internal/models"
+	"permission-service/utils/errors"
 	"permission-service/utils/logger"
 	"permission-service/internal/services"
 	"permission-service/proto/generated/permission"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"shared/authn"
 )
 
 // PermissionHandler implements the PermissionServiceServer
@@ -13,36 +23,380 @@ type PermissionHandler struct {
 	PermissionService *services.PermissionService
 }
 
-// CheckPermission checks if a user has a specific permission for a file.
+// CheckPermission checks if a user has a specific permission for a file or folder.
 func (h *PermissionHandler) CheckPermission(ctx context.Context, req *permission.CheckPermissionRequest) (*permission.CheckPermissionResponse, error) {
-	logger.Info.Println("Received CheckPermission request for user:", req.UserId, "on file:", req.FileId)
+	userID, role, err := h.caller(ctx, req.UserId, req.Role)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	logger.Info.Println("Received CheckPermission request for user:", userID, "on file:", req.FileId)
 
 	// Delegate the logic to the service
-	hasPermission, err := h.PermissionService.CheckPermission(req.UserId, req.FileId, req.Permission)
+	hasPermission, validUntil, err := h.PermissionService.CheckPermission(userID, role, req.ObjectType, req.FileId, req.Permission, req.ClientIp)
 	if err != nil {
-		return nil, err
+		return nil, statusError(err)
 	}
 
 	return &permission.CheckPermissionResponse{
 		HasPermission: hasPermission,
+		ValidUntil:    unixOrZero(validUntil),
 	}, nil
 }
 
-// UpdatePermission updates permissions for a file or folder for a shared user.
+// BatchCheckPermission checks a permission on several files or folders.
+func (h *PermissionHandler) BatchCheckPermission(ctx context.Context, req *permission.BatchCheckPermissionRequest) (*permission.BatchCheckPermissionResponse, error) {
+	userID, role, err := h.caller(ctx, req.UserId, req.Role)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	logger.Info.Println("Received BatchCheckPermission request for user:", userID, "on", len(req.FileIds), "objects")
+
+	results, err := h.PermissionService.BatchCheckPermission(userID, role, req.ObjectType, req.FileIds, req.Permission, req.ClientIp)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return &permission.BatchCheckPermissionResponse{
+		Results: results,
+	}, nil
+}
+
+// ListAccessibleFileIDs lists the files a user holds a permission on.
+func (h *PermissionHandler) ListAccessibleFileIDs(ctx context.Context, req *permission.ListAccessibleFileIDsRequest) (*permission.ListAccessibleFileIDsResponse, error) {
+	userID, role, err := h.caller(ctx, req.UserId, req.Role)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	logger.Info.Println("Received ListAccessibleFileIDs request for user:", userID)
+
+	fileIDs, allFiles, err := h.PermissionService.ListAccessibleFileIDs(userID, role, req.Permission, req.ClientIp)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return &permission.ListAccessibleFileIDsResponse{
+		FileIds:  fileIDs,
+		AllFiles: allFiles,
+	}, nil
+}
+
+// WatchPermissionChanges streams the changes that may affect permission decisions until the
+// client disconnects. The stream ends early if the client falls behind; clients should then drop
+// their cached decisions and watch again.
+func (h *PermissionHandler) WatchPermissionChanges(req *permission.WatchPermissionChangesRequest, stream permission.PermissionService_WatchPermissionChangesServer) error {
+	logger.Info.Println("Permission change watcher connected")
+
+	changes, stop := h.PermissionService.WatchChanges()
+	defer stop()
+
+	// Headers tell the client it is registered, so no change made from now on can be missed
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			logger.Info.Println("Permission change watcher disconnected")
+			return nil
+		case change, ok := <-changes:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "permission change watcher fell behind or changes cannot be heard")
+			}
+			err := stream.Send(&permission.PermissionChange{
+				FileId:   change.FileID,
+				UserId:   change.UserID,
+				ResetAll: change.Reset,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// RecordCachedDecisions records the decisions a client-side cache served without checking them.
+// Only service accounts that may delegate, such as the file picker's, may record decisions.
+func (h *PermissionHandler) RecordCachedDecisions(ctx context.Context, req *permission.RecordCachedDecisionsRequest) (*permission.RecordCachedDecisionsResponse, error) {
+	if !h.delegating(ctx) {
+		return nil, statusError(errors.ErrUserNotAuthorized)
+	}
+
+	decisions := make([]services.CachedDecision, len(req.Decisions))
+	for i, decision := range req.Decisions {
+		decisions[i] = services.CachedDecision{
+			UserID:     decision.UserId,
+			ObjectType: decision.ObjectType,
+			ObjectID:   decision.FileId,
+			Permission: decision.Permission,
+			Allowed:    decision.HasPermission,
+		}
+		if decision.Time != 0 {
+			decisions[i].Time = time.UnixMilli(decision.Time)
+		}
+	}
+	if err := h.PermissionService.RecordCachedDecisions(decisions); err != nil {
+		return nil, statusError(err)
+	}
+	return &permission.RecordCachedDecisionsResponse{}, nil
+}
+
+// UpdatePermission updates permissions for a file or folder for a shared user. Owner grants claim
+// files that have no tuples yet, which only the file picker may do as it creates them, so they are
+// only accepted from service accounts that may delegate.
 func (h *PermissionHandler) UpdatePermission(ctx context.Context, req *permission.UpdatePermissionRequest) (*permission.UpdatePermissionResponse, error) {
-	logger.Info.Println("Received UpdatePermission request for owner:", req.OwnerId, "to share with user:", req.SharedUserId)
+	ownerID, _, err := h.caller(ctx, req.OwnerId, "")
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if req.IsOwner && !h.delegating(ctx) {
+		logger.Warning.Println("Refused owner grant from a caller that may not delegate, for owner:", ownerID)
+		return nil, statusError(errors.ErrUserNotAuthorized)
+	}
+	logger.Info.Println("Received UpdatePermission request for owner:", ownerID, "to share with user:", req.SharedUserId)
+
+	var expiresAt *time.Time
+	if req.ExpiresAt != 0 {
+		expiry := time.Unix(req.ExpiresAt, 0)
+		expiresAt = &expiry
+	}
 
 	// Delegate the logic to the service
-	err := h.PermissionService.UpdatePermission(req.OwnerId, req.SharedUserId, req.FileIds, req.Permissions)
+	err = h.PermissionService.UpdatePermission(ownerID, req.SharedUserId, req.SharedGroupId, req.FileIds, req.Permissions, req.IsOwner, expiresAt, req.Condition)
 	if err != nil {
 		return &permission.UpdatePermissionResponse{
 			Success: false,
 			Message: "Failed to update permissions",
-		}, err
+		}, statusError(err)
 	}
 
 	return &permission.UpdatePermissionResponse{
 		Success: true,
 		Message: "Permissions updated successfully",
 	}, nil
-}
\ No newline at end of file
+}
+
+// RevokePermission removes a user's or group's access to a file.
+func (h *PermissionHandler) RevokePermission(ctx context.Context, req *permission.RevokePermissionRequest) (*permission.RevokePermissionResponse, error) {
+	ownerID, _, err := h.caller(ctx, req.OwnerId, "")
+	if err != nil {
+		return nil, statusError(err)
+	}
+	logger.Info.Println("Received RevokePermission request from owner:", ownerID, "on file:", req.FileId)
+
+	err = h.PermissionService.RevokePermission(ownerID, req.FileId, req.UserId, req.GroupId)
+	if err != nil {
+		return &permission.RevokePermissionResponse{
+			Success: false,
+			Message: "Failed to revoke permission",
+		}, statusError(err)
+	}
+
+	return &permission.RevokePermissionResponse{
+		Success: true,
+		Message: "Permission revoked successfully",
+	}, nil
+}
+
+// ListFilePermissions lists who has been granted access to a file.
+func (h *PermissionHandler) ListFilePermissions(ctx context.Context, req *permission.ListFilePermissionsRequest) (*permission.ListFilePermissionsResponse, error) {
+	ownerID, _, err := h.caller(ctx, req.OwnerId, "")
+	if err != nil {
+		return nil, statusError(err)
+	}
+	logger.Info.Println("Received ListFilePermissions request from owner:", ownerID, "on file:", req.FileId)
+
+	tuples, err := h.PermissionService.ListFilePermissions(ownerID, req.FileId)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	resp := &permission.ListFilePermissionsResponse{}
+	for _, tuple := range tuples {
+		resp.Grants = append(resp.Grants, &permission.FileGrant{
+			SubjectType: tuple.SubjectType,
+			SubjectId:   tuple.SubjectID,
+			Relation:    tuple.Relation,
+			GrantedBy:   tuple.OwnerID,
+			GrantedAt:   tuple.CreatedAt.Unix(),
+			ExpiresAt:   unixOrZero(tuple.ExpiresAt),
+			Condition:   tuple.Condition,
+		})
+	}
+	return resp, nil
+}
+
+// ListUserGrants lists a page of the files and folders a user can access.
+func (h *PermissionHandler) ListUserGrants(ctx context.Context, req *permission.ListUserGrantsRequest) (*permission.ListUserGrantsResponse, error) {
+	userID, _, err := h.caller(ctx, req.UserId, "")
+	if err != nil {
+		return nil, statusError(err)
+	}
+	logger.Info.Println("Received ListUserGrants request for user:", userID)
+
+	tuples, nextPageToken, err := h.PermissionService.ListUserGrants(userID, int(req.PageSize), req.PageToken)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	resp := &permission.ListUserGrantsResponse{NextPageToken: nextPageToken}
+	for _, tuple := range tuples {
+		grant := &permission.UserGrant{
+			ObjectType: tuple.ObjectType,
+			ObjectId:   tuple.ObjectID,
+			Relation:   tuple.Relation,
+			ExpiresAt:  unixOrZero(tuple.ExpiresAt),
+		}
+		if tuple.SubjectType == models.TypeGroup {
+			grant.GroupId, _ = strconv.ParseUint(tuple.SubjectID, 10, 64)
+		}
+		resp.Grants = append(resp.Grants, grant)
+	}
+	return resp, nil
+}
+
+// WriteRelation stores a relation tuple such as a group membership or a file's parent folder.
+func (h *PermissionHandler) WriteRelation(ctx context.Context, req *permission.WriteRelationRequest) (*permission.WriteRelationResponse, error) {
+	ownerID, _, err := h.caller(ctx, req.OwnerId, "")
+	if err != nil {
+		return nil, statusError(err)
+	}
+	tuple := req.GetTuple()
+	logger.Info.Println("Received WriteRelation request from user:", ownerID, "for", tuple.GetObjectType(), tuple.GetObjectId())
+
+	err = h.PermissionService.WriteRelation(ownerID, &models.RelationTuple{
+		ObjectType:      tuple.GetObjectType(),
+		ObjectID:        tuple.GetObjectId(),
+		Relation:        tuple.GetRelation(),
+		SubjectType:     tuple.GetSubjectType(),
+		SubjectID:       tuple.GetSubjectId(),
+		SubjectRelation: tuple.GetSubjectRelation(),
+	})
+	if err != nil {
+		return &permission.WriteRelationResponse{
+			Success: false,
+			Message: "Failed to write relation",
+		}, statusError(err)
+	}
+
+	return &permission.WriteRelationResponse{
+		Success: true,
+		Message: "Relation written successfully",
+	}, nil
+}
+
+// QueryAuditLog returns a page of the audit log.
+func (h *PermissionHandler) QueryAuditLog(ctx context.Context, req *permission.QueryAuditLogRequest) (*permission.QueryAuditLogResponse, error) {
+	requesterID, role, err := h.caller(ctx, req.RequesterId, req.Role)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	logger.Info.Println("Received QueryAuditLog request from user:", requesterID)
+
+	query := models.AuditQuery{
+		ActorID:     req.ActorId,
+		SubjectType: req.SubjectType,
+		SubjectID:   req.SubjectId,
+		ObjectID:    req.ObjectId,
+		Action:      req.Action,
+	}
+	if req.StartTime != 0 {
+		start := time.Unix(req.StartTime, 0)
+		query.Start = &start
+	}
+	if req.EndTime != 0 {
+		end := time.Unix(req.EndTime, 0)
+		query.End = &end
+	}
+
+	entries, nextPageToken, err := h.PermissionService.QueryAuditLog(requesterID, role, query, int(req.PageSize), req.PageToken)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	resp := &permission.QueryAuditLogResponse{NextPageToken: nextPageToken}
+	for _, entry := range entries {
+		resp.Entries = append(resp.Entries, &permission.AuditEntry{
+			Id:          entry.ID,
+			Time:        entry.CreatedAt.UnixMilli(),
+			Action:      entry.Action,
+			ActorId:     entry.ActorID,
+			SubjectType: entry.SubjectType,
+			SubjectId:   entry.SubjectID,
+			ObjectType:  entry.ObjectType,
+			ObjectId:    entry.ObjectID,
+			Permission:  entry.Permission,
+			Decision:    entry.Decision,
+			Reason:      entry.Reason,
+		})
+	}
+	return resp, nil
+}
+
+// PurgeUser removes a deleted user from the permission data. The files and folders whose tuples are
+// removed are those the user owns; file_ids is ignored.
+func (h *PermissionHandler) PurgeUser(ctx context.Context, req *permission.PurgeUserRequest) (*permission.PurgeUserResponse, error) {
+	requesterID, role, err := h.caller(ctx, req.RequesterId, req.Role)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	logger.Info.Println("Received PurgeUser request for user:", req.UserId, "from user:", requesterID)
+
+	removed, err := h.PermissionService.PurgeUser(requesterID, role, req.UserId)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &permission.PurgeUserResponse{Removed: removed}, nil
+}
+
+// statusError converts a service error into a gRPC status so that callers can tell invalid
+// requests, missing records and authorization failures apart.
+func statusError(err error) error {
+	switch {
+	case stderrors.Is(err, errors.ErrInvalidPermission), stderrors.Is(err, errors.ErrInvalidRelation), stderrors.Is(err, errors.ErrInvalidGroup),
+		stderrors.Is(err, errors.ErrGroupCycle), stderrors.Is(err, errors.ErrInvalidPageToken), stderrors.Is(err, errors.ErrInvalidCondition),
+		stderrors.Is(err, errors.ErrInvalidExpiry), stderrors.Is(err, errors.ErrBatchTooLarge):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case stderrors.Is(err, errors.ErrPermissionNotFound), stderrors.Is(err, errors.ErrGroupNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case stderrors.Is(err, errors.ErrUserNotAuthorized):
+		return status.Error(codes.PermissionDenied, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// caller returns the user a request acts for and that user's role. Callers act as the user of
+// their access token, and requests naming another user are denied, except from service accounts
+// whose role may delegate: they act for the user and role named in the request, or as themselves
+// when it names none. Calls without a token, which are only let through when tokens are not
+// required, act for the user and role named in the request.
+func (h *PermissionHandler) caller(ctx context.Context, userID uint64, role string) (uint64, string, error) {
+	claims, ok := authn.FromContext(ctx)
+	if !ok {
+		return userID, role, nil
+	}
+	if userID != 0 && claims.ServiceAccount && h.PermissionService.AllowsDelegation(claims.Role) {
+		return userID, role, nil
+	}
+	if userID != 0 && userID != uint64(claims.UserID) {
+		return 0, "", errors.ErrUserNotAuthorized
+	}
+	return uint64(claims.UserID), claims.Role, nil
+}
+
+// delegating reports whether the request comes from a service account whose role may delegate,
+// such as the file picker's. Calls without a token, which are only let through when tokens are
+// not required, are trusted like one.
+func (h *PermissionHandler) delegating(ctx context.Context) bool {
+	claims, ok := authn.FromContext(ctx)
+	return !ok || (claims.ServiceAccount && h.PermissionService.AllowsDelegation(claims.Role))
+}
+
+// unixOrZero returns t as Unix time, or 0 when t is not set.
+func unixOrZero(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.Unix()
+}
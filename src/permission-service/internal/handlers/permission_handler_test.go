@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"permission-service/internal/models"
+	"permission-service/internal/services"
+	"permission-service/internal/testutil"
+	"permission-service/proto/generated/permission"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	"shared/authn"
+)
+
+// newTestHandler creates a PermissionHandler with the default role policy, in which admins may
+// delegate.
+func newTestHandler(t *testing.T) (*PermissionHandler, *gorm.DB) {
+	t.Helper()
+	db := testutil.OpenDB(t)
+	policy, err := services.NewPolicyStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &PermissionHandler{PermissionService: services.NewPermissionService(db, 10, nil, policy)}, db
+}
+
+// callerContext returns the context of a request made with the token of the given account.
+func callerContext(userID uint, role string, serviceAccount bool) context.Context {
+	return authn.NewContext(context.Background(), &authn.Claims{UserID: userID, Role: role, ServiceAccount: serviceAccount})
+}
+
+func TestOwnerClaimRequiresDelegatingService(t *testing.T) {
+	h, db := newTestHandler(t)
+	claim := func(ctx context.Context, ownerID uint64, fileID string) error {
+		_, err := h.UpdatePermission(ctx, &permission.UpdatePermissionRequest{OwnerId: ownerID, FileIds: []string{fileID}, IsOwner: true})
+		return err
+	}
+
+	// Users cannot claim files, even ones nobody holds yet, nor can service accounts that may not
+	// delegate
+	for _, ctx := range []context.Context{callerContext(5, "editor", false), callerContext(5, "admin", false), callerContext(9, "editor", true)} {
+		if err := claim(ctx, 5, "file-1"); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("Expected the claim to be refused, got %v", err)
+		}
+	}
+	var count int64
+	if err := db.Model(&models.RelationTuple{}).Count(&count).Error; err != nil || count != 0 {
+		t.Fatalf("Expected no tuples, got %d (%v)", count, err)
+	}
+
+	// The picker claims new files for the user creating them
+	picker := callerContext(9, "admin", true)
+	if err := claim(picker, 5, "file-1"); err != nil {
+		t.Fatalf("Expected the picker's claim to succeed, got %v", err)
+	}
+	var owner models.RelationTuple
+	if err := db.Where("object_id = ? AND relation = ?", "file-1", models.RelationOwner).First(&owner).Error; err != nil || owner.SubjectID != models.UserSubject(5) {
+		t.Errorf("Expected user 5 to own the file, got %+v (%v)", owner, err)
+	}
+
+	// Files that already have tuples, and the empty file ID, cannot be claimed
+	if err := claim(picker, 6, "file-1"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected the claim of an owned file to be refused, got %v", err)
+	}
+	if err := claim(picker, 5, ""); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected the claim of an empty file ID to be refused, got %v", err)
+	}
+}
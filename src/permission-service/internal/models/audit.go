@@ -0,0 +1,131 @@
+package models
+
+import (
+	"permission-service/utils/errors"
+	"permission-service/utils/logger"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Audit actions
+const (
+	AuditCheck         = "check"          // A permission was checked
+	AuditGrant         = "grant"          // Access to a file was granted
+	AuditRevoke        = "revoke"         // Access to a file was revoked
+	AuditExpire        = "expire"         // An expired grant or membership was swept
+	AuditWriteRelation = "write_relation" // A relation tuple was written
+	AuditGroupAdd      = "group_add"      // A member was added to a group
+	AuditGroupRemove   = "group_remove"   // A member was removed from a group
+	AuditPolicyReload  = "policy_reload"  // The role policy was reloaded
+	AuditPurgeUser     = "purge_user"     // A tuple was removed because a user was deleted
+)
+
+// Audit decisions
+const (
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+)
+
+// AuditEntry records an access decision or a change to who can access what. Entries are only
+// ever inserted; see ProtectAuditLog.
+type AuditEntry struct {
+	ID          uint64    `gorm:"primaryKey;autoIncrement"`
+	CreatedAt   time.Time `gorm:"autoCreateTime;index"`
+	Action      string    `gorm:"type:varchar(32);not null;index"`
+	ActorID     uint64    `gorm:"not null;index"`                                               // The user who made the request, 0 for the service itself
+	SubjectType string    `gorm:"type:varchar(32);not null;default:'';index:idx_audit_subject"` // Who gained, lost or used access
+	SubjectID   string    `gorm:"not null;default:'';index:idx_audit_subject"`
+	ObjectType  string    `gorm:"type:varchar(32);not null;default:''"`
+	ObjectID    string    `gorm:"not null;default:'';index"`
+	Permission  string    `gorm:"type:varchar(32);not null;default:''"` // The permission checked, or the relation granted
+	Decision    string    `gorm:"type:varchar(8);not null;default:''"`  // allow or deny, for checks and refused changes
+	Reason      string    `gorm:"type:text;not null;default:''"`
+}
+
+// AuditQuery filters the audit log. Zero-valued filters are not applied.
+type AuditQuery struct {
+	Start       *time.Time // Inclusive
+	End         *time.Time // Exclusive
+	ActorID     uint64
+	SubjectType string
+	SubjectID   string
+	ObjectID    string
+	Action      string
+}
+
+// auditBatchSize is the most audit entries inserted per statement, which keeps large purges below
+// the database's limit on bind parameters.
+const auditBatchSize = 500
+
+// RecordAudit appends entries to the audit log.
+func RecordAudit(db *gorm.DB, entries ...*AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := db.CreateInBatches(entries, auditBatchSize).Error; err != nil {
+		logger.Error.Println("Failed to record audit entries:", err)
+		return errors.WrapDatabaseError(err)
+	}
+	return nil
+}
+
+// QueryAuditLog retrieves, in ID order, up to limit entries matching the query with an ID above
+// afterID.
+func QueryAuditLog(db *gorm.DB, query AuditQuery, afterID uint64, limit int) ([]AuditEntry, error) {
+	tx := db.Where("id > ?", afterID)
+	if query.Start != nil {
+		tx = tx.Where("created_at >= ?", *query.Start)
+	}
+	if query.End != nil {
+		tx = tx.Where("created_at < ?", *query.End)
+	}
+	if query.ActorID != 0 {
+		tx = tx.Where("actor_id = ?", query.ActorID)
+	}
+	if query.SubjectType != "" {
+		tx = tx.Where("subject_type = ?", query.SubjectType)
+	}
+	if query.SubjectID != "" {
+		tx = tx.Where("subject_id = ?", query.SubjectID)
+	}
+	if query.ObjectID != "" {
+		tx = tx.Where("object_id = ?", query.ObjectID)
+	}
+	if query.Action != "" {
+		tx = tx.Where("action = ?", query.Action)
+	}
+
+	var entries []AuditEntry
+	if err := tx.Order("id").Limit(limit).Find(&entries).Error; err != nil {
+		logger.Error.Println("Failed to query the audit log:", err)
+		return nil, errors.WrapDatabaseError(err)
+	}
+	return entries, nil
+}
+
+// ProtectAuditLog installs triggers rejecting updates, deletes and truncation of the audit log, so that
+// entries cannot be altered even by a faulty or compromised service instance. It is safe to run
+// on every start.
+func ProtectAuditLog(db *gorm.DB) error {
+	statements := []string{
+		`CREATE OR REPLACE FUNCTION reject_audit_change() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'the audit log is append-only';
+		END;
+		$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS audit_entries_append_only ON audit_entries`,
+		`CREATE TRIGGER audit_entries_append_only BEFORE UPDATE OR DELETE ON audit_entries
+		FOR EACH ROW EXECUTE FUNCTION reject_audit_change()`,
+		`DROP TRIGGER IF EXISTS audit_entries_no_truncate ON audit_entries`,
+		`CREATE TRIGGER audit_entries_no_truncate BEFORE TRUNCATE ON audit_entries
+		FOR EACH STATEMENT EXECUTE FUNCTION reject_audit_change()`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			logger.Error.Println("Failed to protect the audit log:", err)
+			return errors.WrapDatabaseError(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"net"
+	"permission-service/utils/errors"
+	"strings"
+)
+
+// Condition restricts when a grant applies. Conditions are written as clauses joined by "&&",
+// all of which must hold:
+//
+//	ip in 10.0.0.0/8,192.168.1.0/24   the request comes from one of the ranges
+//	permission != download,delete     the grant does not cover these permissions
+//
+// so "ip in 10.0.0.0/8 && permission != download" gives read access from the office network
+// only, with downloads disabled.
+type Condition struct {
+	networks []*net.IPNet
+	excluded map[string]bool
+}
+
+// ParseCondition parses a condition expression. An empty expression yields a condition that
+// always holds.
+func ParseCondition(expression string) (*Condition, error) {
+	condition := &Condition{excluded: make(map[string]bool)}
+	if strings.TrimSpace(expression) == "" {
+		return condition, nil
+	}
+
+	for _, clause := range strings.Split(expression, "&&") {
+		clause = strings.TrimSpace(clause)
+		switch {
+		case strings.HasPrefix(clause, "ip in "):
+			for _, cidr := range splitList(strings.TrimPrefix(clause, "ip in ")) {
+				_, network, err := net.ParseCIDR(cidr)
+				if err != nil {
+					return nil, errors.ErrInvalidCondition
+				}
+				condition.networks = append(condition.networks, network)
+			}
+		case strings.HasPrefix(clause, "permission != "):
+			for _, permission := range splitList(strings.TrimPrefix(clause, "permission != ")) {
+				if _, ok := RelationForPermission(permission); !ok {
+					return nil, errors.ErrInvalidCondition
+				}
+				condition.excluded[permission] = true
+			}
+		default:
+			return nil, errors.ErrInvalidCondition
+		}
+	}
+	return condition, nil
+}
+
+// Allows reports whether the condition holds for a check of permission from clientIP. Grants
+// restricted to IP ranges do not apply when the client address is unknown.
+func (c *Condition) Allows(permission string, clientIP net.IP) bool {
+	if c.excluded[permission] {
+		return false
+	}
+	if len(c.networks) == 0 {
+		return true
+	}
+	if clientIP == nil {
+		return false
+	}
+	for _, network := range c.networks {
+		if network.Contains(clientIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma-separated list, dropping blank entries.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
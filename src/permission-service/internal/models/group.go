@@ -0,0 +1,46 @@
+package models
+
+import (
+	"permission-service/utils/errors"
+	"permission-service/utils/logger"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Group is a named set of users and nested groups. Membership is stored as relation tuples
+// (group:id#member@user:id or group:id#member@group:id#member); the owner manages the members.
+type Group struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement"`
+	Name      string    `gorm:"not null"`
+	OwnerID   uint64    `gorm:"not null;index"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+// CreateGroup creates a new group owned by the given user.
+func CreateGroup(db *gorm.DB, name string, ownerID uint64) (*Group, error) {
+	group := Group{Name: name, OwnerID: ownerID}
+	if err := db.Create(&group).Error; err != nil {
+		logger.Error.Println("Failed to create group:", name, "Error:", err)
+		return nil, errors.WrapDatabaseError(err)
+	}
+
+	logger.Info.Println("Group created successfully:", group.ID, "for owner:", ownerID)
+	return &group, nil
+}
+
+// GetGroup retrieves a group by its ID.
+func GetGroup(db *gorm.DB, groupID uint64) (*Group, error) {
+	var group Group
+	err := db.First(&group, groupID).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			logger.Warning.Println("Group not found:", groupID)
+			return nil, errors.ErrGroupNotFound
+		}
+		logger.Error.Println("Failed to retrieve group:", groupID, "Error:", err)
+		return nil, errors.WrapDatabaseError(err)
+	}
+	return &group, nil
+}
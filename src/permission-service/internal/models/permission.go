@@ -8,7 +8,8 @@ import (
 	"gorm.io/gorm"
 )
 
-// Permission represents the permissions granted to a user for a file.
+// Permission is a flat (user, file, permission) grant as stored before relation tuples were
+// introduced. It is only read to migrate existing grants; see MigrateLegacyPermissions.
 type Permission struct {
 	ID         uint64    `gorm:"primaryKey;autoIncrement"`
 	UserID     uint64    `gorm:"not null"`
@@ -19,69 +20,68 @@ type Permission struct {
 	UpdatedAt  time.Time `gorm:"autoUpdateTime"`
 }
 
-// CreatePermission creates a new permission entry in the database.
-func CreatePermission(db *gorm.DB, userID uint64, fileID string, ownerID uint64, permission string) error {
-	newPermission := Permission{
-		UserID:     userID,
-		FileID:     fileID,
-		OwnerID:    ownerID,
-		Permission: permission,
+// MigrateLegacyPermissions converts the rows of the legacy permissions table into relation tuples,
+// giving each user the weakest relation that covers all of their permissions on a file. Rows
+// without a user (UserID 0) are those the owner registered a file with; they make the row's owner
+// the file's owner. Rows with unknown permissions, or with neither user nor owner, are skipped.
+// Running it again does not create duplicate tuples.
+func MigrateLegacyPermissions(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&Permission{}) {
+		return nil
 	}
 
-	// Create the permission in the database
-	err := db.Create(&newPermission).Error
-	if err != nil {
-		logger.Error.Println("Failed to create permission:", err)
+	var permissions []Permission
+	if err := db.Find(&permissions).Error; err != nil {
+		logger.Error.Println("Failed to read legacy permissions:", err)
 		return errors.WrapDatabaseError(err)
 	}
 
-	logger.Info.Println("Permission created successfully for user:", userID)
-	return nil
-}
-
-// GetPermissionsByUser retrieves all permissions for a specific user.
-func GetPermissionsByUser(db *gorm.DB, userID uint64) ([]Permission, error) {
-	var permissions []Permission
-	err := db.Where("user_id = ?", userID).Find(&permissions).Error
-	if err != nil {
-		logger.Error.Println("Failed to retrieve permissions for user:", userID, "Error:", err)
-		return nil, errors.WrapDatabaseError(err)
+	type grant struct {
+		userID uint64
+		fileID string
 	}
-	return permissions, nil
-}
-
-// GetPermission checks if a specific user has a specific permission for a file.
-func GetPermission(db *gorm.DB, userID uint64, fileID string, permissionType string) (bool, error) {
-	var permission Permission
-	err := db.Where("user_id = ? AND file_id = ? AND permission = ?", userID, fileID, permissionType).First(&permission).Error
-	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			logger.Warning.Println("Permission not found for user:", userID, "on file:", fileID)
-			return false, errors.ErrPermissionNotFound
+	granted := make(map[grant][]string)
+	owners := make(map[grant]uint64)
+	fileOwners := make(map[grant]bool)
+	for _, permission := range permissions {
+		key := grant{userID: permission.UserID, fileID: permission.FileID}
+		if permission.UserID == 0 {
+			if permission.OwnerID == 0 {
+				logger.Warning.Println("Skipping legacy permission without user or owner on file:", permission.FileID)
+				continue
+			}
+			key.userID = permission.OwnerID
+			fileOwners[key] = true
 		}
-		logger.Error.Println("Failed to check permission for user:", userID, "Error:", err)
-		return false, errors.WrapDatabaseError(err)
-	}
-
-	logger.Info.Println("Permission found for user:", userID, "on file:", fileID)
-	return true, nil
-}
-
-// UpdatePermission updates the permission for a user on a specific file.
-func UpdatePermission(db *gorm.DB, userID, fileID uint64, newPermission string) error {
-	var permission Permission
-	err := db.Where("user_id = ? AND file_id = ?", userID, fileID).First(&permission).Error
-	if err != nil {
-		logger.Error.Println("Failed to find permission to update for user:", userID, "on file:", fileID)
-		return errors.WrapDatabaseError(err)
+		if _, ok := RelationForPermission(permission.Permission); !ok {
+			logger.Warning.Println("Skipping legacy permission", permission.Permission, "for user:", permission.UserID, "on file:", permission.FileID)
+			continue
+		}
+		granted[key] = append(granted[key], permission.Permission)
+		owners[key] = permission.OwnerID
 	}
 
-	permission.Permission = newPermission
-	if err := db.Save(&permission).Error; err != nil {
-		logger.Error.Println("Failed to update permission for user:", userID, "on file:", fileID, "Error:", err)
-		return errors.WrapDatabaseError(err)
+	for key, grantedPermissions := range granted {
+		relation, err := RelationForPermissions(grantedPermissions)
+		if err != nil {
+			return err
+		}
+		if fileOwners[key] {
+			relation = RelationOwner
+		}
+		err = WriteRelationTuple(db, &RelationTuple{
+			ObjectType:  TypeFile,
+			ObjectID:    key.fileID,
+			Relation:    relation,
+			SubjectType: TypeUser,
+			SubjectID:   UserSubject(key.userID),
+			OwnerID:     owners[key],
+		})
+		if err != nil {
+			return err
+		}
 	}
 
-	logger.Info.Println("Permission updated successfully for user:", userID, "on file:", fileID)
+	logger.Info.Println("Migrated", len(permissions), "legacy permissions into", len(granted), "relation tuples")
 	return nil
 }
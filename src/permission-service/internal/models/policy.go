@@ -0,0 +1,118 @@
+package models
+
+import (
+	"encoding/json"
+	"permission-service/utils/errors"
+)
+
+// RolePolicy lists the capabilities a role has besides the grants its users hold.
+type RolePolicy struct {
+	Global []string `json:"global"` // Permissions for actions not tied to a file, such as "write" to upload
+	Allow  []string `json:"allow"`  // Permissions held on every file, whatever the grants
+	Deny   []string `json:"deny"`   // Permissions never held on any file, whatever the grants
+	Audit  bool     `json:"audit"`  // Whether the role may query the audit log
+	Users  bool     `json:"users"`  // Whether the role may manage users, such as purging deleted ones
+	// Delegate is whether service accounts with the role may act on behalf of other users, naming
+	// them in requests, as the file-picker-service does for work done outside a user's request
+	Delegate bool `json:"delegate"`
+}
+
+// Policy maps the roles found in auth tokens to their capabilities. Roles missing from Roles get
+// the capabilities of DefaultRole.
+type Policy struct {
+	DefaultRole string                `json:"default_role"`
+	Roles       map[string]RolePolicy `json:"roles"`
+}
+
+// DefaultPolicy returns the policy used when no policy file is configured: viewers can only read,
+// editors can also upload, and admins can read every file and the audit log and manage users.
+// Service accounts with the admin role may act on behalf of users.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		DefaultRole: "viewer",
+		Roles: map[string]RolePolicy{
+			"viewer": {Global: []string{"read"}, Deny: []string{"write", "transform"}},
+			"editor": {Global: []string{"read", "write"}},
+			"admin":  {Global: []string{"read", "write"}, Allow: []string{"read", "download"}, Audit: true, Users: true, Delegate: true},
+		},
+	}
+}
+
+// ParsePolicy parses a JSON policy, checking that it only names known permissions and that its
+// default role is defined.
+func ParsePolicy(data []byte) (*Policy, error) {
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, errors.ErrInvalidPolicy
+	}
+	if _, ok := policy.Roles[policy.DefaultRole]; !ok {
+		return nil, errors.ErrInvalidPolicy
+	}
+
+	for _, role := range policy.Roles {
+		for _, permissions := range [][]string{role.Global, role.Allow, role.Deny} {
+			for _, permission := range permissions {
+				if _, ok := RelationForPermission(permission); !ok {
+					return nil, errors.ErrInvalidPolicy
+				}
+			}
+		}
+	}
+	return &policy, nil
+}
+
+// AllowsGlobal reports whether a role may perform an action not tied to a file. An empty role
+// gets the default role's capabilities.
+func (p *Policy) AllowsGlobal(role, permission string) bool {
+	return contains(p.rolePolicy(role).Global, permission)
+}
+
+// AllowsAudit reports whether a role may query the audit log. An empty role gets the default
+// role's capabilities.
+func (p *Policy) AllowsAudit(role string) bool {
+	return p.rolePolicy(role).Audit
+}
+
+// AllowsUserManagement reports whether a role may manage users. An empty role gets the default
+// role's capabilities.
+func (p *Policy) AllowsUserManagement(role string) bool {
+	return p.rolePolicy(role).Users
+}
+
+// AllowsDelegation reports whether service accounts with a role may act on behalf of other users.
+func (p *Policy) AllowsDelegation(role string) bool {
+	return p.rolePolicy(role).Delegate
+}
+
+// Decide returns the decision the policy makes on a permission for a file or folder, if any.
+// Denials take precedence over allowances. An empty or unknown role gets the default role's
+// decisions, so checks never escape its denials by leaving the role out.
+func (p *Policy) Decide(role, permission string) (allowed bool, decided bool) {
+	rolePolicy := p.rolePolicy(role)
+	switch {
+	case contains(rolePolicy.Deny, permission):
+		return false, true
+	case contains(rolePolicy.Allow, permission):
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+// rolePolicy returns the capabilities of a role, falling back to the default role.
+func (p *Policy) rolePolicy(role string) RolePolicy {
+	if rolePolicy, ok := p.Roles[role]; ok {
+		return rolePolicy
+	}
+	return p.Roles[p.DefaultRole]
+}
+
+// contains reports whether permissions includes permission.
+func contains(permissions []string, permission string) bool {
+	for _, candidate := range permissions {
+		if candidate == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,245 @@
+package models
+
+import (
+	"fmt"
+	"permission-service/utils/errors"
+	"permission-service/utils/logger"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RelationTuple records that a subject holds a relation on an object, written
+// object_type:object_id#relation@subject_type:subject_id[#subject_relation]:
+//
+//	file:f1#viewer@user:42              user 42 can view f1
+//	file:f1#editor@group:eng#member     every member of eng can edit f1
+//	file:f1#parent@folder:reports       f1 is in reports and inherits its relations
+//	group:eng#member@group:ops#member   members of ops are members of eng
+type RelationTuple struct {
+	ID              uint64     `gorm:"primaryKey;autoIncrement"`
+	ObjectType      string     `gorm:"type:varchar(32);not null;uniqueIndex:idx_relation_tuple"`
+	ObjectID        string     `gorm:"not null;uniqueIndex:idx_relation_tuple"`
+	Relation        string     `gorm:"type:varchar(32);not null;uniqueIndex:idx_relation_tuple"`
+	SubjectType     string     `gorm:"type:varchar(32);not null;uniqueIndex:idx_relation_tuple;index:idx_relation_subject"`
+	SubjectID       string     `gorm:"not null;uniqueIndex:idx_relation_tuple;index:idx_relation_subject"`
+	SubjectRelation string     `gorm:"type:varchar(32);not null;default:'';uniqueIndex:idx_relation_tuple"` // Set when the subject is a userset
+	OwnerID         uint64     `gorm:"not null"`                                                            // The user who wrote the tuple
+	ExpiresAt       *time.Time `gorm:"index"`                                                               // Nil for tuples that never expire
+	Condition       string     `gorm:"type:text;not null;default:''"`                                       // Condition under which the tuple applies, see ParseCondition
+	CreatedAt       time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt       time.Time  `gorm:"autoUpdateTime"`
+}
+
+// Expired reports whether the tuple has expired at the given time.
+func (t RelationTuple) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !t.ExpiresAt.After(now)
+}
+
+// String formats the tuple in object#relation@subject notation.
+func (t RelationTuple) String() string {
+	subject := fmt.Sprintf("%s:%s", t.SubjectType, t.SubjectID)
+	if t.SubjectRelation != "" {
+		subject += "#" + t.SubjectRelation
+	}
+	return fmt.Sprintf("%s:%s#%s@%s", t.ObjectType, t.ObjectID, t.Relation, subject)
+}
+
+// UserSubject returns the subject ID under which a user appears in relation tuples.
+func UserSubject(userID uint64) string {
+	return strconv.FormatUint(userID, 10)
+}
+
+// GroupSubject returns the object or subject ID under which a group appears in relation tuples.
+func GroupSubject(groupID uint64) string {
+	return strconv.FormatUint(groupID, 10)
+}
+
+// WriteRelationTuple stores a relation tuple. Writing a tuple that already exists updates its
+// expiry and condition.
+func WriteRelationTuple(db *gorm.DB, tuple *RelationTuple) error {
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "object_type"}, {Name: "object_id"}, {Name: "relation"}, {Name: "subject_type"}, {Name: "subject_id"}, {Name: "subject_relation"}},
+		DoUpdates: clause.AssignmentColumns([]string{"owner_id", "expires_at", "condition", "updated_at"}),
+	}).Create(tuple).Error
+	if err != nil {
+		logger.Error.Println("Failed to write relation tuple:", tuple, "Error:", err)
+		return errors.WrapDatabaseError(err)
+	}
+
+	logger.Info.Println("Relation tuple written:", tuple)
+	return nil
+}
+
+// SetAccessTuple gives a subject the tuple's access relation on an object, replacing the editor,
+// commenter or viewer relation it held before. An owner relation the subject holds is kept.
+func SetAccessTuple(db *gorm.DB, tuple *RelationTuple) error {
+	var replaced []string
+	for _, relation := range []string{RelationEditor, RelationCommenter, RelationViewer} {
+		if relation != tuple.Relation {
+			replaced = append(replaced, relation)
+		}
+	}
+
+	_, err := DeleteSubjectTuples(db, tuple.ObjectType, tuple.ObjectID, replaced, tuple.SubjectType, tuple.SubjectID, tuple.SubjectRelation)
+	if err != nil {
+		return err
+	}
+	return WriteRelationTuple(db, tuple)
+}
+
+// ListObjectTuples retrieves the tuples on an object that have one of the given relations.
+func ListObjectTuples(db *gorm.DB, objectType, objectID string, relations []string) ([]RelationTuple, error) {
+	var tuples []RelationTuple
+	err := db.Where("object_type = ? AND object_id = ? AND relation IN ?", objectType, objectID, relations).Find(&tuples).Error
+	if err != nil {
+		logger.Error.Println("Failed to retrieve relation tuples for", objectType, objectID, "Error:", err)
+		return nil, errors.WrapDatabaseError(err)
+	}
+	return tuples, nil
+}
+
+// DeleteRelationTuple removes a relation tuple. Deleting a tuple that does not exist is a no-op.
+func DeleteRelationTuple(db *gorm.DB, tuple *RelationTuple) error {
+	err := db.Where("object_type = ? AND object_id = ? AND relation = ? AND subject_type = ? AND subject_id = ? AND subject_relation = ?",
+		tuple.ObjectType, tuple.ObjectID, tuple.Relation, tuple.SubjectType, tuple.SubjectID, tuple.SubjectRelation).
+		Delete(&RelationTuple{}).Error
+	if err != nil {
+		logger.Error.Println("Failed to delete relation tuple:", tuple, "Error:", err)
+		return errors.WrapDatabaseError(err)
+	}
+
+	logger.Info.Println("Relation tuple deleted:", tuple)
+	return nil
+}
+
+// DeleteSubjectTuples removes the tuples granting a subject one of the given relations on an
+// object and returns how many were removed.
+func DeleteSubjectTuples(db *gorm.DB, objectType, objectID string, relations []string, subjectType, subjectID, subjectRelation string) (int64, error) {
+	result := db.Where("object_type = ? AND object_id = ? AND relation IN ? AND subject_type = ? AND subject_id = ? AND subject_relation = ?",
+		objectType, objectID, relations, subjectType, subjectID, subjectRelation).
+		Delete(&RelationTuple{})
+	if result.Error != nil {
+		logger.Error.Println("Failed to delete relation tuples on", objectType, objectID, "for", subjectType, subjectID, "Error:", result.Error)
+		return 0, errors.WrapDatabaseError(result.Error)
+	}
+
+	logger.Info.Println("Deleted", result.RowsAffected, "relation tuples on", objectType, objectID, "for", subjectType, subjectID)
+	return result.RowsAffected, nil
+}
+
+// ListGroupMemberships retrieves the member tuples of the groups that directly contain the given
+// subjects, which are users or the members of groups.
+func ListGroupMemberships(db *gorm.DB, subjectType string, subjectIDs []string) ([]RelationTuple, error) {
+	subjectRelation := ""
+	if subjectType == TypeGroup {
+		subjectRelation = RelationMember
+	}
+
+	var tuples []RelationTuple
+	err := db.Where("object_type = ? AND relation = ? AND subject_type = ? AND subject_id IN ? AND subject_relation = ?",
+		TypeGroup, RelationMember, subjectType, subjectIDs, subjectRelation).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Find(&tuples).Error
+	if err != nil {
+		logger.Error.Println("Failed to retrieve group memberships for", subjectType, subjectIDs, "Error:", err)
+		return nil, errors.WrapDatabaseError(err)
+	}
+	return tuples, nil
+}
+
+// ListUserAccessTuples retrieves, in ID order, the unexpired access relations on files and folders
+// granted directly to a user or to any of the given groups. Only tuples with an ID above afterID
+// are returned, at most limit of them.
+func ListUserAccessTuples(db *gorm.DB, userID uint64, groupIDs []string, afterID uint64, limit int) ([]RelationTuple, error) {
+	var tuples []RelationTuple
+	err := db.Where("object_type IN ? AND relation IN ? AND id > ?", []string{TypeFile, TypeFolder}, ImpliedBy(RelationViewer), afterID).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Where(db.Where("subject_type = ? AND subject_id = ? AND subject_relation = ''", TypeUser, UserSubject(userID)).
+			Or("subject_type = ? AND subject_id IN ? AND subject_relation = ?", TypeGroup, groupIDs, RelationMember)).
+		Order("id").
+		Limit(limit).
+		Find(&tuples).Error
+	if err != nil {
+		logger.Error.Println("Failed to retrieve grants for user:", userID, "Error:", err)
+		return nil, errors.WrapDatabaseError(err)
+	}
+	return tuples, nil
+}
+
+// ListFolderChildren retrieves the unexpired parent tuples placing files or folders in one of the
+// given folders.
+func ListFolderChildren(db *gorm.DB, folderIDs []string) ([]RelationTuple, error) {
+	var tuples []RelationTuple
+	err := db.Where("relation = ? AND subject_type = ? AND subject_id IN ?", RelationParent, TypeFolder, folderIDs).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Find(&tuples).Error
+	if err != nil {
+		logger.Error.Println("Failed to retrieve the contents of folders:", folderIDs, "Error:", err)
+		return nil, errors.WrapDatabaseError(err)
+	}
+	return tuples, nil
+}
+
+// ListExpiredTuples retrieves up to limit tuples that expired before the given time, locking them
+// until the end of the transaction so that they cannot be renewed while they are swept.
+func ListExpiredTuples(db *gorm.DB, now time.Time, limit int) ([]RelationTuple, error) {
+	var tuples []RelationTuple
+	err := db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("expires_at <= ?", now).Order("id").Limit(limit).Find(&tuples).Error
+	if err != nil {
+		logger.Error.Println("Failed to retrieve expired relation tuples:", err)
+		return nil, errors.WrapDatabaseError(err)
+	}
+	return tuples, nil
+}
+
+// ListUserPurgeTuples retrieves the tuples naming a user as their subject, and the tuples on the
+// files and folders the user owns or naming those folders, such as the parent links of the objects
+// in them.
+func ListUserPurgeTuples(db *gorm.DB, userID uint64) ([]RelationTuple, error) {
+	owned := func(objectType string) *gorm.DB {
+		return db.Model(&RelationTuple{}).Select("object_id").
+			Where("object_type = ? AND relation = ? AND subject_type = ? AND subject_id = ?", objectType, RelationOwner, TypeUser, UserSubject(userID))
+	}
+
+	var tuples []RelationTuple
+	err := db.Where("subject_type = ? AND subject_id = ?", TypeUser, UserSubject(userID)).
+		Or("object_type = ? AND object_id IN (?)", TypeFile, owned(TypeFile)).
+		Or("object_type = ? AND object_id IN (?)", TypeFolder, owned(TypeFolder)).
+		Or("subject_type = ? AND subject_id IN (?)", TypeFolder, owned(TypeFolder)).
+		Order("id").Find(&tuples).Error
+	if err != nil {
+		logger.Error.Println("Failed to retrieve relation tuples of user:", userID, "Error:", err)
+		return nil, errors.WrapDatabaseError(err)
+	}
+	return tuples, nil
+}
+
+// DeleteExpiredTuples removes the tuples with the given IDs that expired before the given time,
+// leaving those renewed since they were listed, and returns the number removed.
+func DeleteExpiredTuples(db *gorm.DB, ids []uint64, now time.Time) (int64, error) {
+	result := db.Where("id IN ? AND expires_at <= ?", ids, now).Delete(&RelationTuple{})
+	if result.Error != nil {
+		logger.Error.Println("Failed to delete expired relation tuples:", ids, "Error:", result.Error)
+		return 0, errors.WrapDatabaseError(result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// deleteBatchSize is the most tuple IDs deleted per statement, which keeps large deletions, such
+// as purging a user with many files, below the database's limit on bind parameters.
+const deleteBatchSize = 1000
+
+// DeleteTuplesByID removes the tuples with the given IDs.
+func DeleteTuplesByID(db *gorm.DB, ids []uint64) error {
+	for start := 0; start < len(ids); start += deleteBatchSize {
+		batch := ids[start:min(start+deleteBatchSize, len(ids))]
+		if err := db.Delete(&RelationTuple{}, batch).Error; err != nil {
+			logger.Error.Println("Failed to delete relation tuples:", batch, "Error:", err)
+			return errors.WrapDatabaseError(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"permission-service/utils/errors"
+)
+
+// Object and subject types used in relation tuples.
+const (
+	TypeUser   = "user"
+	TypeGroup  = "group"
+	TypeFile   = "file"
+	TypeFolder = "folder"
+)
+
+// Relations between objects and subjects. The access relations are ordered
+// owner ⇒ editor ⇒ commenter ⇒ viewer; parent links a file or folder to the folder containing it,
+// and member links a group to its members.
+const (
+	RelationOwner     = "owner"
+	RelationEditor    = "editor"
+	RelationCommenter = "commenter"
+	RelationViewer    = "viewer"
+	RelationParent    = "parent"
+	RelationMember    = "member"
+)
+
+// relationRank orders the access relations; a relation implies every relation ranked below it.
+var relationRank = map[string]int{
+	RelationViewer:    1,
+	RelationCommenter: 2,
+	RelationEditor:    3,
+	RelationOwner:     4,
+}
+
+// permissionRelations maps each permission to the weakest relation that grants it.
+var permissionRelations = map[string]string{
+	"read":      RelationViewer,
+	"download":  RelationViewer,
+	"comment":   RelationCommenter,
+	"write":     RelationEditor,
+	"transform": RelationEditor,
+	"delete":    RelationOwner,
+	"share":     RelationEditor,
+}
+
+// IsAccessRelation reports whether relation is one of owner, editor, commenter or viewer.
+func IsAccessRelation(relation string) bool {
+	_, ok := relationRank[relation]
+	return ok
+}
+
+// RelationForPermission returns the weakest relation that grants a permission.
+func RelationForPermission(permission string) (string, bool) {
+	relation, ok := permissionRelations[permission]
+	return relation, ok
+}
+
+// RelationForPermissions returns the weakest relation that grants all of the given permissions.
+func RelationForPermissions(permissions []string) (string, error) {
+	relation := ""
+	for _, permission := range permissions {
+		required, ok := permissionRelations[permission]
+		if !ok {
+			return "", errors.ErrInvalidPermission
+		}
+		if relationRank[required] > relationRank[relation] {
+			relation = required
+		}
+	}
+	if relation == "" {
+		return "", errors.ErrInvalidPermission
+	}
+	return relation, nil
+}
+
+// ImpliedBy returns the relations that grant relation: the relation itself and, for access
+// relations, every stronger one.
+func ImpliedBy(relation string) []string {
+	rank, ok := relationRank[relation]
+	if !ok {
+		return []string{relation}
+	}
+
+	relations := []string{}
+	for candidate, candidateRank := range relationRank {
+		if candidateRank >= rank {
+			relations = append(relations, candidate)
+		}
+	}
+	return relations
+}
+
+// ValidateTuple checks that a tuple is allowed by the schema:
+//   - files and folders take access relations from users and usersets, and a parent folder;
+//   - groups take members, which are users or the members of another group.
+func ValidateTuple(tuple *RelationTuple) error {
+	if tuple.ObjectID == "" || tuple.SubjectID == "" {
+		return errors.ErrInvalidRelation
+	}
+	if _, err := ParseCondition(tuple.Condition); err != nil {
+		return err
+	}
+
+	switch tuple.ObjectType {
+	case TypeFile, TypeFolder:
+		if tuple.Relation == RelationParent {
+			if tuple.SubjectType != TypeFolder || tuple.SubjectRelation != "" {
+				return errors.ErrInvalidRelation
+			}
+			return nil
+		}
+		if !IsAccessRelation(tuple.Relation) {
+			return errors.ErrInvalidRelation
+		}
+	case TypeGroup:
+		if tuple.Relation != RelationMember {
+			return errors.ErrInvalidRelation
+		}
+	default:
+		return errors.ErrInvalidRelation
+	}
+
+	return validateSubject(tuple.SubjectType, tuple.SubjectRelation)
+}
+
+// validateSubject checks that a subject is a user or a userset that can hold users.
+func validateSubject(subjectType, subjectRelation string) error {
+	switch subjectType {
+	case TypeUser:
+		if subjectRelation == "" {
+			return nil
+		}
+	case TypeGroup:
+		if subjectRelation == RelationMember {
+			return nil
+		}
+	case TypeFolder:
+		if IsAccessRelation(subjectRelation) {
+			return nil
+		}
+	}
+	return errors.ErrInvalidRelation
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"net"
+	"permission-service/internal/models"
+	"permission-service/utils/errors"
+	"permission-service/utils/logger"
+	"sort"
+	"time"
+)
+
+// maxBatchCheckSize bounds the objects checked by one BatchCheckPermission call.
+const maxBatchCheckSize = 1000
+
+// BatchCheckPermission checks a permission on several files or folders and returns the result for
+// each object ID. The role policy applies and decisions are audited as in CheckPermission.
+func (s *PermissionService) BatchCheckPermission(userID uint64, role, objectType string, objectIDs []string, permissionType string, clientIP string) (map[string]bool, error) {
+	logger.Info.Println("Checking permission", permissionType, "for user:", userID, "on", len(objectIDs), "objects")
+
+	if len(objectIDs) > maxBatchCheckSize {
+		return nil, errors.ErrBatchTooLarge
+	}
+	if objectType == "" {
+		objectType = models.TypeFile
+	}
+	relation, ok := models.RelationForPermission(permissionType)
+	if !ok {
+		logger.Warning.Println("Unknown permission type:", permissionType)
+		return nil, errors.ErrInvalidPermission
+	}
+
+	results, entries, err := s.batchCheck(userID, role, objectType, objectIDs, permissionType, relation, clientIP)
+	if err != nil {
+		return nil, err
+	}
+	s.recordChecks(entries...)
+	return results, nil
+}
+
+// batchCheck decides a permission on several objects and returns the results along with the
+// audit entries for them, which the caller records if the decisions are to be audited.
+func (s *PermissionService) batchCheck(userID uint64, role, objectType string, objectIDs []string, permissionType, relation, clientIP string) (map[string]bool, []*models.AuditEntry, error) {
+	policy := s.policy.Policy()
+	ctx := CheckContext{Permission: permissionType, ClientIP: net.ParseIP(clientIP), Now: time.Now()}
+	results := make(map[string]bool, len(objectIDs))
+	var entries []*models.AuditEntry
+	for _, objectID := range objectIDs {
+		if _, done := results[objectID]; done {
+			continue
+		}
+		allowed, reason, _, err := s.decide(policy, userID, role, objectType, objectID, relation, ctx)
+		if err != nil {
+			logger.Error.Println("Error checking permission for user:", userID, "on", objectType, objectID, "Error:", err)
+			return nil, nil, err
+		}
+		results[objectID] = allowed
+		entries = append(entries, checkEntry(userID, objectType, objectID, permissionType, allowed, reason))
+	}
+	return results, entries, nil
+}
+
+// ListAccessibleFileIDs returns, sorted, the IDs of the files a user holds a permission on. The
+// candidates are the files and folders granted to the user or the user's groups, along with
+// everything nested in those folders; each candidate file is then checked, so that weaker
+// relations, expired grants and conditions are honoured exactly as in CheckPermission. Listing is
+// not audited file by file; the files are audited when they are accessed.
+//
+// When the user's role holds the permission on every file, no IDs are listed and the second result
+// is true.
+func (s *PermissionService) ListAccessibleFileIDs(userID uint64, role, permissionType string, clientIP string) ([]string, bool, error) {
+	logger.Info.Println("Listing files user:", userID, "role:", role, "holds permission", permissionType, "on")
+
+	relation, ok := models.RelationForPermission(permissionType)
+	if !ok {
+		logger.Warning.Println("Unknown permission type:", permissionType)
+		return nil, false, errors.ErrInvalidPermission
+	}
+	if allowed, decided := s.policy.Policy().Decide(role, permissionType); decided {
+		return nil, allowed, nil
+	}
+
+	groupIDs, err := s.userGroups(userID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	candidates := make(map[string]bool)
+	folders := make(map[string]bool)
+	var frontier []string
+	for afterID := uint64(0); ; {
+		tuples, err := models.ListUserAccessTuples(s.DB, userID, groupIDs, afterID, maxGrantPageSize)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, tuple := range tuples {
+			switch {
+			case tuple.ObjectType == models.TypeFile:
+				candidates[tuple.ObjectID] = true
+			case !folders[tuple.ObjectID]:
+				folders[tuple.ObjectID] = true
+				frontier = append(frontier, tuple.ObjectID)
+			}
+		}
+		if len(tuples) < maxGrantPageSize {
+			break
+		}
+		afterID = tuples[len(tuples)-1].ID
+	}
+
+	// Folders pass their relations down to their contents, as deep as a check would follow them
+	for depth := 0; depth < s.checker.maxDepth && len(frontier) > 0; depth++ {
+		children, err := models.ListFolderChildren(s.DB, frontier)
+		if err != nil {
+			return nil, false, err
+		}
+
+		frontier = nil
+		for _, child := range children {
+			switch {
+			case child.ObjectType == models.TypeFile:
+				candidates[child.ObjectID] = true
+			case !folders[child.ObjectID]:
+				folders[child.ObjectID] = true
+				frontier = append(frontier, child.ObjectID)
+			}
+		}
+	}
+
+	fileIDs := make([]string, 0, len(candidates))
+	for fileID := range candidates {
+		fileIDs = append(fileIDs, fileID)
+	}
+
+	var accessible []string
+	for start := 0; start < len(fileIDs); start += maxBatchCheckSize {
+		batch := fileIDs[start:min(start+maxBatchCheckSize, len(fileIDs))]
+		results, _, err := s.batchCheck(userID, role, models.TypeFile, batch, permissionType, relation, clientIP)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, fileID := range batch {
+			if results[fileID] {
+				accessible = append(accessible, fileID)
+			}
+		}
+	}
+
+	sort.Strings(accessible)
+	return accessible, false, nil
+}
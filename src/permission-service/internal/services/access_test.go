@@ -0,0 +1,103 @@
+package services
+
+import (
+	stderrors "errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"permission-service/internal/models"
+	"permission-service/utils/errors"
+)
+
+func TestBatchCheckMatchesCheck(t *testing.T) {
+	s, db := newTestService(t)
+	writeTuples(t, db,
+		"file:owned#owner@user:1",
+		"file:edited#editor@user:1",
+		"file:viewed#viewer@user:1",
+		"file:grouped#commenter@group:10#member",
+		"group:10#member@group:11#member",
+		"group:11#member@user:1",
+		"file:nested#parent@folder:d1",
+		"folder:d1#editor@user:1",
+		"file:other#owner@user:2",
+	)
+	expired := parseTuple(t, "file:expired#editor@user:1")
+	expiredAt := time.Now().Add(-time.Minute)
+	expired.ExpiresAt = &expiredAt
+	office := parseTuple(t, "file:office#editor@user:1")
+	office.Condition = "ip in 10.0.0.0/8 && permission != download"
+	for _, tuple := range []models.RelationTuple{expired, office} {
+		if err := models.WriteRelationTuple(db, &tuple); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fileIDs := []string{"owned", "edited", "viewed", "grouped", "nested", "other", "expired", "office", "unknown", "owned"}
+	for _, role := range []string{"viewer", "editor", "admin"} {
+		for _, permission := range []string{"read", "download", "comment", "write", "delete"} {
+			for _, clientIP := range []string{"", "10.1.2.3", "192.168.1.1"} {
+				t.Run(fmt.Sprintf("%s %s from %q", role, permission, clientIP), func(t *testing.T) {
+					results, err := s.BatchCheckPermission(1, role, models.TypeFile, fileIDs, permission, clientIP)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(results) != len(fileIDs)-1 {
+						t.Errorf("Expected a result per file, got %v", results)
+					}
+					for _, fileID := range fileIDs {
+						want, _, err := s.CheckPermission(1, role, models.TypeFile, fileID, permission, clientIP)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if got, ok := results[fileID]; !ok || got != want {
+							t.Errorf("Expected %s on %s to be %v as in a single check, got %v", permission, fileID, want, got)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestBatchCheckAuditsEachObject(t *testing.T) {
+	s, db := newTestService(t)
+	writeTuples(t, db, "file:f1#viewer@user:1")
+
+	results, err := s.BatchCheckPermission(1, "editor", models.TypeFile, []string{"f1", "f2", "f1"}, "read", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results["f1"] || results["f2"] {
+		t.Errorf("Unexpected results %v", results)
+	}
+
+	// One decision is queued per distinct object
+	if queued := len(s.auditQueue); queued != 2 {
+		t.Fatalf("Expected 2 audited decisions, got %d", queued)
+	}
+	decisions := map[string]string{}
+	for i := 0; i < 2; i++ {
+		entry := <-s.auditQueue
+		decisions[entry.ObjectID] = entry.Decision
+	}
+	if decisions["f1"] != models.DecisionAllow || decisions["f2"] != models.DecisionDeny {
+		t.Errorf("Unexpected decisions %v", decisions)
+	}
+}
+
+func TestBatchCheckRejectsInvalidRequests(t *testing.T) {
+	s, _ := newTestService(t)
+
+	if _, err := s.BatchCheckPermission(1, "editor", models.TypeFile, []string{"f1"}, "fly", ""); !stderrors.Is(err, errors.ErrInvalidPermission) {
+		t.Errorf("Expected an unknown permission to be refused, got %v", err)
+	}
+	fileIDs := make([]string, maxBatchCheckSize+1)
+	for i := range fileIDs {
+		fileIDs[i] = fmt.Sprint("f", i)
+	}
+	if _, err := s.BatchCheckPermission(1, "editor", models.TypeFile, fileIDs, "read", ""); !stderrors.Is(err, errors.ErrBatchTooLarge) {
+		t.Errorf("Expected an oversized batch to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package services
+
+import (
+	"permission-service/internal/models"
+	"permission-service/utils/errors"
+	"permission-service/utils/logger"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Page sizes for QueryAuditLog.
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+// Check decisions are queued and written in batches by StartAuditWriter, so that checks do not
+// wait on an insert each.
+const (
+	auditQueueSize = 10000 // Decisions queued before checks fall back to writing their own
+	auditFlushSize = 500   // Decisions written per batch
+)
+
+// recordDecisions appends check decisions and refused changes to the audit log. Decisions are
+// still returned when they cannot be recorded, so that an audit outage does not lock users out;
+// the failure is logged.
+func (s *PermissionService) recordDecisions(entries ...*models.AuditEntry) {
+	if err := models.RecordAudit(s.DB, entries...); err != nil {
+		logger.Error.Println("Permission decisions were made without being audited:", err)
+	}
+}
+
+// recordChecks queues check decisions for StartAuditWriter. Entries are timestamped when queued;
+// when the queue is full they are written right away instead, so that decisions are never dropped.
+func (s *PermissionService) recordChecks(entries ...*models.AuditEntry) {
+	now := time.Now()
+	for i, entry := range entries {
+		entry.CreatedAt = now
+		select {
+		case s.auditQueue <- entry:
+		default:
+			s.recordDecisions(entries[i:]...)
+			return
+		}
+	}
+}
+
+// StartAuditWriter writes the queued check decisions every interval, or as soon as a batch is
+// full, until stop is closed. The decisions still queued are written before it returns.
+func (s *PermissionService) StartAuditWriter(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	batch := make([]*models.AuditEntry, 0, auditFlushSize)
+	flush := func() {
+		s.recordDecisions(batch...)
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case entry := <-s.auditQueue:
+			batch = append(batch, entry)
+			if len(batch) == auditFlushSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-stop:
+			for {
+				select {
+				case entry := <-s.auditQueue:
+					batch = append(batch, entry)
+					if len(batch) == auditFlushSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// maxCachedDecisions bounds the decisions recorded by one RecordCachedDecisions call.
+const maxCachedDecisions = 1000
+
+// CachedDecision is a permission decision a client-side cache served without a check.
+type CachedDecision struct {
+	UserID     uint64
+	ObjectType string
+	ObjectID   string
+	Permission string
+	Allowed    bool
+	Time       time.Time // When the decision was served
+}
+
+// RecordCachedDecisions records in the audit log decisions served from a client-side cache, so
+// that every access is audited whether or not it was checked here. They are recorded as checks
+// with "cached decision" as the reason, at the time they were served, or now when that is unset
+// or in the future.
+func (s *PermissionService) RecordCachedDecisions(decisions []CachedDecision) error {
+	if len(decisions) > maxCachedDecisions {
+		return errors.ErrBatchTooLarge
+	}
+
+	now := time.Now()
+	entries := make([]*models.AuditEntry, len(decisions))
+	for i, decision := range decisions {
+		objectType := decision.ObjectType
+		if objectType == "" {
+			objectType = models.TypeFile
+		}
+		entries[i] = checkEntry(decision.UserID, objectType, decision.ObjectID, decision.Permission, decision.Allowed, "cached decision")
+		entries[i].CreatedAt = decision.Time
+		if decision.Time.IsZero() || decision.Time.After(now) {
+			entries[i].CreatedAt = now
+		}
+	}
+	return models.RecordAudit(s.DB, entries...)
+}
+
+// checkEntry builds the audit entry for a permission check.
+func checkEntry(userID uint64, objectType, objectID, permissionType string, allowed bool, reason string) *models.AuditEntry {
+	return &models.AuditEntry{
+		Action:      models.AuditCheck,
+		ActorID:     userID,
+		SubjectType: models.TypeUser,
+		SubjectID:   models.UserSubject(userID),
+		ObjectType:  objectType,
+		ObjectID:    objectID,
+		Permission:  permissionType,
+		Decision:    decision(allowed),
+		Reason:      reason,
+	}
+}
+
+// tupleEntry builds the audit entry for a change to a tuple made by actorID. A change refused with
+// err is recorded as denied, with the error as the reason.
+func tupleEntry(action string, actorID uint64, tuple models.RelationTuple, err error) *models.AuditEntry {
+	entry := &models.AuditEntry{
+		Action:      action,
+		ActorID:     actorID,
+		SubjectType: tuple.SubjectType,
+		SubjectID:   tuple.SubjectID,
+		ObjectType:  tuple.ObjectType,
+		ObjectID:    tuple.ObjectID,
+		Permission:  tuple.Relation,
+		Decision:    decision(err == nil),
+	}
+	if tuple.SubjectRelation != "" {
+		entry.SubjectID += "#" + tuple.SubjectRelation
+	}
+
+	var reasons []string
+	if err != nil {
+		reasons = append(reasons, err.Error())
+	}
+	if tuple.ExpiresAt != nil {
+		reasons = append(reasons, "expires "+tuple.ExpiresAt.UTC().Format("2006-01-02T15:04:05Z"))
+	}
+	if tuple.Condition != "" {
+		reasons = append(reasons, "condition "+tuple.Condition)
+	}
+	entry.Reason = strings.Join(reasons, "; ")
+	return entry
+}
+
+// recordChange appends the entry for a change to a tuple to the audit log through db, which may
+// be the transaction making the change.
+func recordChange(db *gorm.DB, action string, actorID uint64, tuple models.RelationTuple, err error) error {
+	return models.RecordAudit(db, tupleEntry(action, actorID, tuple, err))
+}
+
+// decision returns the audit decision for an outcome.
+func decision(allowed bool) string {
+	if allowed {
+		return models.DecisionAllow
+	}
+	return models.DecisionDeny
+}
+
+// describePath formats the chain of tuples through which access was granted, e.g.
+// "file:f1#parent@folder:reports -> folder:reports#viewer@user:7".
+func describePath(path []models.RelationTuple) string {
+	steps := make([]string, len(path))
+	for i, tuple := range path {
+		steps[i] = tuple.String()
+	}
+	return strings.Join(steps, " -> ")
+}
+
+// QueryAuditLog returns a page of the audit entries matching the query, oldest first, along with
+// the token of the next page. Only roles allowed to audit may query the log.
+func (s *PermissionService) QueryAuditLog(requesterID uint64, role string, query models.AuditQuery, pageSize int, pageToken string) ([]models.AuditEntry, string, error) {
+	logger.Info.Println("Audit log queried by user:", requesterID, "role:", role)
+
+	if !s.policy.Policy().AllowsAudit(role) {
+		logger.Warning.Println("User", requesterID, "with role", role, "may not query the audit log")
+		return nil, "", errors.ErrUserNotAuthorized
+	}
+
+	if pageSize <= 0 {
+		pageSize = defaultAuditPageSize
+	}
+	pageSize = min(pageSize, maxAuditPageSize)
+
+	var afterID uint64
+	if pageToken != "" {
+		var err error
+		if afterID, err = strconv.ParseUint(pageToken, 10, 64); err != nil {
+			return nil, "", errors.ErrInvalidPageToken
+		}
+	}
+
+	entries, err := models.QueryAuditLog(s.DB, query, afterID, pageSize)
+	if err != nil {
+		return nil, "", err
+	}
+
+	nextPageToken := ""
+	if len(entries) == pageSize {
+		nextPageToken = strconv.FormatUint(entries[len(entries)-1].ID, 10)
+	}
+	return entries, nextPageToken, nil
+}
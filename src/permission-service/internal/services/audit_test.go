@@ -0,0 +1,112 @@
+package services
+
+import (
+	stderrors "errors"
+	"testing"
+	"time"
+
+	"permission-service/internal/models"
+	"permission-service/utils/errors"
+)
+
+func TestQueryAuditLogFilters(t *testing.T) {
+	s, db := newTestService(t)
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(hours int) time.Time { return base.Add(time.Duration(hours) * time.Hour) }
+	entries := []*models.AuditEntry{
+		{CreatedAt: at(0), Action: models.AuditCheck, ActorID: 1, SubjectType: models.TypeUser, SubjectID: "1", ObjectType: models.TypeFile, ObjectID: "f1", Decision: models.DecisionAllow},
+		{CreatedAt: at(1), Action: models.AuditGrant, ActorID: 1, SubjectType: models.TypeUser, SubjectID: "2", ObjectType: models.TypeFile, ObjectID: "f1", Decision: models.DecisionAllow},
+		{CreatedAt: at(2), Action: models.AuditGrant, ActorID: 1, SubjectType: models.TypeGroup, SubjectID: "10#member", ObjectType: models.TypeFile, ObjectID: "f2", Decision: models.DecisionAllow},
+		{CreatedAt: at(3), Action: models.AuditCheck, ActorID: 2, SubjectType: models.TypeUser, SubjectID: "2", ObjectType: models.TypeFile, ObjectID: "f1", Decision: models.DecisionDeny},
+		{CreatedAt: at(4), Action: models.AuditExpire, SubjectType: models.TypeUser, SubjectID: "2", ObjectType: models.TypeFile, ObjectID: "f1"},
+	}
+	if err := models.RecordAudit(db, entries...); err != nil {
+		t.Fatal(err)
+	}
+	start, end := at(1), at(3)
+
+	tests := []struct {
+		name  string
+		query models.AuditQuery
+		want  []int // Indexes of the entries returned
+	}{
+		{name: "everything", want: []int{0, 1, 2, 3, 4}},
+		{name: "time range", query: models.AuditQuery{Start: &start, End: &end}, want: []int{1, 2}},
+		{name: "from", query: models.AuditQuery{Start: &end}, want: []int{3, 4}},
+		{name: "actor", query: models.AuditQuery{ActorID: 1}, want: []int{0, 1, 2}},
+		{name: "subject type", query: models.AuditQuery{SubjectType: models.TypeGroup}, want: []int{2}},
+		{name: "subject", query: models.AuditQuery{SubjectType: models.TypeUser, SubjectID: "2"}, want: []int{1, 3, 4}},
+		{name: "file", query: models.AuditQuery{ObjectID: "f1"}, want: []int{0, 1, 3, 4}},
+		{name: "action", query: models.AuditQuery{Action: models.AuditGrant}, want: []int{1, 2}},
+		{name: "combined", query: models.AuditQuery{ObjectID: "f1", Action: models.AuditCheck, ActorID: 2}, want: []int{3}},
+		{name: "nothing", query: models.AuditQuery{Action: models.AuditRevoke}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, next, err := s.QueryAuditLog(9, "admin", tt.query, 0, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next != "" {
+				t.Errorf("Expected a single page, got next page %q", next)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected %d entries, got %+v", len(tt.want), got)
+			}
+			for i, index := range tt.want {
+				if got[i].ID != entries[index].ID {
+					t.Errorf("Expected entry %d at %d, got %+v", entries[index].ID, i, got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestQueryAuditLogPages(t *testing.T) {
+	s, db := newTestService(t)
+	for i := 0; i < 5; i++ {
+		if err := models.RecordAudit(db, &models.AuditEntry{Action: models.AuditCheck, ActorID: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []uint64
+	var pages []int
+	token := ""
+	for {
+		entries, next, err := s.QueryAuditLog(9, "admin", models.AuditQuery{}, 2, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, len(entries))
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+		}
+		if next == "" || len(pages) > 5 {
+			break
+		}
+		token = next
+	}
+	if len(pages) != 3 || pages[0] != 2 || pages[1] != 2 || pages[2] != 1 {
+		t.Errorf("Expected pages of 2, 2 and 1 entries, got %v", pages)
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] <= ids[i-1] {
+			t.Errorf("Expected entries oldest first without repeats, got %v", ids)
+			break
+		}
+	}
+
+	if _, _, err := s.QueryAuditLog(9, "admin", models.AuditQuery{}, 2, "abc"); !stderrors.Is(err, errors.ErrInvalidPageToken) {
+		t.Errorf("Expected an invalid page token, got %v", err)
+	}
+}
+
+func TestQueryAuditLogRequiresAuditRole(t *testing.T) {
+	s, _ := newTestService(t)
+	for _, role := range []string{"", "viewer", "editor"} {
+		if _, _, err := s.QueryAuditLog(1, role, models.AuditQuery{}, 0, ""); !stderrors.Is(err, errors.ErrUserNotAuthorized) {
+			t.Errorf("Expected role %q to be refused, got %v", role, err)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"permission-service/internal/models"
+	"permission-service/utils/logger"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// changeBufferSize is the number of changes buffered per watcher before it is dropped.
+const changeBufferSize = 256
+
+// changeChannel is the Postgres notification channel changes are published on when they are
+// shared between replicas; see ListenForChanges.
+const changeChannel = "permission_changes"
+
+// Bounds on the delay before listening for shared changes again after the connection fails.
+const (
+	minListenBackoff = time.Second
+	maxListenBackoff = 30 * time.Second
+)
+
+// PermissionChange describes a write that may change permission decisions: access to FileID, or
+// UserID's group memberships. Reset is set when the affected decisions are not known cheaply,
+// such as when a folder's contents or a nested group change.
+type PermissionChange struct {
+	FileID string `json:"file_id,omitempty"`
+	UserID uint64 `json:"user_id,omitempty"`
+	Reset  bool   `json:"reset,omitempty"`
+}
+
+// ChangeFeed fans permission changes out to watchers, typically the decision caches of other
+// services. A watcher that falls behind is dropped rather than allowed to block writes; its
+// channel is closed so that it can reconnect and start from an empty cache.
+//
+// A feed that is down, because the changes it relays cannot be heard, drops every watcher and
+// refuses new ones until it is up again.
+type ChangeFeed struct {
+	mu       sync.Mutex
+	watchers map[chan PermissionChange]struct{}
+	down     bool
+}
+
+// NewChangeFeed creates a ChangeFeed with no watchers.
+func NewChangeFeed() *ChangeFeed {
+	return &ChangeFeed{watchers: make(map[chan PermissionChange]struct{})}
+}
+
+// Watch registers a watcher and returns its channel along with a function that unregisters it.
+// While the feed is down the channel is closed right away.
+func (f *ChangeFeed) Watch() (<-chan PermissionChange, func()) {
+	changes := make(chan PermissionChange, changeBufferSize)
+
+	f.mu.Lock()
+	if f.down {
+		f.mu.Unlock()
+		close(changes)
+		return changes, func() {}
+	}
+	f.watchers[changes] = struct{}{}
+	f.mu.Unlock()
+
+	return changes, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if _, ok := f.watchers[changes]; ok {
+			delete(f.watchers, changes)
+			close(changes)
+		}
+	}
+}
+
+// Publish sends a change to every watcher.
+func (f *ChangeFeed) Publish(change PermissionChange) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for changes := range f.watchers {
+		select {
+		case changes <- change:
+		default:
+			logger.Warning.Println("Dropping permission change watcher that fell behind")
+			delete(f.watchers, changes)
+			close(changes)
+		}
+	}
+}
+
+// setDown marks the feed as down, dropping every watcher, or as up again.
+func (f *ChangeFeed) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.down = down
+	if down {
+		for changes := range f.watchers {
+			delete(f.watchers, changes)
+			close(changes)
+		}
+	}
+}
+
+// WatchChanges registers a watcher for the changes made through this service, or through every
+// replica when they are shared with ListenForChanges.
+func (s *PermissionService) WatchChanges() (<-chan PermissionChange, func()) {
+	return s.changes.Watch()
+}
+
+// publishTupleChange publishes the change made by writing or deleting a tuple.
+func (s *PermissionService) publishTupleChange(tuple models.RelationTuple) {
+	change := PermissionChange{Reset: true}
+	switch {
+	case tuple.ObjectType == models.TypeFile:
+		change = PermissionChange{FileID: tuple.ObjectID}
+	case tuple.ObjectType == models.TypeGroup && tuple.SubjectType == models.TypeUser:
+		if userID, err := strconv.ParseUint(tuple.SubjectID, 10, 64); err == nil {
+			change = PermissionChange{UserID: userID}
+		}
+	}
+	s.publishChange(change)
+}
+
+// publishChange publishes a change to the watchers. When changes are shared between replicas it is
+// sent as a Postgres notification, which every replica's listener relays to its watchers,
+// including this one's.
+func (s *PermissionService) publishChange(change PermissionChange) {
+	if !s.sharedChanges {
+		s.changes.Publish(change)
+		return
+	}
+
+	payload, err := json.Marshal(change)
+	if err == nil {
+		err = s.DB.Exec("SELECT pg_notify(?, ?)", changeChannel, string(payload)).Error
+	}
+	if err != nil {
+		// Other replicas cannot be told, and their watchers keep their decisions until these
+		// expire; the watchers here are at least reset
+		logger.Error.Println("Failed to share permission change:", change, "Error:", err)
+		s.changes.Publish(PermissionChange{Reset: true})
+	}
+}
+
+// ListenForChanges shares permission changes between the replicas of the service through Postgres
+// notifications on the database at dsn, so that the watchers of every replica hear of changes made
+// through any of them. It must be called before the service is used; it listens, reconnecting with
+// backoff, until ctx is cancelled. While it is not listening the change feed is down, so watchers
+// are dropped and caches stop serving decisions rather than miss changes.
+func (s *PermissionService) ListenForChanges(ctx context.Context, dsn string) {
+	s.sharedChanges = true
+	s.changes.setDown(true)
+
+	go func() {
+		backoff := minListenBackoff
+		for {
+			err := s.listenForChanges(ctx, dsn, func() { backoff = minListenBackoff })
+			s.changes.setDown(true)
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Error.Println("Listening for shared permission changes failed, dropping change watchers:", err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxListenBackoff)
+		}
+	}()
+}
+
+// listenForChanges relays the notifications of one connection to the change feed until the
+// connection fails, calling listening once the feed is up.
+func (s *PermissionService) listenForChanges(ctx context.Context, dsn string, listening func()) error {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+changeChannel); err != nil {
+		return err
+	}
+	s.changes.setDown(false)
+	listening()
+	logger.Info.Println("Listening for permission changes shared by every replica")
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var change PermissionChange
+		if err := json.Unmarshal([]byte(notification.Payload), &change); err != nil {
+			logger.Warning.Println("Malformed permission change, resetting watchers:", notification.Payload)
+			change = PermissionChange{Reset: true}
+		}
+		s.changes.Publish(change)
+	}
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"net"
+	"permission-service/internal/models"
+	"permission-service/utils/logger"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CheckContext describes the request being checked. Expired tuples are ignored, as are tuples
+// whose condition does not hold for the permission and client address.
+type CheckContext struct {
+	Permission string
+	ClientIP   net.IP // Nil when the client address is unknown
+	Now        time.Time
+}
+
+// Checker evaluates relation tuples as a graph. A user holds a relation on an object when a tuple
+// grants it directly or through a stronger relation, when the user belongs to a userset holding
+// it (such as the members of a group), or when the object's parent folder grants it. The search
+// is bounded by maxDepth so that deep or cyclic graphs cannot exhaust the service.
+type Checker struct {
+	DB       *gorm.DB
+	maxDepth int
+}
+
+// NewChecker creates a Checker that follows at most maxDepth indirections per check.
+func NewChecker(db *gorm.DB, maxDepth int) *Checker {
+	return &Checker{DB: db, maxDepth: maxDepth}
+}
+
+// Check reports whether the user holds relation on the object in the given context.
+func (c *Checker) Check(objectType, objectID, relation string, userID uint64, ctx CheckContext) (bool, error) {
+	path, err := c.CheckPath(objectType, objectID, relation, userID, ctx)
+	return path != nil, err
+}
+
+// CheckPath returns the chain of tuples through which the user holds relation on the object, from
+// the object to the tuple naming the user, or nil if the user does not hold it.
+func (c *Checker) CheckPath(objectType, objectID, relation string, userID uint64, ctx CheckContext) ([]models.RelationTuple, error) {
+	return c.check(objectType, objectID, relation, models.UserSubject(userID), ctx, 0, make(map[string]int))
+}
+
+// check evaluates one node of the graph. visited records the shallowest depth at which each node
+// was expanded, so cycles terminate and a node is only expanded again if reached by a shorter path.
+func (c *Checker) check(objectType, objectID, relation, userID string, ctx CheckContext, depth int, visited map[string]int) ([]models.RelationTuple, error) {
+	if depth > c.maxDepth {
+		logger.Warning.Println("Permission check exceeded max depth", c.maxDepth, "at", objectType, objectID, relation)
+		return nil, nil
+	}
+
+	node := objectType + ":" + objectID + "#" + relation
+	if seen, ok := visited[node]; ok && seen <= depth {
+		return nil, nil
+	}
+	visited[node] = depth
+
+	relations := models.ImpliedBy(relation)
+	if models.IsAccessRelation(relation) {
+		// Files and folders inherit access relations from their parent folder
+		relations = append(relations, models.RelationParent)
+	}
+
+	tuples, err := models.ListObjectTuples(c.DB, objectType, objectID, relations)
+	if err != nil {
+		return nil, err
+	}
+	tuples = applicable(tuples, ctx)
+
+	// Direct grants first, as they need no further queries
+	for _, tuple := range tuples {
+		if tuple.Relation != models.RelationParent && tuple.SubjectType == models.TypeUser && tuple.SubjectID == userID {
+			return []models.RelationTuple{tuple}, nil
+		}
+	}
+
+	for _, tuple := range tuples {
+		var path []models.RelationTuple
+		switch {
+		case tuple.Relation == models.RelationParent:
+			path, err = c.check(models.TypeFolder, tuple.SubjectID, relation, userID, ctx, depth+1, visited)
+		case tuple.SubjectRelation != "":
+			path, err = c.check(tuple.SubjectType, tuple.SubjectID, tuple.SubjectRelation, userID, ctx, depth+1, visited)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if path != nil {
+			return append([]models.RelationTuple{tuple}, path...), nil
+		}
+	}
+
+	return nil, nil
+}
+
+// applicable drops the tuples that have expired or whose condition does not hold in ctx.
+func applicable(tuples []models.RelationTuple, ctx CheckContext) []models.RelationTuple {
+	kept := tuples[:0]
+	for _, tuple := range tuples {
+		if tuple.Expired(ctx.Now) {
+			continue
+		}
+		if tuple.Condition != "" {
+			condition, err := models.ParseCondition(tuple.Condition)
+			if err != nil {
+				logger.Warning.Println("Ignoring tuple with invalid condition:", tuple, "Error:", err)
+				continue
+			}
+			if !condition.Allows(ctx.Permission, ctx.ClientIP) {
+				continue
+			}
+		}
+		kept = append(kept, tuple)
+	}
+	return kept
+}
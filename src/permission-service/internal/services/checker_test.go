@@ -0,0 +1,193 @@
+package services
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"permission-service/internal/models"
+	"permission-service/internal/testutil"
+)
+
+// parseTuple parses a tuple written object_type:object_id#relation@subject_type:subject_id, with
+// an optional #subject_relation.
+func parseTuple(t *testing.T, s string) models.RelationTuple {
+	t.Helper()
+	object, subject, ok := strings.Cut(s, "@")
+	objectRef, relation, ok2 := strings.Cut(object, "#")
+	objectType, objectID, ok3 := strings.Cut(objectRef, ":")
+	subjectRef, subjectRelation, _ := strings.Cut(subject, "#")
+	subjectType, subjectID, ok4 := strings.Cut(subjectRef, ":")
+	if !ok || !ok2 || !ok3 || !ok4 {
+		t.Fatalf("Invalid tuple %q", s)
+	}
+	return models.RelationTuple{
+		ObjectType:      objectType,
+		ObjectID:        objectID,
+		Relation:        relation,
+		SubjectType:     subjectType,
+		SubjectID:       subjectID,
+		SubjectRelation: subjectRelation,
+	}
+}
+
+func TestCheckerCheck(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	tests := []struct {
+		name       string
+		tuples     []string
+		expiresAt  *time.Time // Of the last tuple
+		condition  string     // Of the last tuple
+		maxDepth   int        // 10 when zero
+		relation   string
+		userID     uint64
+		permission string
+		clientIP   string
+		want       bool
+	}{
+		{name: "direct grant", tuples: []string{"file:f1#viewer@user:1"}, relation: "viewer", userID: 1, want: true},
+		{name: "direct grant to another user", tuples: []string{"file:f1#viewer@user:1"}, relation: "viewer", userID: 2},
+		{name: "stronger relation", tuples: []string{"file:f1#editor@user:1"}, relation: "viewer", userID: 1, want: true},
+		{name: "weaker relation", tuples: []string{"file:f1#viewer@user:1"}, relation: "editor", userID: 1},
+		{
+			name:     "folder viewer views child",
+			tuples:   []string{"file:f1#parent@folder:d1", "folder:d1#viewer@user:1"},
+			relation: "viewer", userID: 1, want: true,
+		},
+		{
+			name:     "folder viewer does not edit child",
+			tuples:   []string{"file:f1#parent@folder:d1", "folder:d1#viewer@user:1"},
+			relation: "editor", userID: 1,
+		},
+		{
+			name:     "group member",
+			tuples:   []string{"file:f1#editor@group:eng#member", "group:eng#member@user:1"},
+			relation: "editor", userID: 1, want: true,
+		},
+		{
+			name:     "nested group member",
+			tuples:   []string{"file:f1#editor@group:eng#member", "group:eng#member@group:ops#member", "group:ops#member@user:1"},
+			relation: "viewer", userID: 1, want: true,
+		},
+		{
+			name:     "group cycle without the user",
+			tuples:   []string{"file:f1#viewer@group:a#member", "group:a#member@group:b#member", "group:b#member@group:a#member"},
+			relation: "viewer", userID: 1,
+		},
+		{
+			name:     "group cycle with the user",
+			tuples:   []string{"file:f1#viewer@group:a#member", "group:a#member@group:b#member", "group:b#member@group:a#member", "group:b#member@user:1"},
+			relation: "viewer", userID: 1, want: true,
+		},
+		{
+			name:     "folder cycle",
+			tuples:   []string{"file:f1#parent@folder:d1", "folder:d1#parent@folder:d2", "folder:d2#parent@folder:d1"},
+			relation: "viewer", userID: 1,
+		},
+		{
+			name:     "within the depth limit",
+			tuples:   []string{"file:f1#parent@folder:d1", "folder:d1#parent@folder:d2", "folder:d2#parent@folder:d3", "folder:d3#viewer@user:1"},
+			maxDepth: 3, relation: "viewer", userID: 1, want: true,
+		},
+		{
+			name:     "beyond the depth limit",
+			tuples:   []string{"file:f1#parent@folder:d1", "folder:d1#parent@folder:d2", "folder:d2#parent@folder:d3", "folder:d3#viewer@user:1"},
+			maxDepth: 2, relation: "viewer", userID: 1,
+		},
+		{name: "expired grant", tuples: []string{"file:f1#viewer@user:1"}, expiresAt: &past, relation: "viewer", userID: 1},
+		{name: "unexpired grant", tuples: []string{"file:f1#viewer@user:1"}, expiresAt: &future, relation: "viewer", userID: 1, want: true},
+		{
+			name:      "expired group membership",
+			tuples:    []string{"file:f1#viewer@group:eng#member", "group:eng#member@user:1"},
+			expiresAt: &past, relation: "viewer", userID: 1,
+		},
+		{
+			name:      "expired parent link",
+			tuples:    []string{"folder:d1#viewer@user:1", "file:f1#parent@folder:d1"},
+			expiresAt: &past, relation: "viewer", userID: 1,
+		},
+		{
+			name:      "client in the allowed range",
+			tuples:    []string{"file:f1#viewer@user:1"},
+			condition: "ip in 10.0.0.0/8", relation: "viewer", userID: 1, permission: "read", clientIP: "10.1.2.3", want: true,
+		},
+		{
+			name:      "client outside the allowed range",
+			tuples:    []string{"file:f1#viewer@user:1"},
+			condition: "ip in 10.0.0.0/8", relation: "viewer", userID: 1, permission: "read", clientIP: "192.168.1.1",
+		},
+		{
+			name:      "unknown client address",
+			tuples:    []string{"file:f1#viewer@user:1"},
+			condition: "ip in 10.0.0.0/8", relation: "viewer", userID: 1, permission: "read",
+		},
+		{
+			name:      "permission not excluded",
+			tuples:    []string{"file:f1#viewer@user:1"},
+			condition: "permission != download", relation: "viewer", userID: 1, permission: "read", want: true,
+		},
+		{
+			name:      "excluded permission",
+			tuples:    []string{"file:f1#viewer@user:1"},
+			condition: "permission != download", relation: "viewer", userID: 1, permission: "download",
+		},
+		{
+			name:      "excluded permission on the folder",
+			tuples:    []string{"file:f1#parent@folder:d1", "folder:d1#viewer@user:1"},
+			condition: "permission != download", relation: "viewer", userID: 1, permission: "download",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testutil.OpenDB(t)
+			for i, s := range tt.tuples {
+				tuple := parseTuple(t, s)
+				if i == len(tt.tuples)-1 {
+					tuple.ExpiresAt, tuple.Condition = tt.expiresAt, tt.condition
+				}
+				if err := models.WriteRelationTuple(db, &tuple); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			maxDepth := tt.maxDepth
+			if maxDepth == 0 {
+				maxDepth = 10
+			}
+			ctx := CheckContext{Permission: tt.permission, ClientIP: net.ParseIP(tt.clientIP), Now: now}
+			got, err := NewChecker(db, maxDepth).Check(models.TypeFile, "f1", tt.relation, tt.userID, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCheckerCheckPath(t *testing.T) {
+	db := testutil.OpenDB(t)
+	tuples := []string{"file:f1#parent@folder:d1", "folder:d1#editor@group:eng#member", "group:eng#member@user:1"}
+	for _, s := range tuples {
+		tuple := parseTuple(t, s)
+		if err := models.WriteRelationTuple(db, &tuple); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path, err := NewChecker(db, 10).CheckPath(models.TypeFile, "f1", models.RelationViewer, 1, CheckContext{Now: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, tuple := range path {
+		got = append(got, tuple.String())
+	}
+	if strings.Join(got, " ") != strings.Join(tuples, " ") {
+		t.Errorf("Expected the path %q, got %q", tuples, got)
+	}
+}
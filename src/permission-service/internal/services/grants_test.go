@@ -0,0 +1,159 @@
+package services
+
+import (
+	stderrors "errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"permission-service/internal/models"
+	"permission-service/utils/errors"
+
+	"gorm.io/gorm"
+)
+
+// writeTuples stores tuples written in the notation parseTuple reads.
+func writeTuples(t *testing.T, db *gorm.DB, tuples ...string) {
+	t.Helper()
+	for _, s := range tuples {
+		tuple := parseTuple(t, s)
+		if err := models.WriteRelationTuple(db, &tuple); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// hasTuple reports whether a tuple is stored.
+func hasTuple(t *testing.T, db *gorm.DB, s string) bool {
+	t.Helper()
+	tuple := parseTuple(t, s)
+	var count int64
+	err := db.Model(&models.RelationTuple{}).
+		Where("object_type = ? AND object_id = ? AND relation = ? AND subject_type = ? AND subject_id = ? AND subject_relation = ?",
+			tuple.ObjectType, tuple.ObjectID, tuple.Relation, tuple.SubjectType, tuple.SubjectID, tuple.SubjectRelation).
+		Count(&count).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count == 1
+}
+
+func TestRevokePermission(t *testing.T) {
+	tests := []struct {
+		name      string
+		ownerID   uint64
+		userID    uint64
+		groupID   uint64
+		wantErr   error
+		revoked   string // Tuple removed when the revocation succeeds
+		unchanged string // Tuple left in place
+	}{
+		{name: "owner revokes a viewer", ownerID: 1, userID: 3, revoked: "file:f1#viewer@user:3"},
+		{name: "owner revokes a group", ownerID: 1, groupID: 10, revoked: "file:f1#editor@group:10#member"},
+		{name: "owner of the folder revokes", ownerID: 4, userID: 3, revoked: "file:f1#viewer@user:3"},
+		{name: "editor may not revoke", ownerID: 2, userID: 3, wantErr: errors.ErrUserNotAuthorized, unchanged: "file:f1#viewer@user:3"},
+		{name: "viewer may not revoke", ownerID: 3, userID: 2, wantErr: errors.ErrUserNotAuthorized, unchanged: "file:f1#editor@user:2"},
+		{name: "ownership is not revoked", ownerID: 1, userID: 1, wantErr: errors.ErrPermissionNotFound, unchanged: "file:f1#owner@user:1"},
+		{name: "nothing to revoke", ownerID: 1, userID: 9, wantErr: errors.ErrPermissionNotFound},
+		{name: "user and group", ownerID: 1, userID: 3, groupID: 10, wantErr: errors.ErrInvalidPermission, unchanged: "file:f1#viewer@user:3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db := newTestService(t)
+			writeTuples(t, db,
+				"file:f1#owner@user:1",
+				"file:f1#editor@user:2",
+				"file:f1#viewer@user:3",
+				"file:f1#editor@group:10#member",
+				"file:f1#parent@folder:d1",
+				"folder:d1#owner@user:4",
+			)
+
+			err := s.RevokePermission(tt.ownerID, "f1", tt.userID, tt.groupID)
+			if !stderrors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected %v, got %v", tt.wantErr, err)
+			}
+			if tt.revoked != "" && hasTuple(t, db, tt.revoked) {
+				t.Errorf("Expected %s to be revoked", tt.revoked)
+			}
+			if tt.unchanged != "" && !hasTuple(t, db, tt.unchanged) {
+				t.Errorf("Expected %s to be kept", tt.unchanged)
+			}
+
+			decision := models.DecisionAllow
+			if stderrors.Is(tt.wantErr, errors.ErrUserNotAuthorized) {
+				decision = models.DecisionDeny
+			}
+			var count int64
+			db.Model(&models.AuditEntry{}).Where("action = ? AND actor_id = ? AND decision = ?", models.AuditRevoke, tt.ownerID, decision).Count(&count)
+			if wantAudited := tt.wantErr == nil || decision == models.DecisionDeny; (count == 1) != wantAudited {
+				t.Errorf("Expected the revocation to be audited only when made or refused, found %d %s entries", count, decision)
+			}
+		})
+	}
+}
+
+func TestListUserGrantsPages(t *testing.T) {
+	s, db := newTestService(t)
+	writeTuples(t, db,
+		"file:f1#viewer@user:5",
+		"file:other#viewer@user:6",
+		"file:f2#owner@user:5",
+		"folder:d1#commenter@user:5",
+		"file:f3#editor@group:10#member",
+		"file:f4#viewer@group:11#member",
+		"file:f5#parent@folder:d1",
+		"group:10#member@user:5",
+		"group:11#member@group:10#member",
+	)
+	expired := parseTuple(t, "file:f6#viewer@user:5")
+	expiredAt := time.Now().Add(-time.Hour)
+	expired.ExpiresAt = &expiredAt
+	if err := models.WriteRelationTuple(db, &expired); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	var pages []int
+	token := ""
+	for {
+		tuples, next, err := s.ListUserGrants(5, 2, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, len(tuples))
+		for _, tuple := range tuples {
+			got = append(got, tuple.String())
+		}
+		if next == "" {
+			break
+		}
+		if len(pages) > 10 {
+			t.Fatal("Expected the pages to end")
+		}
+		token = next
+	}
+
+	// Grants through nested groups are listed; parent links, expired grants and other users'
+	// grants are not
+	want := []string{
+		"file:f1#viewer@user:5",
+		"file:f2#owner@user:5",
+		"folder:d1#commenter@user:5",
+		"file:f3#editor@group:10#member",
+		"file:f4#viewer@group:11#member",
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if len(pages) != 3 || pages[0] != 2 || pages[1] != 2 || pages[2] != 1 {
+		t.Errorf("Expected pages of 2, 2 and 1 grants, got %v", pages)
+	}
+
+	if _, _, err := s.ListUserGrants(5, 2, "not-a-token"); !stderrors.Is(err, errors.ErrInvalidPageToken) {
+		t.Errorf("Expected an invalid page token, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	stderrors "errors"
+	"testing"
+	"time"
+
+	"permission-service/internal/models"
+	"permission-service/internal/testutil"
+	"permission-service/utils/errors"
+
+	"gorm.io/gorm"
+)
+
+// newTestService creates a PermissionService with the default role policy and a depth limit of 10.
+func newTestService(t *testing.T) (*PermissionService, *gorm.DB) {
+	t.Helper()
+	db := testutil.OpenDB(t)
+	policy, err := NewPolicyStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewPermissionService(db, 10, nil, policy), db
+}
+
+// newTestGroups creates groups owned by user 1 and returns their IDs by name.
+func newTestGroups(t *testing.T, s *PermissionService, names ...string) map[string]uint64 {
+	t.Helper()
+	ids := make(map[string]uint64)
+	for _, name := range names {
+		group, err := s.CreateGroup(1, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = group.ID
+	}
+	return ids
+}
+
+func TestAddGroupMemberRejectsCycles(t *testing.T) {
+	tests := []struct {
+		name    string
+		nested  [][2]string // Group, member group
+		expired [][2]string // Memberships that have expired
+		group   string
+		member  string
+		wantErr error
+	}{
+		{name: "group in itself", group: "a", member: "a", wantErr: errors.ErrInvalidGroup},
+		{name: "direct cycle", nested: [][2]string{{"a", "b"}}, group: "b", member: "a", wantErr: errors.ErrGroupCycle},
+		{name: "indirect cycle", nested: [][2]string{{"a", "b"}, {"b", "c"}}, group: "c", member: "a", wantErr: errors.ErrGroupCycle},
+		{name: "cycle through an expired membership", expired: [][2]string{{"a", "b"}}, group: "b", member: "a", wantErr: errors.ErrGroupCycle},
+		{name: "chain", nested: [][2]string{{"a", "b"}, {"b", "c"}}, group: "a", member: "c"},
+		{name: "diamond", nested: [][2]string{{"a", "b"}, {"a", "c"}, {"b", "d"}}, group: "c", member: "d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db := newTestService(t)
+			groups := newTestGroups(t, s, "a", "b", "c", "d")
+			for _, pair := range tt.nested {
+				if err := s.AddGroupMember(1, groups[pair[0]], 0, groups[pair[1]]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expiredAt := time.Now().Add(-time.Hour)
+			for _, pair := range tt.expired {
+				tuple := groupMemberTuple(groups[pair[0]], 0, groups[pair[1]])
+				tuple.ExpiresAt = &expiredAt
+				if err := models.WriteRelationTuple(db, tuple); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := s.AddGroupMember(1, groups[tt.group], 0, groups[tt.member])
+			if !stderrors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected %v, got %v", tt.wantErr, err)
+			}
+
+			tuple := groupMemberTuple(groups[tt.group], 0, groups[tt.member])
+			var count int64
+			db.Model(&models.RelationTuple{}).Where("object_id = ? AND subject_type = ? AND subject_id = ?", tuple.ObjectID, tuple.SubjectType, tuple.SubjectID).Count(&count)
+			if (count == 1) != (tt.wantErr == nil) {
+				t.Errorf("Expected the membership to be written only when allowed, found %d", count)
+			}
+		})
+	}
+}
+
+func TestAddGroupMemberAuditsRejectedCycle(t *testing.T) {
+	s, db := newTestService(t)
+	groups := newTestGroups(t, s, "a", "b")
+	if err := s.AddGroupMember(1, groups["a"], 0, groups["b"]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddGroupMember(1, groups["b"], 0, groups["a"]); !stderrors.Is(err, errors.ErrGroupCycle) {
+		t.Fatalf("Expected a cycle, got %v", err)
+	}
+
+	var entry models.AuditEntry
+	err := db.Where("action = ? AND decision = ?", models.AuditGroupAdd, models.DecisionDeny).First(&entry).Error
+	if err != nil {
+		t.Fatalf("Expected the refused membership to be audited: %v", err)
+	}
+	if entry.ObjectID != models.GroupSubject(groups["b"]) || entry.SubjectID != models.GroupSubject(groups["a"])+"#member" {
+		t.Errorf("Unexpected audit entry %+v", entry)
+	}
+}
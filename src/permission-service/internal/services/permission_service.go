@@ -1,51 +1,313 @@
 package services
 
 import (
+	"database/sql"
+	stderrors "errors"
+	"net"
+	"permission-service/internal/clients"
 	"permission-service/internal/models"
-	"permission-service/utils/logger"
 	"permission-service/utils/errors"
+	"permission-service/utils/logger"
+	"strconv"
+	"time"
+
 	"gorm.io/gorm"
 )
 
 type PermissionService struct {
-	DB *gorm.DB
+	DB                 *gorm.DB
+	checker            *Checker
+	notificationClient *clients.NotificationClient
+	changes            *ChangeFeed
+	sharedChanges      bool // Changes are published through Postgres; see ListenForChanges
+	policy             *PolicyStore
+	auditQueue         chan *models.AuditEntry // Check decisions waiting for StartAuditWriter
 }
 
-// NewPermissionService creates a new PermissionService
-func NewPermissionService(db *gorm.DB) *PermissionService {
-	return &PermissionService{DB: db}
+// NewPermissionService creates a new PermissionService whose checks follow at most maxDepth
+// indirections (group memberships, parent folders) through the relation graph and apply the
+// role policy held by policy. The notification client, which may be nil, is used to tell users
+// when the grants they made expire. Check decisions are only audited while StartAuditWriter runs.
+func NewPermissionService(db *gorm.DB, maxDepth int, notificationClient *clients.NotificationClient, policy *PolicyStore) *PermissionService {
+	return &PermissionService{DB: db, checker: NewChecker(db, maxDepth), notificationClient: notificationClient, changes: NewChangeFeed(), policy: policy, auditQueue: make(chan *models.AuditEntry, auditQueueSize)}
 }
 
-// CheckPermission checks if a user has a specific permission for a file.
-func (s *PermissionService) CheckPermission(userID uint64, fileID string, permissionType string) (bool, error) {
-	logger.Info.Println("Checking permission for user:", userID, "on file:", fileID)
+// CheckPermission checks if a user has a specific permission for a file or folder. clientIP is
+// the address the request came from, used by grants restricted to IP ranges; it may be empty.
+//
+// The user's role is checked against the role policy first. With an empty objectID the check is
+// for an action not tied to a file, such as uploading, and the role policy alone decides it.
+// Every decision is recorded in the audit log along with the reason for it. When access is granted
+// through grants that expire, the earliest expiry is returned too, so that callers caching the
+// decision know when it stops holding.
+func (s *PermissionService) CheckPermission(userID uint64, role, objectType, objectID string, permissionType string, clientIP string) (bool, *time.Time, error) {
+	logger.Info.Println("Checking permission for user:", userID, "role:", role, "on", objectType, objectID)
+
+	if objectType == "" {
+		objectType = models.TypeFile
+	}
+	relation, ok := models.RelationForPermission(permissionType)
+	if !ok {
+		logger.Warning.Println("Unknown permission type:", permissionType)
+		return false, nil, errors.ErrInvalidPermission
+	}
 
-	// Check the permission in the database
-	hasPermission, err := models.GetPermission(s.DB, userID, fileID, permissionType)
+	ctx := CheckContext{Permission: permissionType, ClientIP: net.ParseIP(clientIP), Now: time.Now()}
+	hasPermission, reason, validUntil, err := s.decide(s.policy.Policy(), userID, role, objectType, objectID, relation, ctx)
 	if err != nil {
 		logger.Error.Println("Error checking permission for user:", userID, "Error:", err)
-		return false, errors.WrapDatabaseError(err)
+		return false, nil, err
 	}
+	s.recordChecks(checkEntry(userID, objectType, objectID, permissionType, hasPermission, reason))
 
-	logger.Info.Println("Permission check result for user:", userID, "on file:", fileID, ":", hasPermission)
-	return hasPermission, nil
+	logger.Info.Println("Permission check result for user:", userID, "on", objectType, objectID, ":", hasPermission, "-", reason)
+	return hasPermission, validUntil, nil
 }
 
-// UpdatePermission updates permissions for a file or folder for a shared user.
-func (s *PermissionService) UpdatePermission(ownerID, sharedUserID uint64, fileIDs []string, permissions []string) error {
-	logger.Info.Println("Updating permissions for owner:", ownerID, "to share with user:", sharedUserID)
+// decide makes a permission decision for CheckPermission and BatchCheckPermission and explains it.
+// Access granted through tuples that expire is only valid until the earliest of them expires.
+func (s *PermissionService) decide(policy *models.Policy, userID uint64, role, objectType, objectID, relation string, ctx CheckContext) (bool, string, *time.Time, error) {
+	if objectID == "" {
+		allowed := policy.AllowsGlobal(role, ctx.Permission)
+		return allowed, "global role policy for role " + strconv.Quote(role), nil, nil
+	}
+	if allowed, decided := policy.Decide(role, ctx.Permission); decided {
+		return allowed, "role policy for role " + strconv.Quote(role), nil, nil
+	}
 
+	// Evaluate the relation graph
+	path, err := s.checker.CheckPath(objectType, objectID, relation, userID, ctx)
+	if err != nil {
+		return false, "", nil, err
+	}
+	if path == nil {
+		return false, "no applicable grant", nil, nil
+	}
+
+	var validUntil *time.Time
+	for _, tuple := range path {
+		if tuple.ExpiresAt != nil && (validUntil == nil || tuple.ExpiresAt.Before(*validUntil)) {
+			validUntil = tuple.ExpiresAt
+		}
+	}
+	return true, describePath(path), validUntil, nil
+}
+
+// UpdatePermission grants permissions on files. Owners are given the owner relation; shared users
+// and groups are given the weakest relation that covers all of the requested permissions. Sharing
+// with a group grants access to all of its members, including those of nested groups.
+//
+// The granter must be allowed to share every file and may not grant more than they hold. The
+// whole batch is applied in one transaction, and sharing again replaces the subject's previous
+// relation on the file instead of adding to it.
+//
+// Grants stop applying at expiresAt when it is set, and only apply while condition holds when
+// one is given.
+func (s *PermissionService) UpdatePermission(ownerID, sharedUserID, sharedGroupID uint64, fileIDs []string, permissions []string, isOwner bool, expiresAt *time.Time, condition string) error {
+	logger.Info.Println("Updating permissions for owner:", ownerID, "to share with user:", sharedUserID, "group:", sharedGroupID)
+
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return errors.ErrInvalidExpiry
+	}
+	if _, err := models.ParseCondition(condition); err != nil {
+		return err
+	}
 	for _, fileID := range fileIDs {
-		for _, perm := range permissions {
-			// Create a new permission entry or update an existing one
-			err := models.CreatePermission(s.DB, sharedUserID, fileID, ownerID, perm)
-			if err != nil {
-				logger.Error.Println("Error updating permission for file:", fileID, "and user:", sharedUserID, "Error:", err)
-				return errors.WrapDatabaseError(err)
+		if fileID == "" {
+			return errors.ErrInvalidPermission
+		}
+	}
+
+	subject := models.RelationTuple{SubjectType: models.TypeUser, SubjectID: models.UserSubject(sharedUserID)}
+	relation := models.RelationOwner
+	if isOwner {
+		subject.SubjectID = models.UserSubject(ownerID)
+	} else {
+		var err error
+		relation, err = models.RelationForPermissions(permissions)
+		if err != nil {
+			logger.Warning.Println("Invalid permissions requested:", permissions)
+			return err
+		}
+
+		switch {
+		case sharedGroupID != 0:
+			if _, err := models.GetGroup(s.DB, sharedGroupID); err != nil {
+				return err
 			}
+			subject = models.RelationTuple{
+				SubjectType:     models.TypeGroup,
+				SubjectID:       models.GroupSubject(sharedGroupID),
+				SubjectRelation: models.RelationMember,
+			}
+		case sharedUserID == 0:
+			return errors.ErrInvalidPermission
 		}
 	}
 
-	logger.Info.Println("Permissions successfully updated for user:", sharedUserID, "on files:", fileIDs)
+	grant := func(fileID string) models.RelationTuple {
+		return models.RelationTuple{
+			ObjectType:      models.TypeFile,
+			ObjectID:        fileID,
+			Relation:        relation,
+			SubjectType:     subject.SubjectType,
+			SubjectID:       subject.SubjectID,
+			SubjectRelation: subject.SubjectRelation,
+			OwnerID:         ownerID,
+			ExpiresAt:       expiresAt,
+			Condition:       condition,
+		}
+	}
+
+	// The batch is authorized and written in one transaction, so that no grant is made on
+	// authority revoked in the meantime, and grants are audited in it too, so none is made without
+	// being recorded
+	var denied *models.RelationTuple
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		for _, fileID := range fileIDs {
+			if err := s.authorizeGrant(tx, ownerID, models.TypeFile, fileID, relation, isOwner); err != nil {
+				tuple := grant(fileID)
+				denied = &tuple
+				return err
+			}
+		}
+
+		for _, fileID := range fileIDs {
+			tuple := grant(fileID)
+			if err := models.SetAccessTuple(tx, &tuple); err != nil {
+				logger.Error.Println("Error updating permission for file:", fileID, "and", subject.SubjectType, subject.SubjectID, "Error:", err)
+				return err
+			}
+			if err := recordChange(tx, models.AuditGrant, ownerID, tuple, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, serializable)
+	if denied != nil {
+		s.recordDecisions(tupleEntry(models.AuditGrant, ownerID, *denied, err))
+	}
+	if err != nil {
+		return err
+	}
+	for _, fileID := range fileIDs {
+		s.publishChange(PermissionChange{FileID: fileID})
+	}
+
+	logger.Info.Println("Permissions successfully updated for", subject.SubjectType, subject.SubjectID, "on files:", fileIDs)
 	return nil
-}
\ No newline at end of file
+}
+
+// WriteRelation stores a relation tuple after validating it against the schema and checking that
+// the writer may make it: group members are managed by the group owner, relations on files and
+// folders follow the same rules as UpdatePermission, and only the owner of a file or folder may
+// place it in a folder, which they must be allowed to share. Ownership cannot be claimed here.
+// The check and the write are made in one transaction.
+func (s *PermissionService) WriteRelation(ownerID uint64, tuple *models.RelationTuple) error {
+	if err := models.ValidateTuple(tuple); err != nil {
+		logger.Warning.Println("Rejected relation tuple:", tuple)
+		return err
+	}
+
+	tuple.OwnerID = ownerID
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := s.authorizeRelation(tx, ownerID, tuple); err != nil {
+			return err
+		}
+		if err := models.WriteRelationTuple(tx, tuple); err != nil {
+			return err
+		}
+		return recordChange(tx, models.AuditWriteRelation, ownerID, *tuple, nil)
+	}, serializable)
+	if stderrors.Is(err, errors.ErrUserNotAuthorized) || stderrors.Is(err, errors.ErrGroupCycle) {
+		s.recordDecisions(tupleEntry(models.AuditWriteRelation, ownerID, *tuple, err))
+	}
+	if err != nil {
+		return err
+	}
+	s.publishTupleChange(*tuple)
+	return nil
+}
+
+// authorizeRelation checks that a writer may store a relation tuple with WriteRelation.
+func (s *PermissionService) authorizeRelation(tx *gorm.DB, writerID uint64, tuple *models.RelationTuple) error {
+	switch {
+	case tuple.ObjectType == models.TypeGroup:
+		groupID, err := strconv.ParseUint(tuple.ObjectID, 10, 64)
+		if err != nil {
+			return errors.ErrInvalidRelation
+		}
+		group, err := models.GetGroup(tx, groupID)
+		if err != nil {
+			return err
+		}
+		if group.OwnerID != writerID {
+			logger.Warning.Println("User", writerID, "does not own group:", groupID)
+			return errors.ErrUserNotAuthorized
+		}
+		return checkGroupCycle(tx, tuple)
+
+	case tuple.Relation == models.RelationParent:
+		// Placing an object in a folder gives the folder's users access to it, including its
+		// owners, so the writer must own the object and be allowed to share the folder
+		return s.requireRelations(tx, writerID, []objectRelation{
+			{tuple.ObjectType, tuple.ObjectID, models.RelationOwner},
+			{tuple.SubjectType, tuple.SubjectID, shareRelation},
+		})
+
+	default:
+		return s.authorizeGrant(tx, writerID, tuple.ObjectType, tuple.ObjectID, tuple.Relation, false)
+	}
+}
+
+// shareRelation is the weakest relation allowed to share an object.
+var shareRelation, _ = models.RelationForPermission("share")
+
+// serializable is used by transactions that check the writer's relations before writing, so that
+// concurrent changes to those relations make one of them fail instead of going unnoticed.
+var serializable = &sql.TxOptions{Isolation: sql.LevelSerializable}
+
+// authorizeGrant checks, within tx, that a granter may give relation on an object: they must be
+// allowed to share it and hold the relation themselves. When claim is set, as it is for the owner
+// grant the file picker makes when a file is created, the granter may instead take ownership of
+// an object that has no tuples at all yet. Callers must only set claim for requests from a
+// service account that may delegate.
+func (s *PermissionService) authorizeGrant(tx *gorm.DB, granterID uint64, objectType, objectID, relation string, claim bool) error {
+	if claim && relation == models.RelationOwner {
+		relations := append(models.ImpliedBy(models.RelationViewer), models.RelationParent)
+		existing, err := models.ListObjectTuples(tx, objectType, objectID, relations)
+		if err != nil {
+			return err
+		}
+		if len(existing) == 0 {
+			return nil
+		}
+	}
+
+	return s.requireRelations(tx, granterID, []objectRelation{
+		{objectType, objectID, shareRelation},
+		{objectType, objectID, relation},
+	})
+}
+
+// objectRelation names a relation on an object.
+type objectRelation struct {
+	objectType, objectID, relation string
+}
+
+// requireRelations checks, within tx, that the user holds every one of the relations, returning
+// ErrUserNotAuthorized otherwise.
+func (s *PermissionService) requireRelations(tx *gorm.DB, userID uint64, required []objectRelation) error {
+	checker := NewChecker(tx, s.checker.maxDepth)
+	for _, r := range required {
+		allowed, err := checker.Check(r.objectType, r.objectID, r.relation, userID, CheckContext{Permission: "share", Now: time.Now()})
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			logger.Warning.Println("User", userID, "lacks", r.relation, "on", r.objectType, r.objectID)
+			return errors.ErrUserNotAuthorized
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"crypto/sha256"
+	stderrors "errors"
+	"io/fs"
+	"os"
+	"permission-service/internal/models"
+	"permission-service/utils/logger"
+	"sync"
+	"time"
+)
+
+// PolicyStore holds the role policy loaded from a policy file and reloads it when the file changes.
+type PolicyStore struct {
+	path string
+
+	mu     sync.RWMutex
+	policy *models.Policy
+	digest [sha256.Size]byte // Digest of the file contents last loaded
+}
+
+// NewPolicyStore loads the role policy from path. When the file does not exist the default
+// policy is used until it is created.
+func NewPolicyStore(path string) (*PolicyStore, error) {
+	store := &PolicyStore{path: path, policy: models.DefaultPolicy()}
+	_, err := store.Reload()
+	switch {
+	case stderrors.Is(err, fs.ErrNotExist):
+		logger.Warning.Println("Policy file", path, "not found, using the default role policy")
+	case err != nil:
+		return nil, err
+	}
+	return store, nil
+}
+
+// Policy returns the current role policy.
+func (s *PolicyStore) Policy() *models.Policy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.policy
+}
+
+// Reload reads the policy file again and reports whether the policy was replaced, which it is
+// when the contents changed since they were last loaded. Contents are compared rather than
+// modification times, which are coarse on some file systems and kept by ConfigMap updates and
+// copies. An invalid file leaves the current policy in place.
+func (s *PolicyStore) Reload() (bool, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return false, err
+	}
+	digest := sha256.Sum256(data)
+
+	s.mu.RLock()
+	unchanged := digest == s.digest
+	s.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	policy, err := models.ParsePolicy(data)
+	if err != nil {
+		logger.Error.Println("Invalid policy file", s.path, "keeping the current role policy:", err)
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.policy = policy
+	s.digest = digest
+	s.mu.Unlock()
+
+	logger.Info.Println("Loaded role policy from", s.path)
+	return true, nil
+}
+
+// ReloadPolicy reloads the role policy file. Decisions cached by other services are dropped when
+// the policy changes.
+func (s *PermissionService) ReloadPolicy() error {
+	changed, err := s.policy.Reload()
+	if changed {
+		s.recordDecisions(&models.AuditEntry{Action: models.AuditPolicyReload, Reason: "reloaded " + s.policy.path})
+		s.publishChange(PermissionChange{Reset: true})
+	}
+	return err
+}
+
+// AllowsDelegation reports whether service accounts with role may act on behalf of other users.
+func (s *PermissionService) AllowsDelegation(role string) bool {
+	return s.policy.Policy().AllowsDelegation(role)
+}
+
+// WatchPolicy reloads the role policy file whenever it changes, checking every interval until stop
+// is closed.
+func (s *PermissionService) WatchPolicy(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// Failures are logged by Reload and the current policy stays in force
+			_ = s.ReloadPolicy()
+		}
+	}
+}
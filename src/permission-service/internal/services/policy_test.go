@@ -0,0 +1,128 @@
+package services
+
+import (
+	stderrors "errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"permission-service/internal/models"
+	"permission-service/internal/testutil"
+	"permission-service/utils/errors"
+)
+
+// Policies differing only in whether viewers may write or share every file, in files of the same
+// size.
+const (
+	viewerWritePolicy = `{"default_role": "viewer", "roles": {"viewer": {"global": ["read"], "allow": ["write"]}}}`
+	viewerSharePolicy = `{"default_role": "viewer", "roles": {"viewer": {"global": ["read"], "allow": ["share"]}}}`
+)
+
+// writePolicy replaces the contents of a policy file.
+func writePolicy(t *testing.T, path, policy string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(policy), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewPolicyStoreDefaultsWithoutFile(t *testing.T) {
+	testutil.OpenDB(t)
+	store, err := NewPolicyStore(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !store.Policy().AllowsDelegation("admin") || store.Policy().AllowsGlobal("viewer", "write") {
+		t.Error("Expected the default policy")
+	}
+}
+
+func TestReloadPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	writePolicy(t, path, viewerWritePolicy)
+	db := testutil.OpenDB(t)
+	store, err := NewPolicyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewPermissionService(db, 10, nil, store)
+	changes, stop := s.WatchChanges()
+	defer stop()
+
+	allowed := func(permission string) bool {
+		t.Helper()
+		allowed, _, err := s.CheckPermission(1, "viewer", models.TypeFile, "f1", permission, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return allowed
+	}
+	if !allowed("write") || allowed("share") {
+		t.Fatal("Expected the policy file to be loaded")
+	}
+
+	// Reloading unchanged contents changes nothing
+	if err := s.ReloadPolicy(); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("Expected no change to be published, got %d", len(changes))
+	}
+
+	// New contents of the same size are picked up, even if the modification time is kept
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePolicy(t, path, viewerSharePolicy)
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadPolicy(); err != nil {
+		t.Fatal(err)
+	}
+	if allowed("write") || !allowed("share") {
+		t.Error("Expected the new policy to apply")
+	}
+	if len(changes) != 1 || !(<-changes).Reset {
+		t.Error("Expected cached decisions to be reset")
+	}
+	var reloads int64
+	db.Model(&models.AuditEntry{}).Where("action = ?", models.AuditPolicyReload).Count(&reloads)
+	if reloads != 1 {
+		t.Errorf("Expected the reload to be audited, got %d entries", reloads)
+	}
+
+	// An invalid policy leaves the current one in force
+	for _, invalid := range []string{`{"default_role": "viewer"`, `{"default_role": "guest", "roles": {}}`, `{"default_role": "viewer", "roles": {"viewer": {"allow": ["fly"]}}}`} {
+		writePolicy(t, path, invalid)
+		if err := s.ReloadPolicy(); !stderrors.Is(err, errors.ErrInvalidPolicy) {
+			t.Errorf("Expected %s to be refused, got %v", invalid, err)
+		}
+	}
+	if allowed("write") || !allowed("share") {
+		t.Error("Expected the last valid policy to stay in force")
+	}
+	if len(changes) != 0 {
+		t.Errorf("Expected no change to be published for invalid policies, got %d", len(changes))
+	}
+}
+
+func TestPolicyDenialsApplyWithoutRole(t *testing.T) {
+	s, db := newTestService(t)
+	writeTuples(t, db, "file:f1#editor@user:1")
+
+	// Checks without a role get the default role's denials, so they cannot escape them
+	for _, role := range []string{"", "unknown"} {
+		allowed, _, err := s.CheckPermission(1, role, models.TypeFile, "f1", "write", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed {
+			t.Errorf("Expected role %q to be denied writes by the default role", role)
+		}
+	}
+	if allowed, _, err := s.CheckPermission(1, "editor", models.TypeFile, "f1", "write", ""); err != nil || !allowed {
+		t.Errorf("Expected editors to write through their grant, got %v (%v)", allowed, err)
+	}
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"permission-service/internal/models"
+	"permission-service/utils/errors"
+	"permission-service/utils/logger"
+
+	"gorm.io/gorm"
+)
+
+// PurgeUser removes a deleted user from the permission data: every tuple naming the user as its
+// subject, such as their grants, ownerships and group memberships, and every tuple on the files
+// and folders they owned. Only roles allowed to manage users may purge them. Purging a user twice
+// removes nothing the second time.
+func (s *PermissionService) PurgeUser(requesterID uint64, role string, userID uint64) (int64, error) {
+	logger.Info.Println("Purging user:", userID, "requested by user:", requesterID)
+
+	if userID == 0 {
+		return 0, errors.ErrInvalidPermission
+	}
+	if !s.policy.Policy().AllowsUserManagement(role) {
+		logger.Warning.Println("User", requesterID, "with role", role, "may not purge users")
+		s.recordDecisions(&models.AuditEntry{
+			Action:      models.AuditPurgeUser,
+			ActorID:     requesterID,
+			SubjectType: models.TypeUser,
+			SubjectID:   models.UserSubject(userID),
+			Decision:    models.DecisionDeny,
+			Reason:      errors.ErrUserNotAuthorized.Error(),
+		})
+		return 0, errors.ErrUserNotAuthorized
+	}
+
+	var tuples []models.RelationTuple
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if tuples, err = models.ListUserPurgeTuples(tx, userID); err != nil || len(tuples) == 0 {
+			return err
+		}
+
+		ids := make([]uint64, len(tuples))
+		entries := make([]*models.AuditEntry, len(tuples))
+		for i, tuple := range tuples {
+			ids[i] = tuple.ID
+			entries[i] = tupleEntry(models.AuditPurgeUser, requesterID, tuple, nil)
+		}
+		if err := models.DeleteTuplesByID(tx, ids); err != nil {
+			return err
+		}
+		return models.RecordAudit(tx, entries...)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, tuple := range tuples {
+		s.publishTupleChange(tuple)
+	}
+
+	logger.Info.Println("Purged", len(tuples), "relation tuples of user:", userID)
+	return int64(len(tuples)), nil
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"fmt"
+	"permission-service/internal/models"
+	"permission-service/utils/logger"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// sweepBatchSize is the number of expired tuples deleted per query.
+const sweepBatchSize = 500
+
+// StartSweeper deletes expired grants every interval until stop is closed.
+func (s *PermissionService) StartSweeper(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := s.SweepExpiredGrants(); err != nil {
+				logger.Error.Println("Failed to sweep expired grants:", err)
+			}
+		}
+	}
+}
+
+// SweepExpiredGrants deletes the tuples that have expired and tells the users who granted them.
+// Expired tuples are already ignored by permission checks; sweeping keeps the table and the
+// grant listings clean.
+func (s *PermissionService) SweepExpiredGrants() error {
+	now := time.Now()
+	swept := 0
+	for {
+		// Tuples are listed and deleted in one transaction, which holds them locked, so that a
+		// grant renewed meanwhile is neither deleted nor reported as expired
+		var tuples []models.RelationTuple
+		err := s.DB.Transaction(func(tx *gorm.DB) error {
+			var err error
+			tuples, err = models.ListExpiredTuples(tx, now, sweepBatchSize)
+			if err != nil || len(tuples) == 0 {
+				return err
+			}
+
+			ids := make([]uint64, len(tuples))
+			for i, tuple := range tuples {
+				ids[i] = tuple.ID
+			}
+			deleted, err := models.DeleteExpiredTuples(tx, ids, now)
+			if err != nil {
+				return err
+			}
+			if deleted != int64(len(tuples)) {
+				return fmt.Errorf("swept %d of %d expired tuples", deleted, len(tuples))
+			}
+			entries := make([]*models.AuditEntry, len(tuples))
+			for i, tuple := range tuples {
+				entries[i] = tupleEntry(models.AuditExpire, 0, tuple, nil)
+			}
+			return models.RecordAudit(tx, entries...)
+		})
+		if err != nil {
+			return err
+		}
+		if len(tuples) == 0 {
+			break
+		}
+
+		for _, tuple := range tuples {
+			s.publishTupleChange(tuple)
+			s.notifyExpired(tuple)
+		}
+
+		swept += len(tuples)
+		if len(tuples) < sweepBatchSize {
+			break
+		}
+	}
+
+	if swept > 0 {
+		logger.Info.Println("Swept", swept, "expired grants")
+	}
+	return nil
+}
+
+// notifyExpired tells the user who made a grant that it has expired.
+func (s *PermissionService) notifyExpired(tuple models.RelationTuple) {
+	if s.notificationClient == nil || tuple.OwnerID == 0 {
+		return
+	}
+
+	message := fmt.Sprintf("The %s access you gave %s %s to %s %s has expired",
+		tuple.Relation, tuple.SubjectType, tuple.SubjectID, tuple.ObjectType, tuple.ObjectID)
+	if tuple.ObjectType == models.TypeGroup {
+		message = fmt.Sprintf("The membership of %s %s in group %s has expired", tuple.SubjectType, tuple.SubjectID, tuple.ObjectID)
+	}
+
+	if err := s.notificationClient.SendNotification(tuple.OwnerID, message); err != nil {
+		logger.Warning.Println("Failed to notify user", tuple.OwnerID, "of expired grant:", tuple, "Error:", err)
+	}
+}
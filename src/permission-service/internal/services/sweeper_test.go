@@ -0,0 +1,121 @@
+package services
+
+import (
+	stderrors "errors"
+	"testing"
+	"time"
+
+	"permission-service/internal/models"
+	"permission-service/utils/errors"
+)
+
+func TestUpdatePermissionExpiry(t *testing.T) {
+	s, db := newTestService(t)
+	writeTuples(t, db, "file:f1#owner@user:1")
+
+	past := time.Now().Add(-time.Minute)
+	if err := s.UpdatePermission(1, 2, 0, []string{"f1"}, []string{"read"}, false, &past, ""); !stderrors.Is(err, errors.ErrInvalidExpiry) {
+		t.Fatalf("Expected a grant expiring in the past to be refused, got %v", err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	if err := s.UpdatePermission(1, 2, 0, []string{"f1"}, []string{"read"}, false, &expiresAt, ""); err != nil {
+		t.Fatal(err)
+	}
+	allowed, validUntil, err := s.CheckPermission(2, "editor", models.TypeFile, "f1", "read", "")
+	if err != nil || !allowed {
+		t.Fatalf("Expected the grant to apply until it expires, got %v (%v)", allowed, err)
+	}
+	if validUntil == nil || validUntil.Sub(expiresAt).Abs() > time.Second {
+		t.Errorf("Expected the decision to hold until %v, got %v", expiresAt, validUntil)
+	}
+
+	// Expired grants stop applying before they are swept
+	if err := db.Model(&models.RelationTuple{}).Where("object_id = ? AND subject_id = ?", "f1", "2").Update("expires_at", past).Error; err != nil {
+		t.Fatal(err)
+	}
+	if allowed, _, err := s.CheckPermission(2, "editor", models.TypeFile, "f1", "read", ""); err != nil || allowed {
+		t.Errorf("Expected the expired grant to be ignored, got %v (%v)", allowed, err)
+	}
+}
+
+func TestUpdatePermissionCondition(t *testing.T) {
+	s, db := newTestService(t)
+	writeTuples(t, db, "file:f1#owner@user:1")
+
+	if err := s.UpdatePermission(1, 2, 0, []string{"f1"}, []string{"read"}, false, nil, "ip in 10.0.0.0/8 && time < 5pm"); !stderrors.Is(err, errors.ErrInvalidCondition) {
+		t.Fatalf("Expected an invalid condition to be refused, got %v", err)
+	}
+	if err := s.UpdatePermission(1, 2, 0, []string{"f1"}, []string{"read", "download"}, false, nil, "ip in 10.0.0.0/8 && permission != download"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		permission string
+		clientIP   string
+		want       bool
+	}{
+		{"read", "10.1.2.3", true},
+		{"read", "192.168.1.1", false},
+		{"read", "", false},
+		{"download", "10.1.2.3", false},
+	}
+	for _, tt := range tests {
+		allowed, _, err := s.CheckPermission(2, "editor", models.TypeFile, "f1", tt.permission, tt.clientIP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != tt.want {
+			t.Errorf("Expected %s from %q to be %v, got %v", tt.permission, tt.clientIP, tt.want, allowed)
+		}
+	}
+}
+
+func TestSweepExpiredGrants(t *testing.T) {
+	s, db := newTestService(t)
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+
+	// More expired tuples than are swept per batch
+	var tuples []models.RelationTuple
+	for i := 0; i < sweepBatchSize+1; i++ {
+		tuple := parseTuple(t, "file:f1#viewer@user:2")
+		tuple.SubjectID = models.UserSubject(uint64(100 + i))
+		tuple.OwnerID, tuple.ExpiresAt = 1, &past
+		tuples = append(tuples, tuple)
+	}
+	membership := parseTuple(t, "group:10#member@user:3")
+	membership.OwnerID, membership.ExpiresAt = 1, &past
+	renewed := parseTuple(t, "file:f2#viewer@user:2")
+	renewed.OwnerID, renewed.ExpiresAt = 1, &future
+	tuples = append(tuples, membership, renewed, parseTuple(t, "file:f2#owner@user:1"))
+	if err := db.CreateInBatches(tuples, 100).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SweepExpiredGrants(); err != nil {
+		t.Fatal(err)
+	}
+
+	var remaining []models.RelationTuple
+	if err := db.Order("id").Find(&remaining).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 2 || remaining[0].String() != "file:f2#viewer@user:2" || remaining[1].String() != "file:f2#owner@user:1" {
+		t.Errorf("Expected only the unexpired tuples to remain, got %v", remaining)
+	}
+
+	var expired int64
+	db.Model(&models.AuditEntry{}).Where("action = ?", models.AuditExpire).Count(&expired)
+	if expired != sweepBatchSize+2 {
+		t.Errorf("Expected every swept tuple to be audited, got %d entries", expired)
+	}
+
+	// Sweeping again finds nothing
+	if err := s.SweepExpiredGrants(); err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&models.AuditEntry{}).Where("action = ?", models.AuditExpire).Count(&expired)
+	if expired != sweepBatchSize+2 {
+		t.Errorf("Expected nothing more to be swept, got %d entries", expired)
+	}
+}
@@ -0,0 +1,44 @@
+// Package testutil provides the database the permission service's tests run against.
+package testutil
+
+import (
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+
+	"permission-service/internal/models"
+	"permission-service/utils/logger"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// OpenDB opens a migrated SQLite database in a temporary directory, and discards the service's
+// log output.
+func OpenDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	if logger.Info == nil {
+		logger.Info = log.New(io.Discard, "", 0)
+		logger.Warning = log.New(io.Discard, "", 0)
+		logger.Error = log.New(io.Discard, "", 0)
+	}
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "permissions.db")), &gorm.Config{Logger: gormlogger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SQLite allows one writer at a time
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(&models.RelationTuple{}, &models.Group{}, &models.AuditEntry{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
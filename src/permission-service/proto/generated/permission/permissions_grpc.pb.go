@@ -19,8 +19,22 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	PermissionService_CheckPermission_FullMethodName  = "/permission.PermissionService/CheckPermission"
-	PermissionService_UpdatePermission_FullMethodName = "/permission.PermissionService/UpdatePermission"
+	PermissionService_CheckPermission_FullMethodName        = "/permission.PermissionService/CheckPermission"
+	PermissionService_BatchCheckPermission_FullMethodName   = "/permission.PermissionService/BatchCheckPermission"
+	PermissionService_ListAccessibleFileIDs_FullMethodName  = "/permission.PermissionService/ListAccessibleFileIDs"
+	PermissionService_WatchPermissionChanges_FullMethodName = "/permission.PermissionService/WatchPermissionChanges"
+	PermissionService_RecordCachedDecisions_FullMethodName  = "/permission.PermissionService/RecordCachedDecisions"
+	PermissionService_UpdatePermission_FullMethodName       = "/permission.PermissionService/UpdatePermission"
+	PermissionService_RevokePermission_FullMethodName       = "/permission.PermissionService/RevokePermission"
+	PermissionService_ListFilePermissions_FullMethodName    = "/permission.PermissionService/ListFilePermissions"
+	PermissionService_ListUserGrants_FullMethodName         = "/permission.PermissionService/ListUserGrants"
+	PermissionService_WriteRelation_FullMethodName          = "/permission.PermissionService/WriteRelation"
+	PermissionService_CreateGroup_FullMethodName            = "/permission.PermissionService/CreateGroup"
+	PermissionService_AddGroupMember_FullMethodName         = "/permission.PermissionService/AddGroupMember"
+	PermissionService_RemoveGroupMember_FullMethodName      = "/permission.PermissionService/RemoveGroupMember"
+	PermissionService_ListGroupMembers_FullMethodName       = "/permission.PermissionService/ListGroupMembers"
+	PermissionService_QueryAuditLog_FullMethodName          = "/permission.PermissionService/QueryAuditLog"
+	PermissionService_PurgeUser_FullMethodName              = "/permission.PermissionService/PurgeUser"
 )
 
 // PermissionServiceClient is the client API for PermissionService service.
@@ -31,8 +45,33 @@ const (
 type PermissionServiceClient interface {
 	// RPC to check if a user has permission for a file
 	CheckPermission(ctx context.Context, in *CheckPermissionRequest, opts ...grpc.CallOption) (*CheckPermissionResponse, error)
+	// RPC to check one permission on many files or folders at once
+	BatchCheckPermission(ctx context.Context, in *BatchCheckPermissionRequest, opts ...grpc.CallOption) (*BatchCheckPermissionResponse, error)
+	// RPC to list the IDs of every file a user holds a permission on
+	ListAccessibleFileIDs(ctx context.Context, in *ListAccessibleFileIDsRequest, opts ...grpc.CallOption) (*ListAccessibleFileIDsResponse, error)
+	// RPC streaming the changes that may affect permission decisions, for client-side caches
+	WatchPermissionChanges(ctx context.Context, in *WatchPermissionChangesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[PermissionChange], error)
+	// RPC recording in the audit log the decisions client-side caches served without a check
+	RecordCachedDecisions(ctx context.Context, in *RecordCachedDecisionsRequest, opts ...grpc.CallOption) (*RecordCachedDecisionsResponse, error)
 	// RPC to update permissions for a shared file or folder
 	UpdatePermission(ctx context.Context, in *UpdatePermissionRequest, opts ...grpc.CallOption) (*UpdatePermissionResponse, error)
+	// RPC to revoke a user's or group's access to a file
+	RevokePermission(ctx context.Context, in *RevokePermissionRequest, opts ...grpc.CallOption) (*RevokePermissionResponse, error)
+	// RPC to list who has been granted access to a file
+	ListFilePermissions(ctx context.Context, in *ListFilePermissionsRequest, opts ...grpc.CallOption) (*ListFilePermissionsResponse, error)
+	// RPC to list what a user can access, directly or through groups
+	ListUserGrants(ctx context.Context, in *ListUserGrantsRequest, opts ...grpc.CallOption) (*ListUserGrantsResponse, error)
+	// RPC to write a relation tuple (group membership, folder parent, userset share)
+	WriteRelation(ctx context.Context, in *WriteRelationRequest, opts ...grpc.CallOption) (*WriteRelationResponse, error)
+	// RPCs to manage groups, whose members inherit everything shared with the group
+	CreateGroup(ctx context.Context, in *CreateGroupRequest, opts ...grpc.CallOption) (*CreateGroupResponse, error)
+	AddGroupMember(ctx context.Context, in *GroupMemberRequest, opts ...grpc.CallOption) (*GroupMemberResponse, error)
+	RemoveGroupMember(ctx context.Context, in *GroupMemberRequest, opts ...grpc.CallOption) (*GroupMemberResponse, error)
+	ListGroupMembers(ctx context.Context, in *ListGroupMembersRequest, opts ...grpc.CallOption) (*ListGroupMembersResponse, error)
+	// RPC to query the audit log of grants, revocations and access decisions
+	QueryAuditLog(ctx context.Context, in *QueryAuditLogRequest, opts ...grpc.CallOption) (*QueryAuditLogResponse, error)
+	// RPC to remove every relation of a deleted user, and every grant on the files they owned
+	PurgeUser(ctx context.Context, in *PurgeUserRequest, opts ...grpc.CallOption) (*PurgeUserResponse, error)
 }
 
 type permissionServiceClient struct {
@@ -53,6 +92,55 @@ func (c *permissionServiceClient) CheckPermission(ctx context.Context, in *Check
 	return out, nil
 }
 
+func (c *permissionServiceClient) BatchCheckPermission(ctx context.Context, in *BatchCheckPermissionRequest, opts ...grpc.CallOption) (*BatchCheckPermissionResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchCheckPermissionResponse)
+	err := c.cc.Invoke(ctx, PermissionService_BatchCheckPermission_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *permissionServiceClient) ListAccessibleFileIDs(ctx context.Context, in *ListAccessibleFileIDsRequest, opts ...grpc.CallOption) (*ListAccessibleFileIDsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListAccessibleFileIDsResponse)
+	err := c.cc.Invoke(ctx, PermissionService_ListAccessibleFileIDs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *permissionServiceClient) WatchPermissionChanges(ctx context.Context, in *WatchPermissionChangesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[PermissionChange], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &PermissionService_ServiceDesc.Streams[0], PermissionService_WatchPermissionChanges_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchPermissionChangesRequest, PermissionChange]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PermissionService_WatchPermissionChangesClient = grpc.ServerStreamingClient[PermissionChange]
+
+func (c *permissionServiceClient) RecordCachedDecisions(ctx context.Context, in *RecordCachedDecisionsRequest, opts ...grpc.CallOption) (*RecordCachedDecisionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RecordCachedDecisionsResponse)
+	err := c.cc.Invoke(ctx, PermissionService_RecordCachedDecisions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *permissionServiceClient) UpdatePermission(ctx context.Context, in *UpdatePermissionRequest, opts ...grpc.CallOption) (*UpdatePermissionResponse, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(UpdatePermissionResponse)
@@ -63,6 +151,106 @@ func (c *permissionServiceClient) UpdatePermission(ctx context.Context, in *Upda
 	return out, nil
 }
 
+func (c *permissionServiceClient) RevokePermission(ctx context.Context, in *RevokePermissionRequest, opts ...grpc.CallOption) (*RevokePermissionResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RevokePermissionResponse)
+	err := c.cc.Invoke(ctx, PermissionService_RevokePermission_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *permissionServiceClient) ListFilePermissions(ctx context.Context, in *ListFilePermissionsRequest, opts ...grpc.CallOption) (*ListFilePermissionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListFilePermissionsResponse)
+	err := c.cc.Invoke(ctx, PermissionService_ListFilePermissions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *permissionServiceClient) ListUserGrants(ctx context.Context, in *ListUserGrantsRequest, opts ...grpc.CallOption) (*ListUserGrantsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListUserGrantsResponse)
+	err := c.cc.Invoke(ctx, PermissionService_ListUserGrants_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *permissionServiceClient) WriteRelation(ctx context.Context, in *WriteRelationRequest, opts ...grpc.CallOption) (*WriteRelationResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(WriteRelationResponse)
+	err := c.cc.Invoke(ctx, PermissionService_WriteRelation_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *permissionServiceClient) CreateGroup(ctx context.Context, in *CreateGroupRequest, opts ...grpc.CallOption) (*CreateGroupResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CreateGroupResponse)
+	err := c.cc.Invoke(ctx, PermissionService_CreateGroup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *permissionServiceClient) AddGroupMember(ctx context.Context, in *GroupMemberRequest, opts ...grpc.CallOption) (*GroupMemberResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GroupMemberResponse)
+	err := c.cc.Invoke(ctx, PermissionService_AddGroupMember_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *permissionServiceClient) RemoveGroupMember(ctx context.Context, in *GroupMemberRequest, opts ...grpc.CallOption) (*GroupMemberResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GroupMemberResponse)
+	err := c.cc.Invoke(ctx, PermissionService_RemoveGroupMember_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *permissionServiceClient) ListGroupMembers(ctx context.Context, in *ListGroupMembersRequest, opts ...grpc.CallOption) (*ListGroupMembersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListGroupMembersResponse)
+	err := c.cc.Invoke(ctx, PermissionService_ListGroupMembers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *permissionServiceClient) QueryAuditLog(ctx context.Context, in *QueryAuditLogRequest, opts ...grpc.CallOption) (*QueryAuditLogResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(QueryAuditLogResponse)
+	err := c.cc.Invoke(ctx, PermissionService_QueryAuditLog_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *permissionServiceClient) PurgeUser(ctx context.Context, in *PurgeUserRequest, opts ...grpc.CallOption) (*PurgeUserResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PurgeUserResponse)
+	err := c.cc.Invoke(ctx, PermissionService_PurgeUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // PermissionServiceServer is the server API for PermissionService service.
 // All implementations must embed UnimplementedPermissionServiceServer
 // for forward compatibility.
@@ -71,8 +259,33 @@ func (c *permissionServiceClient) UpdatePermission(ctx context.Context, in *Upda
 type PermissionServiceServer interface {
 	// RPC to check if a user has permission for a file
 	CheckPermission(context.Context, *CheckPermissionRequest) (*CheckPermissionResponse, error)
+	// RPC to check one permission on many files or folders at once
+	BatchCheckPermission(context.Context, *BatchCheckPermissionRequest) (*BatchCheckPermissionResponse, error)
+	// RPC to list the IDs of every file a user holds a permission on
+	ListAccessibleFileIDs(context.Context, *ListAccessibleFileIDsRequest) (*ListAccessibleFileIDsResponse, error)
+	// RPC streaming the changes that may affect permission decisions, for client-side caches
+	WatchPermissionChanges(*WatchPermissionChangesRequest, grpc.ServerStreamingServer[PermissionChange]) error
+	// RPC recording in the audit log the decisions client-side caches served without a check
+	RecordCachedDecisions(context.Context, *RecordCachedDecisionsRequest) (*RecordCachedDecisionsResponse, error)
 	// RPC to update permissions for a shared file or folder
 	UpdatePermission(context.Context, *UpdatePermissionRequest) (*UpdatePermissionResponse, error)
+	// RPC to revoke a user's or group's access to a file
+	RevokePermission(context.Context, *RevokePermissionRequest) (*RevokePermissionResponse, error)
+	// RPC to list who has been granted access to a file
+	ListFilePermissions(context.Context, *ListFilePermissionsRequest) (*ListFilePermissionsResponse, error)
+	// RPC to list what a user can access, directly or through groups
+	ListUserGrants(context.Context, *ListUserGrantsRequest) (*ListUserGrantsResponse, error)
+	// RPC to write a relation tuple (group membership, folder parent, userset share)
+	WriteRelation(context.Context, *WriteRelationRequest) (*WriteRelationResponse, error)
+	// RPCs to manage groups, whose members inherit everything shared with the group
+	CreateGroup(context.Context, *CreateGroupRequest) (*CreateGroupResponse, error)
+	AddGroupMember(context.Context, *GroupMemberRequest) (*GroupMemberResponse, error)
+	RemoveGroupMember(context.Context, *GroupMemberRequest) (*GroupMemberResponse, error)
+	ListGroupMembers(context.Context, *ListGroupMembersRequest) (*ListGroupMembersResponse, error)
+	// RPC to query the audit log of grants, revocations and access decisions
+	QueryAuditLog(context.Context, *QueryAuditLogRequest) (*QueryAuditLogResponse, error)
+	// RPC to remove every relation of a deleted user, and every grant on the files they owned
+	PurgeUser(context.Context, *PurgeUserRequest) (*PurgeUserResponse, error)
 	mustEmbedUnimplementedPermissionServiceServer()
 }
 
@@ -86,9 +299,51 @@ type UnimplementedPermissionServiceServer struct{}
 func (UnimplementedPermissionServiceServer) CheckPermission(context.Context, *CheckPermissionRequest) (*CheckPermissionResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CheckPermission not implemented")
 }
+func (UnimplementedPermissionServiceServer) BatchCheckPermission(context.Context, *BatchCheckPermissionRequest) (*BatchCheckPermissionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchCheckPermission not implemented")
+}
+func (UnimplementedPermissionServiceServer) ListAccessibleFileIDs(context.Context, *ListAccessibleFileIDsRequest) (*ListAccessibleFileIDsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAccessibleFileIDs not implemented")
+}
+func (UnimplementedPermissionServiceServer) WatchPermissionChanges(*WatchPermissionChangesRequest, grpc.ServerStreamingServer[PermissionChange]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchPermissionChanges not implemented")
+}
+func (UnimplementedPermissionServiceServer) RecordCachedDecisions(context.Context, *RecordCachedDecisionsRequest) (*RecordCachedDecisionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RecordCachedDecisions not implemented")
+}
 func (UnimplementedPermissionServiceServer) UpdatePermission(context.Context, *UpdatePermissionRequest) (*UpdatePermissionResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpdatePermission not implemented")
 }
+func (UnimplementedPermissionServiceServer) RevokePermission(context.Context, *RevokePermissionRequest) (*RevokePermissionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokePermission not implemented")
+}
+func (UnimplementedPermissionServiceServer) ListFilePermissions(context.Context, *ListFilePermissionsRequest) (*ListFilePermissionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListFilePermissions not implemented")
+}
+func (UnimplementedPermissionServiceServer) ListUserGrants(context.Context, *ListUserGrantsRequest) (*ListUserGrantsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUserGrants not implemented")
+}
+func (UnimplementedPermissionServiceServer) WriteRelation(context.Context, *WriteRelationRequest) (*WriteRelationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WriteRelation not implemented")
+}
+func (UnimplementedPermissionServiceServer) CreateGroup(context.Context, *CreateGroupRequest) (*CreateGroupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateGroup not implemented")
+}
+func (UnimplementedPermissionServiceServer) AddGroupMember(context.Context, *GroupMemberRequest) (*GroupMemberResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddGroupMember not implemented")
+}
+func (UnimplementedPermissionServiceServer) RemoveGroupMember(context.Context, *GroupMemberRequest) (*GroupMemberResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveGroupMember not implemented")
+}
+func (UnimplementedPermissionServiceServer) ListGroupMembers(context.Context, *ListGroupMembersRequest) (*ListGroupMembersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListGroupMembers not implemented")
+}
+func (UnimplementedPermissionServiceServer) QueryAuditLog(context.Context, *QueryAuditLogRequest) (*QueryAuditLogResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryAuditLog not implemented")
+}
+func (UnimplementedPermissionServiceServer) PurgeUser(context.Context, *PurgeUserRequest) (*PurgeUserResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PurgeUser not implemented")
+}
 func (UnimplementedPermissionServiceServer) mustEmbedUnimplementedPermissionServiceServer() {}
 func (UnimplementedPermissionServiceServer) testEmbeddedByValue()                           {}
 
@@ -128,6 +383,71 @@ func _PermissionService_CheckPermission_Handler(srv interface{}, ctx context.Con
 	return interceptor(ctx, in, info, handler)
 }
 
+func _PermissionService_BatchCheckPermission_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchCheckPermissionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PermissionServiceServer).BatchCheckPermission(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PermissionService_BatchCheckPermission_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PermissionServiceServer).BatchCheckPermission(ctx, req.(*BatchCheckPermissionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PermissionService_ListAccessibleFileIDs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAccessibleFileIDsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PermissionServiceServer).ListAccessibleFileIDs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PermissionService_ListAccessibleFileIDs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PermissionServiceServer).ListAccessibleFileIDs(ctx, req.(*ListAccessibleFileIDsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PermissionService_WatchPermissionChanges_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchPermissionChangesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PermissionServiceServer).WatchPermissionChanges(m, &grpc.GenericServerStream[WatchPermissionChangesRequest, PermissionChange]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PermissionService_WatchPermissionChangesServer = grpc.ServerStreamingServer[PermissionChange]
+
+func _PermissionService_RecordCachedDecisions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RecordCachedDecisionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PermissionServiceServer).RecordCachedDecisions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PermissionService_RecordCachedDecisions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PermissionServiceServer).RecordCachedDecisions(ctx, req.(*RecordCachedDecisionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _PermissionService_UpdatePermission_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(UpdatePermissionRequest)
 	if err := dec(in); err != nil {
@@ -146,6 +466,186 @@ func _PermissionService_UpdatePermission_Handler(srv interface{}, ctx context.Co
 	return interceptor(ctx, in, info, handler)
 }
 
+func _PermissionService_RevokePermission_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokePermissionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PermissionServiceServer).RevokePermission(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PermissionService_RevokePermission_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PermissionServiceServer).RevokePermission(ctx, req.(*RevokePermissionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PermissionService_ListFilePermissions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListFilePermissionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PermissionServiceServer).ListFilePermissions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PermissionService_ListFilePermissions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PermissionServiceServer).ListFilePermissions(ctx, req.(*ListFilePermissionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PermissionService_ListUserGrants_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUserGrantsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PermissionServiceServer).ListUserGrants(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PermissionService_ListUserGrants_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PermissionServiceServer).ListUserGrants(ctx, req.(*ListUserGrantsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PermissionService_WriteRelation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WriteRelationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PermissionServiceServer).WriteRelation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PermissionService_WriteRelation_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PermissionServiceServer).WriteRelation(ctx, req.(*WriteRelationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PermissionService_CreateGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateGroupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PermissionServiceServer).CreateGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PermissionService_CreateGroup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PermissionServiceServer).CreateGroup(ctx, req.(*CreateGroupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PermissionService_AddGroupMember_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GroupMemberRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PermissionServiceServer).AddGroupMember(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PermissionService_AddGroupMember_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PermissionServiceServer).AddGroupMember(ctx, req.(*GroupMemberRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PermissionService_RemoveGroupMember_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GroupMemberRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PermissionServiceServer).RemoveGroupMember(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PermissionService_RemoveGroupMember_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PermissionServiceServer).RemoveGroupMember(ctx, req.(*GroupMemberRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PermissionService_ListGroupMembers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListGroupMembersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PermissionServiceServer).ListGroupMembers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PermissionService_ListGroupMembers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PermissionServiceServer).ListGroupMembers(ctx, req.(*ListGroupMembersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PermissionService_QueryAuditLog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryAuditLogRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PermissionServiceServer).QueryAuditLog(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PermissionService_QueryAuditLog_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PermissionServiceServer).QueryAuditLog(ctx, req.(*QueryAuditLogRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PermissionService_PurgeUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PurgeUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PermissionServiceServer).PurgeUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PermissionService_PurgeUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PermissionServiceServer).PurgeUser(ctx, req.(*PurgeUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // PermissionService_ServiceDesc is the grpc.ServiceDesc for PermissionService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -157,11 +657,69 @@ var PermissionService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "CheckPermission",
 			Handler:    _PermissionService_CheckPermission_Handler,
 		},
+		{
+			MethodName: "BatchCheckPermission",
+			Handler:    _PermissionService_BatchCheckPermission_Handler,
+		},
+		{
+			MethodName: "ListAccessibleFileIDs",
+			Handler:    _PermissionService_ListAccessibleFileIDs_Handler,
+		},
+		{
+			MethodName: "RecordCachedDecisions",
+			Handler:    _PermissionService_RecordCachedDecisions_Handler,
+		},
 		{
 			MethodName: "UpdatePermission",
 			Handler:    _PermissionService_UpdatePermission_Handler,
 		},
+		{
+			MethodName: "RevokePermission",
+			Handler:    _PermissionService_RevokePermission_Handler,
+		},
+		{
+			MethodName: "ListFilePermissions",
+			Handler:    _PermissionService_ListFilePermissions_Handler,
+		},
+		{
+			MethodName: "ListUserGrants",
+			Handler:    _PermissionService_ListUserGrants_Handler,
+		},
+		{
+			MethodName: "WriteRelation",
+			Handler:    _PermissionService_WriteRelation_Handler,
+		},
+		{
+			MethodName: "CreateGroup",
+			Handler:    _PermissionService_CreateGroup_Handler,
+		},
+		{
+			MethodName: "AddGroupMember",
+			Handler:    _PermissionService_AddGroupMember_Handler,
+		},
+		{
+			MethodName: "RemoveGroupMember",
+			Handler:    _PermissionService_RemoveGroupMember_Handler,
+		},
+		{
+			MethodName: "ListGroupMembers",
+			Handler:    _PermissionService_ListGroupMembers_Handler,
+		},
+		{
+			MethodName: "QueryAuditLog",
+			Handler:    _PermissionService_QueryAuditLog_Handler,
+		},
+		{
+			MethodName: "PurgeUser",
+			Handler:    _PermissionService_PurgeUser_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchPermissionChanges",
+			Handler:       _PermissionService_WatchPermissionChanges_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "permissions.proto",
 }
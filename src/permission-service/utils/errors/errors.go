@@ -6,8 +6,17 @@ import "errors"
 var (
 	ErrPermissionNotFound = errors.New("permission not found")
 	ErrInvalidPermission  = errors.New("invalid permission type")
+	ErrInvalidRelation    = errors.New("invalid relation tuple")
 	ErrUserNotAuthorized  = errors.New("user not authorized to access this file")
 	ErrDatabaseError      = errors.New("database error occurred")
+	ErrGroupNotFound      = errors.New("group not found")
+	ErrInvalidGroup       = errors.New("invalid group or group member")
+	ErrGroupCycle         = errors.New("group would be nested in itself")
+	ErrInvalidPageToken   = errors.New("invalid page token")
+	ErrInvalidCondition   = errors.New("invalid grant condition")
+	ErrInvalidExpiry      = errors.New("grant expiry must be in the future")
+	ErrBatchTooLarge      = errors.New("too many objects in batch")
+	ErrInvalidPolicy      = errors.New("invalid role policy")
 )
 
 // WrapDatabaseError provides a wrapper for logging and returning database errors
@@ -0,0 +1,71 @@
+package authn
+
+import (
+	"context"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Scopes of the access tokens issued for API keys. Tokens of interactive logins carry no scope
+// and are not limited.
+const (
+	ScopeRead  = "read"  // Read-only requests
+	ScopeWrite = "write" // Requests that make changes
+)
+
+// Claims are the claims of an access token issued by the auth-service.
+type Claims struct {
+	UserID    uint   `json:"userId"`
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid"`             // The login session, see the auth-service revoked sessions list
+	Scope     string `json:"scope,omitempty"` // Space-separated scopes of tokens issued for API keys
+	// ServiceAccount is set on the tokens of service accounts, the accounts services use to call
+	// each other on behalf of users
+	ServiceAccount bool `json:"svc,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Allows reports whether the token may be used for requests needing scope. Tokens without scopes
+// allow everything.
+func (c *Claims) Allows(scope string) bool {
+	if c.Scope == "" {
+		return true
+	}
+	for _, s := range strings.Fields(c.Scope) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type (
+	claimsKey struct{}
+	tokenKey  struct{}
+)
+
+// NewContext returns a copy of ctx carrying the claims of the caller's token.
+func NewContext(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// FromContext returns the claims of the caller's token, if the caller presented one.
+func FromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
+
+// NewTokenContext returns a copy of ctx carrying the caller's bearer token, so that calls made
+// while serving the request can pass it on.
+func NewTokenContext(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenKey{}, token)
+}
+
+// TokenFromContext returns the caller's bearer token, if the caller presented one.
+func TokenFromContext(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(tokenKey{}).(string)
+	return token, ok && token != ""
+}
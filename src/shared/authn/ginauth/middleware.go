@@ -0,0 +1,75 @@
+// Package ginauth verifies access tokens in Gin handlers.
+package ginauth
+
+import (
+	"errors"
+	"net/http"
+
+	"shared/authn"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Context keys under which the caller's identity is set.
+const (
+	UserIDKey = "userId" // uint, read with c.GetUint
+	RoleKey   = "role"   // string, read with c.GetString
+	ClaimsKey = "claims" // *authn.Claims
+)
+
+// Middleware rejects requests without a valid bearer token, or API key, with 401 Unauthorized,
+// and API keys that cannot be exchanged yet because too many are being exchanged with 429 Too Many
+// Requests. Tokens issued for API keys need the read scope for GET, HEAD and OPTIONS requests and
+// the write scope for others, or are rejected with 403 Forbidden. For valid tokens it sets the
+// user ID, role and claims in the Gin context, and the claims and token in the request context for
+// authn.FromContext and authn.TokenFromContext; for API keys, the token is the access token the key
+// was exchanged for.
+func Middleware(verifier *authn.Verifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, err := authn.BearerToken(c.GetHeader("Authorization"))
+		if err != nil {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		claims, token, err := verifier.Authenticate(token)
+		if errors.Is(err, authn.ErrTooManyExchanges) {
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			message := "invalid token"
+			if errors.Is(err, authn.ErrRevokedToken) {
+				message = err.Error()
+			}
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": message})
+			return
+		}
+
+		if scope := RequiredScope(c.Request.Method); !claims.Allows(scope) {
+			c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient scope"})
+			return
+		}
+
+		c.Set(UserIDKey, claims.UserID)
+		c.Set(RoleKey, claims.Role)
+		c.Set(ClaimsKey, claims)
+		ctx := authn.NewTokenContext(authn.NewContext(c.Request.Context(), claims), token)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// RequiredScope returns the scope requests with the given method need.
+func RequiredScope(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return authn.ScopeRead
+	default:
+		return authn.ScopeWrite
+	}
+}
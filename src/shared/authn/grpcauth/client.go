@@ -0,0 +1,47 @@
+package grpcauth
+
+import (
+	"context"
+
+	"shared/authn"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// DialOptions returns the options installing both client interceptors on a gRPC connection.
+func DialOptions(serviceToken string) []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(UnaryClientInterceptor(serviceToken)),
+		grpc.WithChainStreamInterceptor(StreamClientInterceptor(serviceToken)),
+	}
+}
+
+// UnaryClientInterceptor passes a token in the authorization metadata of unary calls: the token of
+// the request being served, found with authn.TokenFromContext, or else serviceToken, the access
+// token or API key of the calling service. Calls are made without a token when neither is set.
+func UnaryClientInterceptor(serviceToken string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoingContext(ctx, serviceToken), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor passes a token in the authorization metadata of streaming calls, like
+// UnaryClientInterceptor.
+func StreamClientInterceptor(serviceToken string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoingContext(ctx, serviceToken), desc, cc, method, opts...)
+	}
+}
+
+// outgoingContext adds the caller's token, or else serviceToken, to the outgoing metadata of ctx.
+func outgoingContext(ctx context.Context, serviceToken string) context.Context {
+	token, ok := authn.TokenFromContext(ctx)
+	if !ok {
+		token = serviceToken
+	}
+	if token == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
@@ -0,0 +1,123 @@
+// Package grpcauth verifies access tokens passed to gRPC servers in the authorization metadata.
+package grpcauth
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"shared/authn"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Options controls which calls must carry a token. The zero value requires a valid token on
+// every call.
+type Options struct {
+	// AllowAnonymous lets calls without a token through without claims, for services that do not
+	// authorize on the caller's identity. Invalid tokens are always rejected.
+	AllowAnonymous bool
+	// Exempt lists full method name prefixes that never need a token, such as
+	// "/grpc.reflection." or "/grpc.health.".
+	Exempt []string
+	// ReadOnly lists full method name prefixes that make no changes, which tokens issued for API
+	// keys may call with the read scope. Other methods need the write scope.
+	ReadOnly []string
+}
+
+// ServerOptions returns the options installing both interceptors on a gRPC server.
+func ServerOptions(verifier *authn.Verifier, opts Options) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(UnaryServerInterceptor(verifier, opts)),
+		grpc.ChainStreamInterceptor(StreamServerInterceptor(verifier, opts)),
+	}
+}
+
+// UnaryServerInterceptor verifies the token of unary calls and puts it and its claims in the
+// context for authn.TokenFromContext and authn.FromContext.
+func UnaryServerInterceptor(verifier *authn.Verifier, opts Options) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := authenticate(ctx, verifier, opts, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor verifies the token of streaming calls and puts its claims in the stream
+// context for authn.FromContext.
+func StreamServerInterceptor(verifier *authn.Verifier, opts Options) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(stream.Context(), verifier, opts, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// authenticate verifies the token in the call metadata.
+func authenticate(ctx context.Context, verifier *authn.Verifier, opts Options, method string) (context.Context, error) {
+	if hasPrefix(method, opts.Exempt) {
+		return ctx, nil
+	}
+
+	var header string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			header = values[0]
+		}
+	}
+	if header == "" && opts.AllowAnonymous {
+		return ctx, nil
+	}
+
+	token, err := authn.BearerToken(header)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	claims, token, err := verifier.Authenticate(token)
+	if errors.Is(err, authn.ErrTooManyExchanges) {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err != nil {
+		message := "invalid token"
+		if errors.Is(err, authn.ErrRevokedToken) {
+			message = err.Error()
+		}
+		return nil, status.Error(codes.Unauthenticated, message)
+	}
+
+	scope := authn.ScopeWrite
+	if hasPrefix(method, opts.ReadOnly) {
+		scope = authn.ScopeRead
+	}
+	if !claims.Allows(scope) {
+		return nil, status.Errorf(codes.PermissionDenied, "token lacks the %s scope", scope)
+	}
+	return authn.NewTokenContext(authn.NewContext(ctx, claims), token), nil
+}
+
+// hasPrefix reports whether method starts with any of prefixes.
+func hasPrefix(method string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// serverStream replaces the context of a stream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
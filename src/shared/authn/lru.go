@@ -0,0 +1,55 @@
+package authn
+
+import "container/list"
+
+// lru is a map bounded to max entries that evicts the least recently used one when full, so that
+// a client sending many keys only pushes out keys nobody has used since. It is not safe for
+// concurrent use.
+type lru[V any] struct {
+	max   int
+	order *list.List // Most recently used first
+	items map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key   string
+	value V
+}
+
+func newLRU[V any](max int) *lru[V] {
+	return &lru[V]{max: max, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+// get returns the value of key and marks it used.
+func (c *lru[V]) get(key string) (V, bool) {
+	element, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*lruEntry[V]).value, true
+}
+
+// add sets the value of key, evicting the least recently used entry if the map is full.
+func (c *lru[V]) add(key string, value V) {
+	if element, ok := c.items[key]; ok {
+		element.Value.(*lruEntry[V]).value = value
+		c.order.MoveToFront(element)
+		return
+	}
+	if c.order.Len() >= c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry[V]).key)
+	}
+	c.items[key] = c.order.PushFront(&lruEntry[V]{key: key, value: value})
+}
+
+// remove deletes key.
+func (c *lru[V]) remove(key string) {
+	if element, ok := c.items[key]; ok {
+		c.order.Remove(element)
+		delete(c.items, key)
+	}
+}
@@ -0,0 +1,510 @@
+// Package authn verifies the access tokens issued by the auth-service: their signature against the
+// auth-service JWKS, issuer, audience, expiry and session. API keys are accepted too, by
+// exchanging them for access tokens. The ginauth and grpcauth packages apply it to HTTP and gRPC
+// servers.
+package authn
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrMissingToken is returned when a request carries no bearer token.
+	ErrMissingToken = errors.New("missing bearer token")
+	// ErrInvalidToken is returned for tokens that fail verification.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrRevokedToken is returned for tokens of sessions that have been logged out or revoked.
+	ErrRevokedToken = errors.New("token revoked")
+	// ErrTooManyExchanges is returned for API keys that are not known yet while more keys are
+	// being exchanged than the auth-service should be asked to.
+	ErrTooManyExchanges = errors.New("too many API key exchanges, try again later")
+
+	// errKeyRefused is returned for API keys the auth-service refused to exchange.
+	errKeyRefused = fmt.Errorf("%w: API key refused", ErrInvalidToken)
+)
+
+// Config configures a Verifier.
+type Config struct {
+	JWKSURL            string        // The auth-service /.well-known/jwks.json
+	Keys               KeyFunc       // Looks up keys instead of fetching JWKSURL, for the auth-service itself
+	RevokedSessionsURL string        // The auth-service /api/revoked-sessions; revocations are not checked if empty
+	TokenURL           string        // The auth-service /api/token; API keys are not accepted if empty
+	ServiceAPIKey      string        // API key of a service account, which the revoked sessions list requires
+	Issuer             string        // Required iss claim
+	Audience           string        // Required aud claim; not checked if empty
+	RefreshInterval    time.Duration // How often keys and revocations are refetched, default 5 minutes
+}
+
+// KeyFunc returns the public key with the given kid, or nil if there is none.
+type KeyFunc func(kid string) any
+
+// Verifier verifies access tokens. Keys and revoked sessions are fetched in the background, and
+// keys again when a token names one that is not known yet, so that rotated keys are picked up.
+type Verifier struct {
+	config Config
+	client *http.Client
+	parser *jwt.Parser
+
+	mu          sync.RWMutex
+	keys        map[string]any       // Public keys by kid
+	revoked     map[string]time.Time // Revocation times by session ID
+	lastRefresh time.Time            // Last fetch of the keys, to rate limit fetches for unknown kids
+	service     apiKeyToken          // Access token issued for ServiceAPIKey
+	stop        chan struct{}
+
+	exchangeMu     sync.Mutex
+	exchanged      *lru[apiKeyToken] // Access tokens issued for API keys, by key hash
+	refused        *lru[time.Time]   // API keys the auth-service refused, by key hash, until when they are not retried
+	exchangeTokens float64           // Exchanges that may be made now, refilled at exchangeRate
+	exchangeLast   time.Time         // Last refill of exchangeTokens
+}
+
+// apiKeyToken is an access token an API key was exchanged for.
+type apiKeyToken struct {
+	token   string
+	expires time.Time
+}
+
+// APIKeyPrefix starts every API key issued by the auth-service.
+const APIKeyPrefix = "fsk_"
+
+// minKeyRefresh is the minimum time between two fetches of the keys.
+const minKeyRefresh = 30 * time.Second
+
+// maxExchangedTokens bounds the access tokens kept for API keys, and the refused keys kept.
+const maxExchangedTokens = 1000
+
+// refusedKeyTTL is how long an API key the auth-service refused is refused without asking again,
+// so that retrying a bogus key does not reach the auth-service.
+const refusedKeyTTL = time.Minute
+
+// API keys not known yet are exchanged at most exchangeRate times a second, with bursts of
+// exchangeBurst, so that clients cannot send the auth-service more exchanges than that.
+const (
+	exchangeRate  = 10
+	exchangeBurst = 20
+)
+
+// NewVerifier creates a Verifier and fetches the keys. It fails if the keys cannot be fetched,
+// since no token could be verified without them.
+func NewVerifier(config Config) (*Verifier, error) {
+	if config.JWKSURL == "" && config.Keys == nil {
+		return nil, errors.New("authn: JWKS URL is required")
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = 5 * time.Minute
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(config.Issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+
+	v := &Verifier{
+		config:         config,
+		client:         &http.Client{Timeout: 10 * time.Second},
+		parser:         jwt.NewParser(options...),
+		keys:           make(map[string]any),
+		revoked:        make(map[string]time.Time),
+		stop:           make(chan struct{}),
+		exchanged:      newLRU[apiKeyToken](maxExchangedTokens),
+		refused:        newLRU[time.Time](maxExchangedTokens),
+		exchangeTokens: exchangeBurst,
+		exchangeLast:   time.Now(),
+	}
+	if err := v.refreshKeys(); err != nil {
+		return nil, err
+	}
+	if err := v.refreshRevoked(); err != nil {
+		log.Printf("authn: failed to fetch revoked sessions: %v", err)
+	}
+
+	go v.refreshLoop()
+	return v, nil
+}
+
+// Close stops refreshing keys and revocations.
+func (v *Verifier) Close() {
+	close(v.stop)
+}
+
+// Verify verifies a token and returns its claims. API keys are exchanged for access tokens at
+// the auth-service, which are kept until they expire.
+func (v *Verifier) Verify(tokenString string) (*Claims, error) {
+	claims, _, err := v.Authenticate(tokenString)
+	return claims, err
+}
+
+// Authenticate verifies a token like Verify, and also returns the access token to pass on to the
+// services called while serving the request: the token itself, or the access token an API key
+// was exchanged for. The key is not sent any further, and the called services check the scopes
+// of the same token.
+func (v *Verifier) Authenticate(tokenString string) (*Claims, string, error) {
+	if strings.HasPrefix(tokenString, APIKeyPrefix) {
+		return v.verifyAPIKey(tokenString)
+	}
+	claims, err := v.verifyToken(tokenString)
+	if err != nil {
+		return nil, "", err
+	}
+	return claims, tokenString, nil
+}
+
+// verifyToken verifies an access token and returns its claims.
+func (v *Verifier) verifyToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	if _, err := v.parser.ParseWithClaims(tokenString, claims, v.key); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	v.mu.RLock()
+	revokedAt, revoked := v.revoked[claims.SessionID]
+	v.mu.RUnlock()
+	if revoked && (claims.IssuedAt == nil || !claims.IssuedAt.After(revokedAt)) {
+		return nil, ErrRevokedToken
+	}
+	return claims, nil
+}
+
+// verifyAPIKey verifies the access token an API key is exchanged for, and returns its claims and
+// the token. A token that stops verifying, because the key was revoked, is dropped so that the
+// key is exchanged again. Keys the auth-service refused are refused for refusedKeyTTL without
+// asking it again, and keys not known yet are exchanged at a limited rate.
+func (v *Verifier) verifyAPIKey(apiKey string) (*Claims, string, error) {
+	sum := sha256.Sum256([]byte(apiKey))
+	hash := hex.EncodeToString(sum[:])
+	now := time.Now()
+
+	v.exchangeMu.Lock()
+	cached, ok := v.exchanged.get(hash)
+	v.exchangeMu.Unlock()
+	if ok && now.Before(cached.expires) {
+		claims, err := v.verifyToken(cached.token)
+		if err == nil {
+			return claims, cached.token, nil
+		}
+	}
+
+	v.exchangeMu.Lock()
+	v.exchanged.remove(hash)
+	until, refused := v.refused.get(hash)
+	if refused && now.Before(until) {
+		v.exchangeMu.Unlock()
+		return nil, "", errKeyRefused
+	}
+	allowed := v.allowExchange(now)
+	v.exchangeMu.Unlock()
+	if !allowed {
+		return nil, "", ErrTooManyExchanges
+	}
+
+	token, err := v.exchange(apiKey)
+	if errors.Is(err, errKeyRefused) {
+		v.exchangeMu.Lock()
+		v.refused.add(hash, time.Now().Add(refusedKeyTTL))
+		v.exchangeMu.Unlock()
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	claims, err := v.verifyToken(token.token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	v.exchangeMu.Lock()
+	v.refused.remove(hash)
+	if time.Now().Before(token.expires) {
+		v.exchanged.add(hash, token)
+	}
+	v.exchangeMu.Unlock()
+	return claims, token.token, nil
+}
+
+// allowExchange reports whether an API key may be exchanged now, taking one of the exchanges
+// refilled at exchangeRate. The caller holds exchangeMu.
+func (v *Verifier) allowExchange(now time.Time) bool {
+	v.exchangeTokens = min(exchangeBurst, v.exchangeTokens+now.Sub(v.exchangeLast).Seconds()*exchangeRate)
+	v.exchangeLast = now
+	if v.exchangeTokens < 1 {
+		return false
+	}
+	v.exchangeTokens--
+	return true
+}
+
+// exchange exchanges an API key for an access token at the auth-service.
+func (v *Verifier) exchange(apiKey string) (apiKeyToken, error) {
+	if v.config.TokenURL == "" {
+		return apiKeyToken{}, fmt.Errorf("%w: API keys are not accepted", ErrInvalidToken)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, v.config.TokenURL, nil)
+	if err != nil {
+		return apiKeyToken{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return apiKeyToken{}, fmt.Errorf("%w: exchanging API key: %v", ErrInvalidToken, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return apiKeyToken{}, fmt.Errorf("%w: %s", errKeyRefused, resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return apiKeyToken{}, fmt.Errorf("%w: exchanging API key: %s", ErrInvalidToken, resp.Status)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return apiKeyToken{}, fmt.Errorf("%w: exchanging API key: %v", ErrInvalidToken, err)
+	}
+	if body.AccessToken == "" {
+		return apiKeyToken{}, fmt.Errorf("%w: exchanging API key: no access token", ErrInvalidToken)
+	}
+	// Exchange again a little before the token expires, so that it never expires in use: 30
+	// seconds before, or a tenth of the lifetime before for tokens shorter lived than 5 minutes
+	lifetime := time.Duration(body.ExpiresIn) * time.Second
+	expiresIn := lifetime - min(30*time.Second, lifetime/10)
+	return apiKeyToken{token: body.AccessToken, expires: time.Now().Add(expiresIn)}, nil
+}
+
+// BearerToken extracts the token from an Authorization header value.
+func BearerToken(header string) (string, error) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", ErrMissingToken
+	}
+	return strings.TrimSpace(token), nil
+}
+
+// key finds the public key a token was signed with.
+func (v *Verifier) key(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no kid")
+	}
+	if v.config.Keys != nil {
+		if key := v.config.Keys(kid); key != nil {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	v.mu.RLock()
+	key, ok := v.keys[kid]
+	v.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	// The token may have been signed with a key rotated in since the last fetch
+	v.mu.RLock()
+	recent := time.Since(v.lastRefresh) < minKeyRefresh
+	v.mu.RUnlock()
+	if !recent {
+		if err := v.refreshKeys(); err != nil {
+			log.Printf("authn: failed to refresh keys: %v", err)
+		}
+		v.mu.RLock()
+		key, ok = v.keys[kid]
+		v.mu.RUnlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+func (v *Verifier) refreshLoop() {
+	ticker := time.NewTicker(v.config.RefreshInterval)
+	defer ticker.Stop()
+
+	// Revocations are polled more often than keys; a revoked token must stop working well
+	// before it expires on its own
+	revokedTicker := time.NewTicker(min(v.config.RefreshInterval, 30*time.Second))
+	defer revokedTicker.Stop()
+
+	for {
+		select {
+		case <-v.stop:
+			return
+		case <-ticker.C:
+			if err := v.refreshKeys(); err != nil {
+				log.Printf("authn: failed to refresh keys: %v", err)
+			}
+		case <-revokedTicker.C:
+			if err := v.refreshRevoked(); err != nil {
+				log.Printf("authn: failed to refresh revoked sessions: %v", err)
+			}
+		}
+	}
+}
+
+// jwk is a public key in JSON Web Key format.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// refreshKeys fetches the JWKS and replaces the known keys with it.
+func (v *Verifier) refreshKeys() error {
+	if v.config.Keys != nil {
+		return nil
+	}
+
+	v.mu.Lock()
+	v.lastRefresh = time.Now()
+	v.mu.Unlock()
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.getJSON(v.config.JWKSURL, "", &set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]any, len(set.Keys))
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			log.Printf("authn: skipping key %q: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.mu.Unlock()
+	return nil
+}
+
+// refreshRevoked fetches the revoked sessions.
+func (v *Verifier) refreshRevoked() error {
+	if v.config.RevokedSessionsURL == "" {
+		return nil
+	}
+
+	if v.config.ServiceAPIKey == "" {
+		return errors.New("a service API key is required to fetch revoked sessions")
+	}
+	token, err := v.serviceToken()
+	if err != nil {
+		return err
+	}
+
+	var list struct {
+		Sessions []struct {
+			SID       string `json:"sid"`
+			RevokedAt int64  `json:"revoked_at"`
+		} `json:"sessions"`
+	}
+	if err := v.getJSON(v.config.RevokedSessionsURL, token, &list); err != nil {
+		return err
+	}
+
+	revoked := make(map[string]time.Time, len(list.Sessions))
+	for _, session := range list.Sessions {
+		revoked[session.SID] = time.Unix(session.RevokedAt, 0)
+	}
+
+	v.mu.Lock()
+	v.revoked = revoked
+	v.mu.Unlock()
+	return nil
+}
+
+// serviceToken returns the access token ServiceAPIKey is exchanged for, exchanging it again once
+// it expires. Revocations are only fetched by NewVerifier and then by the refresh loop, one at a
+// time, so the token needs no locking.
+func (v *Verifier) serviceToken() (string, error) {
+	if time.Now().Before(v.service.expires) {
+		return v.service.token, nil
+	}
+	token, err := v.exchange(v.config.ServiceAPIKey)
+	if err != nil {
+		return "", err
+	}
+	v.service = token
+	return token.token, nil
+}
+
+// getJSON fetches url, with the bearer token if there is one, and decodes the JSON response.
+func (v *Verifier) getJSON(url, bearer string, out any) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// publicKey decodes an RSA or Ed25519 public key.
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
@@ -0,0 +1,210 @@
+package authn
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testIssuer = "test-issuer"
+
+// fakeAuthService serves the auth-service endpoints a Verifier uses, signing tokens with an
+// Ed25519 key.
+type fakeAuthService struct {
+	t       *testing.T
+	key     ed25519.PrivateKey
+	server  *httptest.Server
+	apiKeys map[string]Claims // Claims of the tokens issued for each API key
+	revoked map[string]time.Time
+
+	expiresIn int64        // expires_in of exchanged tokens, in seconds
+	exchanges atomic.Int32 // Requests to the token endpoint
+}
+
+func newFakeAuthService(t *testing.T) *fakeAuthService {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeAuthService{t: t, key: private, apiKeys: map[string]Claims{}, revoked: map[string]time.Time{}, expiresIn: 900}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "OKP", "crv": "Ed25519", "kid": "k1", "x": base64.RawURLEncoding.EncodeToString(public),
+		}}})
+	})
+	mux.HandleFunc("/api/token", func(w http.ResponseWriter, r *http.Request) {
+		f.exchanges.Add(1)
+		apiKey, _ := BearerToken(r.Header.Get("Authorization"))
+		claims, ok := f.apiKeys[apiKey]
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"access_token": f.sign(claims), "expires_in": f.expiresIn})
+	})
+	mux.HandleFunc("/api/revoked-sessions", func(w http.ResponseWriter, r *http.Request) {
+		token, _ := BearerToken(r.Header.Get("Authorization"))
+		claims := &Claims{}
+		if _, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) { return public, nil }); err != nil || !claims.ServiceAccount {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var sessions []map[string]any
+		for sid, at := range f.revoked {
+			sessions = append(sessions, map[string]any{"sid": sid, "revoked_at": at.Unix()})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"sessions": sessions})
+	})
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// sign issues an access token with the given claims, valid for 15 minutes from a second ago.
+func (f *fakeAuthService) sign(claims Claims) string {
+	now := time.Now()
+	claims.Issuer = testIssuer
+	claims.IssuedAt = jwt.NewNumericDate(now.Add(-time.Second))
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(15 * time.Minute))
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(f.key)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return signed
+}
+
+// verifier creates a Verifier of the fake auth-service with the given service API key.
+func (f *fakeAuthService) verifier(serviceAPIKey string) *Verifier {
+	f.t.Helper()
+	v, err := NewVerifier(Config{
+		JWKSURL:            f.server.URL + "/.well-known/jwks.json",
+		RevokedSessionsURL: f.server.URL + "/api/revoked-sessions",
+		TokenURL:           f.server.URL + "/api/token",
+		ServiceAPIKey:      serviceAPIKey,
+		Issuer:             testIssuer,
+	})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.t.Cleanup(v.Close)
+	return v
+}
+
+func TestVerifierRejectsRevokedSessions(t *testing.T) {
+	auth := newFakeAuthService(t)
+	auth.apiKeys["fsk_service"] = Claims{UserID: 1, SessionID: "svc", Scope: "read", ServiceAccount: true}
+	token := auth.sign(Claims{UserID: 7, SessionID: "s1"})
+	auth.revoked["s1"] = time.Now()
+
+	v := auth.verifier("fsk_service")
+	if _, err := v.Verify(token); !errors.Is(err, ErrRevokedToken) {
+		t.Errorf("Expected the token of a revoked session to be rejected, got %v", err)
+	}
+	if _, err := v.Verify(auth.sign(Claims{UserID: 7, SessionID: "s2"})); err != nil {
+		t.Errorf("Expected the token of another session to verify, got %v", err)
+	}
+}
+
+func TestVerifierNeedsServiceKeyForRevokedSessions(t *testing.T) {
+	auth := newFakeAuthService(t)
+	auth.apiKeys["fsk_user"] = Claims{UserID: 2, SessionID: "user-key", Scope: "read"}
+	auth.revoked["s1"] = time.Now()
+	token := auth.sign(Claims{UserID: 7, SessionID: "s1"})
+
+	// Without a service account the list cannot be fetched, so the revocation is not known
+	for _, apiKey := range []string{"", "fsk_user"} {
+		v := auth.verifier(apiKey)
+		if err := v.refreshRevoked(); err == nil {
+			t.Errorf("Expected fetching revoked sessions with key %q to fail", apiKey)
+		}
+		if _, err := v.Verify(token); err != nil {
+			t.Errorf("Expected the token to verify without the revocation list, got %v", err)
+		}
+	}
+}
+
+func TestVerifierExchangesAPIKeys(t *testing.T) {
+	auth := newFakeAuthService(t)
+	auth.apiKeys["fsk_ci"] = Claims{UserID: 3, SessionID: "ci-key", Scope: "read"}
+	// Tokens shorter lived than the usual margin are still kept for most of their lifetime
+	auth.expiresIn = 20
+	v := auth.verifier("")
+
+	for i := 0; i < 3; i++ {
+		claims, token, err := v.Authenticate("fsk_ci")
+		if err != nil || claims.UserID != 3 || claims.Allows(ScopeWrite) {
+			t.Fatalf("Expected the key's read-only claims, got %+v (%v)", claims, err)
+		}
+		// The access token is passed on, not the key
+		if forwarded, err := v.Verify(token); err != nil || forwarded.SessionID != "ci-key" {
+			t.Errorf("Expected the exchanged token to be returned, got %q (%v)", token, err)
+		}
+	}
+	if n := auth.exchanges.Load(); n != 1 {
+		t.Errorf("Expected the key to be exchanged once, got %d", n)
+	}
+}
+
+func TestVerifierRemembersRefusedKeys(t *testing.T) {
+	auth := newFakeAuthService(t)
+	v := auth.verifier("")
+
+	for i := 0; i < 5; i++ {
+		if _, err := v.Verify("fsk_bogus"); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("Expected the unknown key to be rejected, got %v", err)
+		}
+	}
+	if n := auth.exchanges.Load(); n != 1 {
+		t.Errorf("Expected the refused key to be sent to the auth-service once, got %d", n)
+	}
+}
+
+func TestVerifierLimitsExchanges(t *testing.T) {
+	auth := newFakeAuthService(t)
+	v := auth.verifier("")
+
+	limited := 0
+	for i := 0; i < 2*exchangeBurst; i++ {
+		_, err := v.Verify("fsk_bogus" + strconv.Itoa(i))
+		if errors.Is(err, ErrTooManyExchanges) {
+			limited++
+		} else if !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	if limited == 0 || auth.exchanges.Load() > exchangeBurst+1 {
+		t.Errorf("Expected exchanges beyond the burst to be refused, got %d exchanges and %d refused", auth.exchanges.Load(), limited)
+	}
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newLRU[int](2)
+	cache.add("a", 1)
+	cache.add("b", 2)
+	cache.get("a")
+	cache.add("c", 3)
+
+	if _, ok := cache.get("b"); ok {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+	for key, want := range map[string]int{"a": 1, "c": 3} {
+		if got, ok := cache.get(key); !ok || got != want {
+			t.Errorf("Expected %s to be %d, got %d (%t)", key, want, got, ok)
+		}
+	}
+}